@@ -0,0 +1,76 @@
+package main
+
+import (
+	"context"
+	"dockyard/config"
+	"dockyard/pkg/aws"
+	"dockyard/pkg/kube"
+	"errors"
+	"flag"
+	"fmt"
+)
+
+// Runs an asg subcommand, `dockyard asg restore <asg>` reapplies the
+// configuration the asg had before its rollout, e.g. after dockyard exited
+// in the middle of a rollout
+func runAsg(
+	ctx context.Context,
+	config config.Config,
+	kubeOpts kube.KubeConfigOptions,
+	args []string,
+) error {
+	flags := flag.NewFlagSet("asg", flag.ContinueOnError)
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if flags.NArg() != 2 || flags.Arg(0) != "restore" {
+		return errors.New("usage: dockyard asg restore <asg>")
+	}
+	asgName := flags.Arg(1)
+
+	k8sClient, err := kube.NewKubeClient(
+		config.AsgRollout.PrivateRegistry,
+		config.AsgRollout.IgnoreNotFound,
+		config.AsgRollout.EksClusterName,
+		kubeOpts,
+	)
+	if err != nil {
+		return err
+	}
+	defer k8sClient.Close()
+	asgClient := aws.NewAsgRollout(ctx, config.AwsConfig, k8sClient, config.AsgRollout)
+
+	snapshot, err := asgClient.GetAsgSnapshot(asgName)
+	if err != nil {
+		return err
+	}
+	if snapshot == nil {
+		return fmt.Errorf("Unable to restore asg %s, it has no snapshot", asgName)
+	}
+	fmt.Printf(
+		"Restoring asg %s to min %d, max %d, desired %d as of %s\n",
+		asgName,
+		snapshot.MinSize,
+		snapshot.MaxSize,
+		snapshot.DesiredCapacity,
+		snapshot.TakenAt.Local().Format("2006-01-02 15:04:05"),
+	)
+
+	eventLogs := make(chan string)
+	done := make(chan error)
+	go func() {
+		done <- asgClient.RestoreAsgSnapshot(asgName, eventLogs)
+	}()
+	for {
+		select {
+		case event := <-eventLogs:
+			fmt.Println(event)
+		case err := <-done:
+			if err != nil {
+				return err
+			}
+			fmt.Printf("Asg %s restored\n", asgName)
+			return nil
+		}
+	}
+}
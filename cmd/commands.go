@@ -0,0 +1,46 @@
+package main
+
+import (
+	"context"
+	"dockyard/config"
+	"dockyard/pkg/kube"
+	"fmt"
+)
+
+// Runs a non interactive dockyard command such as
+// `dockyard drain --dry-run <node>`, `dockyard public-images`,
+// `dockyard preflight`, `dockyard asg restore <asg>` or
+// `dockyard reconcile`
+func runCommand(
+	ctx context.Context,
+	config config.Config,
+	kubeOpts kube.KubeConfigOptions,
+	name string,
+	args []string,
+) error {
+	switch name {
+	case "drain":
+		return runDrain(ctx, config, kubeOpts, args)
+	case "public-images":
+		return runPublicImages(ctx, config, kubeOpts, args)
+	case "preflight":
+		return runPreflight(ctx, config, kubeOpts, args)
+	case "asg":
+		return runAsg(ctx, config, kubeOpts, args)
+	case "reconcile":
+		return runReconcile(ctx, config, kubeOpts, args)
+	default:
+		return fmt.Errorf("unknown command %s", name)
+	}
+}
+
+// Error of a command which exits with code instead of 1, e.g. failed
+// preflight checks
+type exitError struct {
+	code int
+	err  error
+}
+
+func (e *exitError) Error() string {
+	return e.err.Error()
+}
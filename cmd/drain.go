@@ -0,0 +1,84 @@
+package main
+
+import (
+	"context"
+	"dockyard/config"
+	"dockyard/pkg/kube"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"text/tabwriter"
+)
+
+// Simulates the drain of a node using server side dry-run evictions
+// and prints the impact on each pod
+func runDrain(
+	ctx context.Context,
+	config config.Config,
+	kubeOpts kube.KubeConfigOptions,
+	args []string,
+) error {
+	flags := flag.NewFlagSet("drain", flag.ContinueOnError)
+	dryRun := flags.Bool(
+		"dry-run",
+		false,
+		"Simulate the drain using server side dry-run evictions",
+	)
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if flags.NArg() != 1 {
+		return errors.New("usage: dockyard drain --dry-run <node>")
+	}
+	if !*dryRun {
+		return errors.New("only dry-run drains are supported, use --dry-run")
+	}
+	nodeName := flags.Arg(0)
+
+	k8sClient, err := kube.NewKubeClient(
+		config.AsgRollout.PrivateRegistry,
+		config.AsgRollout.IgnoreNotFound,
+		config.AsgRollout.EksClusterName,
+		kubeOpts,
+	)
+	if err != nil {
+		return err
+	}
+	defer k8sClient.Close()
+
+	impacts, err := k8sClient.SimulateDrain(
+		nodeName,
+		true,
+		config.AsgRollout.IgnoreNotFound,
+	)
+	if err != nil {
+		return err
+	}
+
+	evictable := 0
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "POD\tNAMESPACE\tIMPACT")
+	for _, impact := range impacts {
+		if !impact.HasImpact() {
+			evictable++
+		}
+		fmt.Fprintf(
+			w,
+			"%s\t%s\t%s\n",
+			impact.PodName,
+			impact.Namespace,
+			impact.String(),
+		)
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	fmt.Printf(
+		"\n%d of %d pods on node %s can be evicted without impact\n",
+		evictable,
+		len(impacts),
+		nodeName,
+	)
+	return nil
+}
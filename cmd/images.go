@@ -0,0 +1,102 @@
+package main
+
+import (
+	"context"
+	"dockyard/config"
+	"dockyard/pkg/kube"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"text/tabwriter"
+)
+
+// Lists images pulled from outside the private and allowed registries,
+// e.g. to hand the list to the teams owning the workloads
+func runPublicImages(
+	ctx context.Context,
+	config config.Config,
+	kubeOpts kube.KubeConfigOptions,
+	args []string,
+) error {
+	flags := flag.NewFlagSet("public-images", flag.ContinueOnError)
+	output := flags.String(
+		"output",
+		"table",
+		"Output format, one of table, csv or json",
+	)
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if flags.NArg() != 0 {
+		return errors.New("usage: dockyard public-images [--output table|csv|json]")
+	}
+
+	k8sClient, err := kube.NewKubeClient(
+		config.AsgRollout.PrivateRegistry,
+		config.AsgRollout.IgnoreNotFound,
+		config.AsgRollout.EksClusterName,
+		kubeOpts,
+	)
+	if err != nil {
+		return err
+	}
+	defer k8sClient.Close()
+
+	images, err := k8sClient.ListPublicImages(config.AsgRollout.AllowedRegistries)
+	if err != nil {
+		return err
+	}
+	return writePublicImages(os.Stdout, images, *output)
+}
+
+func writePublicImages(w io.Writer, images []kube.PublicImage, output string) error {
+	switch output {
+	case "json":
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(images)
+	case "csv":
+		writer := csv.NewWriter(w)
+		if err := writer.Write(
+			[]string{"image", "kind", "namespace", "name", "containers"},
+		); err != nil {
+			return err
+		}
+		for _, image := range images {
+			err := writer.Write([]string{
+				image.Image,
+				image.Kind,
+				image.Namespace,
+				image.Name,
+				strings.Join(image.Containers, ","),
+			})
+			if err != nil {
+				return err
+			}
+		}
+		writer.Flush()
+		return writer.Error()
+	case "table":
+		tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(tw, "IMAGE\tKIND\tNAMESPACE\tNAME\tCONTAINERS")
+		for _, image := range images {
+			fmt.Fprintf(
+				tw,
+				"%s\t%s\t%s\t%s\t%s\n",
+				image.Image,
+				image.Kind,
+				image.Namespace,
+				image.Name,
+				strings.Join(image.Containers, ","),
+			)
+		}
+		return tw.Flush()
+	default:
+		return fmt.Errorf("unknown output %s, use table, csv or json", output)
+	}
+}
@@ -3,9 +3,12 @@ package main
 import (
 	"context"
 	"dockyard/config"
+	"dockyard/pkg/aws"
 	"dockyard/pkg/kube"
 	"dockyard/pkg/ui"
 	"dockyard/utils"
+	"errors"
+	"flag"
 	"fmt"
 	"strings"
 
@@ -32,6 +35,21 @@ func (f *MyJSONFormatter) Format(entry *log.Entry) ([]byte, error) {
 
 func main() {
 
+	kubeOpts := kube.KubeConfigOptions{}
+	flag.StringVar(
+		&kubeOpts.Kubeconfig,
+		"kubeconfig",
+		"",
+		"Path to the kubeconfig file, defaults to KUBECONFIG or ${HOME}/.kube/config",
+	)
+	flag.StringVar(
+		&kubeOpts.Context,
+		"context",
+		"",
+		"Kubeconfig context to use, defaults to the current context",
+	)
+	flag.Parse()
+
 	config, err := config.LoadConfig()
 	if err != nil {
 		log.Fatal(err)
@@ -44,6 +62,12 @@ func main() {
 		utils.SetupLogger(log.InfoLevel)
 	}
 
+	// Dockyard running as a pod verifies that it was deployed to the eks
+	// cluster it rolls
+	kubeOpts.VerifyInCluster = func(clusterName string, caData []byte) error {
+		return aws.NewAwsEKS(clusterName, config.AwsConfig).VerifyClusterCA(caData)
+	}
+
 	ctx := context.Background()
 	ctx, cancel := context.WithCancel(ctx)
 	c := make(chan os.Signal, 1)
@@ -55,20 +79,47 @@ func main() {
 		cancel()
 	}()
 
-	renderUi(ctx, config)
+	if flag.NArg() > 0 {
+		err := runCommand(ctx, config, kubeOpts, flag.Arg(0), flag.Args()[1:])
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			var exit *exitError
+			if errors.As(err, &exit) {
+				os.Exit(exit.code)
+			}
+			os.Exit(1)
+		}
+		return
+	}
+
+	renderUi(ctx, config, kubeOpts)
 }
 
 // Renders Terminal UI for dockyard
-func renderUi(ctx context.Context, config config.Config) {
+func renderUi(
+	ctx context.Context,
+	config config.Config,
+	kubeOpts kube.KubeConfigOptions,
+) {
 	var k8sClient kube.KubeClient
-	k8sClient, err := kube.NewKubeClient(config.AsgRollout.PrivateRegistry, config.AsgRollout.IgnoreNotFound, config.AsgRollout.EksClusterName)
+	k8sClient, err := kube.NewKubeClient(config.AsgRollout.PrivateRegistry, config.AsgRollout.IgnoreNotFound, config.AsgRollout.EksClusterName, kubeOpts)
 
 	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
 		log.Fatal(err)
 	}
 
-	dockyardTUI := ui.NewTUI(ctx, k8sClient, config.AwsConfig, config.AsgRollout)
+	dockyardTUI := ui.NewTUI(
+		ctx,
+		k8sClient,
+		config.AwsConfig,
+		config.AsgRollout,
+		kubeOpts,
+		config.Clusters,
+		config.Preflight,
+	)
 	dockyardTUI.EnableEventCapture()
+	defer dockyardTUI.Close()
 
 	// Start the application.
 	if err := dockyardTUI.App.SetRoot(dockyardTUI.RenderTUI(), true).EnableMouse(true).Run(); err != nil {
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"context"
+	"dockyard/config"
+	"dockyard/pkg/aws"
+	"dockyard/pkg/kube"
+	"dockyard/pkg/preflight"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+)
+
+// Runs all preflight checks non interactively and writes the report, e.g.
+// to attach it to a change ticket or to gate a pipeline on it. Failed
+// checks exit with the code of their worst severity.
+func runPreflight(
+	ctx context.Context,
+	config config.Config,
+	kubeOpts kube.KubeConfigOptions,
+	args []string,
+) error {
+	flags := flag.NewFlagSet("preflight", flag.ContinueOnError)
+	output := flags.String(
+		"output",
+		preflight.FormatMarkdown,
+		"Report format, one of json, junit or markdown",
+	)
+	file := flags.String(
+		"file",
+		"",
+		"File the report is written to, defaults to stdout",
+	)
+	asgName := flags.String(
+		"asg",
+		"",
+		"Asg about to be rolled, defaults to all asgs of the cluster",
+	)
+	batchSize := flags.Int64(
+		"batch-size",
+		1,
+		"Nodes rolled per batch",
+	)
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if flags.NArg() != 0 {
+		return errors.New(
+			"usage: dockyard preflight [--output json|junit|markdown] [--file <path>] [--asg <asg>] [--batch-size <n>]",
+		)
+	}
+	if err := preflight.ValidateFormat(*output); err != nil {
+		return err
+	}
+
+	k8sClient, err := kube.NewKubeClient(
+		config.AsgRollout.PrivateRegistry,
+		config.AsgRollout.IgnoreNotFound,
+		config.AsgRollout.EksClusterName,
+		kubeOpts,
+	)
+	if err != nil {
+		return err
+	}
+	defer k8sClient.Close()
+
+	// Clusters of other accounts are checked with the aws settings of
+	// their account, like the TUI does
+	cluster := aws.ClusterOfContext(
+		kubeOpts,
+		k8sClient.GetContext(),
+		k8sClient.GetClusterName(),
+		config.Clusters,
+		config.AwsConfig,
+	)
+	awsConfig := cluster.AwsConfig(config.AwsConfig)
+	env := &preflight.Env{
+		Kube:                  k8sClient,
+		Asg:                   aws.NewAsgRollout(ctx, &awsConfig, k8sClient, config.AsgRollout),
+		Eks:                   aws.NewAwsEKS(k8sClient.GetClusterName(), &awsConfig),
+		ClusterName:           k8sClient.GetClusterName(),
+		IgnoreNotFound:        config.AsgRollout.IgnoreNotFound,
+		AsgName:               *asgName,
+		BatchSize:             *batchSize,
+		AllowedRegistries:     config.AsgRollout.AllowedRegistries,
+		ClusterAutoscalerMode: config.AsgRollout.ClusterAutoscalerMode(),
+	}
+	report := preflight.NewRunner(config.Preflight).Run(ctx, env)
+
+	w := io.Writer(os.Stdout)
+	if len(*file) != 0 {
+		f, err := os.Create(*file)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		w = f
+	}
+	if err := preflight.WriteReport(w, report, *output); err != nil {
+		return err
+	}
+
+	if code := report.ExitCode(); code != preflight.ExitPass {
+		return &exitError{
+			code: code,
+			err:  fmt.Errorf("Preflight checks failed, verdict %s", report.Verdict),
+		}
+	}
+	return nil
+}
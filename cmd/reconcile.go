@@ -0,0 +1,107 @@
+package main
+
+import (
+	"context"
+	"dockyard/config"
+	"dockyard/pkg/aws"
+	"dockyard/pkg/kube"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"text/tabwriter"
+)
+
+// Detects state left behind by interrupted rollouts, such as node-state
+// labels, rolling taints, dockyard tags, snapshots and scale in protection,
+// and restores the clean state with --fix. Asgs rolled by a live dockyard
+// process are never touched.
+func runReconcile(
+	ctx context.Context,
+	config config.Config,
+	kubeOpts kube.KubeConfigOptions,
+	args []string,
+) error {
+	flags := flag.NewFlagSet("reconcile", flag.ContinueOnError)
+	asgName := flags.String(
+		"asg",
+		"",
+		"Asg to reconcile, defaults to all asgs of the cluster and asgs with dockyard tags",
+	)
+	fix := flags.Bool(
+		"fix",
+		false,
+		"Restore the clean state of the leftovers",
+	)
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if flags.NArg() != 0 {
+		return errors.New("usage: dockyard reconcile [--asg <asg>] [--fix]")
+	}
+
+	k8sClient, err := kube.NewKubeClient(
+		config.AsgRollout.PrivateRegistry,
+		config.AsgRollout.IgnoreNotFound,
+		config.AsgRollout.EksClusterName,
+		kubeOpts,
+	)
+	if err != nil {
+		return err
+	}
+	defer k8sClient.Close()
+	asgClient := aws.NewAsgRollout(ctx, config.AwsConfig, k8sClient, config.AsgRollout)
+
+	leftovers, err := asgClient.FindLeftovers(*asgName)
+	if err != nil {
+		return err
+	}
+	if len(leftovers) == 0 {
+		fmt.Println("No leftovers of interrupted rollouts found")
+		return nil
+	}
+
+	held := 0
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "ASG\tKIND\tRESOURCE\tEXPLANATION")
+	for _, leftover := range leftovers {
+		asg := leftover.AsgName
+		if len(asg) == 0 {
+			asg = "-"
+		}
+		explanation := leftover.Explanation
+		if len(leftover.HeldBy) != 0 {
+			held++
+			explanation += fmt.Sprintf(" ( rollout held by %s )", leftover.HeldBy)
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", asg, leftover.Kind, leftover.Resource, explanation)
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	fmt.Printf("\n%d leftovers found, %d of asgs under a live rollout\n", len(leftovers), held)
+
+	if !*fix {
+		fmt.Println("Run with --fix to restore the clean state")
+		return nil
+	}
+	fmt.Println()
+
+	eventLogs := make(chan string)
+	done := make(chan error)
+	go func() {
+		done <- asgClient.FixLeftovers(leftovers, eventLogs)
+	}()
+	for {
+		select {
+		case event := <-eventLogs:
+			fmt.Println(event)
+		case err := <-done:
+			if err != nil {
+				return err
+			}
+			fmt.Println("Leftovers fixed")
+			return nil
+		}
+	}
+}
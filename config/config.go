@@ -2,6 +2,7 @@ package config
 
 import (
 	"dockyard/pkg/aws"
+	"dockyard/pkg/preflight"
 	"dockyard/utils"
 
 	"github.com/go-playground/validator"
@@ -13,6 +14,8 @@ type Config struct {
 	AwsConfig  *aws.AwsConfig        `mapstructure:"AWS_CONFIG"`
 	Logging    *utils.LoggingConfig  `mapstructure:"LOGGING"`
 	AsgRollout *aws.AsgRolloutConfig `mapstructure:"ASG_ROLLOUT"`
+	Clusters   []aws.ClusterConfig   `mapstructure:"CLUSTERS" validate:"dive"`
+	Preflight  *preflight.Config     `mapstructure:"PREFLIGHT"`
 }
 
 // Reads config.yaml from current working directory and sets configuration for dockyard
@@ -25,19 +28,33 @@ func LoadConfig() (config Config, err error) {
 	viper.SetDefault(
 		"ASG_ROLLOUT",
 		map[string]interface{}{
-			"IGNORE_NOT_FOUND":  true,
-			"FORCE_DELETE_PODS": false,
+			"IGNORE_NOT_FOUND":   true,
+			"FORCE_DELETE_PODS":  false,
+			"WAIT_FOR_JOBS":      false,
+			"PREFER_NO_SCHEDULE": false,
 			"PERIOD_WAIT": map[string]interface{}{
 				"BEFORE_POST":           60,
 				"AFTER_BATCH":           30,
 				"K8S_READY":             30,
 				"NEW_NODE_ASG_REGISTER": 30,
+				"JOB_COMPLETION":        30,
 			},
 			"TIMEOUTS": map[string]interface{}{
 				"NEW_NODE_ASG_REGISTER": 600,
+				"JOB_COMPLETION":        3600,
+			},
+			"CLUSTER_AUTOSCALER": map[string]interface{}{
+				"MODE": "none",
+			},
+			"SUSPEND_PROCESSES": []string{
+				"AZRebalance",
+				"ReplaceUnhealthy",
+				"ScheduledActions",
+				"AlarmNotification",
 			},
 		},
 	)
+	viper.SetDefault("PREFLIGHT.BLOCK_ON", "critical")
 	viper.AutomaticEnv()
 
 	err = viper.ReadInConfig()
@@ -59,6 +76,12 @@ func LoadConfig() (config Config, err error) {
 		return
 	}
 
+	err = config.Preflight.Validate()
+
+	if err != nil {
+		return
+	}
+
 	if config.Logging != nil && config.Logging.Level != nil {
 		_, err = log.ParseLevel(*config.Logging.Level)
 	}
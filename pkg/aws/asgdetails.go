@@ -8,29 +8,30 @@ import (
 	"strconv"
 
 	"github.com/aws/aws-sdk-go/aws"
-	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/autoscaling"
+	"github.com/aws/aws-sdk-go/service/autoscaling/autoscalingiface"
 	"github.com/aws/aws-sdk-go/service/ec2"
 	log "github.com/sirupsen/logrus"
 )
 
 func getAsg(
 	asgName string,
-	session *session.Session,
+	autoScalingCl autoscalingiface.AutoScalingAPI,
 ) (*autoscaling.Group, error) {
 
-	autoScalingCl := autoscaling.New(session)
-
 	input := &autoscaling.DescribeAutoScalingGroupsInput{
 		AutoScalingGroupNames: []*string{
 			aws.String(asgName),
 		},
 	}
-	result, err := autoScalingCl.DescribeAutoScalingGroups(input)
+	groups, err := describeAsgs(autoScalingCl, input)
 	if err != nil {
 		return nil, err
 	}
-	return result.AutoScalingGroups[0], err
+	if len(groups) == 0 {
+		return nil, fmt.Errorf("no autoscaling group found with name %v", asgName)
+	}
+	return groups[0], nil
 }
 
 // Fetches all Auto Scaling groups in the region
@@ -65,7 +66,7 @@ func (asgRollout *asgRolloutClient) FetchAsgOfEks(
 func (asgRollout *asgRolloutClient) FetchAsgWithTags(
 	tags []string,
 ) ([]AsgInfo, error) {
-	autoScalingSvc := autoscaling.New(asgRollout.session)
+	autoScalingSvc := asgRollout.autoScalingCl
 
 	filters := []*autoscaling.Filter{}
 
@@ -83,7 +84,7 @@ func (asgRollout *asgRolloutClient) FetchAsgWithTags(
 		Filters: filters,
 	}
 
-	result, err := autoScalingSvc.DescribeAutoScalingGroups(input)
+	groups, err := describeAsgs(autoScalingSvc, input)
 
 	if err != nil {
 		return nil, err
@@ -93,11 +94,11 @@ func (asgRollout *asgRolloutClient) FetchAsgWithTags(
 	asgToInstanceIdsMap := map[string][]*string{}
 	allInstanceIds := []*string{}
 
-	ec2Svc := ec2.New(asgRollout.session)
+	ec2Svc := asgRollout.ec2Cl
 
 	amiIds := []*string{}
 
-	for _, group := range result.AutoScalingGroups {
+	for _, group := range groups {
 
 		var defaultAmiId *string
 
@@ -150,11 +151,7 @@ func (asgRollout *asgRolloutClient) FetchAsgWithTags(
 		asgInfos = append(asgInfos, asgInfo)
 	}
 
-	describeImagesInput := &ec2.DescribeImagesInput{
-		ImageIds: amiIds,
-	}
-
-	imageResult, err := ec2Svc.DescribeImages(describeImagesInput)
+	images, err := describeImages(ec2Svc, amiIds)
 
 	if err != nil {
 		return nil, err
@@ -162,7 +159,7 @@ func (asgRollout *asgRolloutClient) FetchAsgWithTags(
 
 	amiNames := map[string]string{}
 
-	for _, image := range imageResult.Images {
+	for _, image := range images {
 		amiNames[*image.ImageId] = *image.Name
 	}
 
@@ -176,33 +173,106 @@ func (asgRollout *asgRolloutClient) FetchAsgWithTags(
 	return newAsgInfos, nil
 }
 
-// Separate out old and new instances for this asg
-func (asgRollout *asgRolloutClient) GetOldnNewInstancesOfAsg(
-	asgName string,
-) (oldInstances []*string, newInstances []*string, err error) {
-	autoScalingSvc := autoscaling.New(asgRollout.session)
+// Returns names of all asgs of the eks cluster
+func (asgRollout *asgRolloutClient) ListAsgsOfEks(
+	eksClusterName string,
+) ([]string, error) {
+	name := fmt.Sprintf("tag:kubernetes.io/cluster/%v", eksClusterName)
+	value := "owned"
+	groups, err := describeAsgs(
+		asgRollout.autoScalingCl,
+		&autoscaling.DescribeAutoScalingGroupsInput{
+			Filters: []*autoscaling.Filter{
+				{Name: &name, Values: []*string{&value}},
+			},
+		},
+	)
+	if err != nil {
+		return nil, err
+	}
+	asgNames := make([]string, 0, len(groups))
+	for _, group := range groups {
+		asgNames = append(asgNames, aws.StringValue(group.AutoScalingGroupName))
+	}
+	return asgNames, nil
+}
+
+// Returns k8s node names of old instances in all asgs of the eks cluster.
+// These are the nodes which would be drained during a rollout
+func (asgRollout *asgRolloutClient) GetNodesToRollout(
+	eksClusterName string,
+) ([]string, error) {
+	autoScalingSvc := asgRollout.autoScalingCl
 
+	name := fmt.Sprintf("tag:kubernetes.io/cluster/%v", eksClusterName)
+	value := "owned"
 	input := &autoscaling.DescribeAutoScalingGroupsInput{
-		AutoScalingGroupNames: []*string{
-			aws.String(asgName),
+		Filters: []*autoscaling.Filter{
+			{Name: &name, Values: []*string{&value}},
 		},
 	}
 
-	result, err := autoScalingSvc.DescribeAutoScalingGroups(input)
+	groups, err := describeAsgs(autoScalingSvc, input)
 
 	if err != nil {
-		return []*string{}, []*string{}, err
+		return nil, err
 	}
 
-	if len(result.AutoScalingGroups) == 0 {
-		return []*string{}, []*string{}, fmt.Errorf(
-			"no autoscaling group found with name %v",
-			asgName,
-		)
+	oldInstances := make([]*string, 0)
+	for _, group := range groups {
+		oldInstancesOfAsg, _, _, err := asgRollout.getOldnNewInstancesOfAsg(group)
+
+		if err != nil {
+			return nil, err
+		}
+		oldInstances = append(oldInstances, oldInstancesOfAsg...)
+	}
+	return asgRollout.nodeNamesOfInstances(oldInstances)
+}
+
+// Returns k8s node names of old instances of the asg
+func (asgRollout *asgRolloutClient) GetNodesToRolloutOfAsg(
+	asgName string,
+) ([]string, error) {
+	oldInstances, _, err := asgRollout.GetOldnNewInstancesOfAsg(asgName)
+	if err != nil {
+		return nil, err
+	}
+	return asgRollout.nodeNamesOfInstances(oldInstances)
+}
+
+// Returns names of the k8s nodes the instances registered as, instances
+// which haven't registered are left out
+func (asgRollout *asgRolloutClient) nodeNamesOfInstances(
+	instanceIds []*string,
+) ([]string, error) {
+	mapping, err := asgRollout.mapNodes(instanceIds)
+	if err != nil {
+		return nil, err
+	}
+	nodeNames := make([]string, 0)
+	for _, instanceId := range mapping.InstanceIds {
+		k8sNode := mapping.NodeName(instanceId)
+		if len(k8sNode) == 0 {
+			continue
+		}
+		nodeNames = append(nodeNames, k8sNode)
+	}
+	return nodeNames, nil
+}
+
+// Separate out old and new instances for this asg
+func (asgRollout *asgRolloutClient) GetOldnNewInstancesOfAsg(
+	asgName string,
+) (oldInstances []*string, newInstances []*string, err error) {
+	group, err := getAsg(asgName, asgRollout.autoScalingCl)
+
+	if err != nil {
+		return []*string{}, []*string{}, err
 	}
 
 	oldInstances, newInstances, _, err = asgRollout.getOldnNewInstancesOfAsg(
-		result.AutoScalingGroups[0],
+		group,
 	)
 
 	return
@@ -232,8 +302,8 @@ func (asgRollout *asgRolloutClient) AreInstancesNew(
 func (asgRollout *asgRolloutClient) getOldnNewInstancesOfAsg(
 	group *autoscaling.Group,
 ) (oldInstances []*string, newInstances []*string, defaultAmiId *string, err error) {
-	autoScalingSvc := autoscaling.New(asgRollout.session)
-	ec2Svc := ec2.New(asgRollout.session)
+	autoScalingSvc := asgRollout.autoScalingCl
+	ec2Svc := asgRollout.ec2Cl
 
 	var launchTemplateInput *ec2.DescribeLaunchTemplateVersionsInput
 
@@ -259,7 +329,8 @@ func (asgRollout *asgRolloutClient) getOldnNewInstancesOfAsg(
 			},
 		}
 
-		result, err := autoScalingSvc.DescribeLaunchConfigurations(
+		launchConfigs, err := describeLaunchConfigs(
+			autoScalingSvc,
 			describeLaunchConfigInput,
 		)
 
@@ -267,8 +338,8 @@ func (asgRollout *asgRolloutClient) getOldnNewInstancesOfAsg(
 			return []*string{}, []*string{}, nil, err
 		}
 
-		if len(result.LaunchConfigurations) > 0 {
-			defaultAmiId = result.LaunchConfigurations[0].ImageId
+		if len(launchConfigs) > 0 {
+			defaultAmiId = launchConfigs[0].ImageId
 		}
 
 	} else if group.LaunchTemplate != nil {
@@ -278,48 +349,51 @@ func (asgRollout *asgRolloutClient) getOldnNewInstancesOfAsg(
 			// Versions:         aws.StringSlice([]string{"$Latest"}),
 		}
 
-		launchTemplatesResult, err := ec2Svc.DescribeLaunchTemplateVersions(launchTemplateInput)
+		launchTemplateVersions, err := describeLaunchTemplateVersions(
+			ec2Svc,
+			launchTemplateInput,
+		)
 
 		if err != nil {
 			return []*string{}, []*string{}, nil, err
 		}
 
-		for _, launchTemplate := range launchTemplatesResult.LaunchTemplateVersions {
+		for _, launchTemplate := range launchTemplateVersions {
 
 			if *launchTemplate.VersionNumber > int64(latestVersionNumber) {
 				latestVersionNumber = int(*launchTemplate.VersionNumber)
 				latestLaunchTemplateId = *launchTemplate.LaunchTemplateId
+				if launchTemplate.LaunchTemplateData != nil {
+					defaultAmiId = launchTemplate.LaunchTemplateData.ImageId
+				}
 			}
 		}
-
-		if len(launchTemplatesResult.LaunchTemplateVersions) > 0 {
-			defaultAmiId = launchTemplatesResult.LaunchTemplateVersions[0].LaunchTemplateData.ImageId
-		}
 	} else if group.MixedInstancesPolicy != nil {
 		log.Debug("MixedInstancesPolicy found ", *group.AutoScalingGroupName)
 		launchTemplateInput = &ec2.DescribeLaunchTemplateVersionsInput{
 			LaunchTemplateId: group.MixedInstancesPolicy.LaunchTemplate.LaunchTemplateSpecification.LaunchTemplateId,
 		}
 
-		launchTemplatesResult, err := ec2Svc.DescribeLaunchTemplateVersions(launchTemplateInput)
+		launchTemplateVersions, err := describeLaunchTemplateVersions(
+			ec2Svc,
+			launchTemplateInput,
+		)
 
 		if err != nil {
 			return []*string{}, []*string{}, nil, err
 		}
 
-		for _, launchTemplate := range launchTemplatesResult.LaunchTemplateVersions {
+		for _, launchTemplate := range launchTemplateVersions {
 
 			if *launchTemplate.VersionNumber > int64(latestVersionNumber) {
 				latestVersionNumber = int(*launchTemplate.VersionNumber)
 				latestLaunchTemplateId = *launchTemplate.LaunchTemplateId
+				if launchTemplate.LaunchTemplateData != nil {
+					defaultAmiId = launchTemplate.LaunchTemplateData.ImageId
+				}
 			}
 
 		}
-
-		if len(launchTemplatesResult.LaunchTemplateVersions) > 0 {
-			defaultAmiId = launchTemplatesResult.LaunchTemplateVersions[0].LaunchTemplateData.ImageId
-		}
-
 	}
 
 	instanceIds := []*string{}
@@ -375,19 +449,33 @@ func (asgRollout *asgRolloutClient) getOldnNewInstancesOfAsg(
 func (asgRollout *asgRolloutClient) GetAmiDetails(
 	imageIds []*string,
 ) ([]*ec2.Image, error) {
-	ec2Svc := ec2.New(asgRollout.session)
+	return describeImages(asgRollout.ec2Cl, imageIds)
+}
 
-	describeImagesInput := &ec2.DescribeImagesInput{
-		ImageIds: imageIds,
+// Returns ec2 image details of the ami new instances of the asg are
+// launched with
+func (asgRollout *asgRolloutClient) GetTargetAmiOfAsg(
+	asgName string,
+) (*ec2.Image, error) {
+	group, err := getAsg(asgName, asgRollout.autoScalingCl)
+	if err != nil {
+		return nil, err
 	}
-
-	imageResult, err := ec2Svc.DescribeImages(describeImagesInput)
-
+	_, _, amiId, err := asgRollout.getOldnNewInstancesOfAsg(group)
 	if err != nil {
 		return nil, err
 	}
-
-	return imageResult.Images, nil
+	if amiId == nil {
+		return nil, fmt.Errorf("Unable to find the target ami of asg %s", asgName)
+	}
+	images, err := describeImages(asgRollout.ec2Cl, []*string{amiId})
+	if err != nil {
+		return nil, err
+	}
+	if len(images) == 0 {
+		return nil, fmt.Errorf("Unable to find details of ami %s", *amiId)
+	}
+	return images[0], nil
 }
 
 func (asgRollout *asgRolloutClient) FormatAsgs(asgs []AsgInfo) [][]string {
@@ -449,7 +537,7 @@ func (asgRollout *asgRolloutClient) GetEksVersionFromAmiName(
 func (asgRollout *asgRolloutClient) GetInstancesOfAsg(
 	asgName string,
 ) ([]*string, error) {
-	asg, err := getAsg(asgName, asgRollout.session)
+	asg, err := getAsg(asgName, asgRollout.autoScalingCl)
 	if err != nil {
 		return nil, err
 	}
@@ -470,23 +558,10 @@ func (asgRollout *asgRolloutClient) GetInstanceDetailsOfAsg(
 		return nil, err
 	}
 
-	ec2Cl := ec2.New(asgRollout.session)
-	ec2input := &ec2.DescribeInstancesInput{
-		InstanceIds: instances,
-	}
-
-	nodesResult, err := ec2Cl.DescribeInstances(ec2input)
-
-	if err != nil {
-		return nil, err
-	}
-
-	instanceArray := []*ec2.Instance{}
-
-	for _, reservation := range nodesResult.Reservations {
-		instanceArray = append(instanceArray, reservation.Instances...)
+	// An asg without instances must not describe all instances of the region
+	if len(instances) == 0 {
+		return []*ec2.Instance{}, nil
 	}
 
-	return instanceArray, nil
-
+	return describeInstances(asgRollout.ec2Cl, instances)
 }
@@ -0,0 +1,108 @@
+package aws
+
+import (
+	"fmt"
+	"testing"
+
+	"dockyard/pkg/aws/fake"
+)
+
+func newTestAsgClient(cloud *fake.Cloud) *asgRolloutClient {
+	return NewAsgRolloutWithClients(
+		cloud.AutoScaling(),
+		cloud.EC2(),
+		nil,
+		&AsgRolloutConfig{EksClusterName: testClusterName},
+	).(*asgRolloutClient)
+}
+
+func TestFetchAsgWithTagsMergesPages(t *testing.T) {
+	cloud := fake.NewCloud()
+	cloud.SetPageSize(2)
+	cloud.AddImage("ami-old", "amazon-eks-node-1.21-v20220824")
+	cloud.AddImage("ami-new", "amazon-eks-node-1.22-v20220914")
+	cloud.AddLaunchTemplateVersion(testLaunchTemplateId, "ami-old")
+	cloud.AddLaunchTemplateVersion(testLaunchTemplateId, "ami-old")
+	cloud.AddLaunchTemplateVersion(testLaunchTemplateId, "ami-new")
+	for i := 0; i < 5; i++ {
+		cloud.AddAsg(fake.AsgSpec{
+			Name:             fmt.Sprintf("%s-%d", testAsgName, i),
+			LaunchTemplateId: testLaunchTemplateId,
+			MinSize:          1,
+			MaxSize:          1,
+			DesiredCapacity:  1,
+			Tags: map[string]string{
+				"kubernetes.io/cluster/" + testClusterName: "owned",
+				"team": "platform",
+				"tier": "workers",
+			},
+		})
+	}
+	cloud.AddAsg(fake.AsgSpec{
+		Name:             "other-cluster-workers",
+		LaunchTemplateId: testLaunchTemplateId,
+		Tags:             map[string]string{"kubernetes.io/cluster/other": "owned"},
+	})
+
+	asgClient := newTestAsgClient(cloud)
+	asgInfos, err := asgClient.FetchAsgWithTags(
+		[]string{"tag:kubernetes.io/cluster/" + testClusterName},
+	)
+	if err != nil {
+		t.Fatalf("FetchAsgWithTags failed, %s", err.Error())
+	}
+	if len(asgInfos) != 5 {
+		t.Fatalf("FetchAsgWithTags returned %d asgs, want 5", len(asgInfos))
+	}
+	for _, asgInfo := range asgInfos {
+		if asgInfo.AmiId != "ami-new" || asgInfo.Ami != "amazon-eks-node-1.22-v20220914" {
+			t.Errorf(
+				"Asg %s has ami %s (%s), want the latest launch template version's ami",
+				asgInfo.Name,
+				asgInfo.AmiId,
+				asgInfo.Ami,
+			)
+		}
+	}
+
+	// The cluster tag sorts first, the tag looked up is on a later page
+	err = asgClient.AddTagToAsG(testAsgName+"-4", "zone-count", "3")
+	if err != nil {
+		t.Fatalf("AddTagToAsG failed, %s", err.Error())
+	}
+	count, err := asgClient.GetTagValueOfAsg(testAsgName+"-4", "zone-count")
+	if err != nil {
+		t.Fatalf("GetTagValueOfAsg failed, %s", err.Error())
+	}
+	if count != 3 {
+		t.Errorf("GetTagValueOfAsg returned %d, want 3", count)
+	}
+
+	err = asgClient.AddTagToAsG(testAsgName+"-4", "zone-count", "three")
+	if err != nil {
+		t.Fatalf("AddTagToAsG failed, %s", err.Error())
+	}
+	if _, err = asgClient.GetTagValueOfAsg(testAsgName+"-4", "zone-count"); err == nil {
+		t.Errorf("GetTagValueOfAsg parsed tag value three without error")
+	}
+}
+
+func TestGetInstanceDetailsOfAsgBatchesIds(t *testing.T) {
+	cloud := fake.NewCloud()
+	cloud.AddLaunchTemplateVersion(testLaunchTemplateId, "ami-old")
+	cloud.AddAsg(fake.AsgSpec{
+		Name:             testAsgName,
+		LaunchTemplateId: testLaunchTemplateId,
+		MinSize:          0,
+		MaxSize:          250,
+		DesiredCapacity:  250,
+	})
+
+	instances, err := newTestAsgClient(cloud).GetInstanceDetailsOfAsg(testAsgName)
+	if err != nil {
+		t.Fatalf("GetInstanceDetailsOfAsg failed, %s", err.Error())
+	}
+	if len(instances) != 250 {
+		t.Errorf("GetInstanceDetailsOfAsg returned %d instances, want 250", len(instances))
+	}
+}
@@ -3,25 +3,18 @@ package aws
 import (
 	"context"
 	"fmt"
-	"strconv"
 	"strings"
 	"sync"
 	"time"
 
+	"dockyard/pkg/kube"
+
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/service/autoscaling"
 	"github.com/aws/aws-sdk-go/service/ec2"
 	log "github.com/sirupsen/logrus"
 )
 
-var (
-	progress = &RolloutProgress{
-		StepsSize: int32(0),
-		StepsDone: int32(0),
-		TotalSize: int32(0),
-	}
-)
-
 type AsgRolloutConfig struct {
 	IgnoreNotFound  bool           `mapstructure:"IGNORE_NOT_FOUND"`
 	PeriodWait      rolloutPeriod  `mapstructure:"PERIOD_WAIT"`
@@ -29,6 +22,23 @@ type AsgRolloutConfig struct {
 	PrivateRegistry string         `mapstructure:"PRIVATE_REGISTRY"`
 	ForceDeletePods bool           `mapstructure:"FORCE_DELETE_PODS"`
 	EksClusterName  string         `mapstructure:"EKS_CLUSTER_NAME"`
+	WaitForJobs     bool           `mapstructure:"WAIT_FOR_JOBS"`
+	// taint old nodes with PreferNoSchedule until they are drained
+	PreferNoSchedule bool `mapstructure:"PREFER_NO_SCHEDULE"`
+	// Registry patterns of mirrors and pull-through caches images may be
+	// pulled from besides the private registry
+	AllowedRegistries []string `mapstructure:"ALLOWED_REGISTRIES"`
+	// How cluster-autoscaler is kept from scaling the asg during a rollout
+	ClusterAutoscaler clusterAutoscalerConfig `mapstructure:"CLUSTER_AUTOSCALER"`
+	// Scaling processes suspended while the asg is rolled, e.g. AZRebalance.
+	// Launch and Terminate are needed by the rollout itself.
+	SuspendProcesses []string `mapstructure:"SUSPEND_PROCESSES" validate:"dive,oneof=AddToLoadBalancer AlarmNotification AZRebalance HealthCheck InstanceRefresh ReplaceUnhealthy ScheduledActions"`
+}
+
+type clusterAutoscalerConfig struct {
+	// none, annotate the nodes of the asg with scale-down-disabled or
+	// scale-to-zero the cluster-autoscaler deployments until post rollout
+	Mode string `mapstructure:"MODE" validate:"omitempty,oneof=none annotate scale-to-zero"`
 }
 
 type rolloutPeriod struct {
@@ -40,12 +50,20 @@ type rolloutPeriod struct {
 	WaitForReady int64 `mapstructure:"K8S_READY"`
 	// time to wait for new ec2 instance to join asg
 	WaitForNewNode int64 `mapstructure:"NEW_NODE_ASG_REGISTER"`
+	// time to wait before checking again if jobs on a node have completed
+	JobCompletion int64 `mapstructure:"JOB_COMPLETION"`
 }
 
 type rolloutTimeout struct {
 	NewNodeTimeout int64 `mapstructure:"NEW_NODE_ASG_REGISTER"`
+	// max time to wait for jobs on a node to complete before evicting them
+	JobCompletionTimeout int64 `mapstructure:"JOB_COMPLETION"`
 }
 
+// Time given to the asg to settle after min and max are restored, before
+// scale in protection of the instances is removed
+var scaleInSettleWait = 1 * time.Minute
+
 // Struct to denote a progress of rollout
 // at a specific time.
 type RolloutProgress struct {
@@ -68,6 +86,14 @@ type AsgRolloutClient interface {
 	// kubernetes.io/cluster/example-cluster : owner
 	FetchAsgOfEks(string) ([][]string, error)
 
+	// Returns names of all asgs of the eks cluster
+	ListAsgsOfEks(eksClusterName string) ([]string, error)
+
+	// Returns the surge a rollout of the asg in batches of batchSize
+	// nodes needs, i.e. instances, vCPUs and ips on top of its desired
+	// capacity
+	GetSurgeRequirements(asgName string, batchSize int64) (SurgeRequirements, error)
+
 	// Returns desired capacity of the provided asg
 	GetDesiredCount(asgName string) (int64, error)
 
@@ -104,23 +130,39 @@ type AsgRolloutClient interface {
 	// Returns ec2 image details of the provided ami ids
 	GetAmiDetails(imageIds []*string) ([]*ec2.Image, error)
 
-	// Returns Private DNS Name of the provided instanceId
+	// Returns name of the k8s node the instance registered as, empty if
+	// the instance hasn't registered with the cluster yet
 	GetNodeNameFromInstanceId(instanceId string) (*string, error)
 
+	// Returns the mapping of all instances of the asg to the k8s nodes they
+	// registered as, matched by the spec.providerID of the nodes
+	GetNodeMapping(asgName string) (*NodeMapping, error)
+
 	// Returns health status of the asg
 	GetAsgHealth(asgName string) (bool, error)
 
+	// Returns k8s node names of old instances in all asgs of the eks
+	// cluster
+	GetNodesToRollout(eksClusterName string) ([]string, error)
+
+	// Returns k8s node names of old instances of the asg
+	GetNodesToRolloutOfAsg(asgName string) ([]string, error)
+
 	// Separate out old and new instances for this asg
 	GetOldnNewInstancesOfAsg(
 		string,
 	) (oldInstances []*string, newInstances []*string, err error)
 
-	// Returns instance id from the instance's Private DNS Name
+	// Returns id of the instance of this asg backing the k8s node
 	GetInstanceIdFromNodeName(nodeName, asgName string) (string, error)
 
 	// Returns eks version from the ami name
 	GetEksVersionFromAmiName(amiName string) (string, error)
 
+	// Returns ec2 image details of the ami new instances of the asg are
+	// launched with
+	GetTargetAmiOfAsg(asgName string) (*ec2.Image, error)
+
 	// Returns an array of same length as instanceIds. If result[i] has
 	// value true, instanceIds[i] is a new instance otherwise old.
 	AreInstancesNew(
@@ -130,17 +172,28 @@ type AsgRolloutClient interface {
 	// Returns value of tag tagKey for this asg
 	GetTagValueOfAsg(asgName, tagKey string) (int64, error)
 
+	// Returns all tags of this asg keyed by tag key
+	GetTagsOfAsg(asgName string) (map[string]string, error)
+
 	// Deletes tag with key tagKey of this asg
 	DeleteTagOfAsg(asgName, tagKey, tagVal string) error
 
+	// Returns the configuration of this asg stored by pre rollout, nil if
+	// the asg has no snapshot
+	GetAsgSnapshot(asgName string) (*AsgSnapshot, error)
+
+	// Reapplies the snapshot of this asg exactly, including its desired
+	// capacity and suspended processes, and deletes it
+	RestoreAsgSnapshot(asgName string, eventLogs chan string) error
+
 	// Checks if dockyard asg upgrade has already been started
 	UpgradeStarted(asgName string) (bool, error)
 
 	// Checks if dockyard rollout has completed
 	RolloutCompleted(asgName string) (bool, error)
 
-	// Perform prerollout steps of the asg such as add node-state, min
-	// and max tags on asg etc
+	// Perform prerollout steps of the asg such as add node-state labels
+	// and snapshot the asg configuration
 	PreRolloutStart(asgName string, eventLogs chan string, rolloutProgressChan RolloutProgressChan) error
 
 	//Returns array of new instance ids for this asg
@@ -150,8 +203,8 @@ type AsgRolloutClient interface {
 	// batchSize nodes
 	NodesToDrain(asgName string, batchSize int) ([]string, error)
 
-	// Perform post rolloout steps like clean up tags, restoring min
-	// and max of the asg
+	// Perform post rolloout steps like clean up labels and restoring the
+	// asg configuration from its snapshot
 	PostRolloutStart(
 		asgName string,
 		rolloutProgressChan RolloutProgressChan,
@@ -168,8 +221,8 @@ type AsgRolloutClient interface {
 		eventLogs chan string,
 	) error
 
-	// Terminate instance with private DNS name nodeName
-	// of this asg
+	// Terminate the instance of this asg backing the k8s node
+	// nodeName
 	TerminateInstance(nodeName, asgName string) error
 
 	// Returns healthy status of the instance
@@ -178,6 +231,33 @@ type AsgRolloutClient interface {
 	// Disables instance scale in protection for this particular
 	// instance in asg
 	RemoveInstanceScaleInProtection(instanceId, asgName string) error
+
+	// Returns the jobs which nodes under rollout are waiting on
+	JobsWaitedOn() [][]string
+
+	// Acquires the rollout lease of the asg and renews it till release is
+	// called or ctx is done. Fails with a *kube.RolloutLeaseHeldError if
+	// another dockyard process holds it, an expired lease is only taken
+	// over if takeover is set. Once the lease is taken over by another
+	// process or can't be renewed before it expires, the rollout of the asg
+	// is aborted and its post rollout fails.
+	HoldRollout(
+		ctx context.Context,
+		asgName string,
+		takeover bool,
+	) (release func(), err error)
+
+	// Returns the rollout lease of the asg, nil if no dockyard process
+	// holds it
+	GetRolloutLease(asgName string) (*kube.RolloutLease, error)
+
+	// Returns the leftovers of interrupted rollouts of the asg, of all
+	// asgs and nodes of the cluster and the account if asgName is empty
+	FindLeftovers(asgName string) ([]Leftover, error)
+
+	// Restores the clean state of the leftovers with the post rollout
+	// steps, skipping asgs held by a live dockyard process
+	FixLeftovers(leftovers []Leftover, eventLogs chan string) error
 }
 
 // Fetches all Auto Scaling groups in the region
@@ -211,7 +291,7 @@ func StringPointerSliceContains(slice []*string, item *string) bool {
 func (asgRollout *asgRolloutClient) EnableNewInstanceProtection(
 	asgName string,
 ) error {
-	svc := autoscaling.New(asgRollout.session)
+	svc := asgRollout.autoScalingCl
 	input := &autoscaling.UpdateAutoScalingGroupInput{
 		AutoScalingGroupName:             aws.String(asgName),
 		NewInstancesProtectedFromScaleIn: aws.Bool(true),
@@ -225,7 +305,7 @@ func (asgRollout *asgRolloutClient) DisableNewInstanceProtection(
 	asgName string,
 ) error {
 
-	svc := autoscaling.New(asgRollout.session)
+	svc := asgRollout.autoScalingCl
 	input := &autoscaling.UpdateAutoScalingGroupInput{
 		AutoScalingGroupName:             aws.String(asgName),
 		NewInstancesProtectedFromScaleIn: aws.Bool(false),
@@ -236,7 +316,7 @@ func (asgRollout *asgRolloutClient) DisableNewInstanceProtection(
 
 // Returns health status of the asg
 func (asgRollout *asgRolloutClient) GetAsgHealth(asgName string) (bool, error) {
-	asg, err := getAsg(asgName, asgRollout.session)
+	asg, err := getAsg(asgName, asgRollout.autoScalingCl)
 	if err != nil {
 		return false, err
 	}
@@ -297,8 +377,8 @@ func (asgRollout *asgRolloutClient) RolloutCompleted(
 	}
 }
 
-// Perform prerollout steps of the asg such as add node-state labels, min
-// and max tags on asg etc
+// Perform prerollout steps of the asg such as add node-state labels and
+// snapshot the asg configuration
 func (asgRollout *asgRolloutClient) PreRolloutStart(
 	asgName string,
 	eventLogs chan string,
@@ -312,149 +392,128 @@ func (asgRollout *asgRolloutClient) PreRolloutStart(
 		log.Errorf("unable to fetch instance details for asg %s", asgName)
 		return fmt.Errorf("Unable to fetch Instances of asg %s", asgName)
 	}
+	mapping, err := asgRollout.mapNodes(append(instances, newInstances...))
+	if err != nil {
+		log.Errorf("unable to map instances of asg %s to k8s nodes due to %s", asgName, err.Error())
+		return fmt.Errorf("Unable to get k8s Nodes of asg %s", asgName)
+	}
 
 	// labelling new instances
 	for _, instance := range newInstances {
-		k8sNode, err := asgRollout.GetNodeNameFromInstanceId(*instance)
-		if err != nil {
-			log.Errorf("unable to fetch k8sNode for instance %s due to %s", *instance, err.Error())
-			return fmt.Errorf(
-				"Unable to get k8s Node for instance with id %s",
-				*instance,
-			)
-		}
-		if len(*k8sNode) == 0 {
+		k8sNode := mapping.NodeName(*instance)
+		if len(k8sNode) == 0 {
 			continue
 		}
-		eventLogs <- fmt.Sprintf("Ignoring node %s for rollout", *k8sNode)
-		log.Infof("Ignoring node %s for rollout ", *k8sNode)
+		eventLogs <- fmt.Sprintf("Ignoring node %s for rollout", k8sNode)
+		log.Infof("Ignoring node %s for rollout ", k8sNode)
 		err = asgRollout.kube.AddLabelToNode(
-			*k8sNode,
+			k8sNode,
 			NodeStateLabelKey,
 			"new",
 			asgRollout.rolloutConfig.IgnoreNotFound,
 		)
 		if err != nil {
-			log.Errorf("Unable to label k8s Node %s due to ", *k8sNode, err.Error())
+			log.Errorf("Unable to label k8s Node %s due to %s", k8sNode, err.Error())
 			return fmt.Errorf("Unable to label k8s Node %s", err.Error())
 		}
+		// New nodes stay schedulable so that evicted pods can land on them
+		err = asgRollout.kube.RemoveTaint(
+			k8sNode,
+			RollingTaintKey,
+			asgRollout.rolloutConfig.IgnoreNotFound,
+		)
+		if err != nil {
+			log.Errorf("Unable to remove taint of k8s Node %s due to %s", k8sNode, err.Error())
+			return fmt.Errorf("Unable to remove taint of k8s Node %s", err.Error())
+		}
 	}
 
 	// labelling old instances
 	for _, instance := range instances {
-		k8sNode, err := asgRollout.GetNodeNameFromInstanceId(*instance)
-		if err != nil {
-			log.Errorf("unable to fetch k8sNode for instance %s due to %s", *instance, err.Error())
-			return fmt.Errorf(
-				"Unable to get k8s Node for instance with id %s",
-				*instance,
-			)
-		}
-		if len(*k8sNode) == 0 {
+		k8sNode := mapping.NodeName(*instance)
+		if len(k8sNode) == 0 {
 			continue
 		}
-		eventLogs <- fmt.Sprintf("Marking node %s for rollout", *k8sNode)
-		log.Infof("Marking node %s for rollout ", *k8sNode)
+		eventLogs <- fmt.Sprintf("Marking node %s for rollout", k8sNode)
+		log.Infof("Marking node %s for rollout ", k8sNode)
 		err = asgRollout.kube.AddLabelToNode(
-			*k8sNode,
+			k8sNode,
 			NodeStateLabelKey,
 			"old",
 			asgRollout.rolloutConfig.IgnoreNotFound,
 		)
 		if err != nil {
-			log.Errorf("Unable to label k8s Node %s due to %s", *k8sNode, err.Error())
+			log.Errorf("Unable to label k8s Node %s due to %s", k8sNode, err.Error())
 			return fmt.Errorf("Unable to label k8s Node %s", err.Error())
 		}
-	}
 
-	asgMax, err := asgRollout.GetMaxCount(asgName)
-	if err != nil {
-		log.Errorf("Unable to fetch max count for asg %s due to %s", asgName, err.Error())
-		return err
+		effect := TaintEffectNoSchedule
+		if asgRollout.rolloutConfig.PreferNoSchedule {
+			effect = TaintEffectPreferNoSchedule
+		}
+		eventLogs <- fmt.Sprintf("Taint node %s with %s:%s", k8sNode, RollingTaintKey, effect)
+		log.Infof("Taint node %s with %s:%s", k8sNode, RollingTaintKey, effect)
+		err = asgRollout.kube.TaintNode(
+			k8sNode,
+			RollingTaintKey,
+			"true",
+			effect,
+			asgRollout.rolloutConfig.IgnoreNotFound,
+		)
+		if err != nil {
+			log.Errorf("Unable to taint k8s Node %s due to %s", k8sNode, err.Error())
+			return fmt.Errorf("Unable to taint k8s Node %s", err.Error())
+		}
 	}
-	asgMin, err := asgRollout.GetMinCount(asgName)
+
+	// Snapshot is only taken by the first pre rollout of the asg, a resumed
+	// rollout must not overwrite it with the capacity changed by dockyard.
+	// Rollouts started before snapshots existed keep their min/max tags.
+	snapshot, err := asgRollout.GetAsgSnapshot(asgName)
 	if err != nil {
-		log.Errorf("Unable to fetch min count for asg %s due to %s", asgName, err.Error())
+		log.Errorf("Unable to fetch snapshot of asg %s due to %s", asgName, err.Error())
 		return err
 	}
-	asgDesired, err := asgRollout.GetDesiredCount(asgName)
+	tags, err := asgRollout.GetTagsOfAsg(asgName)
 	if err != nil {
-		log.Errorf("Unable to fetch desired count for asg %s due to %s", asgName, err.Error())
+		log.Errorf("Unable to fetch tags of asg %s due to %s", asgName, err.Error())
 		return err
 	}
-
-	desiredNodes, _ := asgRollout.GetTagValueOfAsg(
-		asgName,
-		"dockyard.io/desired",
-	)
-
-	//	First Time Pre rollout execution since tags are already stored
-	// No need to store tags again
-	if desiredNodes == 0 {
-		eventLogs <- "Storing intial asg state in asg tags"
-		err = asgRollout.AddTagToAsG(
-			asgName,
-			"dockyard.io/min",
-			strconv.Itoa(int(asgMin)),
-		)
+	if _, legacy := tags[legacyMinTagKey]; snapshot == nil && !legacy {
+		eventLogs <- "Storing initial asg state in a snapshot"
+		snapshot, err = asgRollout.takeSnapshot(asgName)
 		if err != nil {
-			log.Errorf("Unable tag asg %s due to %s", asgName, err.Error())
+			log.Errorf("Unable to snapshot asg %s due to %s", asgName, err.Error())
 			return err
 		}
-		log.Infof("Asg %s tagged with key=dockyard.io/min value=%s ", asgName, asgMin)
-		err = asgRollout.AddTagToAsG(
-			asgName,
-			"dockyard.io/max",
-			strconv.Itoa(int(asgMax)),
-		)
+		err = asgRollout.saveSnapshot(snapshot)
 		if err != nil {
-			log.Errorf("Unable tag asg %s due to %s", asgName, err.Error())
-			return err
+			log.Errorf("Unable to save snapshot of asg %s due to %s", asgName, err.Error())
+			return fmt.Errorf("Unable to save snapshot of asg %s, %s", asgName, err.Error())
 		}
-		log.Infof("Asg %s tagged with key=dockyard.io/max value=%s", asgName, asgMax)
-		err = asgRollout.AddTagToAsG(
+		log.Infof(
+			"Asg %s snapshotted with min=%d max=%d desired=%d",
 			asgName,
-			"dockyard.io/desired",
-			strconv.Itoa(int(asgDesired)),
+			snapshot.MinSize,
+			snapshot.MaxSize,
+			snapshot.DesiredCapacity,
 		)
-		if err != nil {
-			log.Errorf("Unable tag asg %s due to %s", asgName, err.Error())
-			return err
-		}
-		log.Infof("Asg %s tagged with key=dockyard.io/desired value=%s", asgName, asgDesired)
 	}
 
-	currentAsgNodes := make([]string, 0)
-	inst, err := asgRollout.GetInstancesOfAsg(asgName)
+	nodeNames := make([]string, 0, len(instances)+len(newInstances))
+	for _, instance := range append(instances, newInstances...) {
+		if k8sNode := mapping.NodeName(*instance); len(k8sNode) != 0 {
+			nodeNames = append(nodeNames, k8sNode)
+		}
+	}
+	err = asgRollout.pauseClusterAutoscaler(asgName, nodeNames, eventLogs)
 	if err != nil {
 		return err
 	}
 
-	for _, i := range inst {
-		kNode, err := asgRollout.GetNodeNameFromInstanceId(*i)
-
-		if err != nil {
-			log.Errorf("Unable k8sNode from instanceid %s due to %s", *i, err.Error())
-			return fmt.Errorf(
-				"Unable to get k8s Node for instance with id %s",
-				*kNode,
-			)
-		}
-		currentAsgNodes = append(currentAsgNodes, *kNode)
-	}
-
-	for _, k8sNode := range currentAsgNodes {
-		if len(k8sNode) == 0 {
-			continue
-		}
-		// Handle error
-		eventLogs <- fmt.Sprintf("Cordon node %s", k8sNode)
-		log.Infof("Cordon node %s", k8sNode)
-		err = asgRollout.kube.CordonNode(k8sNode, asgRollout.rolloutConfig.IgnoreNotFound)
-		if err != nil {
-			log.Errorf("Unable to cordon Node %s due to %s ", k8sNode, err.Error())
-			return fmt.Errorf("Unable to cordon Node %s,%s ", k8sNode, err)
-		}
+	err = asgRollout.suspendProcesses(asgName, eventLogs)
+	if err != nil {
+		return err
 	}
 
 	eventLogs <- fmt.Sprintf("Enabling new instance protection for asg %s", asgName)
@@ -470,8 +529,8 @@ func (asgRollout *asgRolloutClient) PreRolloutStart(
 
 	//}
 
-	progress.StepsDone = 1
-	rolloutProgressChan <- *progress
+	asgRollout.progress.StepsDone = 1
+	rolloutProgressChan <- *asgRollout.progress
 
 	eventLogs <- "Pre rollout steps executed"
 	log.Infof("Pre rollout steps executed for asg %s", asgName)
@@ -504,8 +563,8 @@ func (asgRollout *asgRolloutClient) NodesToDrain(
 	return []string{}, nil
 }
 
-// Perform post rolloout steps like clean up tags, restoring min
-// and max of the asg
+// Perform post rolloout steps like clean up labels and restoring the
+// asg configuration from its snapshot
 func (asgRollout *asgRolloutClient) PostRolloutStart(
 	asgName string,
 	rolloutProgressChan RolloutProgressChan,
@@ -513,8 +572,35 @@ func (asgRollout *asgRolloutClient) PostRolloutStart(
 	rolloutSuccess bool,
 ) error {
 
+	// Another process may roll the asg already, its state isn't touched
+	if err := asgRollout.lostRolloutLease(asgName); err != nil {
+		log.Errorf("Skipping post rollout of asg %s due to %s", asgName, err.Error())
+		return err
+	}
+
 	eventLogs <- fmt.Sprintf("Starting post rollout execution")
 	log.Infof("Starting post rollout execution for asg %s", asgName)
+
+	// Taints are removed first so that an aborted rollout never leaves
+	// unschedulable nodes behind. A failed removal doesn't stop the rest
+	// of the post rollout, it is returned once the asg is restored.
+	taintErr := asgRollout.removeRollingTaints(asgName, eventLogs)
+	if taintErr != nil {
+		eventLogs <- fmt.Sprintf("Unable to remove rolling taints, %s", taintErr.Error())
+	}
+
+	// A failed restore keeps the cluster-autoscaler and processes tags so
+	// that the next post rollout retries, it must not keep min and max from
+	// being restored
+	err := asgRollout.resumeClusterAutoscaler(asgName, eventLogs)
+	if err != nil {
+		eventLogs <- fmt.Sprintf("Unable to restore cluster-autoscaler, %s", err.Error())
+	}
+	err = asgRollout.resumeProcesses(asgName, eventLogs)
+	if err != nil {
+		eventLogs <- fmt.Sprintf("Unable to resume processes, %s", err.Error())
+	}
+
 	nodes, err := asgRollout.kube.GetNodeByLabel(
 		getNodeStateLabel("new"),
 		asgRollout.rolloutConfig.IgnoreNotFound,
@@ -560,130 +646,98 @@ func (asgRollout *asgRolloutClient) PostRolloutStart(
 		log.Infof("Removing label %s for node %s ", NodeStateLabelKey, node.Name)
 	}
 
-	currentAsgNodes := make([]string, 0)
-	inst, err := asgRollout.GetInstancesOfAsg(asgName)
+	snapshot, err := asgRollout.GetAsgSnapshot(asgName)
 	if err != nil {
-		log.Errorf("Unable to fetch instance of asg %s due to %s", asgName, err.Error())
+		log.Errorf("Unable to fetch snapshot of asg %s due to %s", asgName, err.Error())
 		return err
 	}
-
-	for _, i := range inst {
-		kNode, _ := asgRollout.GetNodeNameFromInstanceId(*i)
-		currentAsgNodes = append(currentAsgNodes, *kNode)
-	}
-
-	for _, node := range currentAsgNodes {
-		if len(node) == 0 {
-			continue
+	if snapshot != nil {
+		// Desired capacity is left to the asg, the rollout replaced its
+		// instances and processes were resumed above
+		err = asgRollout.restoreSnapshot(snapshot, false, eventLogs)
+		if err != nil {
+			return err
+		}
+		eventLogs <- fmt.Sprintf("Deleting snapshot of asg %s", asgName)
+		log.Infof("Deleting snapshot of asg %s", asgName)
+		err = asgRollout.kube.DeleteAsgSnapshot(asgName)
+		if err != nil {
+			log.Errorf("Unable to delete snapshot of asg %s due to %s", asgName, err.Error())
+			return err
+		}
+	} else {
+		err = asgRollout.restoreLegacyTags(asgName, eventLogs)
+		if err != nil {
+			return err
+		}
+		if !rolloutSuccess {
+			eventLogs <- fmt.Sprintf("Disabling new Instance Protection for asg %s", asgName)
+			err := asgRollout.DisableNewInstanceProtection(asgName)
+			if err != nil {
+				log.Errorf("Unable to unset instance protection of asg %s due to %s", asgName, err.Error())
+				eventLogs <- fmt.Sprintf("Unable to unset new Instance Protection %s", err.Error())
+			}
 		}
-		// Handle error
-		eventLogs <- fmt.Sprintf("Uncordon node  %s", node)
-		log.Infof("Uncordon node %s", node)
-		asgRollout.kube.UnCordonNode(node, asgRollout.rolloutConfig.IgnoreNotFound)
-	}
-
-	minNodes, err := asgRollout.GetTagValueOfAsg(asgName, "dockyard.io/min")
-
-	if err != nil {
-		log.Errorf("Unable to fetch tags of asg %s due to %s", asgName, err.Error())
-		return err
-	}
-	maxNodes, err := asgRollout.GetTagValueOfAsg(asgName, "dockyard.io/max")
-
-	if err != nil {
-		log.Errorf("Unable to fetch tags of asg %s due to %s", asgName, err.Error())
-		return err
-	}
-	desiredNodes, err := asgRollout.GetTagValueOfAsg(
-		asgName,
-		"dockyard.io/desired",
-	)
-
-	if err != nil {
-		log.Errorf("Unable to fetch tags of asg %s due to %s", asgName, err.Error())
-		return err
-	}
-
-	err = asgRollout.DeleteTagOfAsg(
-		asgName,
-		"dockyard.io/min",
-		strconv.Itoa(int(minNodes)),
-	)
-
-	if err != nil {
-		return err
 	}
 
-	eventLogs <- fmt.Sprintf("Deleting tag %s of asg %s", "dockyard.io/max", asgName)
-	log.Infof("Deleting tag %s of asg %s ", "dockyard.io/max", asgName)
-	err = asgRollout.DeleteTagOfAsg(
-		asgName,
-		"dockyard.io/max",
-		strconv.Itoa(int(maxNodes)),
-	)
-
-	if err != nil {
-		log.Errorf("Unable to delete tags of asg %s due to %s", asgName, err.Error())
-		return err
+	if !rolloutSuccess {
+		eventLogs <- fmt.Sprintf("Post rollout steps executed")
+		log.Infof("Post rollout steps executed for asg %s", asgName)
+		return taintErr
 	}
+	// Stuck issue if post excuted before successful rollout
+	asgRollout.progress.StepsDone = 1
+	rolloutProgressChan <- *asgRollout.progress
 
-	eventLogs <- fmt.Sprintf("Deleting tag %s of asg %s", "dockyard.io/desired", asgName)
-	log.Infof("Deleting tag %s of asg %s", "dockyard.io/desired", asgName)
-	err = asgRollout.DeleteTagOfAsg(
-		asgName,
-		"dockyard.io/desired",
-		strconv.Itoa(int(desiredNodes)),
-	)
-
-	if err != nil {
-		log.Errorf("Unable to delete tags of asg %s due to %s", asgName, err.Error())
-		return err
-	}
-	log.Infof("Updating desired count %s for asgName %s ", desiredNodes, asgName)
-	err = asgRollout.SetMinCount(asgName, minNodes)
+	eventLogs <- fmt.Sprintf("Post rollout steps executed")
+	//close(eventLogs)
+	return taintErr
+}
 
-	eventLogs <- fmt.Sprintf("Updating min count of asg %s to previous state ", asgName)
-	if err != nil {
-		log.Errorf("Unable to update tags of asg %s due to %s", asgName, err.Error())
-		return err
-	}
-	log.Infof("Updating min count %s for asgName %s ", minNodes, asgName)
-	err = asgRollout.SetMaxCount(asgName, maxNodes)
-	eventLogs <- fmt.Sprintf("Updating max count of asg %s to previous state ", asgName)
+// Removes the dockyard rolling taint from all nodes of the asg
+func (asgRollout *asgRolloutClient) removeRollingTaints(
+	asgName string,
+	eventLogs chan string,
+) error {
+	currentAsgNodes := make([]string, 0)
+	mapping, err := asgRollout.GetNodeMapping(asgName)
 	if err != nil {
-		log.Errorf("Unable to update tags of asg %s due to %s", asgName, err.Error())
+		log.Errorf("Unable to map instances of asg %s to k8s nodes due to %s", asgName, err.Error())
 		return err
 	}
 
-	log.Infof("Updating max count %s for asgName %s ", maxNodes, asgName)
-	time.Sleep(1 * time.Minute)
-	instances, _ := asgRollout.GetInstancesOfAsg(asgName)
-
-	for _, instance := range instances {
-		eventLogs <- fmt.Sprintf("Removing instance scale in protection for instance %s ", *instance)
-		// handle error
-		asgRollout.RemoveInstanceScaleInProtection(*instance, asgName)
-		log.Infof("Removing instance scale in protection for instance %s,%s", *instance, asgName)
+	for _, instanceId := range mapping.InstanceIds {
+		currentAsgNodes = append(currentAsgNodes, mapping.NodeName(instanceId))
 	}
 
-	if !rolloutSuccess {
-		eventLogs <- fmt.Sprintf("Disabling new Instance Protection for asg %s", asgName)
-		err := asgRollout.DisableNewInstanceProtection(asgName)
+	// Every node is attempted, failures are reported together
+	failed := make([]string, 0)
+	for _, node := range currentAsgNodes {
+		if len(node) == 0 {
+			continue
+		}
+		eventLogs <- fmt.Sprintf("Removing taint %s of node %s", RollingTaintKey, node)
+		log.Infof("Removing taint %s of node %s", RollingTaintKey, node)
+		err := asgRollout.kube.RemoveTaint(
+			node,
+			RollingTaintKey,
+			asgRollout.rolloutConfig.IgnoreNotFound,
+		)
 		if err != nil {
-			log.Errorf("Unable to unset instance protection of asg %s due to %s", asgName, err.Error())
-			eventLogs <- fmt.Sprintf("Unable to unset new Instance Protection %s", err.Error())
+			failed = append(failed, node)
+			log.Errorf("Unable to remove taint of node %s due to %s", node, err.Error())
+			eventLogs <- fmt.Sprintf("Unable to remove taint of node %s, %s", node, err.Error())
 		}
-		log.Infof("Removed instance scale in protection for asg %s", asgName)
-		eventLogs <- fmt.Sprintf("Post rollout steps executed")
-		log.Infof("Post rollout steps executed for asg %s", asgName)
-		return nil
 	}
-	// Stuck issue if post excuted before successful rollout
-	progress.StepsDone = 1
-	rolloutProgressChan <- *progress
+	if len(failed) != 0 {
+		return fmt.Errorf(
+			"Unable to remove taint %s of %d nodes ( %s )",
+			RollingTaintKey,
+			len(failed),
+			strings.Join(failed, ","),
+		)
+	}
 
-	eventLogs <- fmt.Sprintf("Post rollout steps executed")
-	//close(eventLogs)
 	return nil
 }
 
@@ -696,7 +750,22 @@ func (asgRollout *asgRolloutClient) StartRollout(
 	batchSize int64,
 	rolloutProgressChan RolloutProgressChan,
 	eventLogs chan string,
-) error {
+) (err error) {
+	// Keeps concurrent dockyard processes from rolling the asg, the lease
+	// is already held if the caller holds it across post rollout. The
+	// rollout is aborted once the lease is lost.
+	ctx, release, err := asgRollout.holdRollout(ctx, asgName, false)
+	if err != nil {
+		return err
+	}
+	defer release()
+	defer func() {
+		if lostErr := asgRollout.lostRolloutLease(asgName); lostErr != nil {
+			eventLogs <- fmt.Sprintf("Aborting rollout, %s", lostErr.Error())
+			err = lostErr
+		}
+	}()
+
 	oldInstances, _, err := asgRollout.GetOldnNewInstancesOfAsg(asgName)
 	if err != nil {
 		return fmt.Errorf("Unable to fetch Instances of asg %s", asgName)
@@ -704,9 +773,9 @@ func (asgRollout *asgRolloutClient) StartRollout(
 	countOldInstances := len(oldInstances)
 
 	// +2 is for executing preRollout, postRollout
-	progress.TotalSize = int32(countOldInstances + 2)
-	progress.StepsSize = int32(batchSize)
-	rolloutProgressChan <- *progress
+	asgRollout.progress.TotalSize = int32(countOldInstances + 2)
+	asgRollout.progress.StepsSize = int32(batchSize)
+	rolloutProgressChan <- *asgRollout.progress
 
 	err = asgRollout.PreRolloutStart(asgName, eventLogs, rolloutProgressChan)
 	if err != nil {
@@ -747,6 +816,9 @@ func (asgRollout *asgRolloutClient) StartRollout(
 	eventLogs <- fmt.Sprintf("Updating desired count of asg to %v", asgDesired+batchSize)
 	lastBatch := false
 	for i := 0; i < steps; i++ {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
 
 		// all instances joining after the last batch should not have instance protection enabled
 		if i == steps-1 {
@@ -790,8 +862,8 @@ func (asgRollout *asgRolloutClient) StartRollout(
 
 		////block till all olds nodes from batch is recycled
 		for err := range errChan {
-			progress.StepsDone = 1
-			rolloutProgressChan <- *progress
+			asgRollout.progress.StepsDone = 1
+			rolloutProgressChan <- *asgRollout.progress
 			if err != nil {
 				errors = append(errors, err)
 
@@ -834,9 +906,9 @@ func rolloutNode(
 	// TODO should propogate error channel here
 	var newNode string
 	nodeFound := make(chan string, 1)
-	errC := make(chan error)
+	errC := make(chan error, 1)
 
-	timeout := time.Duration(asgRollout.rolloutConfig.Timeout.NewNodeTimeout)
+	timeout := time.Duration(asgRollout.rolloutConfig.Timeout.NewNodeTimeout) * time.Second
 
 	ctxWithTimeout, cancel := context.WithTimeout(ctx, timeout)
 
@@ -856,9 +928,9 @@ func rolloutNode(
 			newNode = <-nodeFound
 			//errCh <- nil
 		}
-	case <-ctx.Done():
+	case <-ctxWithTimeout.Done():
 
-		switch ctx.Err() {
+		switch ctxWithTimeout.Err() {
 
 		case context.DeadlineExceeded:
 			errCh <- fmt.Errorf("unable to get new node, Timeout Exceeded")
@@ -867,7 +939,8 @@ func rolloutNode(
 		case context.Canceled:
 			errCh <- fmt.Errorf("unable to get new node")
 		}
-
+		cancel()
+		return
 	}
 	cancel()
 	isReady := make(chan error, 1)
@@ -876,23 +949,16 @@ func rolloutNode(
 	log.Infof("Waiting to provision new nodes for asg %s to be in healthy state", asgName)
 	// Parse context with timeout
 	go func(isReady chan error, ctx context.Context) {
-		for {
-			isHealthy, err := asgRollout.kube.IsNodeHealthy(
-				newNode,
-				asgRollout.rolloutConfig.IgnoreNotFound,
-			)
-			if err != nil {
-				isReady <- err
-				log.Errorf("Unable to fetch health status of the node")
-				return
-			}
-			if isHealthy {
-				isReady <- nil
-				return
-			} else {
-				time.Sleep(time.Duration(asgRollout.rolloutConfig.PeriodWait.WaitForReady) * time.Second)
-			}
+		err := asgRollout.kube.WaitForNodeReady(
+			ctx,
+			newNode,
+			time.Duration(asgRollout.rolloutConfig.PeriodWait.WaitForReady)*time.Second,
+			asgRollout.rolloutConfig.IgnoreNotFound,
+		)
+		if err != nil {
+			log.Errorf("Unable to fetch health status of the node")
 		}
+		isReady <- err
 	}(isReady, ctx)
 
 	// NewNode is healthy
@@ -911,6 +977,37 @@ func rolloutNode(
 			errCh <- err
 			return
 		}
+		if asgRollout.rolloutConfig.ClusterAutoscalerMode() == ClusterAutoscalerModeAnnotate {
+			err = asgRollout.disableScaleDown(newNode, eventLogs)
+			if err != nil {
+				errCh <- err
+				return
+			}
+		}
+		// Node was only softly tainted while waiting for its turn, it is
+		// closed to new pods before its jobs are waited on
+		if asgRollout.rolloutConfig.PreferNoSchedule {
+			err = asgRollout.kube.TaintNode(
+				nodeName,
+				RollingTaintKey,
+				"true",
+				TaintEffectNoSchedule,
+				asgRollout.rolloutConfig.IgnoreNotFound,
+			)
+			if err != nil {
+				errCh <- err
+				return
+			}
+		}
+
+		if asgRollout.rolloutConfig.WaitForJobs {
+			err = asgRollout.waitForJobs(ctx, nodeName, eventLogs)
+			if err != nil {
+				errCh <- err
+				return
+			}
+		}
+
 		eventLogs <- fmt.Sprintf("Started draining node %s", nodeName)
 		log.Infof("Started drainng node %s", nodeName)
 		errs := asgRollout.kube.DrainNode(
@@ -940,6 +1037,12 @@ func rolloutNode(
 			errCh <- err
 			return
 		}
+		// The instance can't be mapped to the node once the node is deleted
+		instanceId, err := asgRollout.GetInstanceIdFromNodeName(nodeName, asgName)
+		if err != nil {
+			errCh <- err
+			return
+		}
 		// No error in all pods eviction
 		eventLogs <- fmt.Sprintf("Deleting Node %s ", nodeName)
 		log.Infof("Deleting node %s", nodeName)
@@ -957,7 +1060,7 @@ func rolloutNode(
 			}
 		}
 		log.Infof("Terminating instance %s of asg %s", nodeName, asgName)
-		errCh <- asgRollout.TerminateInstance(nodeName, asgName)
+		errCh <- asgRollout.terminateInstance(instanceId)
 		eventLogs <- fmt.Sprintf("Terminating Instance %s ", nodeName)
 	} else {
 		errCh <- isReadyError
@@ -974,14 +1077,18 @@ func (asgRollout *asgRolloutClient) TerminateInstance(
 	if err != nil {
 		return err
 	}
-	ec2Cl := ec2.New(asgRollout.session)
+	return asgRollout.terminateInstance(instanceId)
+}
+
+func (asgRollout *asgRolloutClient) terminateInstance(instanceId string) error {
+	ec2Cl := asgRollout.ec2Cl
 	input := &ec2.TerminateInstancesInput{
 		InstanceIds: []*string{
 			aws.String(instanceId),
 		},
 	}
 
-	_, err = ec2Cl.TerminateInstances(input)
+	_, err := ec2Cl.TerminateInstances(input)
 	return err
 }
 
@@ -993,7 +1100,7 @@ func getNodeStateLabel(state string) string {
 func (asgRollout *asgRolloutClient) RemoveInstanceScaleInProtection(
 	instanceId, asgName string,
 ) error {
-	svc := autoscaling.New(asgRollout.session)
+	svc := asgRollout.autoScalingCl
 	input := &autoscaling.SetInstanceProtectionInput{
 		AutoScalingGroupName: aws.String(asgName),
 		InstanceIds: []*string{
@@ -0,0 +1,935 @@
+package aws
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"dockyard/pkg/aws/fake"
+	"dockyard/pkg/kube"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/autoscaling"
+	appsv1 "k8s.io/api/apps/v1"
+	autoscalingv1 "k8s.io/api/autoscaling/v1"
+	corev1 "k8s.io/api/core/v1"
+	policy "k8s.io/api/policy/v1beta1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	k8sfake "k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+const (
+	testClusterName      = "test-cluster"
+	testAsgName          = "test-workers"
+	testLaunchTemplateId = "lt-0123456789"
+)
+
+type rolloutEnv struct {
+	cloud     *fake.Cloud
+	clientSet *k8sfake.Clientset
+	rollout   AsgRolloutClient
+	events    chan string
+	progress  RolloutProgressChan
+	// k8s nodes of the instances the asg was created with
+	oldNodes []string
+}
+
+// Creates an asg of two instances whose launch template got a new version
+// afterwards, so both instances have to be rolled. Every launched instance
+// registers a Ready k8s node running a pod of a deployment and a daemonset.
+func newRolloutEnv(t *testing.T) *rolloutEnv {
+	t.Helper()
+
+	env := &rolloutEnv{
+		cloud:     fake.NewCloud(),
+		clientSet: k8sfake.NewSimpleClientset(),
+		events:    make(chan string),
+		progress:  make(RolloutProgressChan),
+	}
+
+	// Fake client set doesn't implement evictions, evicted pods are deleted
+	env.clientSet.PrependReactor(
+		"create",
+		"pods",
+		func(action k8stesting.Action) (bool, runtime.Object, error) {
+			if action.GetSubresource() != "eviction" {
+				return false, nil, nil
+			}
+			eviction := action.(k8stesting.CreateAction).GetObject().(*policy.Eviction)
+			err := env.clientSet.Tracker().Delete(
+				corev1.SchemeGroupVersion.WithResource("pods"),
+				eviction.Namespace,
+				eviction.Name,
+			)
+			return true, nil, err
+		},
+	)
+
+	// Every describe call has to follow next tokens to see all items
+	env.cloud.SetPageSize(1)
+
+	env.cloud.OnLaunch(func(instance fake.Instance) {
+		env.registerNode(t, instance)
+	})
+
+	env.cloud.AddImage("ami-old", "amazon-eks-node-1.21-v20220824")
+	env.cloud.AddImage("ami-new", "amazon-eks-node-1.22-v20220914")
+	env.cloud.AddLaunchTemplateVersion(testLaunchTemplateId, "ami-old")
+	env.cloud.AddAsg(fake.AsgSpec{
+		Name:             testAsgName,
+		LaunchTemplateId: testLaunchTemplateId,
+		MinSize:          1,
+		MaxSize:          3,
+		DesiredCapacity:  2,
+		Tags: map[string]string{
+			"kubernetes.io/cluster/" + testClusterName: "owned",
+		},
+	})
+	env.cloud.AddLaunchTemplateVersion(testLaunchTemplateId, "ami-new")
+
+	for _, instance := range env.cloud.Instances(testAsgName) {
+		env.oldNodes = append(env.oldNodes, testNodeName(instance))
+	}
+
+	previousWait := scaleInSettleWait
+	scaleInSettleWait = 0
+	t.Cleanup(func() { scaleInSettleWait = previousWait })
+
+	kubeClient := kube.NewKubeClientWithClientSet(
+		env.clientSet,
+		"registry.example.com",
+		true,
+		testClusterName,
+	)
+	t.Cleanup(kubeClient.Close)
+	env.rollout = NewAsgRolloutWithClients(
+		env.cloud.AutoScaling(),
+		env.cloud.EC2(),
+		kubeClient,
+		&AsgRolloutConfig{
+			IgnoreNotFound: true,
+			EksClusterName: testClusterName,
+			PeriodWait: rolloutPeriod{
+				WaitForReady:   1,
+				WaitForNewNode: 1,
+				JobCompletion:  1,
+			},
+			Timeout: rolloutTimeout{
+				NewNodeTimeout:       30,
+				JobCompletionTimeout: 30,
+			},
+		},
+	)
+
+	// Rollout blocks on unread events and progress
+	done := make(chan struct{})
+	t.Cleanup(func() { close(done) })
+	go func() {
+		for {
+			select {
+			case <-done:
+				return
+			case <-env.events:
+			case <-env.progress:
+			}
+		}
+	}()
+
+	return env
+}
+
+// Nodes are named by resource name based hostnames, which differ from the
+// private dns names of their instances
+func testNodeName(instance fake.Instance) string {
+	return instance.InstanceId + ".ec2.internal"
+}
+
+func (env *rolloutEnv) registerNode(t *testing.T, instance fake.Instance) {
+	node := &corev1.Node{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: testNodeName(instance),
+			Labels: map[string]string{
+				"kubernetes.io/hostname": testNodeName(instance),
+			},
+		},
+		Spec: corev1.NodeSpec{
+			ProviderID: "aws:///us-east-1a/" + instance.InstanceId,
+		},
+		Status: corev1.NodeStatus{
+			Conditions: []corev1.NodeCondition{
+				{Type: corev1.NodeReady, Status: corev1.ConditionTrue},
+			},
+		},
+	}
+	_, err := env.clientSet.CoreV1().
+		Nodes().
+		Create(context.TODO(), node, metav1.CreateOptions{})
+	if err != nil {
+		t.Errorf("Unable to register node %s, %s", node.Name, err.Error())
+	}
+
+	owners := map[string]metav1.OwnerReference{
+		"app": {
+			APIVersion: appsv1.SchemeGroupVersion.String(),
+			Kind:       "ReplicaSet",
+			Name:       "app-5d8f7c",
+			Controller: boolPtr(true),
+		},
+		"agent": {
+			APIVersion: appsv1.SchemeGroupVersion.String(),
+			Kind:       "DaemonSet",
+			Name:       "agent",
+			Controller: boolPtr(true),
+		},
+	}
+	for prefix, owner := range owners {
+		pod := &corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:            prefix + "-" + instance.InstanceId,
+				Namespace:       "default",
+				OwnerReferences: []metav1.OwnerReference{owner},
+			},
+			Spec: corev1.PodSpec{NodeName: node.Name},
+			Status: corev1.PodStatus{
+				Phase: corev1.PodRunning,
+			},
+		}
+		_, err := env.clientSet.CoreV1().
+			Pods(pod.Namespace).
+			Create(context.TODO(), pod, metav1.CreateOptions{})
+		if err != nil {
+			t.Errorf("Unable to create pod %s, %s", pod.Name, err.Error())
+		}
+	}
+}
+
+func (env *rolloutEnv) getNode(t *testing.T, nodeName string) *corev1.Node {
+	t.Helper()
+	node, err := env.clientSet.CoreV1().
+		Nodes().
+		Get(context.TODO(), nodeName, metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("Unable to get node %s, %s", nodeName, err.Error())
+	}
+	return node
+}
+
+func hasRollingTaint(node *corev1.Node) (string, bool) {
+	for _, taint := range node.Spec.Taints {
+		if taint.Key == RollingTaintKey {
+			return string(taint.Effect), true
+		}
+	}
+	return "", false
+}
+
+func boolPtr(b bool) *bool {
+	return &b
+}
+
+func TestPreRolloutStart(t *testing.T) {
+	env := newRolloutEnv(t)
+
+	err := env.rollout.PreRolloutStart(testAsgName, env.events, env.progress)
+	if err != nil {
+		t.Fatalf("PreRolloutStart failed, %s", err.Error())
+	}
+
+	for _, nodeName := range env.oldNodes {
+		node := env.getNode(t, nodeName)
+		if state := node.Labels[NodeStateLabelKey]; state != "old" {
+			t.Errorf("Node %s has state %q, want old", nodeName, state)
+		}
+		if effect, ok := hasRollingTaint(node); !ok || effect != TaintEffectNoSchedule {
+			t.Errorf("Node %s has rolling taint %q, want %s", nodeName, effect, TaintEffectNoSchedule)
+		}
+	}
+
+	snapshot, err := env.rollout.GetAsgSnapshot(testAsgName)
+	if err != nil {
+		t.Fatalf("GetAsgSnapshot failed, %s", err.Error())
+	}
+	if snapshot == nil {
+		t.Fatalf("Asg %s has no snapshot", testAsgName)
+	}
+	if snapshot.MinSize != 1 || snapshot.MaxSize != 3 || snapshot.DesiredCapacity != 2 {
+		t.Errorf(
+			"Snapshot has min %d, max %d, desired %d, want 1, 3, 2",
+			snapshot.MinSize,
+			snapshot.MaxSize,
+			snapshot.DesiredCapacity,
+		)
+	}
+	if snapshot.NewInstancesProtectedFromScaleIn {
+		t.Errorf("Snapshot was taken after enabling new instance protection")
+	}
+
+	if !env.cloud.NewInstancesProtected(testAsgName) {
+		t.Errorf("New instances of asg %s are not protected from scale in", testAsgName)
+	}
+}
+
+func TestStartRollout(t *testing.T) {
+	env := newRolloutEnv(t)
+	oldInstances := env.cloud.Instances(testAsgName)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	err := env.rollout.StartRollout(ctx, testAsgName, 1, env.progress, env.events)
+	if err != nil {
+		t.Fatalf("StartRollout failed, %s", err.Error())
+	}
+	err = env.rollout.PostRolloutStart(testAsgName, env.progress, env.events, true)
+	if err != nil {
+		t.Fatalf("PostRolloutStart failed, %s", err.Error())
+	}
+
+	for _, old := range oldInstances {
+		instance, _ := env.cloud.Instance(old.InstanceId)
+		if !instance.Terminated {
+			t.Errorf("Old instance %s wasn't terminated", old.InstanceId)
+		}
+		_, err := env.clientSet.CoreV1().
+			Nodes().
+			Get(context.TODO(), testNodeName(old), metav1.GetOptions{})
+		if !apierrors.IsNotFound(err) {
+			t.Errorf("Old node %s wasn't deleted", testNodeName(old))
+		}
+		_, err = env.clientSet.CoreV1().
+			Pods("default").
+			Get(context.TODO(), "app-"+old.InstanceId, metav1.GetOptions{})
+		if !apierrors.IsNotFound(err) {
+			t.Errorf("Pod of old node %s wasn't evicted", testNodeName(old))
+		}
+	}
+
+	instances := env.cloud.Instances(testAsgName)
+	if len(instances) < 2 {
+		t.Errorf("Asg has %d instances, want at least 2", len(instances))
+	}
+	for _, instance := range instances {
+		if instance.LaunchTemplateVersion != 2 {
+			t.Errorf(
+				"Instance %s runs launch template version %d, want 2",
+				instance.InstanceId,
+				instance.LaunchTemplateVersion,
+			)
+		}
+		if instance.ProtectedFromScaleIn {
+			t.Errorf("Instance %s is still protected from scale in", instance.InstanceId)
+		}
+		node := env.getNode(t, testNodeName(instance))
+		if state, ok := node.Labels[NodeStateLabelKey]; ok {
+			t.Errorf("Node %s still has state %q", node.Name, state)
+		}
+		if _, ok := hasRollingTaint(node); ok {
+			t.Errorf("Node %s is still tainted", node.Name)
+		}
+	}
+
+	min, max, _ := env.cloud.Capacity(testAsgName)
+	if min != 1 || max != 3 {
+		t.Errorf("Asg min/max is %d/%d, want 1/3", min, max)
+	}
+	for key := range env.cloud.Tags(testAsgName) {
+		if key != "kubernetes.io/cluster/"+testClusterName {
+			t.Errorf("Tag %s wasn't removed", key)
+		}
+	}
+}
+
+func TestPostRolloutStartAfterAbort(t *testing.T) {
+	env := newRolloutEnv(t)
+
+	err := env.rollout.PreRolloutStart(testAsgName, env.events, env.progress)
+	if err != nil {
+		t.Fatalf("PreRolloutStart failed, %s", err.Error())
+	}
+	err = env.rollout.PostRolloutStart(testAsgName, env.progress, env.events, false)
+	if err != nil {
+		t.Fatalf("PostRolloutStart failed, %s", err.Error())
+	}
+
+	for _, nodeName := range env.oldNodes {
+		node := env.getNode(t, nodeName)
+		if state, ok := node.Labels[NodeStateLabelKey]; ok {
+			t.Errorf("Node %s still has state %q", nodeName, state)
+		}
+		if _, ok := hasRollingTaint(node); ok {
+			t.Errorf("Node %s is still tainted", nodeName)
+		}
+	}
+
+	if env.cloud.NewInstancesProtected(testAsgName) {
+		t.Errorf("New instances of asg %s are still protected from scale in", testAsgName)
+	}
+	min, max, desired := env.cloud.Capacity(testAsgName)
+	if min != 1 || max != 3 || desired != 2 {
+		t.Errorf("Asg min/max/desired is %d/%d/%d, want 1/3/2", min, max, desired)
+	}
+	if len(env.cloud.Instances(testAsgName)) != 2 {
+		t.Errorf("Aborted rollout changed the instances of the asg")
+	}
+}
+
+func TestPostRolloutStartReportsTaintFailures(t *testing.T) {
+	env := newRolloutEnv(t)
+
+	err := env.rollout.PreRolloutStart(testAsgName, env.events, env.progress)
+	if err != nil {
+		t.Fatalf("PreRolloutStart failed, %s", err.Error())
+	}
+
+	// Api server rejects the removal of the taint of the first node
+	stuck := env.oldNodes[0]
+	env.clientSet.PrependReactor(
+		"update",
+		"nodes",
+		func(action k8stesting.Action) (bool, runtime.Object, error) {
+			node := action.(k8stesting.UpdateAction).GetObject().(*corev1.Node)
+			if _, tainted := hasRollingTaint(node); node.Name != stuck || tainted {
+				return false, nil, nil
+			}
+			return true, nil, fmt.Errorf("admission webhook denied the request")
+		},
+	)
+
+	err = env.rollout.PostRolloutStart(testAsgName, env.progress, env.events, false)
+	if err == nil || !strings.Contains(err.Error(), stuck) {
+		t.Fatalf("PostRolloutStart returned %v, want an error naming node %s", err, stuck)
+	}
+
+	if _, ok := hasRollingTaint(env.getNode(t, stuck)); !ok {
+		t.Errorf("Node %s lost its taint", stuck)
+	}
+	for _, nodeName := range env.oldNodes {
+		node := env.getNode(t, nodeName)
+		if state, ok := node.Labels[NodeStateLabelKey]; ok {
+			t.Errorf("Node %s still has state %q", nodeName, state)
+		}
+		if _, ok := hasRollingTaint(node); ok && nodeName != stuck {
+			t.Errorf("Node %s is still tainted", nodeName)
+		}
+	}
+	// Rest of the post rollout isn't held back by the failure
+	min, max, desired := env.cloud.Capacity(testAsgName)
+	if min != 1 || max != 3 || desired != 2 {
+		t.Errorf("Asg min/max/desired is %d/%d/%d, want 1/3/2", min, max, desired)
+	}
+}
+
+// Adds a cluster-autoscaler deployment discovering the asgs of the test
+// cluster. Fake client set doesn't implement the scale subresource, scales
+// are served from the deployment.
+func (env *rolloutEnv) addClusterAutoscaler(t *testing.T, replicas int32) {
+	t.Helper()
+	deployment := &appsv1.Deployment{
+		ObjectMeta: metav1.ObjectMeta{Name: "cluster-autoscaler", Namespace: "kube-system"},
+		Spec: appsv1.DeploymentSpec{
+			Replicas: &replicas,
+			Template: corev1.PodTemplateSpec{
+				Spec: corev1.PodSpec{
+					Containers: []corev1.Container{{
+						Name:  "cluster-autoscaler",
+						Image: "registry.k8s.io/autoscaling/cluster-autoscaler:v1.22.3",
+						Command: []string{
+							"./cluster-autoscaler",
+							"--node-group-auto-discovery=asg:tag=kubernetes.io/cluster/" + testClusterName,
+						},
+					}},
+				},
+			},
+		},
+	}
+	_, err := env.clientSet.AppsV1().
+		Deployments(deployment.Namespace).
+		Create(context.TODO(), deployment, metav1.CreateOptions{})
+	if err != nil {
+		t.Fatalf("Unable to create deployment, %s", err.Error())
+	}
+
+	deployments := appsv1.SchemeGroupVersion.WithResource("deployments")
+	env.clientSet.PrependReactor(
+		"*",
+		"deployments",
+		func(action k8stesting.Action) (bool, runtime.Object, error) {
+			if action.GetSubresource() != "scale" {
+				return false, nil, nil
+			}
+			var name string
+			var update *autoscalingv1.Scale
+			switch action := action.(type) {
+			case k8stesting.GetAction:
+				name = action.GetName()
+			case k8stesting.UpdateAction:
+				update = action.GetObject().(*autoscalingv1.Scale)
+				name = update.Name
+			default:
+				return false, nil, nil
+			}
+			obj, err := env.clientSet.Tracker().Get(deployments, action.GetNamespace(), name)
+			if err != nil {
+				return true, nil, err
+			}
+			deployment := obj.(*appsv1.Deployment)
+			if update != nil {
+				deployment.Spec.Replicas = &update.Spec.Replicas
+				err = env.clientSet.Tracker().Update(deployments, deployment, deployment.Namespace)
+				if err != nil {
+					return true, nil, err
+				}
+			}
+			return true, &autoscalingv1.Scale{
+				ObjectMeta: deployment.ObjectMeta,
+				Spec:       autoscalingv1.ScaleSpec{Replicas: *deployment.Spec.Replicas},
+			}, nil
+		},
+	)
+}
+
+func (env *rolloutEnv) clusterAutoscalerReplicas(t *testing.T) int32 {
+	t.Helper()
+	deployment, err := env.clientSet.AppsV1().
+		Deployments("kube-system").
+		Get(context.TODO(), "cluster-autoscaler", metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("Unable to get cluster-autoscaler, %s", err.Error())
+	}
+	return *deployment.Spec.Replicas
+}
+
+func TestClusterAutoscalerScaleToZero(t *testing.T) {
+	env := newRolloutEnv(t)
+	env.addClusterAutoscaler(t, 2)
+	env.rollout.(*asgRolloutClient).rolloutConfig.ClusterAutoscaler.Mode = ClusterAutoscalerModeScaleToZero
+
+	err := env.rollout.PreRolloutStart(testAsgName, env.events, env.progress)
+	if err != nil {
+		t.Fatalf("PreRolloutStart failed, %s", err.Error())
+	}
+	if replicas := env.clusterAutoscalerReplicas(t); replicas != 0 {
+		t.Errorf("Cluster-autoscaler has %d replicas during the rollout, want 0", replicas)
+	}
+	want := "kube-system/cluster-autoscaler=2"
+	if tag := env.cloud.Tags(testAsgName)[ClusterAutoscalerTagKey]; tag != want {
+		t.Errorf("Tag %s is %q, want %q", ClusterAutoscalerTagKey, tag, want)
+	}
+
+	// Resumed pre rollout must not record the scaled down replicas
+	err = env.rollout.PreRolloutStart(testAsgName, env.events, env.progress)
+	if err != nil {
+		t.Fatalf("PreRolloutStart failed, %s", err.Error())
+	}
+	if tag := env.cloud.Tags(testAsgName)[ClusterAutoscalerTagKey]; tag != want {
+		t.Errorf("Tag %s is %q after resume, want %q", ClusterAutoscalerTagKey, tag, want)
+	}
+
+	err = env.rollout.PostRolloutStart(testAsgName, env.progress, env.events, false)
+	if err != nil {
+		t.Fatalf("PostRolloutStart failed, %s", err.Error())
+	}
+	if replicas := env.clusterAutoscalerReplicas(t); replicas != 2 {
+		t.Errorf("Cluster-autoscaler has %d replicas after the rollout, want 2", replicas)
+	}
+	if tag, ok := env.cloud.Tags(testAsgName)[ClusterAutoscalerTagKey]; ok {
+		t.Errorf("Tag %s is still %q", ClusterAutoscalerTagKey, tag)
+	}
+}
+
+func TestClusterAutoscalerAnnotate(t *testing.T) {
+	env := newRolloutEnv(t)
+	env.rollout.(*asgRolloutClient).rolloutConfig.ClusterAutoscaler.Mode = ClusterAutoscalerModeAnnotate
+
+	err := env.rollout.PreRolloutStart(testAsgName, env.events, env.progress)
+	if err != nil {
+		t.Fatalf("PreRolloutStart failed, %s", err.Error())
+	}
+	for _, nodeName := range env.oldNodes {
+		node := env.getNode(t, nodeName)
+		if value := node.Annotations[kube.ScaleDownDisabledAnnotationKey]; value != "true" {
+			t.Errorf("Node %s has scale down disabled %q, want true", nodeName, value)
+		}
+	}
+
+	err = env.rollout.PostRolloutStart(testAsgName, env.progress, env.events, false)
+	if err != nil {
+		t.Fatalf("PostRolloutStart failed, %s", err.Error())
+	}
+	for _, nodeName := range env.oldNodes {
+		node := env.getNode(t, nodeName)
+		if value, ok := node.Annotations[kube.ScaleDownDisabledAnnotationKey]; ok {
+			t.Errorf("Node %s still has scale down disabled %q", nodeName, value)
+		}
+	}
+}
+
+func TestSuspendProcesses(t *testing.T) {
+	env := newRolloutEnv(t)
+	env.rollout.(*asgRolloutClient).rolloutConfig.SuspendProcesses = []string{
+		"AZRebalance",
+		"ReplaceUnhealthy",
+	}
+	_, err := env.cloud.AutoScaling().SuspendProcesses(&autoscaling.ScalingProcessQuery{
+		AutoScalingGroupName: aws.String(testAsgName),
+		ScalingProcesses:     aws.StringSlice([]string{"AZRebalance"}),
+	})
+	if err != nil {
+		t.Fatalf("Unable to suspend AZRebalance, %s", err.Error())
+	}
+
+	err = env.rollout.PreRolloutStart(testAsgName, env.events, env.progress)
+	if err != nil {
+		t.Fatalf("PreRolloutStart failed, %s", err.Error())
+	}
+	want := []string{"AZRebalance", "ReplaceUnhealthy"}
+	if processes := env.cloud.SuspendedProcesses(testAsgName); !reflect.DeepEqual(processes, want) {
+		t.Errorf("Suspended processes are %v during the rollout, want %v", processes, want)
+	}
+	if tag := env.cloud.Tags(testAsgName)[SuspendedProcessesTagKey]; tag != "ReplaceUnhealthy" {
+		t.Errorf("Tag %s is %q, want ReplaceUnhealthy", SuspendedProcessesTagKey, tag)
+	}
+
+	err = env.rollout.PostRolloutStart(testAsgName, env.progress, env.events, false)
+	if err != nil {
+		t.Fatalf("PostRolloutStart failed, %s", err.Error())
+	}
+	want = []string{"AZRebalance"}
+	if processes := env.cloud.SuspendedProcesses(testAsgName); !reflect.DeepEqual(processes, want) {
+		t.Errorf("Suspended processes are %v after the rollout, want %v", processes, want)
+	}
+	if tag, ok := env.cloud.Tags(testAsgName)[SuspendedProcessesTagKey]; ok {
+		t.Errorf("Tag %s is still %q", SuspendedProcessesTagKey, tag)
+	}
+}
+
+func TestSnapshotRestore(t *testing.T) {
+	env := newRolloutEnv(t)
+	autoScaling := env.cloud.AutoScaling()
+	instances := env.cloud.Instances(testAsgName)
+
+	_, err := autoScaling.UpdateAutoScalingGroup(&autoscaling.UpdateAutoScalingGroupInput{
+		AutoScalingGroupName: aws.String(testAsgName),
+		MinSize:              aws.Int64(0),
+		DefaultCooldown:      aws.Int64(120),
+	})
+	if err != nil {
+		t.Fatalf("Unable to update asg, %s", err.Error())
+	}
+	_, err = autoScaling.SetInstanceProtection(&autoscaling.SetInstanceProtectionInput{
+		AutoScalingGroupName: aws.String(testAsgName),
+		InstanceIds:          aws.StringSlice([]string{instances[0].InstanceId}),
+		ProtectedFromScaleIn: aws.Bool(true),
+	})
+	if err != nil {
+		t.Fatalf("Unable to protect instance, %s", err.Error())
+	}
+	_, err = autoScaling.SuspendProcesses(&autoscaling.ScalingProcessQuery{
+		AutoScalingGroupName: aws.String(testAsgName),
+		ScalingProcesses:     aws.StringSlice([]string{"AZRebalance"}),
+	})
+	if err != nil {
+		t.Fatalf("Unable to suspend AZRebalance, %s", err.Error())
+	}
+
+	err = env.rollout.PreRolloutStart(testAsgName, env.events, env.progress)
+	if err != nil {
+		t.Fatalf("PreRolloutStart failed, %s", err.Error())
+	}
+	// Changes during the rollout must not end up in the snapshot of a
+	// resumed pre rollout, whatever the desired capacity was before
+	_, err = autoScaling.UpdateAutoScalingGroup(&autoscaling.UpdateAutoScalingGroupInput{
+		AutoScalingGroupName: aws.String(testAsgName),
+		MinSize:              aws.Int64(2),
+		MaxSize:              aws.Int64(5),
+		DesiredCapacity:      aws.Int64(3),
+		DefaultCooldown:      aws.Int64(30),
+	})
+	if err != nil {
+		t.Fatalf("Unable to update asg, %s", err.Error())
+	}
+	err = env.rollout.PreRolloutStart(testAsgName, env.events, env.progress)
+	if err != nil {
+		t.Fatalf("Resumed PreRolloutStart failed, %s", err.Error())
+	}
+
+	err = env.rollout.RestoreAsgSnapshot(testAsgName, env.events)
+	if err != nil {
+		t.Fatalf("RestoreAsgSnapshot failed, %s", err.Error())
+	}
+
+	if min, max, desired := env.cloud.Capacity(testAsgName); min != 0 || max != 3 || desired != 2 {
+		t.Errorf("Asg has min %d, max %d, desired %d, want 0, 3, 2", min, max, desired)
+	}
+	if cooldown := env.cloud.Cooldown(testAsgName); cooldown != 120 {
+		t.Errorf("Asg has cooldown %d, want 120", cooldown)
+	}
+	if env.cloud.NewInstancesProtected(testAsgName) {
+		t.Errorf("New instances of asg %s are still protected from scale in", testAsgName)
+	}
+	want := []string{"AZRebalance"}
+	if processes := env.cloud.SuspendedProcesses(testAsgName); !reflect.DeepEqual(processes, want) {
+		t.Errorf("Suspended processes are %v, want %v", processes, want)
+	}
+	for i, instance := range instances {
+		current, _ := env.cloud.Instance(instance.InstanceId)
+		if current.ProtectedFromScaleIn != (i == 0) {
+			t.Errorf(
+				"Instance %s is protected %t, want %t",
+				instance.InstanceId,
+				current.ProtectedFromScaleIn,
+				i == 0,
+			)
+		}
+	}
+	if tag, ok := env.cloud.Tags(testAsgName)[SuspendedProcessesTagKey]; ok {
+		t.Errorf("Tag %s is still %q", SuspendedProcessesTagKey, tag)
+	}
+	snapshot, err := env.rollout.GetAsgSnapshot(testAsgName)
+	if err != nil || snapshot != nil {
+		t.Errorf("Snapshot of asg %s wasn't deleted, %v", testAsgName, err)
+	}
+}
+
+func TestReconcile(t *testing.T) {
+	env := newRolloutEnv(t)
+	rollout := env.rollout.(*asgRolloutClient)
+	rollout.rolloutConfig.SuspendProcesses = []string{"AZRebalance"}
+
+	// Tainted node which belongs to no asg
+	_, err := env.clientSet.CoreV1().Nodes().Create(
+		context.TODO(),
+		&corev1.Node{
+			ObjectMeta: metav1.ObjectMeta{Name: "stray-node"},
+			Spec: corev1.NodeSpec{
+				Taints: []corev1.Taint{{Key: RollingTaintKey, Effect: corev1.TaintEffectNoSchedule}},
+			},
+		},
+		metav1.CreateOptions{},
+	)
+	if err != nil {
+		t.Fatalf("Unable to create node, %s", err.Error())
+	}
+
+	// Rollout is interrupted right after pre rollout, with an instance
+	// launched and protected meanwhile
+	err = env.rollout.PreRolloutStart(testAsgName, env.events, env.progress)
+	if err != nil {
+		t.Fatalf("PreRolloutStart failed, %s", err.Error())
+	}
+	instanceId := env.cloud.Instances(testAsgName)[0].InstanceId
+	_, err = env.cloud.AutoScaling().SetInstanceProtection(&autoscaling.SetInstanceProtectionInput{
+		AutoScalingGroupName: aws.String(testAsgName),
+		InstanceIds:          aws.StringSlice([]string{instanceId}),
+		ProtectedFromScaleIn: aws.Bool(true),
+	})
+	if err != nil {
+		t.Fatalf("Unable to protect instance, %s", err.Error())
+	}
+
+	leftovers, err := env.rollout.FindLeftovers("")
+	if err != nil {
+		t.Fatalf("FindLeftovers failed, %s", err.Error())
+	}
+	kinds := map[string]int{}
+	for _, leftover := range leftovers {
+		kinds[leftover.Kind]++
+	}
+	want := map[string]int{
+		LeftoverTaint:                 3,
+		LeftoverNodeLabel:             2,
+		LeftoverTag:                   1,
+		LeftoverSnapshot:              1,
+		LeftoverInstanceProtection:    1,
+		LeftoverNewInstanceProtection: 1,
+	}
+	if !reflect.DeepEqual(kinds, want) {
+		t.Errorf("FindLeftovers found %v, want %v", kinds, want)
+	}
+
+	// Asg rolled by a live dockyard process is left alone
+	other := kube.RolloutLease{AsgName: testAsgName, Holder: "other/1", Duration: time.Minute}
+	if err := rollout.kube.AcquireRolloutLease(other, false); err != nil {
+		t.Fatalf("AcquireRolloutLease failed, %s", err.Error())
+	}
+	leftovers, err = env.rollout.FindLeftovers(testAsgName)
+	if err != nil {
+		t.Fatalf("FindLeftovers failed, %s", err.Error())
+	}
+	for _, leftover := range leftovers {
+		if leftover.HeldBy != "other/1" {
+			t.Errorf("Leftover %s %s is held by %q, want other/1", leftover.Kind, leftover.Resource, leftover.HeldBy)
+		}
+	}
+	if err := env.rollout.FixLeftovers(leftovers, env.events); err != nil {
+		t.Fatalf("FixLeftovers failed, %s", err.Error())
+	}
+	if _, ok := env.cloud.Tags(testAsgName)[SuspendedProcessesTagKey]; !ok {
+		t.Errorf("Asg %s under a live rollout was reconciled", testAsgName)
+	}
+
+	// Node outside of any asg may belong to the rolled asg
+	leftovers, err = env.rollout.FindLeftovers("")
+	if err != nil {
+		t.Fatalf("FindLeftovers failed, %s", err.Error())
+	}
+	stray := make([]Leftover, 0)
+	for _, leftover := range leftovers {
+		if leftover.Resource == "stray-node" {
+			stray = append(stray, leftover)
+		}
+	}
+	if len(stray) != 1 || stray[0].AsgName != "" || stray[0].HeldBy != "other/1" {
+		t.Errorf("FindLeftovers found %v for the stray node, want a taint held by other/1", stray)
+	}
+	if err := env.rollout.FixLeftovers(stray, env.events); err != nil {
+		t.Fatalf("FixLeftovers failed, %s", err.Error())
+	}
+	if _, tainted := hasRollingTaint(env.getNode(t, "stray-node")); !tainted {
+		t.Errorf("Node outside of any asg was reconciled during a live rollout")
+	}
+
+	// Lease of a dead process doesn't hold the asg
+	other.Duration = 0
+	if err := rollout.kube.AcquireRolloutLease(other, false); err != nil {
+		t.Fatalf("AcquireRolloutLease failed, %s", err.Error())
+	}
+	leftovers, err = env.rollout.FindLeftovers("")
+	if err != nil {
+		t.Fatalf("FindLeftovers failed, %s", err.Error())
+	}
+	if err := env.rollout.FixLeftovers(leftovers, env.events); err != nil {
+		t.Fatalf("FixLeftovers failed, %s", err.Error())
+	}
+
+	leftovers, err = env.rollout.FindLeftovers("")
+	if err != nil {
+		t.Fatalf("FindLeftovers failed, %s", err.Error())
+	}
+	for _, leftover := range leftovers {
+		t.Errorf("Leftover %s %s of asg %s wasn't fixed", leftover.Kind, leftover.Resource, leftover.AsgName)
+	}
+	if min, max, _ := env.cloud.Capacity(testAsgName); min != 1 || max != 3 {
+		t.Errorf("Asg has min %d, max %d, want 1, 3", min, max)
+	}
+	if processes := env.cloud.SuspendedProcesses(testAsgName); len(processes) != 0 {
+		t.Errorf("Processes %v are still suspended", processes)
+	}
+}
+
+func TestRolloutLease(t *testing.T) {
+	env := newRolloutEnv(t)
+	rollout := env.rollout.(*asgRolloutClient)
+	other := NewAsgRolloutWithClients(
+		env.cloud.AutoScaling(),
+		env.cloud.EC2(),
+		rollout.kube,
+		rollout.rolloutConfig,
+	).(*asgRolloutClient)
+	other.identity = kube.RolloutLease{Holder: "other@host/1", User: "other", Host: "host"}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	release, err := other.HoldRollout(ctx, testAsgName, false)
+	if err != nil {
+		t.Fatalf("HoldRollout failed, %s", err.Error())
+	}
+	// Holding again from the same client keeps the lease till both holds
+	// are released
+	nested, err := other.HoldRollout(ctx, testAsgName, false)
+	if err != nil {
+		t.Fatalf("Nested HoldRollout failed, %s", err.Error())
+	}
+	nested()
+
+	err = env.rollout.StartRollout(ctx, testAsgName, 1, env.progress, env.events)
+	var heldErr *kube.RolloutLeaseHeldError
+	if !errors.As(err, &heldErr) || heldErr.Expired {
+		t.Fatalf("StartRollout of a held asg returned %v, want a live lease error", err)
+	}
+	if heldErr.Lease.HolderName() != "other@host" {
+		t.Errorf("Lease is held by %s, want other@host", heldErr.Lease.HolderName())
+	}
+
+	release()
+	lease, err := env.rollout.GetRolloutLease(testAsgName)
+	if err != nil || lease != nil {
+		t.Fatalf("Lease wasn't released, %v %v", lease, err)
+	}
+
+	// Lease of a process which died without releasing it
+	expired := other.identity
+	expired.AsgName = testAsgName
+	if err := rollout.kube.AcquireRolloutLease(expired, false); err != nil {
+		t.Fatalf("AcquireRolloutLease failed, %s", err.Error())
+	}
+	_, err = env.rollout.HoldRollout(ctx, testAsgName, false)
+	if !errors.As(err, &heldErr) || !heldErr.Expired {
+		t.Fatalf("HoldRollout of an expired lease returned %v, want an expired lease error", err)
+	}
+	release, err = env.rollout.HoldRollout(ctx, testAsgName, true)
+	if err != nil {
+		t.Fatalf("Takeover of the expired lease failed, %s", err.Error())
+	}
+	lease, err = env.rollout.GetRolloutLease(testAsgName)
+	if err != nil || lease == nil || lease.Holder != rollout.identity.Holder {
+		t.Fatalf("Lease is %v after the takeover, want holder %s", lease, rollout.identity.Holder)
+	}
+	if err := rollout.kube.RenewRolloutLease(testAsgName, expired.Holder); err == nil {
+		t.Errorf("Previous holder renewed the lease after the takeover")
+	}
+	release()
+
+	// Lease is taken over by another process during the rollout. Leases
+	// shorter than a second are expired right away, so the takeover
+	// succeeds while the rollout renews its lease.
+	previousDuration := rolloutLeaseDuration
+	rolloutLeaseDuration = 300 * time.Millisecond
+	t.Cleanup(func() { rolloutLeaseDuration = previousDuration })
+
+	release, err = env.rollout.HoldRollout(ctx, testAsgName, false)
+	if err != nil {
+		t.Fatalf("HoldRollout failed, %s", err.Error())
+	}
+	defer release()
+	takenOver := false
+	env.cloud.OnLaunch(func(instance fake.Instance) {
+		if takenOver {
+			return
+		}
+		takenOver = true
+		if err := rollout.kube.AcquireRolloutLease(expired, true); err != nil {
+			t.Errorf("Takeover during the rollout failed, %s", err.Error())
+			return
+		}
+		// Launch blocks the rollout till the renewal notices the takeover
+		deadline := time.Now().Add(5 * time.Second)
+		for rollout.lostRolloutLease(testAsgName) == nil && time.Now().Before(deadline) {
+			time.Sleep(10 * time.Millisecond)
+		}
+	})
+
+	err = env.rollout.StartRollout(ctx, testAsgName, 1, env.progress, env.events)
+	if !errors.As(err, &heldErr) || heldErr.Lease.Holder != expired.Holder {
+		t.Fatalf("StartRollout returned %v after the takeover, want a lease held by %s", err, expired.Holder)
+	}
+	err = env.rollout.PostRolloutStart(testAsgName, env.progress, env.events, false)
+	if !errors.As(err, &heldErr) {
+		t.Errorf("PostRolloutStart returned %v after the takeover, want the lost lease", err)
+	}
+	// Post rollout is left to the new holder
+	if _, tainted := hasRollingTaint(env.getNode(t, env.oldNodes[0])); !tainted {
+		t.Errorf("Node %s was untainted by the post rollout of a lost lease", env.oldNodes[0])
+	}
+	if _, err := env.rollout.HoldRollout(ctx, testAsgName, false); !errors.As(err, &heldErr) {
+		t.Errorf("HoldRollout of a lost lease returned %v, want the lost lease", err)
+	}
+}
@@ -5,9 +5,11 @@ import (
 	//"dockyard/config"
 
 	"dockyard/pkg/kube"
-	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/autoscaling"
+	"github.com/aws/aws-sdk-go/service/autoscaling/autoscalingiface"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/aws/aws-sdk-go/service/ec2/ec2iface"
 	"log"
-	"os"
 	"sync"
 )
 
@@ -29,31 +31,63 @@ type AsgInfo struct {
 }
 
 type asgRolloutClient struct {
-	session       *session.Session
+	autoScalingCl autoscalingiface.AutoScalingAPI
+	ec2Cl         ec2iface.EC2API
 	kube          kube.KubeClient
 	lock          sync.Mutex
 	rolloutConfig *AsgRolloutConfig
+	jobsLock      sync.Mutex
+	// job pods which nodes under rollout are waiting on, keyed by node name
+	jobsWaitedOn map[string][]kube.JobPod
+	// progress of the rollout started by this client
+	progress *RolloutProgress
+	// identity of this process in rollout leases
+	identity   kube.RolloutLease
+	leasesLock sync.Mutex
+	// rollout leases held by this client, keyed by asg name
+	leases map[string]*heldLease
 }
 
 func NewAsgRollout(ctx context.Context, config *AwsConfig, client kube.KubeClient, rolloutConfig *AsgRolloutConfig) AsgRolloutClient {
 
-	if config != nil {
-		os.Setenv("AWS_REGION", config.Region)
-		os.Setenv("AWS_PROFILE", config.Profile)
-	}
-
-	sess, err := session.NewSessionWithOptions(
-		session.Options{SharedConfigState: session.SharedConfigEnable},
-	)
+	sess, err := newSession(config)
 
 	if err != nil {
 		log.Fatal(err)
 	}
 
+	endpoints := endpointsOf(config)
+	return NewAsgRolloutWithClients(
+		autoscaling.New(sess, endpointConfig(endpoints.AutoScaling)),
+		ec2.New(sess, endpointConfig(endpoints.EC2)),
+		client,
+		rolloutConfig,
+	)
+}
+
+// Creates the rollout client on top of the provided aws clients, e.g. the
+// in-memory fakes of dockyard/pkg/aws/fake
+func NewAsgRolloutWithClients(
+	autoScalingCl autoscalingiface.AutoScalingAPI,
+	ec2Cl ec2iface.EC2API,
+	client kube.KubeClient,
+	rolloutConfig *AsgRolloutConfig,
+) AsgRolloutClient {
 	return &asgRolloutClient{
-		session:       sess,
+		autoScalingCl: autoScalingCl,
+		ec2Cl:         ec2Cl,
 		kube:          client,
 		lock:          sync.Mutex{},
 		rolloutConfig: rolloutConfig,
+		jobsLock:      sync.Mutex{},
+		jobsWaitedOn:  map[string][]kube.JobPod{},
+		progress: &RolloutProgress{
+			StepsSize: int32(0),
+			StepsDone: int32(0),
+			TotalSize: int32(0),
+		},
+		identity:   rolloutIdentity(),
+		leasesLock: sync.Mutex{},
+		leases:     map[string]*heldLease{},
 	}
 }
@@ -0,0 +1,304 @@
+package aws
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"dockyard/pkg/kube"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/autoscaling"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	ClusterAutoscalerModeNone        = "none"
+	ClusterAutoscalerModeAnnotate    = "annotate"
+	ClusterAutoscalerModeScaleToZero = "scale-to-zero"
+
+	// Tag holding the replicas of the cluster-autoscaler deployments scaled
+	// to zero for the rollout of the asg, as <namespace>/<name>=<replicas>,...
+	ClusterAutoscalerTagKey = "dockyard.io/cluster-autoscaler"
+)
+
+// Returns the cluster-autoscaler coordination mode, defaults to none
+func (c AsgRolloutConfig) ClusterAutoscalerMode() string {
+	if len(c.ClusterAutoscaler.Mode) == 0 {
+		return ClusterAutoscalerModeNone
+	}
+	return c.ClusterAutoscaler.Mode
+}
+
+// Keeps cluster-autoscaler from scaling in the asg while it is rolled,
+// either by annotating its nodes or by scaling cluster-autoscaler to zero
+func (asgRollout *asgRolloutClient) pauseClusterAutoscaler(
+	asgName string,
+	nodeNames []string,
+	eventLogs chan string,
+) error {
+	switch asgRollout.rolloutConfig.ClusterAutoscalerMode() {
+	case ClusterAutoscalerModeAnnotate:
+		for _, nodeName := range nodeNames {
+			err := asgRollout.disableScaleDown(nodeName, eventLogs)
+			if err != nil {
+				return err
+			}
+		}
+	case ClusterAutoscalerModeScaleToZero:
+		replicas, err := asgRollout.clusterAutoscalerReplicas(asgName)
+		if err != nil {
+			log.Errorf("Unable to fetch cluster-autoscaler replicas due to %s", err.Error())
+			return fmt.Errorf("Unable to fetch cluster-autoscaler replicas %s", err.Error())
+		}
+		// Replicas are stored before scaling down so that post rollout
+		// restores them even if dockyard exits in between
+		err = asgRollout.AddTagToAsG(
+			asgName,
+			ClusterAutoscalerTagKey,
+			formatReplicas(replicas),
+		)
+		if err != nil {
+			log.Errorf("Unable tag asg %s due to %s", asgName, err.Error())
+			return err
+		}
+		for _, deployment := range sortedDeployments(replicas) {
+			namespace, name := splitDeployment(deployment)
+			eventLogs <- fmt.Sprintf("Scaling cluster-autoscaler %s to 0", deployment)
+			log.Infof("Scaling cluster-autoscaler %s to 0", deployment)
+			err = asgRollout.kube.ScaleDeployment(namespace, name, 0)
+			if err != nil {
+				log.Errorf("Unable to scale %s due to %s", deployment, err.Error())
+				return fmt.Errorf("Unable to scale cluster-autoscaler %s", err.Error())
+			}
+		}
+	}
+	return nil
+}
+
+// Annotates the node so that cluster-autoscaler doesn't scale it down
+func (asgRollout *asgRolloutClient) disableScaleDown(
+	nodeName string,
+	eventLogs chan string,
+) error {
+	eventLogs <- fmt.Sprintf("Disabling cluster-autoscaler scale down of node %s", nodeName)
+	log.Infof("Annotating node %s with %s", nodeName, kube.ScaleDownDisabledAnnotationKey)
+	err := asgRollout.kube.AnnotateNode(
+		nodeName,
+		kube.ScaleDownDisabledAnnotationKey,
+		"true",
+		asgRollout.rolloutConfig.IgnoreNotFound,
+	)
+	if err != nil {
+		log.Errorf("Unable to annotate k8s Node %s due to %s", nodeName, err.Error())
+		return fmt.Errorf("Unable to annotate k8s Node %s", err.Error())
+	}
+	return nil
+}
+
+// Returns the replicas to restore cluster-autoscaler to after the rollout.
+// Replicas recorded by a resumed rollout of the asg, or by a concurrent
+// rollout of another asg, win over the current ones which may already be
+// zero.
+func (asgRollout *asgRolloutClient) clusterAutoscalerReplicas(
+	asgName string,
+) (map[string]int32, error) {
+	holders, err := asgRollout.clusterAutoscalerTagHolders()
+	if err != nil {
+		return nil, err
+	}
+	if value, ok := holders[asgName]; ok {
+		return parseReplicas(value), nil
+	}
+	for _, holder := range sortedAsgs(holders) {
+		return parseReplicas(holders[holder]), nil
+	}
+
+	autoscalers, err := asgRollout.kube.ListClusterAutoscalers()
+	if err != nil {
+		return nil, err
+	}
+	replicas := make(map[string]int32, len(autoscalers))
+	for _, ca := range autoscalers {
+		if ca.Replicas == 0 {
+			continue
+		}
+		replicas[ca.Namespace+"/"+ca.Name] = ca.Replicas
+	}
+	return replicas, nil
+}
+
+// Returns the cluster-autoscaler tag of the asgs of the cluster which
+// scaled cluster-autoscaler to zero, keyed by asg name
+func (asgRollout *asgRolloutClient) clusterAutoscalerTagHolders() (map[string]string, error) {
+	tags, err := describeAsgTags(
+		asgRollout.autoScalingCl,
+		&autoscaling.DescribeTagsInput{
+			Filters: []*autoscaling.Filter{
+				{
+					Name:   aws.String("key"),
+					Values: []*string{aws.String(ClusterAutoscalerTagKey)},
+				},
+			},
+		},
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	var clusterAsgs []string
+	if clusterName := asgRollout.rolloutConfig.EksClusterName; len(clusterName) != 0 {
+		clusterAsgs, err = asgRollout.ListAsgsOfEks(clusterName)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	holders := make(map[string]string, len(tags))
+	for _, tag := range tags {
+		asgName := aws.StringValue(tag.ResourceId)
+		if clusterAsgs != nil && !StringSliceContains(clusterAsgs, asgName) {
+			continue
+		}
+		holders[asgName] = aws.StringValue(tag.Value)
+	}
+	return holders, nil
+}
+
+// Undoes pauseClusterAutoscaler. Cluster-autoscaler is only scaled up once
+// no other asg of the cluster is rolled.
+func (asgRollout *asgRolloutClient) resumeClusterAutoscaler(
+	asgName string,
+	eventLogs chan string,
+) error {
+	switch asgRollout.rolloutConfig.ClusterAutoscalerMode() {
+	case ClusterAutoscalerModeAnnotate:
+		mapping, err := asgRollout.GetNodeMapping(asgName)
+		if err != nil {
+			log.Errorf("Unable to map instances of asg %s to k8s nodes due to %s", asgName, err.Error())
+			return err
+		}
+		for _, instanceId := range mapping.InstanceIds {
+			nodeName := mapping.NodeName(instanceId)
+			if len(nodeName) == 0 {
+				continue
+			}
+			eventLogs <- fmt.Sprintf("Enabling cluster-autoscaler scale down of node %s", nodeName)
+			log.Infof("Removing annotation %s of node %s", kube.ScaleDownDisabledAnnotationKey, nodeName)
+			err := asgRollout.kube.RemoveNodeAnnotation(
+				nodeName,
+				kube.ScaleDownDisabledAnnotationKey,
+				asgRollout.rolloutConfig.IgnoreNotFound,
+			)
+			if err != nil {
+				log.Errorf("Unable to remove annotation of node %s due to %s", nodeName, err.Error())
+				eventLogs <- fmt.Sprintf("Unable to remove annotation of node %s, %s", nodeName, err.Error())
+			}
+		}
+	case ClusterAutoscalerModeScaleToZero:
+		return asgRollout.restoreClusterAutoscaler(asgName, eventLogs)
+	}
+	return nil
+}
+
+// Scales cluster-autoscaler back to the replicas stored in the tag of the
+// asg once no other asg of the cluster holds the tag, and deletes the tag
+func (asgRollout *asgRolloutClient) restoreClusterAutoscaler(
+	asgName string,
+	eventLogs chan string,
+) error {
+	holders, err := asgRollout.clusterAutoscalerTagHolders()
+	if err != nil {
+		log.Errorf("Unable to fetch tags of asgs due to %s", err.Error())
+		return err
+	}
+	value, ok := holders[asgName]
+	if !ok {
+		return nil
+	}
+	delete(holders, asgName)
+
+	if len(holders) == 0 {
+		replicas := parseReplicas(value)
+		for _, deployment := range sortedDeployments(replicas) {
+			namespace, name := splitDeployment(deployment)
+			eventLogs <- fmt.Sprintf(
+				"Restoring cluster-autoscaler %s to %d replicas",
+				deployment,
+				replicas[deployment],
+			)
+			log.Infof("Scaling cluster-autoscaler %s to %d", deployment, replicas[deployment])
+			err = asgRollout.kube.ScaleDeployment(namespace, name, replicas[deployment])
+			if err != nil {
+				// Tag is kept so that the next post rollout retries
+				log.Errorf("Unable to scale %s due to %s", deployment, err.Error())
+				return fmt.Errorf("Unable to restore cluster-autoscaler %s", err.Error())
+			}
+		}
+	} else {
+		eventLogs <- fmt.Sprintf(
+			"Cluster-autoscaler stays scaled down while asgs %s are rolled",
+			strings.Join(sortedAsgs(holders), ", "),
+		)
+	}
+
+	eventLogs <- fmt.Sprintf("Deleting tag %s of asg %s", ClusterAutoscalerTagKey, asgName)
+	log.Infof("Deleting tag %s of asg %s", ClusterAutoscalerTagKey, asgName)
+	err = asgRollout.DeleteTagOfAsg(asgName, ClusterAutoscalerTagKey, value)
+	if err != nil {
+		log.Errorf("Unable to delete tags of asg %s due to %s", asgName, err.Error())
+		return err
+	}
+	return nil
+}
+
+func formatReplicas(replicas map[string]int32) string {
+	pairs := make([]string, 0, len(replicas))
+	for _, deployment := range sortedDeployments(replicas) {
+		pairs = append(pairs, fmt.Sprintf("%s=%d", deployment, replicas[deployment]))
+	}
+	return strings.Join(pairs, ",")
+}
+
+func parseReplicas(value string) map[string]int32 {
+	replicas := map[string]int32{}
+	for _, pair := range strings.Split(value, ",") {
+		deploymentReplicas := strings.SplitN(pair, "=", 2)
+		if len(deploymentReplicas) != 2 {
+			continue
+		}
+		count, err := strconv.Atoi(deploymentReplicas[1])
+		if err != nil {
+			continue
+		}
+		replicas[deploymentReplicas[0]] = int32(count)
+	}
+	return replicas
+}
+
+func splitDeployment(deployment string) (namespace, name string) {
+	parts := strings.SplitN(deployment, "/", 2)
+	if len(parts) != 2 {
+		return "", deployment
+	}
+	return parts[0], parts[1]
+}
+
+func sortedDeployments(replicas map[string]int32) []string {
+	deployments := make([]string, 0, len(replicas))
+	for deployment := range replicas {
+		deployments = append(deployments, deployment)
+	}
+	sort.Strings(deployments)
+	return deployments
+}
+
+func sortedAsgs(holders map[string]string) []string {
+	asgNames := make([]string, 0, len(holders))
+	for asgName := range holders {
+		asgNames = append(asgNames, asgName)
+	}
+	sort.Strings(asgNames)
+	return asgNames
+}
@@ -1,8 +1,167 @@
 package aws
 
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"dockyard/pkg/kube"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials/stscreds"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/sts"
+	log "github.com/sirupsen/logrus"
+)
+
+// Session name of roles assumed by dockyard, shows up in CloudTrail
+const roleSessionName = "dockyard"
+
 type AwsConfig struct {
 	Region  string `mapstructure:"AWS_REGION"  validate:"required"`
 	Profile string `mapstructure:"AWS_PROFILE" validate:"required"`
+	// Role assumed with the credentials of the profile, e.g. a role of the
+	// account the cluster lives in
+	RoleArn    string `mapstructure:"ROLE_ARN"`
+	ExternalId string `mapstructure:"EXTERNAL_ID"`
+	// Serial number ( arn ) of the mfa device, the token is prompted for
+	// whenever the role is assumed
+	MfaSerial string `mapstructure:"MFA_SERIAL"`
+	// Duration of the role session in seconds
+	SessionDuration int          `mapstructure:"SESSION_DURATION" validate:"omitempty,min=900,max=43200"`
+	Endpoints       AwsEndpoints `mapstructure:"ENDPOINTS"`
+	TLS             AwsTLSConfig `mapstructure:"TLS"`
+}
+
+// Endpoints overriding the default endpoints of the aws services, e.g. to
+// point dockyard to LocalStack
+type AwsEndpoints struct {
+	AutoScaling   string `mapstructure:"AUTOSCALING"   validate:"omitempty,url"`
+	EC2           string `mapstructure:"EC2"           validate:"omitempty,url"`
+	EKS           string `mapstructure:"EKS"           validate:"omitempty,url"`
+	ServiceQuotas string `mapstructure:"SERVICEQUOTAS" validate:"omitempty,url"`
+	STS           string `mapstructure:"STS"           validate:"omitempty,url"`
+}
+
+// TLS settings of all connections to aws services
+type AwsTLSConfig struct {
+	// PEM file of CAs trusted in addition to the system CAs
+	CaBundle           string `mapstructure:"CA_BUNDLE" validate:"omitempty,file"`
+	InsecureSkipVerify bool   `mapstructure:"INSECURE_SKIP_VERIFY"`
+}
+
+// Cluster dockyard can switch to, along with the aws settings of its
+// account. Clusters are read from the kubeconfig contexts and from CLUSTERS
+// in config.yaml
+type ClusterConfig struct {
+	// Name shown in the sidebar, defaults to the context name
+	Name           string `mapstructure:"NAME"`
+	Context        string `mapstructure:"CONTEXT"        validate:"required"`
+	EksClusterName string `mapstructure:"EKS_CLUSTER_NAME" validate:"required"`
+	// Defaults to AWS_CONFIG.AWS_REGION
+	AwsRegion string `mapstructure:"AWS_REGION"`
+	// Defaults to AWS_CONFIG.AWS_PROFILE
+	AwsProfile string `mapstructure:"AWS_PROFILE"`
+	// Role of the account the cluster lives in, defaults to
+	// AWS_CONFIG.ROLE_ARN along with its external id
+	AwsRoleArn    string `mapstructure:"AWS_ROLE_ARN"`
+	AwsExternalId string `mapstructure:"AWS_EXTERNAL_ID"`
+}
+
+// Fills the name and the aws settings the cluster doesn't set from the
+// default aws config
+func (cluster ClusterConfig) WithDefaults(defaults *AwsConfig) ClusterConfig {
+	if len(cluster.Name) == 0 {
+		cluster.Name = cluster.Context
+	}
+	if defaults != nil && len(cluster.AwsRegion) == 0 {
+		cluster.AwsRegion = defaults.GetRegion()
+	}
+	if defaults != nil && len(cluster.AwsProfile) == 0 {
+		cluster.AwsProfile = defaults.GetProfile()
+	}
+	if defaults != nil && len(cluster.AwsRoleArn) == 0 {
+		cluster.AwsRoleArn = defaults.RoleArn
+		cluster.AwsExternalId = defaults.ExternalId
+	}
+	return cluster
+}
+
+// Returns the aws config of the cluster. Mfa device, session duration,
+// endpoints and tls settings are shared by all clusters.
+func (cluster ClusterConfig) AwsConfig(defaults *AwsConfig) AwsConfig {
+	awsConfig := NewAwsConfig(cluster.AwsRegion, cluster.AwsProfile)
+	awsConfig.RoleArn = cluster.AwsRoleArn
+	awsConfig.ExternalId = cluster.AwsExternalId
+	if defaults != nil {
+		awsConfig.MfaSerial = defaults.MfaSerial
+		awsConfig.SessionDuration = defaults.SessionDuration
+		awsConfig.Endpoints = defaults.Endpoints
+		awsConfig.TLS = defaults.TLS
+	}
+	return awsConfig
+}
+
+// Returns clusters dockyard can switch to. Clusters of the config take
+// precedence over kubeconfig contexts pointing to the same context. Contexts
+// which can't be mapped to an eks cluster are skipped.
+func ListClusters(
+	kubeOpts kube.KubeConfigOptions,
+	configured []ClusterConfig,
+	defaults *AwsConfig,
+) []ClusterConfig {
+	clusters := make([]ClusterConfig, 0)
+	configuredContexts := map[string]bool{}
+
+	for _, cluster := range configured {
+		configuredContexts[cluster.Context] = true
+		clusters = append(clusters, cluster.WithDefaults(defaults))
+	}
+
+	contexts, err := kube.ListContexts(kubeOpts)
+	if err != nil {
+		log.Errorf("Unable to list kubeconfig contexts due to %s", err.Error())
+		return clusters
+	}
+
+	for _, kubeContext := range contexts {
+		if configuredContexts[kubeContext.Name] ||
+			len(kubeContext.EksClusterName) == 0 {
+			continue
+		}
+		clusters = append(clusters, ClusterConfig{
+			Context:        kubeContext.Name,
+			EksClusterName: kubeContext.EksClusterName,
+			AwsRegion:      kubeContext.Region,
+			AwsProfile:     kubeContext.Profile,
+			AwsRoleArn:     kubeContext.RoleArn,
+		}.WithDefaults(defaults))
+	}
+	return clusters
+}
+
+// Returns the cluster of the kubeconfig context along with the aws settings
+// of its account. A context which isn't listed by ListClusters, e.g. the
+// in-cluster config, gets the default aws settings.
+func ClusterOfContext(
+	kubeOpts kube.KubeConfigOptions,
+	contextName, eksClusterName string,
+	configured []ClusterConfig,
+	defaults *AwsConfig,
+) ClusterConfig {
+	for _, cluster := range ListClusters(kubeOpts, configured, defaults) {
+		if cluster.Context == contextName {
+			return cluster
+		}
+	}
+	return ClusterConfig{
+		Context:        contextName,
+		EksClusterName: eksClusterName,
+	}.WithDefaults(defaults)
 }
 
 func NewAwsConfig(region, profile string) AwsConfig {
@@ -19,3 +178,160 @@ func (a AwsConfig) GetProfile() string {
 func (a AwsConfig) GetRegion() string {
 	return a.Region
 }
+
+var (
+	// Sessions created so far, keyed by their config. Clients of the same
+	// config share the session and with it the credentials, so a role is
+	// assumed and its mfa token prompted for once per session duration.
+	sessions     = map[AwsConfig]*session.Session{}
+	sessionsLock sync.Mutex
+
+	// Asks for the current token of the mfa device
+	mfaTokenProvider = func(mfaDevice string) (string, error) {
+		var token string
+		fmt.Printf("MFA token of %s: ", mfaDevice)
+		_, err := fmt.Scanln(&token)
+		return token, err
+	}
+)
+
+// Replaces the provider asked for mfa tokens, which reads the token from
+// stdin by default. mfaDevice is the serial of the device, or the profile
+// if the role is assumed by the profile itself.
+func SetMfaTokenProvider(provider func(mfaDevice string) (string, error)) {
+	sessionsLock.Lock()
+	defer sessionsLock.Unlock()
+	mfaTokenProvider = provider
+}
+
+func promptMfaToken(mfaDevice string) (string, error) {
+	sessionsLock.Lock()
+	provider := mfaTokenProvider
+	sessionsLock.Unlock()
+	return provider(mfaDevice)
+}
+
+// Returns the session for the region, profile, role and tls settings of the
+// config. Region and profile are passed explicitly so that sessions of
+// different clusters don't interfere with each other.
+func newSession(config *AwsConfig) (*session.Session, error) {
+	key := AwsConfig{}
+	if config != nil {
+		key = *config
+	}
+
+	sessionsLock.Lock()
+	defer sessionsLock.Unlock()
+
+	if sess, ok := sessions[key]; ok {
+		return sess, nil
+	}
+
+	opts := session.Options{
+		SharedConfigState: session.SharedConfigEnable,
+		Profile:           key.Profile,
+		// Used by profiles which assume a role with mfa_serial themselves
+		AssumeRoleTokenProvider: func() (string, error) {
+			return promptMfaToken("profile " + key.Profile)
+		},
+		AssumeRoleDuration: time.Duration(key.SessionDuration) * time.Second,
+	}
+	if len(key.Region) != 0 {
+		opts.Config.Region = aws.String(key.Region)
+	}
+	var tlsConfig *tls.Config
+	if len(key.TLS.CaBundle) != 0 || key.TLS.InsecureSkipVerify {
+		var err error
+		tlsConfig, err = newTLSConfig(key.TLS)
+		if err != nil {
+			return nil, err
+		}
+		transport := http.DefaultTransport.(*http.Transport).Clone()
+		transport.TLSClientConfig = tlsConfig.Clone()
+		opts.Config.HTTPClient = &http.Client{Transport: transport}
+	}
+
+	sess, err := session.NewSessionWithOptions(opts)
+	if err != nil {
+		return nil, err
+	}
+	if tlsConfig != nil {
+		// The sdk replaces the root CAs by AWS_CA_BUNDLE, the tls settings
+		// of the config take precedence
+		opts.Config.HTTPClient.Transport.(*http.Transport).TLSClientConfig = tlsConfig
+	}
+
+	if len(key.RoleArn) != 0 {
+		creds := stscreds.NewCredentialsWithClient(
+			sts.New(sess, endpointConfig(key.Endpoints.STS)),
+			key.RoleArn,
+			func(p *stscreds.AssumeRoleProvider) {
+				p.RoleSessionName = roleSessionName
+				if len(key.ExternalId) != 0 {
+					p.ExternalID = aws.String(key.ExternalId)
+				}
+				if len(key.MfaSerial) != 0 {
+					p.SerialNumber = aws.String(key.MfaSerial)
+					p.TokenProvider = func() (string, error) {
+						return promptMfaToken(key.MfaSerial)
+					}
+				}
+				if key.SessionDuration != 0 {
+					p.Duration = time.Duration(key.SessionDuration) * time.Second
+				}
+			},
+		)
+		sess = sess.Copy(&aws.Config{Credentials: creds})
+	}
+
+	sessions[key] = sess
+	return sess, nil
+}
+
+// Returns the client config for the endpoint override of a service, the
+// default endpoint is used if endpoint is empty
+func endpointConfig(endpoint string) *aws.Config {
+	config := aws.NewConfig()
+	if len(endpoint) != 0 {
+		config = config.WithEndpoint(endpoint)
+	}
+	return config
+}
+
+// Returns endpoint overrides of the config
+func endpointsOf(config *AwsConfig) AwsEndpoints {
+	if config == nil {
+		return AwsEndpoints{}
+	}
+	return config.Endpoints
+}
+
+func newTLSConfig(awsTLSConfig AwsTLSConfig) (*tls.Config, error) {
+	tlsConfig := &tls.Config{
+		MinVersion:         tls.VersionTLS12,
+		InsecureSkipVerify: awsTLSConfig.InsecureSkipVerify,
+	}
+
+	if len(awsTLSConfig.CaBundle) != 0 {
+		caBundle, err := os.ReadFile(awsTLSConfig.CaBundle)
+		if err != nil {
+			return nil, fmt.Errorf(
+				"Unable to read CA bundle %s, %s",
+				awsTLSConfig.CaBundle,
+				err.Error(),
+			)
+		}
+		rootCAs, err := x509.SystemCertPool()
+		if err != nil {
+			rootCAs = x509.NewCertPool()
+		}
+		if !rootCAs.AppendCertsFromPEM(caBundle) {
+			return nil, fmt.Errorf(
+				"Unable to find any certificate in CA bundle %s",
+				awsTLSConfig.CaBundle,
+			)
+		}
+		tlsConfig.RootCAs = rootCAs
+	}
+	return tlsConfig, nil
+}
@@ -0,0 +1,279 @@
+package aws
+
+import (
+	"encoding/pem"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"dockyard/pkg/kube"
+)
+
+func TestNewSessionIsSharedPerConfig(t *testing.T) {
+	dir := t.TempDir()
+	configFile := filepath.Join(dir, "config")
+	err := os.WriteFile(configFile, []byte("[profile dockyard]\nregion = eu-west-1\n"), 0600)
+	if err != nil {
+		t.Fatalf("Unable to write aws config, %s", err.Error())
+	}
+	t.Setenv("AWS_CONFIG_FILE", configFile)
+	t.Setenv("AWS_SHARED_CREDENTIALS_FILE", filepath.Join(dir, "credentials"))
+
+	base := AwsConfig{Region: "us-east-1", Profile: "dockyard"}
+	assumed := base
+	assumed.RoleArn = "arn:aws:iam::123456789012:role/dockyard"
+	assumed.MfaSerial = "arn:aws:iam::210987654321:mfa/dockyard"
+
+	first, err := newSession(&base)
+	if err != nil {
+		t.Fatalf("newSession failed, %s", err.Error())
+	}
+	second, err := newSession(&base)
+	if err != nil {
+		t.Fatalf("newSession failed, %s", err.Error())
+	}
+	if first != second {
+		t.Errorf("Clients of the same config got different sessions")
+	}
+
+	withRole, err := newSession(&assumed)
+	if err != nil {
+		t.Fatalf("newSession failed, %s", err.Error())
+	}
+	if withRole == first || withRole.Config.Credentials == first.Config.Credentials {
+		t.Errorf("Session of the role shares the credentials of the profile")
+	}
+	if region := *withRole.Config.Region; region != "us-east-1" {
+		t.Errorf("Session has region %s, want us-east-1", region)
+	}
+}
+
+func TestNewSessionHonoursEndpointsAndTLS(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			fmt.Fprint(w, `<GetCallerIdentityResponse xmlns="https://sts.amazonaws.com/doc/2011-06-15/">
+  <GetCallerIdentityResult>
+    <Arn>arn:aws:iam::000000000000:user/localstack</Arn>
+    <UserId>AIDALOCALSTACK</UserId>
+    <Account>000000000000</Account>
+  </GetCallerIdentityResult>
+  <ResponseMetadata><RequestId>dockyard</RequestId></ResponseMetadata>
+</GetCallerIdentityResponse>`)
+		},
+	))
+	defer server.Close()
+
+	dir := t.TempDir()
+	caBundle := filepath.Join(dir, "ca.pem")
+	certPem := pem.EncodeToMemory(&pem.Block{
+		Type:  "CERTIFICATE",
+		Bytes: server.Certificate().Raw,
+	})
+	if err := os.WriteFile(caBundle, certPem, 0600); err != nil {
+		t.Fatalf("Unable to write CA bundle, %s", err.Error())
+	}
+	credentialsFile := filepath.Join(dir, "credentials")
+	err := os.WriteFile(
+		credentialsFile,
+		[]byte("[localstack]\naws_access_key_id = test\naws_secret_access_key = test\n"),
+		0600,
+	)
+	if err != nil {
+		t.Fatalf("Unable to write aws credentials, %s", err.Error())
+	}
+	t.Setenv("AWS_CONFIG_FILE", filepath.Join(dir, "config"))
+	t.Setenv("AWS_SHARED_CREDENTIALS_FILE", credentialsFile)
+
+	config := AwsConfig{
+		Region:    "us-east-1",
+		Profile:   "localstack",
+		Endpoints: AwsEndpoints{STS: server.URL},
+		TLS:       AwsTLSConfig{CaBundle: caBundle},
+	}
+	identity, err := NewAwsEKS(testClusterName, &config).GetCallerIdentity()
+	if err != nil {
+		t.Fatalf("GetCallerIdentity failed, %s", err.Error())
+	}
+	if identity.Account != "000000000000" {
+		t.Errorf("GetCallerIdentity returned account %s, want 000000000000", identity.Account)
+	}
+}
+
+func TestClusterConfigDefaults(t *testing.T) {
+	defaults := &AwsConfig{
+		Region:          "us-east-1",
+		Profile:         "dockyard",
+		RoleArn:         "arn:aws:iam::123456789012:role/dockyard",
+		ExternalId:      "dockyard-ext",
+		MfaSerial:       "arn:aws:iam::123456789012:mfa/dockyard",
+		SessionDuration: 3600,
+		Endpoints:       AwsEndpoints{EKS: "http://localhost:4566"},
+		TLS:             AwsTLSConfig{InsecureSkipVerify: true},
+	}
+	tests := []struct {
+		name     string
+		cluster  ClusterConfig
+		defaults *AwsConfig
+		want     ClusterConfig
+	}{
+		{
+			name:     "all defaults",
+			cluster:  ClusterConfig{Context: "prod-ctx", EksClusterName: "prod"},
+			defaults: defaults,
+			want: ClusterConfig{
+				Name:           "prod-ctx",
+				Context:        "prod-ctx",
+				EksClusterName: "prod",
+				AwsRegion:      "us-east-1",
+				AwsProfile:     "dockyard",
+				AwsRoleArn:     "arn:aws:iam::123456789012:role/dockyard",
+				AwsExternalId:  "dockyard-ext",
+			},
+		},
+		{
+			name: "own account",
+			cluster: ClusterConfig{
+				Name:           "Staging",
+				Context:        "staging-ctx",
+				EksClusterName: "staging",
+				AwsRegion:      "eu-west-1",
+				AwsProfile:     "staging",
+				AwsRoleArn:     "arn:aws:iam::210987654321:role/dockyard",
+			},
+			defaults: defaults,
+			// External id belongs to the default role
+			want: ClusterConfig{
+				Name:           "Staging",
+				Context:        "staging-ctx",
+				EksClusterName: "staging",
+				AwsRegion:      "eu-west-1",
+				AwsProfile:     "staging",
+				AwsRoleArn:     "arn:aws:iam::210987654321:role/dockyard",
+			},
+		},
+		{
+			name:     "no defaults",
+			cluster:  ClusterConfig{Context: "dev-ctx", EksClusterName: "dev"},
+			defaults: nil,
+			want:     ClusterConfig{Name: "dev-ctx", Context: "dev-ctx", EksClusterName: "dev"},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got := test.cluster.WithDefaults(test.defaults)
+			if !reflect.DeepEqual(got, test.want) {
+				t.Errorf("WithDefaults() = %+v, want %+v", got, test.want)
+			}
+
+			awsConfig := got.AwsConfig(test.defaults)
+			want := AwsConfig{
+				Region:     got.AwsRegion,
+				Profile:    got.AwsProfile,
+				RoleArn:    got.AwsRoleArn,
+				ExternalId: got.AwsExternalId,
+			}
+			// Mfa, session and connection settings are shared by all clusters
+			if test.defaults != nil {
+				want.MfaSerial = test.defaults.MfaSerial
+				want.SessionDuration = test.defaults.SessionDuration
+				want.Endpoints = test.defaults.Endpoints
+				want.TLS = test.defaults.TLS
+			}
+			if awsConfig != want {
+				t.Errorf("AwsConfig() = %+v, want %+v", awsConfig, want)
+			}
+		})
+	}
+}
+
+func TestClusterOfContext(t *testing.T) {
+	kubeconfig := filepath.Join(t.TempDir(), "config")
+	err := os.WriteFile(kubeconfig, []byte(`apiVersion: v1
+kind: Config
+clusters:
+- name: arn:aws:eks:eu-west-1:210987654321:cluster/staging
+  cluster: {server: "https://staging.example.com"}
+- name: arn:aws:eks:us-east-1:123456789012:cluster/prod
+  cluster: {server: "https://prod.example.com"}
+users:
+- name: staging
+  user:
+    exec:
+      apiVersion: client.authentication.k8s.io/v1beta1
+      command: aws
+      args: [eks, get-token, --cluster-name, staging, --role-arn, "arn:aws:iam::210987654321:role/admin"]
+      env: [{name: AWS_PROFILE, value: staging}]
+- name: prod
+  user: {}
+contexts:
+- name: staging-ctx
+  context: {cluster: "arn:aws:eks:eu-west-1:210987654321:cluster/staging", user: staging}
+- name: prod-ctx
+  context: {cluster: "arn:aws:eks:us-east-1:123456789012:cluster/prod", user: prod}
+current-context: staging-ctx
+`), 0600)
+	if err != nil {
+		t.Fatalf("Unable to write kubeconfig, %s", err.Error())
+	}
+	kubeOpts := kube.KubeConfigOptions{Kubeconfig: kubeconfig}
+	defaults := &AwsConfig{Region: "us-east-1", Profile: "dockyard"}
+	configured := []ClusterConfig{{
+		Name:           "Production",
+		Context:        "prod-ctx",
+		EksClusterName: "prod",
+		AwsProfile:     "prod",
+	}}
+
+	tests := []struct {
+		name        string
+		contextName string
+		want        ClusterConfig
+	}{
+		{
+			name:        "kubeconfig context",
+			contextName: "staging-ctx",
+			want: ClusterConfig{
+				Name:           "staging-ctx",
+				Context:        "staging-ctx",
+				EksClusterName: "staging",
+				AwsRegion:      "eu-west-1",
+				AwsProfile:     "staging",
+				AwsRoleArn:     "arn:aws:iam::210987654321:role/admin",
+			},
+		},
+		{
+			name:        "configured cluster",
+			contextName: "prod-ctx",
+			want: ClusterConfig{
+				Name:           "Production",
+				Context:        "prod-ctx",
+				EksClusterName: "prod",
+				AwsRegion:      "us-east-1",
+				AwsProfile:     "prod",
+			},
+		},
+		{
+			name:        "unknown context",
+			contextName: "in-cluster",
+			want: ClusterConfig{
+				Name:           "in-cluster",
+				Context:        "in-cluster",
+				EksClusterName: "fallback",
+				AwsRegion:      "us-east-1",
+				AwsProfile:     "dockyard",
+			},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got := ClusterOfContext(kubeOpts, test.contextName, "fallback", configured, defaults)
+			if !reflect.DeepEqual(got, test.want) {
+				t.Errorf("ClusterOfContext returned %+v, want %+v", got, test.want)
+			}
+		})
+	}
+}
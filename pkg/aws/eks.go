@@ -1,16 +1,21 @@
 package aws
 
 import (
+	"encoding/base64"
+	"encoding/pem"
+	"fmt"
 	"log"
 	"strconv"
 
-	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/service/ec2"
 	"github.com/aws/aws-sdk-go/service/ec2/ec2iface"
 	"github.com/aws/aws-sdk-go/service/eks"
 	"github.com/aws/aws-sdk-go/service/eks/eksiface"
 	"github.com/aws/aws-sdk-go/service/servicequotas"
 	"github.com/aws/aws-sdk-go/service/servicequotas/servicequotasiface"
+	"github.com/aws/aws-sdk-go/service/sts"
+	"github.com/aws/aws-sdk-go/service/sts/stsiface"
 )
 
 const (
@@ -23,39 +28,128 @@ const (
 type AwsEksClient interface {
 	AvailableIp() ([][]string, error)
 	Ec2Limits() ([][]string, error)
+	GetCallerIdentity() (CallerIdentity, error)
+
+	// Returns the vCPU quota of the on-demand or spot standard instances
+	// and the vCPUs currently running against it
+	InstanceQuota(spot bool) (VCpuQuota, error)
+
+	// Returns available ips of the subnets keyed by subnet id
+	AvailableIpOfSubnets(subnetIds []string) (map[string]int64, error)
+
+	// Checks if the PEM encoded CA, e.g. the CA of the in-cluster config,
+	// is the certificate authority of the eks cluster
+	VerifyClusterCA(caData []byte) error
+}
+
+// Identity whose credentials are used for all aws calls
+type CallerIdentity struct {
+	Account string
+	Arn     string
 }
 
 type awsEksClient struct {
-	session     *session.Session
-	clusterName string
+	eksCl           eksiface.EKSAPI
+	ec2Cl           ec2iface.EC2API
+	stsCl           stsiface.STSAPI
+	serviceQuotasCl servicequotasiface.ServiceQuotasAPI
+	clusterName     string
 }
 
-// Fix profile parsing
-func NewAwsEKS(clusterName, profile string) AwsEksClient {
+func NewAwsEKS(clusterName string, config *AwsConfig) AwsEksClient {
 
-	sess, err := session.NewSessionWithOptions(
-		session.Options{
-			Profile:           profile,
-			SharedConfigState: session.SharedConfigEnable,
-		},
-	)
+	sess, err := newSession(config)
 
 	if err != nil {
 		log.Fatal(err)
 	}
 
+	endpoints := endpointsOf(config)
+	return NewAwsEKSWithClients(
+		clusterName,
+		eks.New(sess, endpointConfig(endpoints.EKS)),
+		ec2.New(sess, endpointConfig(endpoints.EC2)),
+		sts.New(sess, endpointConfig(endpoints.STS)),
+		servicequotas.New(sess, endpointConfig(endpoints.ServiceQuotas)),
+	)
+}
+
+// Creates the eks client on top of the provided aws clients, e.g. the
+// in-memory fakes of dockyard/pkg/aws/fake
+func NewAwsEKSWithClients(
+	clusterName string,
+	eksCl eksiface.EKSAPI,
+	ec2Cl ec2iface.EC2API,
+	stsCl stsiface.STSAPI,
+	serviceQuotasCl servicequotasiface.ServiceQuotasAPI,
+) AwsEksClient {
 	return &awsEksClient{
-		session:     sess,
-		clusterName: clusterName,
+		eksCl:           eksCl,
+		ec2Cl:           ec2Cl,
+		stsCl:           stsCl,
+		serviceQuotasCl: serviceQuotasCl,
+		clusterName:     clusterName,
+	}
+}
+
+// Returns account and arn of the identity the credentials belong to, the
+// assumed role if a role is configured
+func (eksClient *awsEksClient) GetCallerIdentity() (CallerIdentity, error) {
+	identity, err := eksClient.stsCl.GetCallerIdentity(&sts.GetCallerIdentityInput{})
+	if err != nil {
+		return CallerIdentity{}, err
+	}
+	return CallerIdentity{
+		Account: aws.StringValue(identity.Account),
+		Arn:     aws.StringValue(identity.Arn),
+	}, nil
+}
+
+func (eksClient *awsEksClient) VerifyClusterCA(caData []byte) error {
+	cluster, err := eksClient.eksCl.DescribeCluster(
+		&eks.DescribeClusterInput{Name: &eksClient.clusterName},
+	)
+	if err != nil {
+		return err
+	}
+	if cluster.Cluster.CertificateAuthority == nil {
+		return fmt.Errorf("Unable to verify CA, cluster %s has no certificate authority", eksClient.clusterName)
+	}
+	clusterCA, err := base64.StdEncoding.DecodeString(
+		aws.StringValue(cluster.Cluster.CertificateAuthority.Data),
+	)
+	if err != nil {
+		return fmt.Errorf("Unable to decode CA of cluster %s, %s", eksClient.clusterName, err.Error())
+	}
+
+	clusterCerts := pemCertificates(clusterCA)
+	for cert := range pemCertificates(caData) {
+		if clusterCerts[cert] {
+			return nil
+		}
+	}
+	return fmt.Errorf("CA doesn't match the certificate authority of cluster %s", eksClient.clusterName)
+}
+
+// Returns DER bytes of all certificates of the PEM data
+func pemCertificates(data []byte) map[string]bool {
+	certs := map[string]bool{}
+	for {
+		var block *pem.Block
+		block, data = pem.Decode(data)
+		if block == nil {
+			return certs
+		}
+		if block.Type == "CERTIFICATE" {
+			certs[string(block.Bytes)] = true
+		}
 	}
 }
 
 // AvailableIp returns available Ips in each subnet that is registered with eks cluster
 
 func (eksClient *awsEksClient) AvailableIp() ([][]string, error) {
-	var eksCl eksiface.EKSAPI = eks.New(eksClient.session)
-	var ec2Cl ec2iface.EC2API = ec2.New(eksClient.session)
-	cluster, err := eksCl.DescribeCluster(
+	cluster, err := eksClient.eksCl.DescribeCluster(
 		&eks.DescribeClusterInput{Name: &eksClient.clusterName},
 	)
 	if err != nil {
@@ -64,20 +158,27 @@ func (eksClient *awsEksClient) AvailableIp() ([][]string, error) {
 
 	vpcId := cluster.Cluster.ResourcesVpcConfig.VpcId
 	filterName := "vpc-id"
-	subnets, err := ec2Cl.DescribeSubnets(&ec2.DescribeSubnetsInput{
-		Filters: []*ec2.Filter{
-			{
-				Name:   &filterName,
-				Values: []*string{vpcId},
+	subnets := []*ec2.Subnet{}
+	err = eksClient.ec2Cl.DescribeSubnetsPages(
+		&ec2.DescribeSubnetsInput{
+			Filters: []*ec2.Filter{
+				{
+					Name:   &filterName,
+					Values: []*string{vpcId},
+				},
 			},
 		},
-	})
+		func(page *ec2.DescribeSubnetsOutput, _ bool) bool {
+			subnets = append(subnets, page.Subnets...)
+			return true
+		},
+	)
 
 	if err != nil {
 		return nil, err
 	}
 	res := make([][]string, 0)
-	for _, subnet := range subnets.Subnets {
+	for _, subnet := range subnets {
 		res = append(
 			res,
 			[]string{
@@ -92,16 +193,12 @@ func (eksClient *awsEksClient) AvailableIp() ([][]string, error) {
 
 // Returns quota limits for ondemand and spot instances
 func (eksClient *awsEksClient) Ec2Limits() ([][]string, error) {
-	var serviceQuotasCl servicequotasiface.ServiceQuotasAPI = servicequotas.New(eksClient.session)
-	ec2ServiceCode := "ec2"
-	quotas, err := serviceQuotasCl.ListServiceQuotas(
-		&servicequotas.ListServiceQuotasInput{ServiceCode: &ec2ServiceCode},
-	)
+	quotas, err := eksClient.ec2Quotas()
 	res := make([][]string, 0)
 	if err != nil {
 		return res, err
 	}
-	for _, quota := range quotas.Quotas {
+	for _, quota := range quotas {
 		if *quota.QuotaCode == EC2SpotServiceQuotaCode {
 			res = append(
 				res,
@@ -117,3 +214,109 @@ func (eksClient *awsEksClient) Ec2Limits() ([][]string, error) {
 	}
 	return res, nil
 }
+
+// Returns all service quotas of ec2
+func (eksClient *awsEksClient) ec2Quotas() ([]*servicequotas.ServiceQuota, error) {
+	ec2ServiceCode := "ec2"
+	quotas := []*servicequotas.ServiceQuota{}
+	err := eksClient.serviceQuotasCl.ListServiceQuotasPages(
+		&servicequotas.ListServiceQuotasInput{ServiceCode: &ec2ServiceCode},
+		func(page *servicequotas.ListServiceQuotasOutput, _ bool) bool {
+			quotas = append(quotas, page.Quotas...)
+			return true
+		},
+	)
+	return quotas, err
+}
+
+// Returns the vCPU quota of the on-demand or spot standard instances along
+// with the vCPUs of the running standard instances of the region
+func (eksClient *awsEksClient) InstanceQuota(spot bool) (VCpuQuota, error) {
+	quotaCode := EC2OnDemandServiceQuotaCode
+	if spot {
+		quotaCode = EC2SpotServiceQuotaCode
+	}
+	quotas, err := eksClient.ec2Quotas()
+	if err != nil {
+		return VCpuQuota{}, err
+	}
+	result := VCpuQuota{}
+	for _, quota := range quotas {
+		if aws.StringValue(quota.QuotaCode) == quotaCode {
+			result.Limit = int64(aws.Float64Value(quota.Value))
+		}
+	}
+
+	instances := []*ec2.Instance{}
+	err = eksClient.ec2Cl.DescribeInstancesPages(
+		&ec2.DescribeInstancesInput{
+			Filters: []*ec2.Filter{
+				{
+					Name: aws.String("instance-state-name"),
+					Values: aws.StringSlice([]string{
+						ec2.InstanceStateNamePending,
+						ec2.InstanceStateNameRunning,
+					}),
+				},
+			},
+		},
+		func(page *ec2.DescribeInstancesOutput, _ bool) bool {
+			for _, reservation := range page.Reservations {
+				instances = append(instances, reservation.Instances...)
+			}
+			return true
+		},
+	)
+	if err != nil {
+		return VCpuQuota{}, err
+	}
+
+	instanceTypes := []*string{}
+	for _, instance := range instances {
+		instanceTypes = append(instanceTypes, instance.InstanceType)
+	}
+	infos, err := describeInstanceTypes(eksClient.ec2Cl, instanceTypes)
+	if err != nil {
+		return VCpuQuota{}, err
+	}
+	vCpusOfType := map[string]int64{}
+	for _, info := range infos {
+		vCpus, _, _ := instanceTypeCapacity(info)
+		vCpusOfType[aws.StringValue(info.InstanceType)] = vCpus
+	}
+
+	for _, instance := range instances {
+		instanceType := aws.StringValue(instance.InstanceType)
+		isSpot := aws.StringValue(instance.InstanceLifecycle) == ec2.InstanceLifecycleTypeSpot
+		if isSpot == spot && isStandardInstanceType(instanceType) {
+			result.Used += vCpusOfType[instanceType]
+		}
+	}
+	return result, nil
+}
+
+// Returns available ips of the subnets keyed by subnet id
+func (eksClient *awsEksClient) AvailableIpOfSubnets(
+	subnetIds []string,
+) (map[string]int64, error) {
+	availableIps := map[string]int64{}
+	if len(subnetIds) == 0 {
+		return availableIps, nil
+	}
+	for _, batch := range batches(aws.StringSlice(subnetIds), maxIdsPerRequest) {
+		err := eksClient.ec2Cl.DescribeSubnetsPages(
+			&ec2.DescribeSubnetsInput{SubnetIds: batch},
+			func(page *ec2.DescribeSubnetsOutput, _ bool) bool {
+				for _, subnet := range page.Subnets {
+					availableIps[aws.StringValue(subnet.SubnetId)] =
+						aws.Int64Value(subnet.AvailableIpAddressCount)
+				}
+				return true
+			},
+		)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return availableIps, nil
+}
@@ -0,0 +1,127 @@
+package aws
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"reflect"
+	"testing"
+	"time"
+
+	"dockyard/pkg/aws/fake"
+)
+
+func newTestEksClient() AwsEksClient {
+	cloud := fake.NewCloud()
+	cloud.SetPageSize(1)
+	cloud.AddCluster(testClusterName, "vpc-0123")
+	cloud.AddSubnet("vpc-0123", "subnet-a", "us-east-1a", 120)
+	cloud.AddSubnet("vpc-0123", "subnet-b", "us-east-1b", 8)
+	cloud.AddSubnet("vpc-4567", "subnet-c", "us-east-1c", 250)
+	cloud.SetQuota(EC2OnDemandServiceQuotaCode, 512)
+	cloud.SetQuota(EC2SpotServiceQuotaCode, 64)
+
+	return NewAwsEKSWithClients(
+		testClusterName,
+		cloud.EKS(),
+		cloud.EC2(),
+		cloud.STS(),
+		cloud.ServiceQuotas(),
+	)
+}
+
+func TestAvailableIp(t *testing.T) {
+	subnets, err := newTestEksClient().AvailableIp()
+	if err != nil {
+		t.Fatalf("AvailableIp failed, %s", err.Error())
+	}
+	want := [][]string{
+		{"subnet-a", "us-east-1a", "120"},
+		{"subnet-b", "us-east-1b", "8"},
+	}
+	if !reflect.DeepEqual(subnets, want) {
+		t.Errorf("AvailableIp returned %v, want %v", subnets, want)
+	}
+}
+
+func TestEc2Limits(t *testing.T) {
+	limits, err := newTestEksClient().Ec2Limits()
+	if err != nil {
+		t.Fatalf("Ec2Limits failed, %s", err.Error())
+	}
+	want := [][]string{
+		{"OnDemand Limit", "512"},
+		{"Spot Limit", "64"},
+	}
+	if !reflect.DeepEqual(limits, want) {
+		t.Errorf("Ec2Limits returned %v, want %v", limits, want)
+	}
+}
+
+// Returns a PEM encoded self-signed CA
+func testCA(t *testing.T, commonName string) []byte {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("Unable to generate key, %s", err.Error())
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: commonName},
+		NotBefore:             time.Now(),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("Unable to create certificate, %s", err.Error())
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+}
+
+func TestVerifyClusterCA(t *testing.T) {
+	clusterCA := testCA(t, "kubernetes")
+	otherCA := testCA(t, "kubernetes")
+
+	cloud := fake.NewCloud()
+	cloud.AddCluster(testClusterName, "vpc-0123")
+	cloud.SetClusterCA(testClusterName, clusterCA)
+	cloud.AddCluster("other-cluster", "vpc-4567")
+
+	newClient := func(clusterName string) AwsEksClient {
+		return NewAwsEKSWithClients(
+			clusterName,
+			cloud.EKS(),
+			cloud.EC2(),
+			cloud.STS(),
+			cloud.ServiceQuotas(),
+		)
+	}
+
+	tests := []struct {
+		name    string
+		cluster string
+		caData  []byte
+		wantErr bool
+	}{
+		{name: "same CA", cluster: testClusterName, caData: clusterCA, wantErr: false},
+		{name: "bundle with the CA", cluster: testClusterName, caData: append(append([]byte{}, otherCA...), clusterCA...), wantErr: false},
+		{name: "other CA", cluster: testClusterName, caData: otherCA, wantErr: true},
+		{name: "not PEM", cluster: testClusterName, caData: []byte("kubernetes"), wantErr: true},
+		{name: "cluster without CA", cluster: "other-cluster", caData: clusterCA, wantErr: true},
+		{name: "unknown cluster", cluster: "missing-cluster", caData: clusterCA, wantErr: true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := newClient(test.cluster).VerifyClusterCA(test.caData)
+			if (err != nil) != test.wantErr {
+				t.Errorf("VerifyClusterCA() = %v, want error %t", err, test.wantErr)
+			}
+		})
+	}
+}
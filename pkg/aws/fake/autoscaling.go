@@ -0,0 +1,413 @@
+package fake
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/autoscaling"
+	"github.com/aws/aws-sdk-go/service/autoscaling/autoscalingiface"
+)
+
+type autoScaling struct {
+	autoscalingiface.AutoScalingAPI
+	cloud *Cloud
+}
+
+func (a *autoScaling) DescribeAutoScalingGroups(
+	input *autoscaling.DescribeAutoScalingGroupsInput,
+) (*autoscaling.DescribeAutoScalingGroupsOutput, error) {
+	c := a.cloud
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if len(input.AutoScalingGroupNames) > maxAsgNamesPerRequest {
+		return nil, tooManyIds(
+			"auto scaling group names",
+			len(input.AutoScalingGroupNames),
+			maxAsgNamesPerRequest,
+		)
+	}
+
+	names := make([]string, 0, len(c.asgs))
+	for name := range c.asgs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	groups := []*autoscaling.Group{}
+	for _, name := range names {
+		group := c.asgs[name]
+		if !matches(input.AutoScalingGroupNames, name) ||
+			!asgMatchesFilters(group, input.Filters) {
+			continue
+		}
+		groups = append(groups, c.describeAsg(group))
+	}
+
+	start, end, next, err := c.page(len(groups), input.NextToken, input.MaxRecords)
+	if err != nil {
+		return nil, err
+	}
+	return &autoscaling.DescribeAutoScalingGroupsOutput{
+		AutoScalingGroups: groups[start:end],
+		NextToken:         next,
+	}, nil
+}
+
+func (a *autoScaling) DescribeAutoScalingGroupsPages(
+	input *autoscaling.DescribeAutoScalingGroupsInput,
+	fn func(*autoscaling.DescribeAutoScalingGroupsOutput, bool) bool,
+) error {
+	pageInput := *input
+	for {
+		output, err := a.DescribeAutoScalingGroups(&pageInput)
+		if err != nil {
+			return err
+		}
+		if !fn(output, output.NextToken == nil) || output.NextToken == nil {
+			return nil
+		}
+		pageInput.NextToken = output.NextToken
+	}
+}
+
+func asgMatchesFilters(group *asg, filters []*autoscaling.Filter) bool {
+	for _, filter := range filters {
+		name := aws.StringValue(filter.Name)
+		switch {
+		case strings.HasPrefix(name, "tag:"):
+			val, ok := group.tags[strings.TrimPrefix(name, "tag:")]
+			if !ok || !matches(filter.Values, val) {
+				return false
+			}
+		case name == "tag-key":
+			found := false
+			for key := range group.tags {
+				found = found || matches(filter.Values, key)
+			}
+			if !found {
+				return false
+			}
+		case name == "tag-value":
+			found := false
+			for _, val := range group.tags {
+				found = found || matches(filter.Values, val)
+			}
+			if !found {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+func (a *autoScaling) DescribeLaunchConfigurations(
+	input *autoscaling.DescribeLaunchConfigurationsInput,
+) (*autoscaling.DescribeLaunchConfigurationsOutput, error) {
+	// Fake asgs always use launch templates
+	return &autoscaling.DescribeLaunchConfigurationsOutput{
+		LaunchConfigurations: []*autoscaling.LaunchConfiguration{},
+	}, nil
+}
+
+func (a *autoScaling) DescribeLaunchConfigurationsPages(
+	input *autoscaling.DescribeLaunchConfigurationsInput,
+	fn func(*autoscaling.DescribeLaunchConfigurationsOutput, bool) bool,
+) error {
+	output, err := a.DescribeLaunchConfigurations(input)
+	if err != nil {
+		return err
+	}
+	fn(output, true)
+	return nil
+}
+
+func (a *autoScaling) UpdateAutoScalingGroup(
+	input *autoscaling.UpdateAutoScalingGroupInput,
+) (*autoscaling.UpdateAutoScalingGroupOutput, error) {
+	var err error
+	a.cloud.mutate(func() {
+		group, ok := a.cloud.asgs[aws.StringValue(input.AutoScalingGroupName)]
+		if !ok {
+			err = validationError(
+				"AutoScalingGroup name not found - %s",
+				aws.StringValue(input.AutoScalingGroupName),
+			)
+			return
+		}
+		minSize, maxSize := group.minSize, group.maxSize
+		if input.MinSize != nil {
+			minSize = *input.MinSize
+		}
+		if input.MaxSize != nil {
+			maxSize = *input.MaxSize
+		}
+		if minSize > maxSize {
+			err = validationError(
+				"Max bound, %d, must be greater than or equal to min bound, %d",
+				maxSize,
+				minSize,
+			)
+			return
+		}
+		group.minSize, group.maxSize = minSize, maxSize
+		if input.DesiredCapacity != nil {
+			group.desiredCapacity = *input.DesiredCapacity
+		}
+		if input.NewInstancesProtectedFromScaleIn != nil {
+			group.newInstancesProtect = *input.NewInstancesProtectedFromScaleIn
+		}
+		if input.DefaultCooldown != nil {
+			group.defaultCooldown = *input.DefaultCooldown
+		}
+	})
+	return &autoscaling.UpdateAutoScalingGroupOutput{}, err
+}
+
+func (a *autoScaling) SetDesiredCapacity(
+	input *autoscaling.SetDesiredCapacityInput,
+) (*autoscaling.SetDesiredCapacityOutput, error) {
+	var err error
+	a.cloud.mutate(func() {
+		group, ok := a.cloud.asgs[aws.StringValue(input.AutoScalingGroupName)]
+		if !ok {
+			err = validationError(
+				"AutoScalingGroup name not found - %s",
+				aws.StringValue(input.AutoScalingGroupName),
+			)
+			return
+		}
+		desired := aws.Int64Value(input.DesiredCapacity)
+		if desired < group.minSize || desired > group.maxSize {
+			err = validationError(
+				"New SetDesiredCapacity value %d is outside of the min size:%d and max size:%d",
+				desired,
+				group.minSize,
+				group.maxSize,
+			)
+			return
+		}
+		group.desiredCapacity = desired
+	})
+	return &autoscaling.SetDesiredCapacityOutput{}, err
+}
+
+func (a *autoScaling) SetInstanceProtection(
+	input *autoscaling.SetInstanceProtectionInput,
+) (*autoscaling.SetInstanceProtectionOutput, error) {
+	var err error
+	a.cloud.mutate(func() {
+		for _, instanceId := range input.InstanceIds {
+			instance, ok := a.cloud.instances[aws.StringValue(instanceId)]
+			if !ok || instance.Terminated ||
+				instance.AsgName != aws.StringValue(input.AutoScalingGroupName) {
+				err = validationError(
+					"The instance %s is not part of Auto Scaling group %s",
+					aws.StringValue(instanceId),
+					aws.StringValue(input.AutoScalingGroupName),
+				)
+				return
+			}
+			instance.ProtectedFromScaleIn = aws.BoolValue(input.ProtectedFromScaleIn)
+		}
+	})
+	return &autoscaling.SetInstanceProtectionOutput{}, err
+}
+
+func (a *autoScaling) TerminateInstanceInAutoScalingGroup(
+	input *autoscaling.TerminateInstanceInAutoScalingGroupInput,
+) (*autoscaling.TerminateInstanceInAutoScalingGroupOutput, error) {
+	var err error
+	a.cloud.mutate(func() {
+		instance, ok := a.cloud.instances[aws.StringValue(input.InstanceId)]
+		if !ok || instance.Terminated {
+			err = validationError(
+				"Instance Id not found - %s",
+				aws.StringValue(input.InstanceId),
+			)
+			return
+		}
+		if group, ok := a.cloud.asgs[instance.AsgName]; ok &&
+			aws.BoolValue(input.ShouldDecrementDesiredCapacity) {
+			group.desiredCapacity--
+		}
+		a.cloud.terminate(instance.InstanceId)
+	})
+	return &autoscaling.TerminateInstanceInAutoScalingGroupOutput{}, err
+}
+
+// Processes of an asg, suspending or resuming without processes affects all
+// of them
+var scalingProcesses = []string{
+	"Launch",
+	"Terminate",
+	"AddToLoadBalancer",
+	"AlarmNotification",
+	"AZRebalance",
+	"HealthCheck",
+	"InstanceRefresh",
+	"ReplaceUnhealthy",
+	"ScheduledActions",
+}
+
+func (a *autoScaling) SuspendProcesses(
+	input *autoscaling.ScalingProcessQuery,
+) (*autoscaling.SuspendProcessesOutput, error) {
+	err := a.setProcessesSuspended(input, true)
+	return &autoscaling.SuspendProcessesOutput{}, err
+}
+
+func (a *autoScaling) ResumeProcesses(
+	input *autoscaling.ScalingProcessQuery,
+) (*autoscaling.ResumeProcessesOutput, error) {
+	err := a.setProcessesSuspended(input, false)
+	return &autoscaling.ResumeProcessesOutput{}, err
+}
+
+func (a *autoScaling) setProcessesSuspended(
+	input *autoscaling.ScalingProcessQuery,
+	suspended bool,
+) error {
+	c := a.cloud
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	group, ok := c.asgs[aws.StringValue(input.AutoScalingGroupName)]
+	if !ok {
+		return validationError(
+			"AutoScalingGroup name not found - %s",
+			aws.StringValue(input.AutoScalingGroupName),
+		)
+	}
+	processes := aws.StringValueSlice(input.ScalingProcesses)
+	if len(processes) == 0 {
+		processes = scalingProcesses
+	}
+	for _, process := range processes {
+		known := false
+		for _, scalingProcess := range scalingProcesses {
+			known = known || scalingProcess == process
+		}
+		if !known {
+			return validationError("Invalid scaling process %s", process)
+		}
+	}
+	for _, process := range processes {
+		if suspended {
+			group.suspendedProcesses[process] = true
+		} else {
+			delete(group.suspendedProcesses, process)
+		}
+	}
+	return nil
+}
+
+func (a *autoScaling) CreateOrUpdateTags(
+	input *autoscaling.CreateOrUpdateTagsInput,
+) (*autoscaling.CreateOrUpdateTagsOutput, error) {
+	c := a.cloud
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	for _, tag := range input.Tags {
+		group, ok := c.asgs[aws.StringValue(tag.ResourceId)]
+		if !ok {
+			return nil, validationError(
+				"AutoScalingGroup name not found - %s",
+				aws.StringValue(tag.ResourceId),
+			)
+		}
+		group.tags[aws.StringValue(tag.Key)] = aws.StringValue(tag.Value)
+	}
+	return &autoscaling.CreateOrUpdateTagsOutput{}, nil
+}
+
+func (a *autoScaling) DeleteTags(
+	input *autoscaling.DeleteTagsInput,
+) (*autoscaling.DeleteTagsOutput, error) {
+	c := a.cloud
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	for _, tag := range input.Tags {
+		group, ok := c.asgs[aws.StringValue(tag.ResourceId)]
+		if !ok {
+			return nil, validationError(
+				"AutoScalingGroup name not found - %s",
+				aws.StringValue(tag.ResourceId),
+			)
+		}
+		delete(group.tags, aws.StringValue(tag.Key))
+	}
+	return &autoscaling.DeleteTagsOutput{}, nil
+}
+
+func (a *autoScaling) DescribeTags(
+	input *autoscaling.DescribeTagsInput,
+) (*autoscaling.DescribeTagsOutput, error) {
+	c := a.cloud
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	tags := []*autoscaling.TagDescription{}
+	names := make([]string, 0, len(c.asgs))
+	for name := range c.asgs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		for _, tag := range c.describeAsg(c.asgs[name]).Tags {
+			if tagMatchesFilters(tag, input.Filters) {
+				tags = append(tags, tag)
+			}
+		}
+	}
+
+	start, end, next, err := c.page(len(tags), input.NextToken, input.MaxRecords)
+	if err != nil {
+		return nil, err
+	}
+	return &autoscaling.DescribeTagsOutput{
+		Tags:      tags[start:end],
+		NextToken: next,
+	}, nil
+}
+
+func (a *autoScaling) DescribeTagsPages(
+	input *autoscaling.DescribeTagsInput,
+	fn func(*autoscaling.DescribeTagsOutput, bool) bool,
+) error {
+	pageInput := *input
+	for {
+		output, err := a.DescribeTags(&pageInput)
+		if err != nil {
+			return err
+		}
+		if !fn(output, output.NextToken == nil) || output.NextToken == nil {
+			return nil
+		}
+		pageInput.NextToken = output.NextToken
+	}
+}
+
+func tagMatchesFilters(
+	tag *autoscaling.TagDescription,
+	filters []*autoscaling.Filter,
+) bool {
+	for _, filter := range filters {
+		switch aws.StringValue(filter.Name) {
+		case "auto-scaling-group":
+			if !matches(filter.Values, aws.StringValue(tag.ResourceId)) {
+				return false
+			}
+		case "key":
+			if !matches(filter.Values, aws.StringValue(tag.Key)) {
+				return false
+			}
+		case "value":
+			if !matches(filter.Values, aws.StringValue(tag.Value)) {
+				return false
+			}
+		}
+	}
+	return true
+}
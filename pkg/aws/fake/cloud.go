@@ -0,0 +1,645 @@
+// Package fake provides an in-memory AWS for testing dockyard without an
+// AWS account. Cloud keeps auto scaling groups, launch templates, instances,
+// tags and quotas, and simulates instance launches and terminations the way
+// an auto scaling group reacts to capacity changes.
+//
+// The fake clients only implement the api calls dockyard makes. Calling any
+// other method of the embedded sdk interfaces panics. Describe and list
+// calls are paginated and reject more ids per request than AWS accepts.
+package fake
+
+import (
+	"encoding/base64"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/autoscaling"
+	"github.com/aws/aws-sdk-go/service/autoscaling/autoscalingiface"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/aws/aws-sdk-go/service/ec2/ec2iface"
+	"github.com/aws/aws-sdk-go/service/eks"
+	"github.com/aws/aws-sdk-go/service/eks/eksiface"
+	"github.com/aws/aws-sdk-go/service/servicequotas/servicequotasiface"
+	"github.com/aws/aws-sdk-go/service/sts/stsiface"
+)
+
+const (
+	// Items per page of describe and list calls unless changed by SetPageSize
+	defaultPageSize = 50
+
+	// Max asg names per DescribeAutoScalingGroups call
+	maxAsgNamesPerRequest = 100
+
+	// Max instance or image ids per ec2 describe call
+	maxIdsPerRequest = 100
+)
+
+// Instance launched by the fake cloud
+type Instance struct {
+	InstanceId     string
+	PrivateDnsName string
+	AsgName        string
+	ImageId        string
+	InstanceType   string
+	// Version of the launch template the instance was launched with
+	LaunchTemplateVersion int64
+	ProtectedFromScaleIn  bool
+	Terminated            bool
+}
+
+// Auto scaling group to be created in the fake cloud
+type AsgSpec struct {
+	Name             string
+	LaunchTemplateId string
+	MinSize          int64
+	MaxSize          int64
+	DesiredCapacity  int64
+	Tags             map[string]string
+	SubnetIds        []string
+	// Processes suspended when the asg is created, e.g. AZRebalance
+	SuspendedProcesses []string
+	// Defaults to 300 seconds like groups created through the api
+	DefaultCooldown int64
+	// Version of the launch template the asg launches, a number, $Latest
+	// or $Default. Defaults to $Latest.
+	LaunchTemplateVersion string
+}
+
+type asg struct {
+	name                  string
+	launchTemplateId      string
+	launchTemplateVersion string
+	minSize               int64
+	maxSize               int64
+	desiredCapacity       int64
+	newInstancesProtect   bool
+	tags                  map[string]string
+	subnetIds             []string
+	instanceIds           []string
+	suspendedProcesses    map[string]bool
+	defaultCooldown       int64
+}
+
+type launchTemplate struct {
+	id string
+	// image ids of all versions, version n is at index n-1
+	imageIds     []string
+	instanceType string
+	// instance types of single versions, overriding instanceType
+	versionInstanceTypes map[int64]string
+	// zero if the latest version is the default one
+	defaultVersion int64
+}
+
+// Returns the version number of a version given as number, $Latest or
+// $Default, zero if the version doesn't exist
+func (lt *launchTemplate) resolve(version string) int64 {
+	switch version {
+	case "", "$Latest":
+		return int64(len(lt.imageIds))
+	case "$Default":
+		if lt.defaultVersion != 0 {
+			return lt.defaultVersion
+		}
+		return int64(len(lt.imageIds))
+	}
+	number, err := strconv.ParseInt(version, 10, 64)
+	if err != nil || number < 1 || number > int64(len(lt.imageIds)) {
+		return 0
+	}
+	return number
+}
+
+func (lt *launchTemplate) instanceTypeOf(version int64) string {
+	if instanceType, ok := lt.versionInstanceTypes[version]; ok {
+		return instanceType
+	}
+	return lt.instanceType
+}
+
+type Cloud struct {
+	lock            sync.Mutex
+	asgs            map[string]*asg
+	launchTemplates map[string]*launchTemplate
+	instances       map[string]*Instance
+	images          map[string]*ec2.Image
+	instanceTypes   map[string]*ec2.InstanceTypeInfo
+	clusters        map[string]*eks.Cluster
+	subnets         []*ec2.Subnet
+	quotas          map[string]float64
+	accountId       string
+	launched        int
+	onLaunch        []func(Instance)
+	pageSize        int
+}
+
+func NewCloud() *Cloud {
+	return &Cloud{
+		asgs:            map[string]*asg{},
+		launchTemplates: map[string]*launchTemplate{},
+		instances:       map[string]*Instance{},
+		images:          map[string]*ec2.Image{},
+		instanceTypes:   map[string]*ec2.InstanceTypeInfo{},
+		clusters:        map[string]*eks.Cluster{},
+		subnets:         []*ec2.Subnet{},
+		quotas:          map[string]float64{},
+		accountId:       "123456789012",
+		pageSize:        defaultPageSize,
+	}
+}
+
+// Sets the max number of items returned per page by describe and list
+// calls, e.g. to make a handful of asgs span several pages
+func (c *Cloud) SetPageSize(size int) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if size > 0 {
+		c.pageSize = size
+	}
+}
+
+// Registers a callback which is invoked for every launched instance, e.g.
+// to register a k8s node for the instance
+func (c *Cloud) OnLaunch(f func(Instance)) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.onLaunch = append(c.onLaunch, f)
+}
+
+// Adds an ami which can be referenced by launch templates
+func (c *Cloud) AddImage(imageId, name string) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.images[imageId] = &ec2.Image{
+		ImageId: aws.String(imageId),
+		Name:    aws.String(name),
+	}
+}
+
+// Adds a new version of the launch template, the launch template is created
+// if it doesn't exist. Returns the number of the new version.
+func (c *Cloud) AddLaunchTemplateVersion(launchTemplateId, imageId string) int64 {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	lt, ok := c.launchTemplates[launchTemplateId]
+	if !ok {
+		lt = &launchTemplate{id: launchTemplateId}
+		c.launchTemplates[launchTemplateId] = lt
+	}
+	lt.imageIds = append(lt.imageIds, imageId)
+	return int64(len(lt.imageIds))
+}
+
+// Sets the instance type of all versions of the launch template
+func (c *Cloud) SetLaunchTemplateInstanceType(launchTemplateId, instanceType string) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	lt, ok := c.launchTemplates[launchTemplateId]
+	if !ok {
+		lt = &launchTemplate{id: launchTemplateId}
+		c.launchTemplates[launchTemplateId] = lt
+	}
+	lt.instanceType = instanceType
+}
+
+// Sets the instance type of a single version of the launch template
+func (c *Cloud) SetLaunchTemplateVersionInstanceType(
+	launchTemplateId string,
+	version int64,
+	instanceType string,
+) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	lt := c.launchTemplates[launchTemplateId]
+	if lt.versionInstanceTypes == nil {
+		lt.versionInstanceTypes = map[int64]string{}
+	}
+	lt.versionInstanceTypes[version] = instanceType
+}
+
+// Makes the version the $Default version of the launch template, the
+// latest version is the default one otherwise
+func (c *Cloud) SetLaunchTemplateDefaultVersion(launchTemplateId string, version int64) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.launchTemplates[launchTemplateId].defaultVersion = version
+}
+
+// Adds an instance type which can be described
+func (c *Cloud) AddInstanceType(instanceType string, vCpus, maxEnis, ipsPerEni int64) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.instanceTypes[instanceType] = &ec2.InstanceTypeInfo{
+		InstanceType: aws.String(instanceType),
+		VCpuInfo:     &ec2.VCpuInfo{DefaultVCpus: aws.Int64(vCpus)},
+		NetworkInfo: &ec2.NetworkInfo{
+			MaximumNetworkInterfaces:  aws.Int64(maxEnis),
+			Ipv4AddressesPerInterface: aws.Int64(ipsPerEni),
+		},
+	}
+}
+
+// Creates the auto scaling group and launches its desired instances
+func (c *Cloud) AddAsg(spec AsgSpec) {
+	c.mutate(func() {
+		tags := map[string]string{}
+		for key, val := range spec.Tags {
+			tags[key] = val
+		}
+		suspended := map[string]bool{}
+		for _, process := range spec.SuspendedProcesses {
+			suspended[process] = true
+		}
+		cooldown := spec.DefaultCooldown
+		if cooldown == 0 {
+			cooldown = 300
+		}
+		version := spec.LaunchTemplateVersion
+		if len(version) == 0 {
+			version = "$Latest"
+		}
+		c.asgs[spec.Name] = &asg{
+			name:                  spec.Name,
+			launchTemplateId:      spec.LaunchTemplateId,
+			launchTemplateVersion: version,
+			minSize:               spec.MinSize,
+			maxSize:               spec.MaxSize,
+			desiredCapacity:       spec.DesiredCapacity,
+			tags:                  tags,
+			subnetIds:             append([]string{}, spec.SubnetIds...),
+			suspendedProcesses:    suspended,
+			defaultCooldown:       cooldown,
+		}
+	})
+}
+
+// Adds an eks cluster along with its vpc
+func (c *Cloud) AddCluster(name, vpcId string) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.clusters[name] = &eks.Cluster{
+		Name: aws.String(name),
+		ResourcesVpcConfig: &eks.VpcConfigResponse{
+			VpcId: aws.String(vpcId),
+		},
+	}
+}
+
+// Sets the PEM encoded certificate authority of the eks cluster
+func (c *Cloud) SetClusterCA(name string, caData []byte) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.clusters[name].CertificateAuthority = &eks.Certificate{
+		Data: aws.String(base64.StdEncoding.EncodeToString(caData)),
+	}
+}
+
+func (c *Cloud) AddSubnet(vpcId, subnetId, availabilityZone string, availableIps int64) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.subnets = append(c.subnets, &ec2.Subnet{
+		VpcId:                   aws.String(vpcId),
+		SubnetId:                aws.String(subnetId),
+		AvailabilityZone:        aws.String(availabilityZone),
+		AvailableIpAddressCount: aws.Int64(availableIps),
+	})
+}
+
+// Sets the value of the ec2 service quota
+func (c *Cloud) SetQuota(quotaCode string, value float64) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.quotas[quotaCode] = value
+}
+
+// Returns running instances of the asg
+func (c *Cloud) Instances(asgName string) []Instance {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	instances := make([]Instance, 0)
+	group, ok := c.asgs[asgName]
+	if !ok {
+		return instances
+	}
+	for _, instanceId := range group.instanceIds {
+		instances = append(instances, *c.instances[instanceId])
+	}
+	return instances
+}
+
+// Returns the instance, terminated instances are returned as well
+func (c *Cloud) Instance(instanceId string) (Instance, bool) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	instance, ok := c.instances[instanceId]
+	if !ok {
+		return Instance{}, false
+	}
+	return *instance, true
+}
+
+// Returns min, max and desired capacity of the asg
+func (c *Cloud) Capacity(asgName string) (min, max, desired int64) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	group, ok := c.asgs[asgName]
+	if !ok {
+		return 0, 0, 0
+	}
+	return group.minSize, group.maxSize, group.desiredCapacity
+}
+
+// Returns tags of the asg
+func (c *Cloud) Tags(asgName string) map[string]string {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	tags := map[string]string{}
+	if group, ok := c.asgs[asgName]; ok {
+		for key, val := range group.tags {
+			tags[key] = val
+		}
+	}
+	return tags
+}
+
+// Returns the suspended processes of the asg in alphabetical order
+func (c *Cloud) SuspendedProcesses(asgName string) []string {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	processes := make([]string, 0)
+	if group, ok := c.asgs[asgName]; ok {
+		for process := range group.suspendedProcesses {
+			processes = append(processes, process)
+		}
+	}
+	sort.Strings(processes)
+	return processes
+}
+
+// Returns the default cooldown of the asg in seconds
+func (c *Cloud) Cooldown(asgName string) int64 {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	group, ok := c.asgs[asgName]
+	if !ok {
+		return 0
+	}
+	return group.defaultCooldown
+}
+
+// Checks if new instances of the asg are protected from scale in
+func (c *Cloud) NewInstancesProtected(asgName string) bool {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	group, ok := c.asgs[asgName]
+	return ok && group.newInstancesProtect
+}
+
+func (c *Cloud) AutoScaling() autoscalingiface.AutoScalingAPI {
+	return &autoScaling{cloud: c}
+}
+
+func (c *Cloud) EC2() ec2iface.EC2API {
+	return &ec2Client{cloud: c}
+}
+
+func (c *Cloud) EKS() eksiface.EKSAPI {
+	return &eksClient{cloud: c}
+}
+
+func (c *Cloud) STS() stsiface.STSAPI {
+	return &stsClient{cloud: c}
+}
+
+func (c *Cloud) ServiceQuotas() servicequotasiface.ServiceQuotasAPI {
+	return &serviceQuotas{cloud: c}
+}
+
+// Runs f with the cloud locked and brings all asgs to their desired
+// capacity afterwards. Launch callbacks are invoked once the lock is
+// released.
+func (c *Cloud) mutate(f func()) {
+	c.lock.Lock()
+	f()
+	launched := make([]Instance, 0)
+	names := make([]string, 0, len(c.asgs))
+	for name := range c.asgs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		launched = append(launched, c.reconcile(c.asgs[name])...)
+	}
+	callbacks := append([]func(Instance){}, c.onLaunch...)
+	c.lock.Unlock()
+
+	for _, instance := range launched {
+		for _, callback := range callbacks {
+			callback(instance)
+		}
+	}
+}
+
+// Clamps the desired capacity to min and max, launches missing instances
+// and terminates surplus ones. Instances protected from scale in are never
+// terminated, instances of older launch template versions are terminated
+// first.
+func (c *Cloud) reconcile(group *asg) []Instance {
+	if group.desiredCapacity < group.minSize {
+		group.desiredCapacity = group.minSize
+	}
+	if group.desiredCapacity > group.maxSize {
+		group.desiredCapacity = group.maxSize
+	}
+
+	launched := make([]Instance, 0)
+	for int64(len(group.instanceIds)) < group.desiredCapacity {
+		instance := c.launch(group)
+		group.instanceIds = append(group.instanceIds, instance.InstanceId)
+		launched = append(launched, *instance)
+	}
+
+	for int64(len(group.instanceIds)) > group.desiredCapacity {
+		victim := -1
+		for i, instanceId := range group.instanceIds {
+			instance := c.instances[instanceId]
+			if instance.ProtectedFromScaleIn {
+				continue
+			}
+			if victim == -1 ||
+				instance.LaunchTemplateVersion <
+					c.instances[group.instanceIds[victim]].LaunchTemplateVersion {
+				victim = i
+			}
+		}
+		if victim == -1 {
+			break
+		}
+		c.instances[group.instanceIds[victim]].Terminated = true
+		group.instanceIds = append(
+			group.instanceIds[:victim],
+			group.instanceIds[victim+1:]...,
+		)
+	}
+	return launched
+}
+
+func (c *Cloud) launch(group *asg) *Instance {
+	c.launched++
+	instance := &Instance{
+		InstanceId:           fmt.Sprintf("i-%017d", c.launched),
+		PrivateDnsName:       fmt.Sprintf("ip-10-0-%d-%d.ec2.internal", c.launched/250, c.launched%250),
+		AsgName:              group.name,
+		ProtectedFromScaleIn: group.newInstancesProtect,
+	}
+	if lt, ok := c.launchTemplates[group.launchTemplateId]; ok {
+		if version := lt.resolve(group.launchTemplateVersion); version != 0 {
+			instance.LaunchTemplateVersion = version
+			instance.ImageId = lt.imageIds[version-1]
+			instance.InstanceType = lt.instanceTypeOf(version)
+		}
+	}
+	c.instances[instance.InstanceId] = instance
+	return instance
+}
+
+// Removes the instance from its asg, the asg launches a replacement to
+// keep its desired capacity
+func (c *Cloud) terminate(instanceId string) {
+	instance, ok := c.instances[instanceId]
+	if !ok || instance.Terminated {
+		return
+	}
+	instance.Terminated = true
+	group, ok := c.asgs[instance.AsgName]
+	if !ok {
+		return
+	}
+	for i, id := range group.instanceIds {
+		if id == instanceId {
+			group.instanceIds = append(group.instanceIds[:i], group.instanceIds[i+1:]...)
+			break
+		}
+	}
+}
+
+func (c *Cloud) describeAsg(group *asg) *autoscaling.Group {
+	result := &autoscaling.Group{
+		AutoScalingGroupName:             aws.String(group.name),
+		MinSize:                          aws.Int64(group.minSize),
+		MaxSize:                          aws.Int64(group.maxSize),
+		DesiredCapacity:                  aws.Int64(group.desiredCapacity),
+		NewInstancesProtectedFromScaleIn: aws.Bool(group.newInstancesProtect),
+		DefaultCooldown:                  aws.Int64(group.defaultCooldown),
+		CreatedTime:                      aws.Time(time.Time{}),
+		VPCZoneIdentifier:                aws.String(strings.Join(group.subnetIds, ",")),
+		LaunchTemplate: &autoscaling.LaunchTemplateSpecification{
+			LaunchTemplateId: aws.String(group.launchTemplateId),
+			Version:          aws.String(group.launchTemplateVersion),
+		},
+	}
+	for _, instanceId := range group.instanceIds {
+		instance := c.instances[instanceId]
+		result.Instances = append(result.Instances, &autoscaling.Instance{
+			InstanceId:           aws.String(instance.InstanceId),
+			HealthStatus:         aws.String("Healthy"),
+			LifecycleState:       aws.String(autoscaling.LifecycleStateInService),
+			ProtectedFromScaleIn: aws.Bool(instance.ProtectedFromScaleIn),
+			LaunchTemplate: &autoscaling.LaunchTemplateSpecification{
+				LaunchTemplateId: aws.String(group.launchTemplateId),
+				Version:          aws.String(strconv.FormatInt(instance.LaunchTemplateVersion, 10)),
+			},
+		})
+	}
+	processes := make([]string, 0, len(group.suspendedProcesses))
+	for process := range group.suspendedProcesses {
+		processes = append(processes, process)
+	}
+	sort.Strings(processes)
+	for _, process := range processes {
+		result.SuspendedProcesses = append(result.SuspendedProcesses, &autoscaling.SuspendedProcess{
+			ProcessName:      aws.String(process),
+			SuspensionReason: aws.String("User suspended at 2022-09-14T10:00:00Z"),
+		})
+	}
+	keys := make([]string, 0, len(group.tags))
+	for key := range group.tags {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		result.Tags = append(result.Tags, &autoscaling.TagDescription{
+			Key:               aws.String(key),
+			Value:             aws.String(group.tags[key]),
+			ResourceId:        aws.String(group.name),
+			ResourceType:      aws.String("auto-scaling-group"),
+			PropagateAtLaunch: aws.Bool(false),
+		})
+	}
+	return result
+}
+
+// Returns the bounds of the page of total items starting at token and the
+// token of the next page, which is nil on the last page. maxItems lowers the
+// page size the way MaxRecords / MaxResults do.
+func (c *Cloud) page(
+	total int,
+	token *string,
+	maxItems *int64,
+) (start, end int, next *string, err error) {
+	size := c.pageSize
+	if maxItems != nil && *maxItems > 0 && int(*maxItems) < size {
+		size = int(*maxItems)
+	}
+	if token != nil {
+		start, err = strconv.Atoi(*token)
+		if err != nil || start < 0 || start > total {
+			return 0, 0, nil, awserr.New(
+				"InvalidNextToken",
+				fmt.Sprintf("The token '%s' is invalid.", *token),
+				nil,
+			)
+		}
+	}
+	end = start + size
+	if end >= total {
+		return start, total, nil, nil
+	}
+	return start, end, aws.String(strconv.Itoa(end)), nil
+}
+
+func notFound(code, format string, args ...interface{}) error {
+	return awserr.New(code, fmt.Sprintf(format, args...), nil)
+}
+
+func validationError(format string, args ...interface{}) error {
+	return awserr.New("ValidationError", fmt.Sprintf(format, args...), nil)
+}
+
+func tooManyIds(kind string, count, max int) error {
+	return validationError(
+		"The maximum number of %s per request is %d, %d were given",
+		kind,
+		max,
+		count,
+	)
+}
+
+// Checks if values contains val, empty values match everything
+func matches(values []*string, val string) bool {
+	if len(values) == 0 {
+		return true
+	}
+	for _, v := range values {
+		if aws.StringValue(v) == val {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,396 @@
+package fake
+
+import (
+	"sort"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/aws/aws-sdk-go/service/ec2/ec2iface"
+)
+
+type ec2Client struct {
+	ec2iface.EC2API
+	cloud *Cloud
+}
+
+func (e *ec2Client) DescribeInstances(
+	input *ec2.DescribeInstancesInput,
+) (*ec2.DescribeInstancesOutput, error) {
+	c := e.cloud
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	instanceIds := aws.StringValueSlice(input.InstanceIds)
+	if len(instanceIds) > maxIdsPerRequest {
+		return nil, tooManyIds("instance ids", len(instanceIds), maxIdsPerRequest)
+	}
+	if len(instanceIds) == 0 {
+		for instanceId := range c.instances {
+			instanceIds = append(instanceIds, instanceId)
+		}
+		sort.Strings(instanceIds)
+	}
+
+	instances := []*ec2.Instance{}
+	for _, instanceId := range instanceIds {
+		instance, ok := c.instances[instanceId]
+		if !ok {
+			return nil, notFound(
+				"InvalidInstanceID.NotFound",
+				"The instance ID '%s' does not exist",
+				instanceId,
+			)
+		}
+		state := ec2.InstanceStateNameRunning
+		if instance.Terminated {
+			state = ec2.InstanceStateNameTerminated
+		}
+		matched := true
+		for _, filter := range input.Filters {
+			if aws.StringValue(filter.Name) == "instance-state-name" &&
+				!matches(filter.Values, state) {
+				matched = false
+			}
+		}
+		if !matched {
+			continue
+		}
+		instances = append(instances, &ec2.Instance{
+			InstanceId:     aws.String(instance.InstanceId),
+			PrivateDnsName: aws.String(instance.PrivateDnsName),
+			ImageId:        aws.String(instance.ImageId),
+			InstanceType:   aws.String(instance.InstanceType),
+			State:          &ec2.InstanceState{Name: aws.String(state)},
+			Tags: []*ec2.Tag{
+				{
+					Key:   aws.String("aws:autoscaling:groupName"),
+					Value: aws.String(instance.AsgName),
+				},
+			},
+		})
+	}
+
+	start, end, next, err := c.page(len(instances), input.NextToken, input.MaxResults)
+	if err != nil {
+		return nil, err
+	}
+	return &ec2.DescribeInstancesOutput{
+		Reservations: []*ec2.Reservation{
+			{Instances: instances[start:end]},
+		},
+		NextToken: next,
+	}, nil
+}
+
+func (e *ec2Client) DescribeInstancesPages(
+	input *ec2.DescribeInstancesInput,
+	fn func(*ec2.DescribeInstancesOutput, bool) bool,
+) error {
+	pageInput := *input
+	for {
+		output, err := e.DescribeInstances(&pageInput)
+		if err != nil {
+			return err
+		}
+		if !fn(output, output.NextToken == nil) || output.NextToken == nil {
+			return nil
+		}
+		pageInput.NextToken = output.NextToken
+	}
+}
+
+// Instances are healthy right after their launch
+func (e *ec2Client) DescribeInstanceStatus(
+	input *ec2.DescribeInstanceStatusInput,
+) (*ec2.DescribeInstanceStatusOutput, error) {
+	c := e.cloud
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if len(input.InstanceIds) > maxIdsPerRequest {
+		return nil, tooManyIds("instance ids", len(input.InstanceIds), maxIdsPerRequest)
+	}
+
+	statuses := []*ec2.InstanceStatus{}
+	for _, instanceId := range aws.StringValueSlice(input.InstanceIds) {
+		instance, ok := c.instances[instanceId]
+		if !ok {
+			return nil, notFound(
+				"InvalidInstanceID.NotFound",
+				"The instance ID '%s' does not exist",
+				instanceId,
+			)
+		}
+		if instance.Terminated {
+			continue
+		}
+		statuses = append(statuses, &ec2.InstanceStatus{
+			InstanceId: aws.String(instanceId),
+			InstanceState: &ec2.InstanceState{
+				Name: aws.String(ec2.InstanceStateNameRunning),
+			},
+			SystemStatus: &ec2.InstanceStatusSummary{
+				Status: aws.String(ec2.SummaryStatusOk),
+			},
+			InstanceStatus: &ec2.InstanceStatusSummary{
+				Status: aws.String(ec2.SummaryStatusOk),
+			},
+		})
+	}
+
+	start, end, next, err := c.page(len(statuses), input.NextToken, input.MaxResults)
+	if err != nil {
+		return nil, err
+	}
+	return &ec2.DescribeInstanceStatusOutput{
+		InstanceStatuses: statuses[start:end],
+		NextToken:        next,
+	}, nil
+}
+
+func (e *ec2Client) DescribeInstanceStatusPages(
+	input *ec2.DescribeInstanceStatusInput,
+	fn func(*ec2.DescribeInstanceStatusOutput, bool) bool,
+) error {
+	pageInput := *input
+	for {
+		output, err := e.DescribeInstanceStatus(&pageInput)
+		if err != nil {
+			return err
+		}
+		if !fn(output, output.NextToken == nil) || output.NextToken == nil {
+			return nil
+		}
+		pageInput.NextToken = output.NextToken
+	}
+}
+
+// Terminating an instance of an asg makes the asg launch a replacement
+func (e *ec2Client) TerminateInstances(
+	input *ec2.TerminateInstancesInput,
+) (*ec2.TerminateInstancesOutput, error) {
+	if len(input.InstanceIds) > maxIdsPerRequest {
+		return nil, tooManyIds("instance ids", len(input.InstanceIds), maxIdsPerRequest)
+	}
+
+	var err error
+	e.cloud.mutate(func() {
+		for _, instanceId := range aws.StringValueSlice(input.InstanceIds) {
+			if _, ok := e.cloud.instances[instanceId]; !ok {
+				err = notFound(
+					"InvalidInstanceID.NotFound",
+					"The instance ID '%s' does not exist",
+					instanceId,
+				)
+				return
+			}
+			e.cloud.terminate(instanceId)
+		}
+	})
+	return &ec2.TerminateInstancesOutput{}, err
+}
+
+// Versions are returned from the latest to the oldest one
+func (e *ec2Client) DescribeLaunchTemplateVersions(
+	input *ec2.DescribeLaunchTemplateVersionsInput,
+) (*ec2.DescribeLaunchTemplateVersionsOutput, error) {
+	c := e.cloud
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	lt, ok := c.launchTemplates[aws.StringValue(input.LaunchTemplateId)]
+	if !ok {
+		return nil, notFound(
+			"InvalidLaunchTemplateId.NotFound",
+			"The specified launch template, with template ID %s, does not exist",
+			aws.StringValue(input.LaunchTemplateId),
+		)
+	}
+
+	// Without versions all versions are described
+	requested := map[int64]bool{}
+	for _, version := range aws.StringValueSlice(input.Versions) {
+		number := lt.resolve(version)
+		if number == 0 {
+			return nil, notFound(
+				"InvalidLaunchTemplateId.VersionNotFound",
+				"Could not find launch template version %s of launch template %s",
+				version,
+				lt.id,
+			)
+		}
+		requested[number] = true
+	}
+	defaultVersion := lt.resolve("$Default")
+
+	versions := []*ec2.LaunchTemplateVersion{}
+	for version := int64(len(lt.imageIds)); version > 0; version-- {
+		if len(requested) != 0 && !requested[version] {
+			continue
+		}
+		versions = append(
+			versions,
+			&ec2.LaunchTemplateVersion{
+				LaunchTemplateId: aws.String(lt.id),
+				VersionNumber:    aws.Int64(version),
+				DefaultVersion:   aws.Bool(version == defaultVersion),
+				LaunchTemplateData: &ec2.ResponseLaunchTemplateData{
+					ImageId:      aws.String(lt.imageIds[version-1]),
+					InstanceType: aws.String(lt.instanceTypeOf(version)),
+				},
+			},
+		)
+	}
+
+	start, end, next, err := c.page(len(versions), input.NextToken, input.MaxResults)
+	if err != nil {
+		return nil, err
+	}
+	return &ec2.DescribeLaunchTemplateVersionsOutput{
+		LaunchTemplateVersions: versions[start:end],
+		NextToken:              next,
+	}, nil
+}
+
+func (e *ec2Client) DescribeLaunchTemplateVersionsPages(
+	input *ec2.DescribeLaunchTemplateVersionsInput,
+	fn func(*ec2.DescribeLaunchTemplateVersionsOutput, bool) bool,
+) error {
+	pageInput := *input
+	for {
+		output, err := e.DescribeLaunchTemplateVersions(&pageInput)
+		if err != nil {
+			return err
+		}
+		if !fn(output, output.NextToken == nil) || output.NextToken == nil {
+			return nil
+		}
+		pageInput.NextToken = output.NextToken
+	}
+}
+
+// Images are only looked up by id, describing all images is refused since
+// it would list every public ami of the region
+func (e *ec2Client) DescribeImages(
+	input *ec2.DescribeImagesInput,
+) (*ec2.DescribeImagesOutput, error) {
+	c := e.cloud
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if len(input.ImageIds) == 0 {
+		return nil, validationError("DescribeImages without image ids isn't supported")
+	}
+	if len(input.ImageIds) > maxIdsPerRequest {
+		return nil, tooManyIds("image ids", len(input.ImageIds), maxIdsPerRequest)
+	}
+
+	output := &ec2.DescribeImagesOutput{Images: []*ec2.Image{}}
+	for _, imageId := range aws.StringValueSlice(input.ImageIds) {
+		if image, ok := c.images[imageId]; ok {
+			output.Images = append(output.Images, image)
+		}
+	}
+	return output, nil
+}
+
+func (e *ec2Client) DescribeSubnets(
+	input *ec2.DescribeSubnetsInput,
+) (*ec2.DescribeSubnetsOutput, error) {
+	c := e.cloud
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	subnets := []*ec2.Subnet{}
+	for _, subnet := range c.subnets {
+		if !matches(input.SubnetIds, aws.StringValue(subnet.SubnetId)) {
+			continue
+		}
+		matched := true
+		for _, filter := range input.Filters {
+			if aws.StringValue(filter.Name) == "vpc-id" &&
+				!matches(filter.Values, aws.StringValue(subnet.VpcId)) {
+				matched = false
+			}
+		}
+		if matched {
+			subnets = append(subnets, subnet)
+		}
+	}
+
+	start, end, next, err := c.page(len(subnets), input.NextToken, input.MaxResults)
+	if err != nil {
+		return nil, err
+	}
+	return &ec2.DescribeSubnetsOutput{
+		Subnets:   subnets[start:end],
+		NextToken: next,
+	}, nil
+}
+
+func (e *ec2Client) DescribeSubnetsPages(
+	input *ec2.DescribeSubnetsInput,
+	fn func(*ec2.DescribeSubnetsOutput, bool) bool,
+) error {
+	pageInput := *input
+	for {
+		output, err := e.DescribeSubnets(&pageInput)
+		if err != nil {
+			return err
+		}
+		if !fn(output, output.NextToken == nil) || output.NextToken == nil {
+			return nil
+		}
+		pageInput.NextToken = output.NextToken
+	}
+}
+
+func (e *ec2Client) DescribeInstanceTypes(
+	input *ec2.DescribeInstanceTypesInput,
+) (*ec2.DescribeInstanceTypesOutput, error) {
+	c := e.cloud
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if len(input.InstanceTypes) > maxIdsPerRequest {
+		return nil, tooManyIds("instance types", len(input.InstanceTypes), maxIdsPerRequest)
+	}
+
+	infos := []*ec2.InstanceTypeInfo{}
+	for _, instanceType := range aws.StringValueSlice(input.InstanceTypes) {
+		info, ok := c.instanceTypes[instanceType]
+		if !ok {
+			return nil, validationError(
+				"The following supplied instance types do not exist: [%s]",
+				instanceType,
+			)
+		}
+		infos = append(infos, info)
+	}
+
+	start, end, next, err := c.page(len(infos), input.NextToken, input.MaxResults)
+	if err != nil {
+		return nil, err
+	}
+	return &ec2.DescribeInstanceTypesOutput{
+		InstanceTypes: infos[start:end],
+		NextToken:     next,
+	}, nil
+}
+
+func (e *ec2Client) DescribeInstanceTypesPages(
+	input *ec2.DescribeInstanceTypesInput,
+	fn func(*ec2.DescribeInstanceTypesOutput, bool) bool,
+) error {
+	pageInput := *input
+	for {
+		output, err := e.DescribeInstanceTypes(&pageInput)
+		if err != nil {
+			return err
+		}
+		if !fn(output, output.NextToken == nil) || output.NextToken == nil {
+			return nil
+		}
+		pageInput.NextToken = output.NextToken
+	}
+}
@@ -0,0 +1,111 @@
+package fake
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/eks"
+	"github.com/aws/aws-sdk-go/service/eks/eksiface"
+	"github.com/aws/aws-sdk-go/service/servicequotas"
+	"github.com/aws/aws-sdk-go/service/servicequotas/servicequotasiface"
+	"github.com/aws/aws-sdk-go/service/sts"
+	"github.com/aws/aws-sdk-go/service/sts/stsiface"
+)
+
+type eksClient struct {
+	eksiface.EKSAPI
+	cloud *Cloud
+}
+
+func (e *eksClient) DescribeCluster(
+	input *eks.DescribeClusterInput,
+) (*eks.DescribeClusterOutput, error) {
+	c := e.cloud
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	cluster, ok := c.clusters[aws.StringValue(input.Name)]
+	if !ok {
+		return nil, notFound(
+			eks.ErrCodeResourceNotFoundException,
+			"No cluster found for name: %s.",
+			aws.StringValue(input.Name),
+		)
+	}
+	return &eks.DescribeClusterOutput{Cluster: cluster}, nil
+}
+
+type stsClient struct {
+	stsiface.STSAPI
+	cloud *Cloud
+}
+
+func (s *stsClient) GetCallerIdentity(
+	input *sts.GetCallerIdentityInput,
+) (*sts.GetCallerIdentityOutput, error) {
+	c := s.cloud
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	return &sts.GetCallerIdentityOutput{
+		Account: aws.String(c.accountId),
+		Arn:     aws.String(fmt.Sprintf("arn:aws:iam::%s:user/dockyard", c.accountId)),
+		UserId:  aws.String("AIDAFAKEDOCKYARD"),
+	}, nil
+}
+
+type serviceQuotas struct {
+	servicequotasiface.ServiceQuotasAPI
+	cloud *Cloud
+}
+
+func (s *serviceQuotas) ListServiceQuotas(
+	input *servicequotas.ListServiceQuotasInput,
+) (*servicequotas.ListServiceQuotasOutput, error) {
+	c := s.cloud
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	quotas := []*servicequotas.ServiceQuota{}
+	if aws.StringValue(input.ServiceCode) == "ec2" {
+		codes := make([]string, 0, len(c.quotas))
+		for code := range c.quotas {
+			codes = append(codes, code)
+		}
+		sort.Strings(codes)
+		for _, code := range codes {
+			quotas = append(quotas, &servicequotas.ServiceQuota{
+				ServiceCode: aws.String("ec2"),
+				QuotaCode:   aws.String(code),
+				Value:       aws.Float64(c.quotas[code]),
+			})
+		}
+	}
+
+	start, end, next, err := c.page(len(quotas), input.NextToken, input.MaxResults)
+	if err != nil {
+		return nil, err
+	}
+	return &servicequotas.ListServiceQuotasOutput{
+		Quotas:    quotas[start:end],
+		NextToken: next,
+	}, nil
+}
+
+func (s *serviceQuotas) ListServiceQuotasPages(
+	input *servicequotas.ListServiceQuotasInput,
+	fn func(*servicequotas.ListServiceQuotasOutput, bool) bool,
+) error {
+	pageInput := *input
+	for {
+		output, err := s.ListServiceQuotas(&pageInput)
+		if err != nil {
+			return err
+		}
+		if !fn(output, output.NextToken == nil) || output.NextToken == nil {
+			return nil
+		}
+		pageInput.NextToken = output.NextToken
+	}
+}
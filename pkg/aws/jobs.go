@@ -0,0 +1,102 @@
+package aws
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"dockyard/pkg/kube"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Waits for pods owned by Jobs on the node to complete before the node is
+// drained. Once ASG_ROLLOUT.TIMEOUTS.JOB_COMPLETION is exceeded the remaining
+// job pods are evicted along with the rest of the pods.
+func (asgRollout *asgRolloutClient) waitForJobs(
+	ctx context.Context,
+	nodeName string,
+	eventLogs chan string,
+) error {
+	defer asgRollout.setJobsWaitedOn(nodeName, nil)
+
+	timeout := time.Duration(asgRollout.rolloutConfig.Timeout.JobCompletionTimeout) * time.Second
+	interval := time.Duration(asgRollout.rolloutConfig.PeriodWait.JobCompletion) * time.Second
+	deadline := time.Now().Add(timeout)
+
+	for {
+		jobPods, err := asgRollout.kube.ListRunningJobPods(
+			nodeName,
+			asgRollout.rolloutConfig.IgnoreNotFound,
+		)
+		if err != nil {
+			log.Errorf("Unable to fetch job pods of node %s due to %s", nodeName, err.Error())
+			return err
+		}
+		if len(jobPods) == 0 {
+			return nil
+		}
+
+		asgRollout.setJobsWaitedOn(nodeName, jobPods)
+
+		if time.Now().After(deadline) {
+			eventLogs <- fmt.Sprintf("Timed out waiting for jobs on node %s, evicting remaining pods", nodeName)
+			log.Infof("Timed out waiting for jobs on node %s", nodeName)
+			return nil
+		}
+
+		jobNames := make([]string, 0)
+		for _, jobPod := range jobPods {
+			jobNames = append(jobNames, jobPod.Namespace+"/"+jobPod.JobName)
+		}
+		eventLogs <- fmt.Sprintf("Node %s waiting on jobs %s", nodeName, strings.Join(jobNames, ","))
+		log.Infof("Node %s waiting on jobs %s", nodeName, strings.Join(jobNames, ","))
+
+		// Re-check as soon as any pod of the node changes
+		err = asgRollout.kube.WaitForPodEventOnNode(ctx, nodeName, interval)
+		if err != nil {
+			return err
+		}
+	}
+}
+
+func (asgRollout *asgRolloutClient) setJobsWaitedOn(
+	nodeName string,
+	jobPods []kube.JobPod,
+) {
+	asgRollout.jobsLock.Lock()
+	defer asgRollout.jobsLock.Unlock()
+	if len(jobPods) == 0 {
+		delete(asgRollout.jobsWaitedOn, nodeName)
+		return
+	}
+	asgRollout.jobsWaitedOn[nodeName] = jobPods
+}
+
+// Returns the jobs which nodes under rollout are waiting on. Each row is of
+// form {"node name", "job name", "job namespace", "running for"}
+func (asgRollout *asgRolloutClient) JobsWaitedOn() [][]string {
+	asgRollout.jobsLock.Lock()
+	defer asgRollout.jobsLock.Unlock()
+
+	nodeNames := make([]string, 0, len(asgRollout.jobsWaitedOn))
+	for nodeName := range asgRollout.jobsWaitedOn {
+		nodeNames = append(nodeNames, nodeName)
+	}
+	sort.Strings(nodeNames)
+
+	result := make([][]string, 0)
+	for _, nodeName := range nodeNames {
+		for _, jobPod := range asgRollout.jobsWaitedOn[nodeName] {
+			result = append(result, []string{
+				nodeName,
+				jobPod.JobName,
+				jobPod.Namespace,
+				time.Since(jobPod.StartTime).Round(time.Second).String(),
+			})
+		}
+	}
+	return result
+}
@@ -0,0 +1,160 @@
+package aws
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func (env *rolloutEnv) addJobPod(t *testing.T, nodeName string) *corev1.Pod {
+	t.Helper()
+	pod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "report-27781234-x7k2p",
+			Namespace: "batch",
+			OwnerReferences: []metav1.OwnerReference{{
+				APIVersion: batchv1.SchemeGroupVersion.String(),
+				Kind:       "Job",
+				Name:       "report-27781234",
+				Controller: boolPtr(true),
+			}},
+		},
+		Spec:   corev1.PodSpec{NodeName: nodeName},
+		Status: corev1.PodStatus{Phase: corev1.PodRunning},
+	}
+	_, err := env.clientSet.CoreV1().
+		Pods(pod.Namespace).
+		Create(context.TODO(), pod, metav1.CreateOptions{})
+	if err != nil {
+		t.Fatalf("Unable to create pod %s, %s", pod.Name, err.Error())
+	}
+	return pod
+}
+
+func TestWaitForJobs(t *testing.T) {
+	tests := []struct {
+		name string
+		// Seconds to wait for jobs before evicting them
+		timeout int64
+		// Job pod completes while waited on
+		complete bool
+		cancel   bool
+		wantErr  bool
+		// Event which has to be reported
+		wantEvent string
+	}{
+		{name: "timeout", timeout: 0, wantEvent: "Timed out waiting for jobs"},
+		{name: "completed", timeout: 30, complete: true, wantEvent: "waiting on jobs batch/report-27781234"},
+		{name: "cancelled", timeout: 30, cancel: true, wantErr: true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			env := newRolloutEnv(t)
+			rollout := env.rollout.(*asgRolloutClient)
+			rollout.rolloutConfig.Timeout.JobCompletionTimeout = test.timeout
+			nodeName := env.oldNodes[0]
+			pod := env.addJobPod(t, nodeName)
+
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			events := make(chan string, 10)
+			done := make(chan error, 1)
+			go func() {
+				done <- rollout.waitForJobs(ctx, nodeName, events)
+			}()
+
+			var reported []string
+			select {
+			case event := <-events:
+				reported = append(reported, event)
+			case err := <-done:
+				t.Fatalf("waitForJobs returned %v before reporting the job", err)
+			case <-time.After(5 * time.Second):
+				t.Fatalf("waitForJobs didn't report the job")
+			}
+			if jobs := rollout.JobsWaitedOn(); test.timeout != 0 && len(jobs) != 1 {
+				t.Errorf("Jobs waited on are %v, want the job of node %s", jobs, nodeName)
+			}
+
+			if test.complete {
+				err := env.clientSet.CoreV1().
+					Pods(pod.Namespace).
+					Delete(context.TODO(), pod.Name, metav1.DeleteOptions{})
+				if err != nil {
+					t.Fatalf("Unable to delete pod %s, %s", pod.Name, err.Error())
+				}
+			}
+			if test.cancel {
+				cancel()
+			}
+
+			select {
+			case err := <-done:
+				if (err != nil) != test.wantErr {
+					t.Errorf("waitForJobs returned %v, want error %t", err, test.wantErr)
+				}
+			case <-time.After(5 * time.Second):
+				t.Fatalf("waitForJobs didn't return")
+			}
+			close(events)
+			for event := range events {
+				reported = append(reported, event)
+			}
+			if len(test.wantEvent) != 0 && !strings.Contains(strings.Join(reported, "\n"), test.wantEvent) {
+				t.Errorf("Events %v don't report %q", reported, test.wantEvent)
+			}
+			if jobs := rollout.JobsWaitedOn(); len(jobs) != 0 {
+				t.Errorf("Jobs waited on are %v after waitForJobs returned, want none", jobs)
+			}
+		})
+	}
+}
+
+func TestRolloutClosesNodeBeforeWaitingForJobs(t *testing.T) {
+	env := newRolloutEnv(t)
+	rollout := env.rollout.(*asgRolloutClient)
+	rollout.rolloutConfig.PreferNoSchedule = true
+	rollout.rolloutConfig.WaitForJobs = true
+	rollout.rolloutConfig.Timeout.JobCompletionTimeout = 30
+	nodeName := env.oldNodes[0]
+	pod := env.addJobPod(t, nodeName)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	done := make(chan error, 1)
+	go func() {
+		done <- env.rollout.StartRollout(ctx, testAsgName, 1, env.progress, env.events)
+	}()
+
+	deadline := time.Now().Add(30 * time.Second)
+	for len(rollout.JobsWaitedOn()) == 0 {
+		if time.Now().After(deadline) {
+			t.Fatalf("Rollout didn't wait on the job of node %s", nodeName)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	// New pods mustn't be scheduled onto the node while its jobs finish
+	if effect, _ := hasRollingTaint(env.getNode(t, nodeName)); effect != TaintEffectNoSchedule {
+		t.Errorf("Node %s is tainted with %q while waiting for jobs, want %s", nodeName, effect, TaintEffectNoSchedule)
+	}
+
+	err := env.clientSet.CoreV1().
+		Pods(pod.Namespace).
+		Delete(context.TODO(), pod.Name, metav1.DeleteOptions{})
+	if err != nil {
+		t.Fatalf("Unable to delete pod %s, %s", pod.Name, err.Error())
+	}
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Errorf("StartRollout failed, %s", err.Error())
+		}
+	case <-time.After(30 * time.Second):
+		t.Fatalf("StartRollout didn't return after the job completed")
+	}
+}
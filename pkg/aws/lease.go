@@ -0,0 +1,195 @@
+package aws
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/user"
+	"time"
+
+	"dockyard/pkg/kube"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Duration of the rollout lease, it is renewed every third of it. A lease
+// which wasn't renewed within its duration belongs to a dead process.
+var rolloutLeaseDuration = 1 * time.Minute
+
+// Identity of this dockyard process in rollout leases
+func rolloutIdentity() kube.RolloutLease {
+	hostname, err := os.Hostname()
+	if err != nil {
+		hostname = "unknown"
+	}
+	username := os.Getenv("USER")
+	if current, err := user.Current(); err == nil {
+		username = current.Username
+	}
+	return kube.RolloutLease{
+		Holder: fmt.Sprintf("%s@%s/%d", username, hostname, os.Getpid()),
+		User:   username,
+		Host:   hostname,
+	}
+}
+
+// Acquires the rollout lease of the asg and renews it till release is
+// called or ctx is done. Fails with a *kube.RolloutLeaseHeldError if another
+// process holds the lease, an expired lease is only taken over if takeover
+// is set. Holding the lease again from the same client succeeds, the lease
+// is released once every hold is released.
+func (asgRollout *asgRolloutClient) HoldRollout(
+	ctx context.Context,
+	asgName string,
+	takeover bool,
+) (release func(), err error) {
+	_, release, err = asgRollout.holdRollout(ctx, asgName, takeover)
+	return release, err
+}
+
+// Holds the rollout lease like HoldRollout and returns the context of the
+// lease, which is cancelled once the lease is lost
+func (asgRollout *asgRolloutClient) holdRollout(
+	ctx context.Context,
+	asgName string,
+	takeover bool,
+) (context.Context, func(), error) {
+	asgRollout.leasesLock.Lock()
+	defer asgRollout.leasesLock.Unlock()
+	if held, ok := asgRollout.leases[asgName]; ok {
+		if held.err != nil {
+			return nil, nil, held.err
+		}
+		held.holds++
+		return held.ctx, asgRollout.releaseFunc(asgName), nil
+	}
+
+	lease := asgRollout.identity
+	lease.AsgName = asgName
+	lease.Duration = rolloutLeaseDuration
+	err := asgRollout.kube.AcquireRolloutLease(lease, takeover)
+	if err != nil {
+		log.Errorf("Unable to acquire rollout lease of asg %s due to %s", asgName, err.Error())
+		return nil, nil, err
+	}
+	log.Infof("Acquired rollout lease of asg %s as %s", asgName, lease.Holder)
+
+	ctx, cancel := context.WithCancel(ctx)
+	held := &heldLease{holds: 1, ctx: ctx, cancel: cancel, done: make(chan struct{})}
+	asgRollout.leases[asgName] = held
+	go asgRollout.renewRolloutLease(held, lease)
+	return ctx, asgRollout.releaseFunc(asgName), nil
+}
+
+// Renews the lease till its context is done. A lease taken over by another
+// process, or which couldn't be renewed within its duration, is lost and
+// its context is cancelled.
+func (asgRollout *asgRolloutClient) renewRolloutLease(held *heldLease, lease kube.RolloutLease) {
+	defer close(held.done)
+	ticker := time.NewTicker(lease.Duration / 3)
+	defer ticker.Stop()
+	renewedAt := time.Now()
+	for {
+		select {
+		case <-held.ctx.Done():
+			return
+		case <-ticker.C:
+			err := asgRollout.kube.RenewRolloutLease(lease.AsgName, lease.Holder)
+			if err == nil {
+				renewedAt = time.Now()
+				continue
+			}
+			log.Errorf("Unable to renew rollout lease of asg %s due to %s", lease.AsgName, err.Error())
+			var heldErr *kube.RolloutLeaseHeldError
+			if !errors.As(err, &heldErr) && time.Since(renewedAt) < lease.Duration {
+				continue
+			}
+			asgRollout.leasesLock.Lock()
+			held.err = fmt.Errorf("Lost rollout lease of asg %s, %w", lease.AsgName, err)
+			asgRollout.leasesLock.Unlock()
+			held.cancel()
+			return
+		}
+	}
+}
+
+// Returns the error the rollout lease of the asg was lost with, nil if the
+// lease is held or isn't held by this client
+func (asgRollout *asgRolloutClient) lostRolloutLease(asgName string) error {
+	asgRollout.leasesLock.Lock()
+	defer asgRollout.leasesLock.Unlock()
+	if held, ok := asgRollout.leases[asgName]; ok {
+		return held.err
+	}
+	return nil
+}
+
+// Rollout lease held by this client
+type heldLease struct {
+	holds int
+	// cancelled once the lease is released or lost
+	ctx    context.Context
+	cancel context.CancelFunc
+	done   chan struct{}
+	// set once the lease is lost
+	err error
+}
+
+func (asgRollout *asgRolloutClient) releaseFunc(asgName string) func() {
+	released := false
+	return func() {
+		asgRollout.leasesLock.Lock()
+		defer asgRollout.leasesLock.Unlock()
+		held, ok := asgRollout.leases[asgName]
+		if released || !ok {
+			return
+		}
+		released = true
+		held.holds--
+		if held.holds != 0 {
+			return
+		}
+		delete(asgRollout.leases, asgName)
+		held.cancel()
+		<-held.done
+		err := asgRollout.kube.ReleaseRolloutLease(asgName, asgRollout.identity.Holder)
+		if err != nil {
+			log.Errorf("Unable to release rollout lease of asg %s due to %s", asgName, err.Error())
+		}
+	}
+}
+
+// Returns the rollout lease of the asg, nil if no dockyard process holds it
+func (asgRollout *asgRolloutClient) GetRolloutLease(asgName string) (*kube.RolloutLease, error) {
+	return asgRollout.kube.GetRolloutLease(asgName)
+}
+
+// Returns the holder of the live rollout lease of the asg, empty if no live
+// dockyard process rolls the asg
+func (asgRollout *asgRolloutClient) rolloutHeldBy(asgName string) (string, error) {
+	lease, err := asgRollout.kube.GetRolloutLease(asgName)
+	if err != nil || lease == nil {
+		return "", err
+	}
+	if !lease.Live(time.Now()) {
+		return "", nil
+	}
+	return lease.HolderName(), nil
+}
+
+// Returns the asg and holder of any live rollout lease of the cluster, empty
+// if no live dockyard process rolls an asg
+func (asgRollout *asgRolloutClient) anyRolloutHeldBy() (string, string, error) {
+	leases, err := asgRollout.kube.ListRolloutLeases()
+	if err != nil {
+		return "", "", err
+	}
+	now := time.Now()
+	for _, lease := range leases {
+		if lease.Live(now) {
+			return lease.AsgName, lease.HolderName(), nil
+		}
+	}
+	return "", "", nil
+}
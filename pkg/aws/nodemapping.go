@@ -0,0 +1,70 @@
+package aws
+
+import (
+	"fmt"
+
+	"github.com/aws/aws-sdk-go/aws"
+)
+
+// Mapping between ec2 instances and the k8s nodes they registered as. Nodes
+// are matched by the instance id of their spec.providerID, so node names
+// can be anything the kubelet was configured with ( custom hostnames,
+// resource name based hostnames, Bottlerocket ).
+type NodeMapping struct {
+	// Mapped instance ids in the order they were provided
+	InstanceIds []string
+	nodeNames   map[string]string
+	instanceIds map[string]string
+}
+
+func newNodeMapping(
+	instanceIds []string,
+	nodeNamesByInstanceId map[string]string,
+) *NodeMapping {
+	mapping := &NodeMapping{
+		InstanceIds: instanceIds,
+		nodeNames:   map[string]string{},
+		instanceIds: map[string]string{},
+	}
+	for _, instanceId := range instanceIds {
+		if nodeName, ok := nodeNamesByInstanceId[instanceId]; ok {
+			mapping.nodeNames[instanceId] = nodeName
+			mapping.instanceIds[nodeName] = instanceId
+		}
+	}
+	return mapping
+}
+
+// Returns name of the node the instance registered as, empty if the
+// instance hasn't registered with the cluster ( yet )
+func (m *NodeMapping) NodeName(instanceId string) string {
+	return m.nodeNames[instanceId]
+}
+
+// Returns id of the instance backing the node
+func (m *NodeMapping) InstanceId(nodeName string) (string, bool) {
+	instanceId, ok := m.instanceIds[nodeName]
+	return instanceId, ok
+}
+
+// Maps the instances to their nodes using a single read of the node cache
+func (asgRollout *asgRolloutClient) mapNodes(
+	instanceIds []*string,
+) (*NodeMapping, error) {
+	nodeNames, err := asgRollout.kube.GetNodeNamesByInstanceId()
+	if err != nil {
+		return nil, fmt.Errorf("Unable to map instances to nodes, %s", err.Error())
+	}
+	return newNodeMapping(aws.StringValueSlice(instanceIds), nodeNames), nil
+}
+
+// Returns the mapping of all instances of the asg to their nodes
+func (asgRollout *asgRolloutClient) GetNodeMapping(
+	asgName string,
+) (*NodeMapping, error) {
+	instanceIds, err := asgRollout.GetInstancesOfAsg(asgName)
+	if err != nil {
+		return nil, err
+	}
+	return asgRollout.mapNodes(instanceIds)
+}
@@ -2,66 +2,38 @@ package aws
 
 import (
 	"context"
-	"errors"
 	"fmt"
 	"time"
 
 	"github.com/aws/aws-sdk-go/aws"
-	"github.com/aws/aws-sdk-go/service/ec2"
 	apierrors "k8s.io/apimachinery/pkg/api/errors"
 )
 
-// Returns Private DNS Name of the provided instanceId
+// Returns name of the k8s node the instance registered as, empty if the
+// instance hasn't registered with the cluster yet
 func (asgRollout *asgRolloutClient) GetNodeNameFromInstanceId(
 	instanceId string,
 ) (*string, error) {
-	ec2Cl := ec2.New(asgRollout.session)
-	ec2input := &ec2.DescribeInstancesInput{
-		InstanceIds: aws.StringSlice([]string{instanceId}),
-	}
-
-	nodesResult, err := ec2Cl.DescribeInstances(ec2input)
-
+	mapping, err := asgRollout.mapNodes(aws.StringSlice([]string{instanceId}))
 	if err != nil {
 		return nil, err
 	}
-
-	//var nt *string
-	for _, reservation := range nodesResult.Reservations {
-
-		if len(reservation.Instances) != 0 {
-			return reservation.Instances[0].PrivateDnsName, nil
-
-			//tags := reservation.Instances[0].Tags
-			//for _, t := range tags {
-			//	if *t.Key == "Name" {
-			//		nt = t.Value
-			//		break
-			//	}
-			//}
-			//return nt, nil
-		}
-	}
-
-	return nil, errors.New("no node name found in any reservations")
+	nodeName := mapping.NodeName(instanceId)
+	return &nodeName, nil
 }
 
-// Returns instance id from the instance's Private DNS Name
+// Returns id of the instance of this asg backing the k8s node
 func (asgRollout *asgRolloutClient) GetInstanceIdFromNodeName(
 	nodeName, asgName string,
 ) (string, error) {
 
-	instances, err := asgRollout.GetInstanceDetailsOfAsg(asgName)
+	mapping, err := asgRollout.GetNodeMapping(asgName)
 	if err != nil {
 		return "", err
 	}
 
-	for _, instance := range instances {
-
-		if *instance.PrivateDnsName == nodeName {
-			return *instance.InstanceId, nil
-		}
-
+	if instanceId, ok := mapping.InstanceId(nodeName); ok {
+		return instanceId, nil
 	}
 
 	return "", fmt.Errorf("Unable to fetch instanceId of node %s", nodeName)
@@ -71,33 +43,26 @@ func (asgRollout *asgRolloutClient) GetInstanceIdFromNodeName(
 func (asgRollout *asgRolloutClient) GetNewNodes(
 	asgName string,
 ) ([]string, error) {
-	instances, err := asgRollout.GetInstancesOfAsg(asgName)
+	mapping, err := asgRollout.GetNodeMapping(asgName)
 	if err != nil {
 		return []string{}, err
 	}
 	newNodeList := make([]string, 0)
-	for _, instance := range instances {
-		k8sNode, err := asgRollout.GetNodeNameFromInstanceId(*instance)
-		if err != nil {
-			return []string{}, err
+	for _, instanceId := range mapping.InstanceIds {
+		k8sNode := mapping.NodeName(instanceId)
+		if len(k8sNode) == 0 {
+			continue
 		}
-		hasLabel, err := asgRollout.kube.NodeHasLabel(
-			*k8sNode,
-			NodeStateLabelKey,
-			"old",
-			asgRollout.rolloutConfig.IgnoreNotFound,
-		)
-		hasLabel2, err := asgRollout.kube.NodeHasLabel(
-			*k8sNode,
+		nodeState, err := asgRollout.kube.GetLabelValOfNode(
+			k8sNode,
 			NodeStateLabelKey,
-			"new",
 			asgRollout.rolloutConfig.IgnoreNotFound,
 		)
 		if err != nil {
 			return []string{}, err
 		}
-		if !hasLabel && !hasLabel2 {
-			newNodeList = append(newNodeList, *k8sNode)
+		if nodeState != "old" && nodeState != "new" {
+			newNodeList = append(newNodeList, k8sNode)
 		}
 	}
 	return newNodeList, nil
@@ -112,36 +77,32 @@ func (asgRollout *asgRolloutClient) getNewNode(
 ) {
 
 	eventLogs <- fmt.Sprintf("Waiting for new node to join ASG %s", asgName)
-	found := false
 	for {
 
-		instances, err := asgRollout.GetInstancesOfAsg(asgName)
+		mapping, err := asgRollout.GetNodeMapping(asgName)
 		if err != nil {
 			errChan <- err
-			break
+			return
 		}
-		for _, instance := range instances {
-
-			ec2Healhty, err := asgRollout.IsInstanceHealthy(*instance)
-
-			if err != nil {
-				errChan <- err
-				break
-			}
+		healthy, err := asgRollout.healthyInstances(mapping.InstanceIds)
+		if err != nil {
+			errChan <- err
+			return
+		}
+		for _, instanceId := range mapping.InstanceIds {
 			// Will skip this instance since it's not yet in ready state
-			if !ec2Healhty {
+			if !healthy[instanceId] {
 				continue
 			}
-			k8sNode, err := asgRollout.GetNodeNameFromInstanceId(*instance)
-			if err != nil {
-				errChan <- err
-				break
+			// Node is not mapped till it registers with the cluster
+			k8sNode := mapping.NodeName(instanceId)
+			if len(k8sNode) == 0 {
+				continue
 			}
-			hasLabel, err := asgRollout.kube.NodeHasLabel(
-				*k8sNode,
+			nodeState, err := asgRollout.kube.GetLabelValOfNode(
+				k8sNode,
 				NodeStateLabelKey,
-				"old",
-				asgRollout.rolloutConfig.IgnoreNotFound,
+				false,
 			)
 			if apierrors.IsNotFound(err) {
 				continue
@@ -149,55 +110,66 @@ func (asgRollout *asgRolloutClient) getNewNode(
 
 			if err != nil {
 				errChan <- err
-				break
-			}
-			hasLabel2, err := asgRollout.kube.NodeHasLabel(
-				*k8sNode,
-				NodeStateLabelKey,
-				"new",
-				asgRollout.rolloutConfig.IgnoreNotFound,
-			)
-			if err != nil {
-				errChan <- err
-				break
+				return
 			}
-			if !hasLabel && !hasLabel2 {
-				found = true
-				node <- *k8sNode
+			if nodeState != "old" && nodeState != "new" {
+				node <- k8sNode
 				errChan <- nil
-				break
+				eventLogs <- fmt.Sprintf("New node has joined ASG %s", asgName)
+				return
 			}
 		}
 
-		if !found {
-			time.Sleep(time.Duration(asgRollout.rolloutConfig.PeriodWait.WaitForNewNode) * time.Second)
-		} else {
-			break
+		// Re-check as soon as a node registers with the cluster
+		err = asgRollout.kube.WaitForNodeEvent(
+			ctx,
+			time.Duration(asgRollout.rolloutConfig.PeriodWait.WaitForNewNode)*time.Second,
+		)
+		if err != nil {
+			errChan <- err
+			return
 		}
-
 	}
+}
 
-	eventLogs <- fmt.Sprintf("New node has joined ASG %s", asgName)
-
+// Returns the instances among instanceIds whose system status is ok, using
+// batched status calls instead of one call per instance
+func (asgRollout *asgRolloutClient) healthyInstances(
+	instanceIds []string,
+) (map[string]bool, error) {
+	healthy := map[string]bool{}
+	if len(instanceIds) == 0 {
+		return healthy, nil
+	}
+	statuses, err := describeInstanceStatus(
+		asgRollout.ec2Cl,
+		aws.StringSlice(instanceIds),
+	)
+	if err != nil {
+		return nil, err
+	}
+	for _, status := range statuses {
+		if status.SystemStatus != nil &&
+			aws.StringValue(status.SystemStatus.Status) == "ok" {
+			healthy[aws.StringValue(status.InstanceId)] = true
+		}
+	}
+	return healthy, nil
 }
 
 // Returns healthy status of the instance
 func (asgRollout *asgRolloutClient) IsInstanceHealthy(
 	instanceId string,
 ) (bool, error) {
-	svc := ec2.New(asgRollout.session)
-	input := &ec2.DescribeInstanceStatusInput{
-		InstanceIds: []*string{
-			aws.String(instanceId),
-		},
-	}
-
-	result, err := svc.DescribeInstanceStatus(input)
+	statuses, err := describeInstanceStatus(
+		asgRollout.ec2Cl,
+		[]*string{aws.String(instanceId)},
+	)
 	if err != nil {
 		return false, err
 	}
 
-	for _, status := range result.InstanceStatuses {
+	for _, status := range statuses {
 		if *status.SystemStatus.Status == "ok" {
 			return true, nil
 		} else {
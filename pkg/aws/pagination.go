@@ -0,0 +1,213 @@
+package aws
+
+import (
+	"github.com/aws/aws-sdk-go/service/autoscaling"
+	"github.com/aws/aws-sdk-go/service/autoscaling/autoscalingiface"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/aws/aws-sdk-go/service/ec2/ec2iface"
+)
+
+const (
+	// Max asg names per DescribeAutoScalingGroups call
+	maxAsgNamesPerRequest = 100
+
+	// Max instance or image ids per ec2 describe call
+	maxIdsPerRequest = 100
+)
+
+// Splits ids into batches of at most size ids
+func batches(ids []*string, size int) [][]*string {
+	result := make([][]*string, 0, (len(ids)+size-1)/size)
+	for len(ids) > size {
+		result = append(result, ids[:size])
+		ids = ids[size:]
+	}
+	if len(ids) > 0 {
+		result = append(result, ids)
+	}
+	return result
+}
+
+// Returns asgs of all pages matching input. Asg names are split into
+// batches the api accepts.
+func describeAsgs(
+	autoScalingCl autoscalingiface.AutoScalingAPI,
+	input *autoscaling.DescribeAutoScalingGroupsInput,
+) ([]*autoscaling.Group, error) {
+	nameBatches := batches(input.AutoScalingGroupNames, maxAsgNamesPerRequest)
+	if len(nameBatches) == 0 {
+		nameBatches = [][]*string{nil}
+	}
+
+	groups := []*autoscaling.Group{}
+	for _, names := range nameBatches {
+		batchInput := *input
+		batchInput.AutoScalingGroupNames = names
+		err := autoScalingCl.DescribeAutoScalingGroupsPages(
+			&batchInput,
+			func(page *autoscaling.DescribeAutoScalingGroupsOutput, _ bool) bool {
+				groups = append(groups, page.AutoScalingGroups...)
+				return true
+			},
+		)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return groups, nil
+}
+
+// Returns asg tags of all pages matching input
+func describeAsgTags(
+	autoScalingCl autoscalingiface.AutoScalingAPI,
+	input *autoscaling.DescribeTagsInput,
+) ([]*autoscaling.TagDescription, error) {
+	tags := []*autoscaling.TagDescription{}
+	err := autoScalingCl.DescribeTagsPages(
+		input,
+		func(page *autoscaling.DescribeTagsOutput, _ bool) bool {
+			tags = append(tags, page.Tags...)
+			return true
+		},
+	)
+	return tags, err
+}
+
+// Returns launch configurations of all pages matching input
+func describeLaunchConfigs(
+	autoScalingCl autoscalingiface.AutoScalingAPI,
+	input *autoscaling.DescribeLaunchConfigurationsInput,
+) ([]*autoscaling.LaunchConfiguration, error) {
+	launchConfigs := []*autoscaling.LaunchConfiguration{}
+	err := autoScalingCl.DescribeLaunchConfigurationsPages(
+		input,
+		func(page *autoscaling.DescribeLaunchConfigurationsOutput, _ bool) bool {
+			launchConfigs = append(launchConfigs, page.LaunchConfigurations...)
+			return true
+		},
+	)
+	return launchConfigs, err
+}
+
+// Returns launch template versions of all pages matching input
+func describeLaunchTemplateVersions(
+	ec2Cl ec2iface.EC2API,
+	input *ec2.DescribeLaunchTemplateVersionsInput,
+) ([]*ec2.LaunchTemplateVersion, error) {
+	versions := []*ec2.LaunchTemplateVersion{}
+	err := ec2Cl.DescribeLaunchTemplateVersionsPages(
+		input,
+		func(page *ec2.DescribeLaunchTemplateVersionsOutput, _ bool) bool {
+			versions = append(versions, page.LaunchTemplateVersions...)
+			return true
+		},
+	)
+	return versions, err
+}
+
+// Returns ec2 instances of the instance ids, ids are split into batches the
+// api accepts
+func describeInstances(
+	ec2Cl ec2iface.EC2API,
+	instanceIds []*string,
+) ([]*ec2.Instance, error) {
+	instances := []*ec2.Instance{}
+	for _, batch := range batches(instanceIds, maxIdsPerRequest) {
+		err := ec2Cl.DescribeInstancesPages(
+			&ec2.DescribeInstancesInput{InstanceIds: batch},
+			func(page *ec2.DescribeInstancesOutput, _ bool) bool {
+				for _, reservation := range page.Reservations {
+					instances = append(instances, reservation.Instances...)
+				}
+				return true
+			},
+		)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return instances, nil
+}
+
+// Returns status of the running instances among instance ids, ids are split
+// into batches the api accepts
+func describeInstanceStatus(
+	ec2Cl ec2iface.EC2API,
+	instanceIds []*string,
+) ([]*ec2.InstanceStatus, error) {
+	statuses := []*ec2.InstanceStatus{}
+	for _, batch := range batches(instanceIds, maxIdsPerRequest) {
+		err := ec2Cl.DescribeInstanceStatusPages(
+			&ec2.DescribeInstanceStatusInput{InstanceIds: batch},
+			func(page *ec2.DescribeInstanceStatusOutput, _ bool) bool {
+				statuses = append(statuses, page.InstanceStatuses...)
+				return true
+			},
+		)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return statuses, nil
+}
+
+// Returns amis of the image ids. Duplicate ids are looked up once and no
+// call is made without ids, which would describe every public ami.
+func describeImages(
+	ec2Cl ec2iface.EC2API,
+	imageIds []*string,
+) ([]*ec2.Image, error) {
+	seen := map[string]bool{}
+	uniqueIds := []*string{}
+	for _, imageId := range imageIds {
+		if imageId == nil || seen[*imageId] {
+			continue
+		}
+		seen[*imageId] = true
+		uniqueIds = append(uniqueIds, imageId)
+	}
+
+	images := []*ec2.Image{}
+	for _, batch := range batches(uniqueIds, maxIdsPerRequest) {
+		result, err := ec2Cl.DescribeImages(&ec2.DescribeImagesInput{
+			ImageIds: batch,
+		})
+		if err != nil {
+			return nil, err
+		}
+		images = append(images, result.Images...)
+	}
+	return images, nil
+}
+
+// Returns details of the instance types. Duplicate types are looked up once
+// and types are split into batches the api accepts.
+func describeInstanceTypes(
+	ec2Cl ec2iface.EC2API,
+	instanceTypes []*string,
+) ([]*ec2.InstanceTypeInfo, error) {
+	seen := map[string]bool{}
+	uniqueTypes := []*string{}
+	for _, instanceType := range instanceTypes {
+		if instanceType == nil || seen[*instanceType] {
+			continue
+		}
+		seen[*instanceType] = true
+		uniqueTypes = append(uniqueTypes, instanceType)
+	}
+
+	infos := []*ec2.InstanceTypeInfo{}
+	for _, batch := range batches(uniqueTypes, maxIdsPerRequest) {
+		err := ec2Cl.DescribeInstanceTypesPages(
+			&ec2.DescribeInstanceTypesInput{InstanceTypes: batch},
+			func(page *ec2.DescribeInstanceTypesOutput, _ bool) bool {
+				infos = append(infos, page.InstanceTypes...)
+				return true
+			},
+		)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return infos, nil
+}
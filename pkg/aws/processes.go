@@ -0,0 +1,132 @@
+package aws
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/autoscaling"
+	log "github.com/sirupsen/logrus"
+)
+
+// Tag holding the scaling processes dockyard suspended for the rollout of
+// the asg, processes which were suspended before aren't recorded so that
+// they stay suspended afterwards
+const SuspendedProcessesTagKey = "dockyard.io/suspended-processes"
+
+// Suspends the configured scaling processes of the asg, e.g. AZRebalance
+// which would terminate new nodes to balance availability zones
+func (asgRollout *asgRolloutClient) suspendProcesses(
+	asgName string,
+	eventLogs chan string,
+) error {
+	tags, err := asgRollout.GetTagsOfAsg(asgName)
+	if err != nil {
+		log.Errorf("Unable to fetch tags of asg %s due to %s", asgName, err.Error())
+		return err
+	}
+
+	var processes []string
+	if value, ok := tags[SuspendedProcessesTagKey]; ok {
+		// Pre rollout is resumed, already suspended processes include the
+		// ones suspended by the first pre rollout
+		processes = splitProcesses(value)
+	} else {
+		if len(asgRollout.rolloutConfig.SuspendProcesses) == 0 {
+			return nil
+		}
+		asg, err := getAsg(asgName, asgRollout.autoScalingCl)
+		if err != nil {
+			log.Errorf("Unable to fetch asg %s due to %s", asgName, err.Error())
+			return err
+		}
+		suspended := make([]string, 0, len(asg.SuspendedProcesses))
+		for _, process := range asg.SuspendedProcesses {
+			suspended = append(suspended, aws.StringValue(process.ProcessName))
+		}
+		for _, process := range asgRollout.rolloutConfig.SuspendProcesses {
+			if StringSliceContains(suspended, process) {
+				eventLogs <- fmt.Sprintf("Process %s of asg %s is already suspended", process, asgName)
+				continue
+			}
+			processes = append(processes, process)
+		}
+
+		// Processes are stored before they are suspended so that post
+		// rollout resumes them even if dockyard exits in between
+		err = asgRollout.AddTagToAsG(
+			asgName,
+			SuspendedProcessesTagKey,
+			strings.Join(processes, ","),
+		)
+		if err != nil {
+			log.Errorf("Unable tag asg %s due to %s", asgName, err.Error())
+			return err
+		}
+	}
+	if len(processes) == 0 {
+		return nil
+	}
+
+	eventLogs <- fmt.Sprintf("Suspending processes %s of asg %s", strings.Join(processes, ", "), asgName)
+	log.Infof("Suspending processes %s of asg %s", strings.Join(processes, ", "), asgName)
+	_, err = asgRollout.autoScalingCl.SuspendProcesses(&autoscaling.ScalingProcessQuery{
+		AutoScalingGroupName: aws.String(asgName),
+		ScalingProcesses:     aws.StringSlice(processes),
+	})
+	if err != nil {
+		log.Errorf("Unable to suspend processes of asg %s due to %s", asgName, err.Error())
+		return fmt.Errorf("Unable to suspend processes of asg %s, %s", asgName, err.Error())
+	}
+	return nil
+}
+
+// Resumes the processes suspended by suspendProcesses. Processes which were
+// suspended before the rollout stay suspended.
+func (asgRollout *asgRolloutClient) resumeProcesses(
+	asgName string,
+	eventLogs chan string,
+) error {
+	tags, err := asgRollout.GetTagsOfAsg(asgName)
+	if err != nil {
+		log.Errorf("Unable to fetch tags of asg %s due to %s", asgName, err.Error())
+		return err
+	}
+	value, ok := tags[SuspendedProcessesTagKey]
+	if !ok {
+		return nil
+	}
+
+	if processes := splitProcesses(value); len(processes) != 0 {
+		eventLogs <- fmt.Sprintf("Resuming processes %s of asg %s", strings.Join(processes, ", "), asgName)
+		log.Infof("Resuming processes %s of asg %s", strings.Join(processes, ", "), asgName)
+		_, err = asgRollout.autoScalingCl.ResumeProcesses(&autoscaling.ScalingProcessQuery{
+			AutoScalingGroupName: aws.String(asgName),
+			ScalingProcesses:     aws.StringSlice(processes),
+		})
+		if err != nil {
+			// Tag is kept so that the next post rollout retries
+			log.Errorf("Unable to resume processes of asg %s due to %s", asgName, err.Error())
+			return fmt.Errorf("Unable to resume processes of asg %s, %s", asgName, err.Error())
+		}
+	}
+
+	eventLogs <- fmt.Sprintf("Deleting tag %s of asg %s", SuspendedProcessesTagKey, asgName)
+	log.Infof("Deleting tag %s of asg %s", SuspendedProcessesTagKey, asgName)
+	err = asgRollout.DeleteTagOfAsg(asgName, SuspendedProcessesTagKey, value)
+	if err != nil {
+		log.Errorf("Unable to delete tags of asg %s due to %s", asgName, err.Error())
+		return err
+	}
+	return nil
+}
+
+func splitProcesses(value string) []string {
+	processes := make([]string, 0)
+	for _, process := range strings.Split(value, ",") {
+		if len(process) != 0 {
+			processes = append(processes, process)
+		}
+	}
+	return processes
+}
@@ -0,0 +1,486 @@
+package aws
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/autoscaling"
+	log "github.com/sirupsen/logrus"
+	corev1 "k8s.io/api/core/v1"
+)
+
+// Kinds of leftovers of interrupted rollouts, in the order they are fixed
+const (
+	LeftoverTaint                 = "taint"
+	LeftoverCordon                = "cordon"
+	LeftoverTag                   = "asg-tag"
+	LeftoverNodeLabel             = "node-label"
+	LeftoverSnapshot              = "snapshot"
+	LeftoverInstanceProtection    = "instance-protection"
+	LeftoverNewInstanceProtection = "new-instance-protection"
+)
+
+var leftoverOrder = map[string]int{
+	LeftoverTaint:                 0,
+	LeftoverCordon:                1,
+	LeftoverTag:                   2,
+	LeftoverNodeLabel:             3,
+	LeftoverSnapshot:              4,
+	LeftoverInstanceProtection:    5,
+	LeftoverNewInstanceProtection: 6,
+}
+
+// Tags dockyard stores on asgs during a rollout
+var rolloutTagKeys = []string{
+	ClusterAutoscalerTagKey,
+	SuspendedProcessesTagKey,
+	legacyMinTagKey,
+	legacyMaxTagKey,
+	legacyDesiredTagKey,
+}
+
+// State left behind by a rollout which didn't finish its post rollout steps
+type Leftover struct {
+	// Empty for nodes which don't belong to any asg checked
+	AsgName string
+	Kind    string
+	// Node name, tag key or instance id, the asg name for asg wide leftovers
+	Resource    string
+	Explanation string
+	// Holder of the live rollout lease of the asg. Leftovers of an asg
+	// rolled by a live dockyard process are never fixed. Leftovers without
+	// asg are held by any live rollout of the cluster, the rolled asg of
+	// their node isn't known.
+	HeldBy string
+}
+
+// Returns the leftovers of interrupted rollouts of the asg. Without asg
+// name all asgs of the cluster, asgs of the account with dockyard tags and
+// asgs with a snapshot are checked, along with all nodes of the cluster.
+func (asgRollout *asgRolloutClient) FindLeftovers(asgName string) ([]Leftover, error) {
+	asgNames := []string{asgName}
+	if len(asgName) == 0 {
+		var err error
+		asgNames, err = asgRollout.asgsToReconcile()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	nodes, err := asgRollout.kube.GetNodeByLabel("", asgRollout.rolloutConfig.IgnoreNotFound)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to list k8s nodes, %s", err.Error())
+	}
+	nodesByName := make(map[string]corev1.Node, len(nodes))
+	for _, node := range nodes {
+		nodesByName[node.Name] = node
+	}
+	nodeNames, err := asgRollout.kube.GetNodeNamesByInstanceId()
+	if err != nil {
+		return nil, fmt.Errorf("Unable to map instances to nodes, %s", err.Error())
+	}
+
+	leftovers := make([]Leftover, 0)
+	checkedNodes := map[string]bool{}
+	for _, name := range asgNames {
+		asgLeftovers, err := asgRollout.findAsgLeftovers(name, nodesByName, nodeNames, checkedNodes)
+		if err != nil {
+			return nil, err
+		}
+		leftovers = append(leftovers, asgLeftovers...)
+	}
+
+	if len(asgName) == 0 {
+		_, heldBy, err := asgRollout.anyRolloutHeldBy()
+		if err != nil {
+			return nil, fmt.Errorf("Unable to list rollout leases, %s", err.Error())
+		}
+		for _, node := range nodes {
+			if !checkedNodes[node.Name] {
+				leftovers = append(leftovers, withHolder(nodeLeftovers("", node), heldBy)...)
+			}
+		}
+	}
+
+	sort.SliceStable(leftovers, func(i, j int) bool {
+		if leftovers[i].AsgName != leftovers[j].AsgName {
+			return leftovers[i].AsgName < leftovers[j].AsgName
+		}
+		if leftovers[i].Kind != leftovers[j].Kind {
+			return leftoverOrder[leftovers[i].Kind] < leftoverOrder[leftovers[j].Kind]
+		}
+		return leftovers[i].Resource < leftovers[j].Resource
+	})
+	return leftovers, nil
+}
+
+// Returns names of the asgs of the cluster, of asgs of the account with a
+// dockyard tag and of asgs with a snapshot
+func (asgRollout *asgRolloutClient) asgsToReconcile() ([]string, error) {
+	names := map[string]bool{}
+	if clusterName := asgRollout.rolloutConfig.EksClusterName; len(clusterName) != 0 {
+		clusterAsgs, err := asgRollout.ListAsgsOfEks(clusterName)
+		if err != nil {
+			return nil, err
+		}
+		for _, name := range clusterAsgs {
+			names[name] = true
+		}
+	}
+
+	tags, err := describeAsgTags(
+		asgRollout.autoScalingCl,
+		&autoscaling.DescribeTagsInput{
+			Filters: []*autoscaling.Filter{
+				{
+					Name:   aws.String("key"),
+					Values: aws.StringSlice(rolloutTagKeys),
+				},
+			},
+		},
+	)
+	if err != nil {
+		return nil, err
+	}
+	for _, tag := range tags {
+		names[aws.StringValue(tag.ResourceId)] = true
+	}
+
+	snapshots, err := asgRollout.kube.ListAsgSnapshots()
+	if err != nil {
+		return nil, fmt.Errorf("Unable to list asg snapshots, %s", err.Error())
+	}
+	for _, name := range snapshots {
+		names[name] = true
+	}
+
+	asgNames := make([]string, 0, len(names))
+	for name := range names {
+		asgNames = append(asgNames, name)
+	}
+	sort.Strings(asgNames)
+	return asgNames, nil
+}
+
+func (asgRollout *asgRolloutClient) findAsgLeftovers(
+	asgName string,
+	nodesByName map[string]corev1.Node,
+	nodeNames map[string]string,
+	checkedNodes map[string]bool,
+) ([]Leftover, error) {
+	heldBy, err := asgRollout.rolloutHeldBy(asgName)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to fetch rollout lease of asg %s, %s", asgName, err.Error())
+	}
+	snapshot, err := asgRollout.GetAsgSnapshot(asgName)
+	if err != nil {
+		return nil, err
+	}
+	groups, err := describeAsgs(
+		asgRollout.autoScalingCl,
+		&autoscaling.DescribeAutoScalingGroupsInput{
+			AutoScalingGroupNames: aws.StringSlice([]string{asgName}),
+		},
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	leftovers := make([]Leftover, 0)
+	if len(groups) == 0 {
+		if snapshot == nil {
+			return nil, fmt.Errorf("no autoscaling group found with name %v", asgName)
+		}
+		leftovers = append(leftovers, Leftover{
+			AsgName:     asgName,
+			Kind:        LeftoverSnapshot,
+			Resource:    asgName,
+			Explanation: "Snapshot of an asg which doesn't exist anymore",
+		})
+		return withHolder(leftovers, heldBy), nil
+	}
+	group := groups[0]
+
+	for _, instance := range group.Instances {
+		nodeName, ok := nodeNames[aws.StringValue(instance.InstanceId)]
+		if !ok {
+			continue
+		}
+		checkedNodes[nodeName] = true
+		if node, ok := nodesByName[nodeName]; ok {
+			leftovers = append(leftovers, nodeLeftovers(asgName, node)...)
+		}
+	}
+
+	legacy := false
+	for _, tag := range group.Tags {
+		key := aws.StringValue(tag.Key)
+		value := aws.StringValue(tag.Value)
+		var explanation string
+		switch key {
+		case ClusterAutoscalerTagKey:
+			explanation = fmt.Sprintf("Cluster-autoscaler %s stays scaled down", value)
+		case SuspendedProcessesTagKey:
+			explanation = fmt.Sprintf("Processes %s stay suspended", value)
+		case legacyMinTagKey, legacyMaxTagKey, legacyDesiredTagKey:
+			legacy = true
+			explanation = fmt.Sprintf("Capacity %s of a rollout before snapshots wasn't restored", value)
+		default:
+			continue
+		}
+		leftovers = append(leftovers, Leftover{
+			AsgName:     asgName,
+			Kind:        LeftoverTag,
+			Resource:    key,
+			Explanation: explanation,
+		})
+	}
+
+	if snapshot != nil {
+		leftovers = append(leftovers, Leftover{
+			AsgName:  asgName,
+			Kind:     LeftoverSnapshot,
+			Resource: asgName,
+			Explanation: fmt.Sprintf(
+				"Configuration of %s ( min %d, max %d ) wasn't restored",
+				snapshot.TakenAt.Format("2006-01-02 15:04:05"),
+				snapshot.MinSize,
+				snapshot.MaxSize,
+			),
+		})
+	}
+
+	// Without snapshot scale in protection is only known to be left by
+	// dockyard if the asg has other leftovers, post rollout of versions
+	// before snapshots removed it from all instances
+	if snapshot != nil || legacy || len(leftovers) != 0 {
+		for _, instance := range group.Instances {
+			instanceId := aws.StringValue(instance.InstanceId)
+			if !aws.BoolValue(instance.ProtectedFromScaleIn) {
+				continue
+			}
+			if snapshot != nil && snapshot.InstanceProtection[instanceId] {
+				continue
+			}
+			leftovers = append(leftovers, Leftover{
+				AsgName:     asgName,
+				Kind:        LeftoverInstanceProtection,
+				Resource:    instanceId,
+				Explanation: "Instance keeps the scale in protection of the rollout",
+			})
+		}
+		protected := aws.BoolValue(group.NewInstancesProtectedFromScaleIn)
+		if protected && (snapshot == nil || !snapshot.NewInstancesProtectedFromScaleIn) {
+			leftovers = append(leftovers, Leftover{
+				AsgName:     asgName,
+				Kind:        LeftoverNewInstanceProtection,
+				Resource:    asgName,
+				Explanation: "New instances of the asg are protected from scale in",
+			})
+		}
+	}
+	return withHolder(leftovers, heldBy), nil
+}
+
+func withHolder(leftovers []Leftover, heldBy string) []Leftover {
+	for i := range leftovers {
+		leftovers[i].HeldBy = heldBy
+	}
+	return leftovers
+}
+
+// Returns the node-state label and rolling taint of the node, and its
+// cordon if the node carries either of them
+func nodeLeftovers(asgName string, node corev1.Node) []Leftover {
+	leftovers := make([]Leftover, 0)
+	state, labelled := node.Labels[NodeStateLabelKey]
+	if labelled {
+		leftovers = append(leftovers, Leftover{
+			AsgName:     asgName,
+			Kind:        LeftoverNodeLabel,
+			Resource:    node.Name,
+			Explanation: fmt.Sprintf("Node is labelled %s=%s", NodeStateLabelKey, state),
+		})
+	}
+	tainted := false
+	for _, taint := range node.Spec.Taints {
+		if taint.Key == RollingTaintKey {
+			tainted = true
+			leftovers = append(leftovers, Leftover{
+				AsgName:     asgName,
+				Kind:        LeftoverTaint,
+				Resource:    node.Name,
+				Explanation: fmt.Sprintf("Node is tainted with %s:%s", RollingTaintKey, taint.Effect),
+			})
+			break
+		}
+	}
+	if node.Spec.Unschedulable && (labelled || tainted) {
+		leftovers = append(leftovers, Leftover{
+			AsgName:     asgName,
+			Kind:        LeftoverCordon,
+			Resource:    node.Name,
+			Explanation: "Node of an interrupted rollout is cordoned",
+		})
+	}
+	return leftovers
+}
+
+// Restores the clean state of the leftovers using the post rollout steps.
+// Leftovers of asgs held by a live dockyard process are skipped. Every
+// leftover is attempted, the returned error counts the failed ones.
+func (asgRollout *asgRolloutClient) FixLeftovers(
+	leftovers []Leftover,
+	eventLogs chan string,
+) error {
+	byAsg := map[string][]Leftover{}
+	asgNames := make([]string, 0)
+	for _, leftover := range leftovers {
+		if _, ok := byAsg[leftover.AsgName]; !ok {
+			asgNames = append(asgNames, leftover.AsgName)
+		}
+		byAsg[leftover.AsgName] = append(byAsg[leftover.AsgName], leftover)
+	}
+	sort.Strings(asgNames)
+
+	failed := 0
+	for _, asgName := range asgNames {
+		failed += asgRollout.fixAsgLeftovers(asgName, byAsg[asgName], eventLogs)
+	}
+	if failed != 0 {
+		return fmt.Errorf("Unable to fix %d of %d leftovers", failed, len(leftovers))
+	}
+	return nil
+}
+
+// Fixes the leftovers of the asg and returns the number of failed fixes
+func (asgRollout *asgRolloutClient) fixAsgLeftovers(
+	asgName string,
+	leftovers []Leftover,
+	eventLogs chan string,
+) int {
+	if len(asgName) != 0 {
+		// Lease is checked again, a rollout may have started meanwhile
+		heldBy, err := asgRollout.rolloutHeldBy(asgName)
+		if err != nil {
+			eventLogs <- fmt.Sprintf("Unable to fetch rollout lease of asg %s, %s", asgName, err.Error())
+			return len(leftovers)
+		}
+		if len(heldBy) != 0 {
+			eventLogs <- fmt.Sprintf("Skipping asg %s, its rollout is held by %s", asgName, heldBy)
+			return 0
+		}
+	} else {
+		// Nodes outside of the checked asgs may belong to any rolled asg
+		heldAsg, heldBy, err := asgRollout.anyRolloutHeldBy()
+		if err != nil {
+			eventLogs <- fmt.Sprintf("Unable to list rollout leases, %s", err.Error())
+			return len(leftovers)
+		}
+		if len(heldBy) != 0 {
+			eventLogs <- fmt.Sprintf(
+				"Skipping nodes outside of any asg, rollout of asg %s is held by %s",
+				heldAsg,
+				heldBy,
+			)
+			return 0
+		}
+	}
+
+	sorted := append([]Leftover{}, leftovers...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return leftoverOrder[sorted[i].Kind] < leftoverOrder[sorted[j].Kind]
+	})
+	// Snapshot and legacy tags restore the protection of all instances
+	protectionRestored := false
+	for _, leftover := range sorted {
+		if leftover.Kind == LeftoverSnapshot ||
+			(leftover.Kind == LeftoverTag && leftover.Resource == legacyMinTagKey) {
+			protectionRestored = true
+		}
+	}
+
+	failed := 0
+	for _, leftover := range sorted {
+		if (leftover.Kind == LeftoverInstanceProtection ||
+			leftover.Kind == LeftoverNewInstanceProtection) && protectionRestored {
+			continue
+		}
+		err := asgRollout.fixLeftover(leftover, eventLogs)
+		if err != nil {
+			failed++
+			log.Errorf("Unable to fix %s %s of asg %s due to %s", leftover.Kind, leftover.Resource, asgName, err.Error())
+			eventLogs <- fmt.Sprintf("Unable to fix %s %s, %s", leftover.Kind, leftover.Resource, err.Error())
+		}
+	}
+	return failed
+}
+
+func (asgRollout *asgRolloutClient) fixLeftover(leftover Leftover, eventLogs chan string) error {
+	asgName := leftover.AsgName
+	ignoreNotFound := asgRollout.rolloutConfig.IgnoreNotFound
+	switch leftover.Kind {
+	case LeftoverTaint:
+		eventLogs <- fmt.Sprintf("Removing taint %s of node %s", RollingTaintKey, leftover.Resource)
+		return asgRollout.kube.RemoveTaint(leftover.Resource, RollingTaintKey, ignoreNotFound)
+	case LeftoverCordon:
+		eventLogs <- fmt.Sprintf("Uncordoning node %s", leftover.Resource)
+		return asgRollout.kube.UnCordonNode(leftover.Resource, ignoreNotFound)
+	case LeftoverNodeLabel:
+		eventLogs <- fmt.Sprintf("Removing labels of node %s", leftover.Resource)
+		return asgRollout.kube.RemoveLabel(leftover.Resource, NodeStateLabelKey, ignoreNotFound)
+	case LeftoverTag:
+		switch leftover.Resource {
+		case ClusterAutoscalerTagKey:
+			return asgRollout.restoreClusterAutoscaler(asgName, eventLogs)
+		case SuspendedProcessesTagKey:
+			return asgRollout.resumeProcesses(asgName, eventLogs)
+		case legacyMinTagKey:
+			return asgRollout.restoreLegacyTags(asgName, eventLogs)
+		default:
+			// Max and desired tags are deleted along with the min tag,
+			// without min tag they are deleted on their own
+			tags, err := asgRollout.GetTagsOfAsg(asgName)
+			if err != nil {
+				return err
+			}
+			value, ok := tags[leftover.Resource]
+			if _, restored := tags[legacyMinTagKey]; !ok || restored {
+				return nil
+			}
+			eventLogs <- fmt.Sprintf("Deleting tag %s of asg %s", leftover.Resource, asgName)
+			return asgRollout.DeleteTagOfAsg(asgName, leftover.Resource, value)
+		}
+	case LeftoverSnapshot:
+		snapshot, err := asgRollout.GetAsgSnapshot(asgName)
+		if err != nil {
+			return err
+		}
+		groups, err := describeAsgs(
+			asgRollout.autoScalingCl,
+			&autoscaling.DescribeAutoScalingGroupsInput{
+				AutoScalingGroupNames: aws.StringSlice([]string{asgName}),
+			},
+		)
+		if err != nil {
+			return err
+		}
+		// Snapshot of a deleted asg is only deleted
+		if snapshot != nil && len(groups) != 0 {
+			err = asgRollout.restoreSnapshot(snapshot, false, eventLogs)
+			if err != nil {
+				return err
+			}
+		}
+		eventLogs <- fmt.Sprintf("Deleting snapshot of asg %s", asgName)
+		return asgRollout.kube.DeleteAsgSnapshot(asgName)
+	case LeftoverInstanceProtection:
+		eventLogs <- fmt.Sprintf("Removing instance scale in protection for instance %s", leftover.Resource)
+		return asgRollout.RemoveInstanceScaleInProtection(leftover.Resource, asgName)
+	case LeftoverNewInstanceProtection:
+		eventLogs <- fmt.Sprintf("Disabling new Instance Protection for asg %s", asgName)
+		return asgRollout.DisableNewInstanceProtection(asgName)
+	}
+	return fmt.Errorf("unknown leftover kind %s", leftover.Kind)
+}
@@ -0,0 +1,348 @@
+package aws
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/autoscaling"
+	log "github.com/sirupsen/logrus"
+)
+
+// Version of the snapshot format written by this dockyard. Snapshots of a
+// newer version are refused instead of being restored partially.
+const AsgSnapshotVersion = 1
+
+// Instances of SetInstanceProtection calls
+const maxInstancesPerProtection = 50
+
+// Configuration of an asg before its rollout, stored as json in a ConfigMap
+// so that post rollout and `dockyard asg restore` can reapply it
+type AsgSnapshot struct {
+	Version                          int       `json:"version"`
+	AsgName                          string    `json:"asg_name"`
+	TakenAt                          time.Time `json:"taken_at"`
+	MinSize                          int64     `json:"min_size"`
+	MaxSize                          int64     `json:"max_size"`
+	DesiredCapacity                  int64     `json:"desired_capacity"`
+	DefaultCooldown                  int64     `json:"default_cooldown"`
+	NewInstancesProtectedFromScaleIn bool      `json:"new_instances_protected_from_scale_in"`
+	SuspendedProcesses               []string  `json:"suspended_processes"`
+	// Scale in protection of the instances, keyed by instance id.
+	// Instances launched afterwards aren't protected after a restore.
+	InstanceProtection map[string]bool `json:"instance_protection"`
+}
+
+// Returns the snapshot of the asg's current configuration
+func (asgRollout *asgRolloutClient) takeSnapshot(asgName string) (*AsgSnapshot, error) {
+	asg, err := getAsg(asgName, asgRollout.autoScalingCl)
+	if err != nil {
+		return nil, err
+	}
+	snapshot := &AsgSnapshot{
+		Version:                          AsgSnapshotVersion,
+		AsgName:                          asgName,
+		TakenAt:                          time.Now().UTC(),
+		MinSize:                          aws.Int64Value(asg.MinSize),
+		MaxSize:                          aws.Int64Value(asg.MaxSize),
+		DesiredCapacity:                  aws.Int64Value(asg.DesiredCapacity),
+		DefaultCooldown:                  aws.Int64Value(asg.DefaultCooldown),
+		NewInstancesProtectedFromScaleIn: aws.BoolValue(asg.NewInstancesProtectedFromScaleIn),
+		SuspendedProcesses:               make([]string, 0, len(asg.SuspendedProcesses)),
+		InstanceProtection:               make(map[string]bool, len(asg.Instances)),
+	}
+	for _, process := range asg.SuspendedProcesses {
+		snapshot.SuspendedProcesses = append(
+			snapshot.SuspendedProcesses,
+			aws.StringValue(process.ProcessName),
+		)
+	}
+	sort.Strings(snapshot.SuspendedProcesses)
+	for _, instance := range asg.Instances {
+		snapshot.InstanceProtection[aws.StringValue(instance.InstanceId)] =
+			aws.BoolValue(instance.ProtectedFromScaleIn)
+	}
+	return snapshot, nil
+}
+
+func (asgRollout *asgRolloutClient) saveSnapshot(snapshot *AsgSnapshot) error {
+	data, err := json.Marshal(snapshot)
+	if err != nil {
+		return err
+	}
+	return asgRollout.kube.SaveAsgSnapshot(snapshot.AsgName, data)
+}
+
+// Returns the snapshot stored before the rollout of the asg, nil if the asg
+// has no snapshot
+func (asgRollout *asgRolloutClient) GetAsgSnapshot(asgName string) (*AsgSnapshot, error) {
+	data, err := asgRollout.kube.GetAsgSnapshot(asgName)
+	if err != nil || data == nil {
+		return nil, err
+	}
+	snapshot := &AsgSnapshot{}
+	if err := json.Unmarshal(data, snapshot); err != nil {
+		return nil, fmt.Errorf("Unable to parse snapshot of asg %s, %s", asgName, err.Error())
+	}
+	if snapshot.Version < 1 || snapshot.Version > AsgSnapshotVersion {
+		return nil, fmt.Errorf(
+			"Unable to read snapshot of asg %s with version %d, supported up to %d",
+			asgName,
+			snapshot.Version,
+			AsgSnapshotVersion,
+		)
+	}
+	return snapshot, nil
+}
+
+// Reapplies the snapshot of the asg exactly, including its desired capacity
+// and suspended processes, and deletes it afterwards
+func (asgRollout *asgRolloutClient) RestoreAsgSnapshot(
+	asgName string,
+	eventLogs chan string,
+) error {
+	snapshot, err := asgRollout.GetAsgSnapshot(asgName)
+	if err != nil {
+		return err
+	}
+	if snapshot == nil {
+		return fmt.Errorf("Unable to restore asg %s, it has no snapshot", asgName)
+	}
+	err = asgRollout.restoreSnapshot(snapshot, true, eventLogs)
+	if err != nil {
+		return err
+	}
+	// Processes are restored from the snapshot, resuming them again in
+	// post rollout would drop processes suspended before the rollout
+	tags, err := asgRollout.GetTagsOfAsg(asgName)
+	if err != nil {
+		return err
+	}
+	if value, ok := tags[SuspendedProcessesTagKey]; ok {
+		err = asgRollout.DeleteTagOfAsg(asgName, SuspendedProcessesTagKey, value)
+		if err != nil {
+			return err
+		}
+	}
+	return asgRollout.kube.DeleteAsgSnapshot(asgName)
+}
+
+// Applies the capacity, cooldown and protection of the snapshot. Desired
+// capacity and suspended processes are only applied if exact, post rollout
+// leaves the desired capacity to the asg and resumes processes on its own.
+func (asgRollout *asgRolloutClient) restoreSnapshot(
+	snapshot *AsgSnapshot,
+	exact bool,
+	eventLogs chan string,
+) error {
+	asgName := snapshot.AsgName
+	input := &autoscaling.UpdateAutoScalingGroupInput{
+		AutoScalingGroupName:             aws.String(asgName),
+		MinSize:                          aws.Int64(snapshot.MinSize),
+		MaxSize:                          aws.Int64(snapshot.MaxSize),
+		DefaultCooldown:                  aws.Int64(snapshot.DefaultCooldown),
+		NewInstancesProtectedFromScaleIn: aws.Bool(snapshot.NewInstancesProtectedFromScaleIn),
+	}
+	if exact {
+		input.DesiredCapacity = aws.Int64(snapshot.DesiredCapacity)
+	}
+	eventLogs <- fmt.Sprintf(
+		"Restoring min %d, max %d and cooldown %ds of asg %s",
+		snapshot.MinSize,
+		snapshot.MaxSize,
+		snapshot.DefaultCooldown,
+		asgName,
+	)
+	log.Infof("Restoring snapshot of asg %s taken at %s", asgName, snapshot.TakenAt)
+	asgRollout.lock.Lock()
+	_, err := asgRollout.autoScalingCl.UpdateAutoScalingGroup(input)
+	asgRollout.lock.Unlock()
+	if err != nil {
+		log.Errorf("Unable to restore asg %s due to %s", asgName, err.Error())
+		return fmt.Errorf("Unable to restore asg %s, %s", asgName, err.Error())
+	}
+
+	if exact {
+		err = asgRollout.restoreProcesses(snapshot, eventLogs)
+		if err != nil {
+			return err
+		}
+	}
+
+	time.Sleep(scaleInSettleWait)
+	return asgRollout.restoreInstanceProtection(snapshot, eventLogs)
+}
+
+// Suspends and resumes processes so that exactly the processes of the
+// snapshot are suspended
+func (asgRollout *asgRolloutClient) restoreProcesses(
+	snapshot *AsgSnapshot,
+	eventLogs chan string,
+) error {
+	asg, err := getAsg(snapshot.AsgName, asgRollout.autoScalingCl)
+	if err != nil {
+		return err
+	}
+	resume := make([]string, 0)
+	suspended := make([]string, 0, len(asg.SuspendedProcesses))
+	for _, process := range asg.SuspendedProcesses {
+		name := aws.StringValue(process.ProcessName)
+		suspended = append(suspended, name)
+		if !StringSliceContains(snapshot.SuspendedProcesses, name) {
+			resume = append(resume, name)
+		}
+	}
+	suspend := make([]string, 0)
+	for _, process := range snapshot.SuspendedProcesses {
+		if !StringSliceContains(suspended, process) {
+			suspend = append(suspend, process)
+		}
+	}
+
+	if len(resume) != 0 {
+		eventLogs <- fmt.Sprintf("Resuming processes %s of asg %s", strings.Join(resume, ", "), snapshot.AsgName)
+		_, err = asgRollout.autoScalingCl.ResumeProcesses(&autoscaling.ScalingProcessQuery{
+			AutoScalingGroupName: aws.String(snapshot.AsgName),
+			ScalingProcesses:     aws.StringSlice(resume),
+		})
+		if err != nil {
+			return fmt.Errorf("Unable to resume processes of asg %s, %s", snapshot.AsgName, err.Error())
+		}
+	}
+	if len(suspend) != 0 {
+		eventLogs <- fmt.Sprintf("Suspending processes %s of asg %s", strings.Join(suspend, ", "), snapshot.AsgName)
+		_, err = asgRollout.autoScalingCl.SuspendProcesses(&autoscaling.ScalingProcessQuery{
+			AutoScalingGroupName: aws.String(snapshot.AsgName),
+			ScalingProcesses:     aws.StringSlice(suspend),
+		})
+		if err != nil {
+			return fmt.Errorf("Unable to suspend processes of asg %s, %s", snapshot.AsgName, err.Error())
+		}
+	}
+	return nil
+}
+
+// Protects the instances which were protected when the snapshot was taken
+// and removes the protection of all others
+func (asgRollout *asgRolloutClient) restoreInstanceProtection(
+	snapshot *AsgSnapshot,
+	eventLogs chan string,
+) error {
+	instances, err := asgRollout.GetInstancesOfAsg(snapshot.AsgName)
+	if err != nil {
+		return err
+	}
+	byProtection := map[bool][]string{}
+	for _, instance := range instances {
+		protected := snapshot.InstanceProtection[aws.StringValue(instance)]
+		byProtection[protected] = append(byProtection[protected], aws.StringValue(instance))
+	}
+
+	for _, protected := range []bool{false, true} {
+		instanceIds := byProtection[protected]
+		for start := 0; start < len(instanceIds); start += maxInstancesPerProtection {
+			end := start + maxInstancesPerProtection
+			if end > len(instanceIds) {
+				end = len(instanceIds)
+			}
+			eventLogs <- fmt.Sprintf(
+				"Setting scale in protection of instances %s to %t",
+				strings.Join(instanceIds[start:end], ", "),
+				protected,
+			)
+			_, err := asgRollout.autoScalingCl.SetInstanceProtection(
+				&autoscaling.SetInstanceProtectionInput{
+					AutoScalingGroupName: aws.String(snapshot.AsgName),
+					InstanceIds:          aws.StringSlice(instanceIds[start:end]),
+					ProtectedFromScaleIn: aws.Bool(protected),
+				},
+			)
+			if err != nil {
+				log.Errorf("Unable to set instance protection of asg %s due to %s", snapshot.AsgName, err.Error())
+				return fmt.Errorf(
+					"Unable to set scale in protection of instances of asg %s, %s",
+					snapshot.AsgName,
+					err.Error(),
+				)
+			}
+		}
+	}
+	return nil
+}
+
+// Tags holding min, max and desired of asgs whose rollout was started before
+// snapshots were stored in ConfigMaps
+const (
+	legacyMinTagKey     = "dockyard.io/min"
+	legacyMaxTagKey     = "dockyard.io/max"
+	legacyDesiredTagKey = "dockyard.io/desired"
+)
+
+// Restores min and max of the asg from the tags of a rollout started
+// before snapshots, and removes the scale in protection of its instances
+func (asgRollout *asgRolloutClient) restoreLegacyTags(
+	asgName string,
+	eventLogs chan string,
+) error {
+	tags, err := asgRollout.GetTagsOfAsg(asgName)
+	if err != nil {
+		log.Errorf("Unable to fetch tags of asg %s due to %s", asgName, err.Error())
+		return err
+	}
+	if _, ok := tags[legacyMinTagKey]; !ok {
+		eventLogs <- fmt.Sprintf("Asg %s has no snapshot to restore", asgName)
+		log.Warnf("Asg %s has no snapshot to restore", asgName)
+		return nil
+	}
+	minNodes, err := asgRollout.GetTagValueOfAsg(asgName, legacyMinTagKey)
+	if err != nil {
+		log.Errorf("Unable to fetch tags of asg %s due to %s", asgName, err.Error())
+		return err
+	}
+	maxNodes, err := asgRollout.GetTagValueOfAsg(asgName, legacyMaxTagKey)
+	if err != nil {
+		log.Errorf("Unable to fetch tags of asg %s due to %s", asgName, err.Error())
+		return err
+	}
+
+	eventLogs <- fmt.Sprintf("Updating min count of asg %s to previous state ", asgName)
+	err = asgRollout.SetMinCount(asgName, minNodes)
+	if err != nil {
+		log.Errorf("Unable to update min count of asg %s due to %s", asgName, err.Error())
+		return err
+	}
+	log.Infof("Updating min count %d for asgName %s ", minNodes, asgName)
+	eventLogs <- fmt.Sprintf("Updating max count of asg %s to previous state ", asgName)
+	err = asgRollout.SetMaxCount(asgName, maxNodes)
+	if err != nil {
+		log.Errorf("Unable to update max count of asg %s due to %s", asgName, err.Error())
+		return err
+	}
+	log.Infof("Updating max count %d for asgName %s ", maxNodes, asgName)
+
+	for _, tagKey := range []string{legacyMinTagKey, legacyMaxTagKey, legacyDesiredTagKey} {
+		value, ok := tags[tagKey]
+		if !ok {
+			continue
+		}
+		eventLogs <- fmt.Sprintf("Deleting tag %s of asg %s", tagKey, asgName)
+		log.Infof("Deleting tag %s of asg %s", tagKey, asgName)
+		err = asgRollout.DeleteTagOfAsg(asgName, tagKey, value)
+		if err != nil {
+			log.Errorf("Unable to delete tags of asg %s due to %s", asgName, err.Error())
+			return err
+		}
+	}
+
+	time.Sleep(scaleInSettleWait)
+	instances, _ := asgRollout.GetInstancesOfAsg(asgName)
+	for _, instance := range instances {
+		eventLogs <- fmt.Sprintf("Removing instance scale in protection for instance %s ", *instance)
+		asgRollout.RemoveInstanceScaleInProtection(*instance, asgName)
+		log.Infof("Removing instance scale in protection for instance %s,%s", *instance, asgName)
+	}
+	return nil
+}
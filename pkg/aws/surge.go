@@ -0,0 +1,203 @@
+package aws
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/autoscaling"
+	"github.com/aws/aws-sdk-go/service/ec2"
+)
+
+// Extra capacity a rollout of the asg launches on top of its desired
+// capacity, one instance per node of a batch
+type SurgeRequirements struct {
+	AsgName      string
+	InstanceType string
+	// Surge instances are spot instances and count against the spot quota
+	Spot bool
+	// Surge instances count against the standard instance quotas, other
+	// families ( G, P, Inf, X, ... ) have quotas of their own
+	Standard bool
+	// Instances launched on top of the desired capacity
+	Instances int64
+	// vCPUs of all surge instances
+	VCpus int64
+	// Ips a surge instance takes up once all its ENIs are attached, which
+	// happens when it runs max pods with the VPC CNI
+	IpsPerInstance int64
+	MaxPods        int64
+	SubnetIds      []string
+	// Max size of the asg is lower than desired capacity plus surge, it is
+	// raised by the rollout
+	ExceedsMax bool
+}
+
+// Running vCPUs of the on-demand or spot standard instances of the region
+// and their quota
+type VCpuQuota struct {
+	// Zero if the quota couldn't be found
+	Limit int64
+	Used  int64
+}
+
+// Returns the free vCPUs of the quota
+func (q VCpuQuota) Free() int64 {
+	return q.Limit - q.Used
+}
+
+// Returns the surge a rollout of the asg in batches of batchSize nodes
+// needs
+func (asgRollout *asgRolloutClient) GetSurgeRequirements(
+	asgName string,
+	batchSize int64,
+) (SurgeRequirements, error) {
+	group, err := getAsg(asgName, asgRollout.autoScalingCl)
+	if err != nil {
+		return SurgeRequirements{}, err
+	}
+
+	instanceType, spot, err := asgRollout.launchSpecOfAsg(group)
+	if err != nil {
+		return SurgeRequirements{}, err
+	}
+	if len(instanceType) == 0 {
+		return SurgeRequirements{}, fmt.Errorf(
+			"Unable to find the instance type of asg %s",
+			asgName,
+		)
+	}
+
+	infos, err := describeInstanceTypes(
+		asgRollout.ec2Cl,
+		[]*string{aws.String(instanceType)},
+	)
+	if err != nil {
+		return SurgeRequirements{}, err
+	}
+	if len(infos) == 0 {
+		return SurgeRequirements{}, fmt.Errorf(
+			"Unable to find details of instance type %s",
+			instanceType,
+		)
+	}
+	vCpus, maxEnis, ipsPerEni := instanceTypeCapacity(infos[0])
+
+	subnetIds := make([]string, 0)
+	for _, subnetId := range strings.Split(aws.StringValue(group.VPCZoneIdentifier), ",") {
+		if subnetId = strings.TrimSpace(subnetId); len(subnetId) != 0 {
+			subnetIds = append(subnetIds, subnetId)
+		}
+	}
+
+	return SurgeRequirements{
+		AsgName:        asgName,
+		InstanceType:   instanceType,
+		Spot:           spot,
+		Standard:       isStandardInstanceType(instanceType),
+		Instances:      batchSize,
+		VCpus:          batchSize * vCpus,
+		IpsPerInstance: maxEnis * ipsPerEni,
+		// Max pods of the EKS AMIs with the VPC CNI
+		MaxPods:   maxEnis*(ipsPerEni-1) + 2,
+		SubnetIds: subnetIds,
+		ExceedsMax: aws.Int64Value(group.DesiredCapacity)+batchSize >
+			aws.Int64Value(group.MaxSize),
+	}, nil
+}
+
+// Returns the instance type new instances of the asg are launched with and
+// whether they are spot instances. The launch template version the asg
+// launches is used. Mixed instances policies are represented by their first
+// override.
+func (asgRollout *asgRolloutClient) launchSpecOfAsg(
+	group *autoscaling.Group,
+) (instanceType string, spot bool, err error) {
+	if group.LaunchConfigurationName != nil {
+		launchConfigs, err := describeLaunchConfigs(
+			asgRollout.autoScalingCl,
+			&autoscaling.DescribeLaunchConfigurationsInput{
+				LaunchConfigurationNames: []*string{group.LaunchConfigurationName},
+			},
+		)
+		if err != nil || len(launchConfigs) == 0 {
+			return "", false, err
+		}
+		return aws.StringValue(launchConfigs[0].InstanceType),
+			launchConfigs[0].SpotPrice != nil,
+			nil
+	}
+
+	var launchTemplateId, launchTemplateVersion *string
+	if group.LaunchTemplate != nil {
+		launchTemplateId = group.LaunchTemplate.LaunchTemplateId
+		launchTemplateVersion = group.LaunchTemplate.Version
+	} else if group.MixedInstancesPolicy != nil &&
+		group.MixedInstancesPolicy.LaunchTemplate != nil {
+		policy := group.MixedInstancesPolicy
+		if policy.LaunchTemplate.LaunchTemplateSpecification != nil {
+			launchTemplateId = policy.LaunchTemplate.LaunchTemplateSpecification.LaunchTemplateId
+			launchTemplateVersion = policy.LaunchTemplate.LaunchTemplateSpecification.Version
+		}
+		if len(policy.LaunchTemplate.Overrides) != 0 {
+			instanceType = aws.StringValue(policy.LaunchTemplate.Overrides[0].InstanceType)
+		}
+		// Surge instances are launched above the on-demand base capacity
+		if policy.InstancesDistribution != nil &&
+			policy.InstancesDistribution.OnDemandPercentageAboveBaseCapacity != nil &&
+			*policy.InstancesDistribution.OnDemandPercentageAboveBaseCapacity == 0 {
+			spot = true
+		}
+	}
+	if launchTemplateId == nil {
+		return instanceType, spot, nil
+	}
+
+	// Asgs without version launch the default version
+	if len(aws.StringValue(launchTemplateVersion)) == 0 {
+		launchTemplateVersion = aws.String("$Default")
+	}
+	versions, err := describeLaunchTemplateVersions(
+		asgRollout.ec2Cl,
+		&ec2.DescribeLaunchTemplateVersionsInput{
+			LaunchTemplateId: launchTemplateId,
+			Versions:         []*string{launchTemplateVersion},
+		},
+	)
+	if err != nil {
+		return "", false, err
+	}
+	if len(versions) != 0 && versions[0].LaunchTemplateData != nil {
+		data := versions[0].LaunchTemplateData
+		if len(instanceType) == 0 {
+			instanceType = aws.StringValue(data.InstanceType)
+		}
+		if data.InstanceMarketOptions != nil &&
+			aws.StringValue(data.InstanceMarketOptions.MarketType) == ec2.MarketTypeSpot {
+			spot = true
+		}
+	}
+	return instanceType, spot, nil
+}
+
+// Returns vCPUs, max ENIs and ipv4 addresses per ENI of the instance type
+func instanceTypeCapacity(info *ec2.InstanceTypeInfo) (vCpus, maxEnis, ipsPerEni int64) {
+	if info.VCpuInfo != nil {
+		vCpus = aws.Int64Value(info.VCpuInfo.DefaultVCpus)
+	}
+	if info.NetworkInfo != nil {
+		maxEnis = aws.Int64Value(info.NetworkInfo.MaximumNetworkInterfaces)
+		ipsPerEni = aws.Int64Value(info.NetworkInfo.Ipv4AddressesPerInterface)
+	}
+	return
+}
+
+// Checks if the instance type counts against the standard ( A, C, D, H, I,
+// M, R, T, Z ) instance quotas
+func isStandardInstanceType(instanceType string) bool {
+	if strings.HasPrefix(instanceType, "inf") || strings.HasPrefix(instanceType, "hpc") {
+		return false
+	}
+	return len(instanceType) != 0 &&
+		strings.ContainsRune("acdhimrtz", rune(instanceType[0]))
+}
@@ -0,0 +1,49 @@
+package aws
+
+import (
+	"testing"
+
+	"dockyard/pkg/aws/fake"
+)
+
+func TestSurgeRequirementsOfLaunchedVersion(t *testing.T) {
+	tests := []struct {
+		name             string
+		version          string
+		wantInstanceType string
+	}{
+		{name: "latest", version: "$Latest", wantInstanceType: "c5.2xlarge"},
+		{name: "default", version: "$Default", wantInstanceType: "m5.xlarge"},
+		{name: "pinned", version: "1", wantInstanceType: "t3.large"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			cloud := fake.NewCloud()
+			cloud.AddImage("ami-old", "amazon-eks-node-1.21-v20220824")
+			cloud.AddInstanceType("t3.large", 2, 3, 12)
+			cloud.AddInstanceType("m5.xlarge", 4, 4, 15)
+			cloud.AddInstanceType("c5.2xlarge", 8, 4, 15)
+			for version, instanceType := range []string{"t3.large", "m5.xlarge", "c5.2xlarge"} {
+				cloud.AddLaunchTemplateVersion(testLaunchTemplateId, "ami-old")
+				cloud.SetLaunchTemplateVersionInstanceType(testLaunchTemplateId, int64(version+1), instanceType)
+			}
+			cloud.SetLaunchTemplateDefaultVersion(testLaunchTemplateId, 2)
+			cloud.AddAsg(fake.AsgSpec{
+				Name:                  testAsgName,
+				LaunchTemplateId:      testLaunchTemplateId,
+				LaunchTemplateVersion: test.version,
+				MinSize:               1,
+				MaxSize:               3,
+				DesiredCapacity:       1,
+			})
+
+			surge, err := newTestAsgClient(cloud).GetSurgeRequirements(testAsgName, 2)
+			if err != nil {
+				t.Fatalf("GetSurgeRequirements failed, %s", err.Error())
+			}
+			if surge.InstanceType != test.wantInstanceType {
+				t.Errorf("Surge instance type is %s, want %s of version %s", surge.InstanceType, test.wantInstanceType, test.version)
+			}
+		})
+	}
+}
@@ -10,6 +10,14 @@ import (
 
 var (
 	NodeStateLabelKey = "dockyard.io/node-state"
+	// Taint applied to old nodes during a rollout so that evicted pods are
+	// scheduled on the new nodes
+	RollingTaintKey = "dockyard.io/rolling"
+)
+
+const (
+	TaintEffectNoSchedule       = "NoSchedule"
+	TaintEffectPreferNoSchedule = "PreferNoSchedule"
 )
 
 // Returns desired capacity of the provided asg
@@ -18,7 +26,7 @@ func (asgRollout *asgRolloutClient) GetDesiredCount(
 ) (int64, error) {
 	asgRollout.lock.Lock()
 	defer asgRollout.lock.Unlock()
-	asg, err := getAsg(asgName, asgRollout.session)
+	asg, err := getAsg(asgName, asgRollout.autoScalingCl)
 	if err != nil {
 		return 0, err
 	} else {
@@ -35,7 +43,7 @@ func (asgRollout *asgRolloutClient) SetDesiredCount(
 
 	asgRollout.lock.Lock()
 	defer asgRollout.lock.Unlock()
-	autoscaligCl := autoscaling.New(asgRollout.session)
+	autoscaligCl := asgRollout.autoScalingCl
 	input := &autoscaling.SetDesiredCapacityInput{
 		AutoScalingGroupName: aws.String(asgName),
 		DesiredCapacity:      aws.Int64(capacity),
@@ -50,7 +58,7 @@ func (asgRollout *asgRolloutClient) SetDesiredCount(
 func (asgRollout *asgRolloutClient) GetMinCount(asgName string) (int64, error) {
 	asgRollout.lock.Lock()
 	defer asgRollout.lock.Unlock()
-	asg, err := getAsg(asgName, asgRollout.session)
+	asg, err := getAsg(asgName, asgRollout.autoScalingCl)
 	if err != nil {
 		return 0, err
 	} else {
@@ -65,7 +73,7 @@ func (asgRollout *asgRolloutClient) SetMinCount(
 ) error {
 	asgRollout.lock.Lock()
 	defer asgRollout.lock.Unlock()
-	autoscaligCl := autoscaling.New(asgRollout.session)
+	autoscaligCl := asgRollout.autoScalingCl
 	input := &autoscaling.UpdateAutoScalingGroupInput{
 		AutoScalingGroupName: aws.String(asgName),
 		MinSize:              aws.Int64(capacity),
@@ -80,7 +88,7 @@ func (asgRollout *asgRolloutClient) GetMaxCount(asgName string) (int64, error) {
 
 	asgRollout.lock.Lock()
 	defer asgRollout.lock.Unlock()
-	asg, err := getAsg(asgName, asgRollout.session)
+	asg, err := getAsg(asgName, asgRollout.autoScalingCl)
 	if err != nil {
 		return 0, err
 	} else {
@@ -96,7 +104,7 @@ func (asgRollout *asgRolloutClient) SetMaxCount(
 
 	asgRollout.lock.Lock()
 	defer asgRollout.lock.Unlock()
-	autoscaligCl := autoscaling.New(asgRollout.session)
+	autoscaligCl := asgRollout.autoScalingCl
 	input := &autoscaling.UpdateAutoScalingGroupInput{
 		AutoScalingGroupName: aws.String(asgName),
 		MaxSize:              aws.Int64(capacity),
@@ -110,7 +118,7 @@ func (asgRollout *asgRolloutClient) SetMaxCount(
 func (asgRollout *asgRolloutClient) AddTagToAsG(
 	asgName, tagKey, tagValue string,
 ) error {
-	autoscaligCl := autoscaling.New(asgRollout.session)
+	autoscaligCl := asgRollout.autoScalingCl
 	_, err := autoscaligCl.CreateOrUpdateTags(
 		&autoscaling.CreateOrUpdateTagsInput{
 			Tags: []*autoscaling.Tag{
@@ -132,7 +140,7 @@ func (asgRollout *asgRolloutClient) GetTagValueOfAsg(
 	asgName, tagKey string,
 ) (int64, error) {
 
-	svc := autoscaling.New(asgRollout.session)
+	svc := asgRollout.autoScalingCl
 	input := &autoscaling.DescribeTagsInput{
 		Filters: []*autoscaling.Filter{
 			{
@@ -144,21 +152,29 @@ func (asgRollout *asgRolloutClient) GetTagValueOfAsg(
 		},
 	}
 
-	result, err := svc.DescribeTags(input)
+	tags, err := describeAsgTags(svc, input)
 
 	if err != nil {
 		return 0, err
 	}
-	for _, tag := range result.Tags {
+	for _, tag := range tags {
 		if *tag.Key == tagKey {
-			v, _ := strconv.Atoi(*tag.Value)
+			v, err := strconv.Atoi(*tag.Value)
+			if err != nil {
+				return 0, fmt.Errorf(
+					"Unable to parse tag %s=%s of asg %s as number",
+					tagKey,
+					*tag.Value,
+					asgName,
+				)
+			}
 			return int64(v), nil
 		}
 	}
 	return 0, fmt.Errorf(
 		"Tag with key %s for asg %s not found",
-		asgName,
 		tagKey,
+		asgName,
 	)
 }
 
@@ -167,7 +183,7 @@ func (asgRollout *asgRolloutClient) DeleteTagOfAsg(
 	asgName, tagKey, tagVal string,
 ) error {
 
-	autoscaligCl := autoscaling.New(asgRollout.session)
+	autoscaligCl := asgRollout.autoScalingCl
 	_, err := autoscaligCl.DeleteTags(&autoscaling.DeleteTagsInput{
 		Tags: []*autoscaling.Tag{
 			{
@@ -180,3 +196,28 @@ func (asgRollout *asgRolloutClient) DeleteTagOfAsg(
 	})
 	return err
 }
+
+// Returns all tags of this asg keyed by tag key
+func (asgRollout *asgRolloutClient) GetTagsOfAsg(
+	asgName string,
+) (map[string]string, error) {
+	tags, err := describeAsgTags(
+		asgRollout.autoScalingCl,
+		&autoscaling.DescribeTagsInput{
+			Filters: []*autoscaling.Filter{
+				{
+					Name:   aws.String("auto-scaling-group"),
+					Values: []*string{aws.String(asgName)},
+				},
+			},
+		},
+	)
+	if err != nil {
+		return nil, err
+	}
+	asgTags := make(map[string]string, len(tags))
+	for _, tag := range tags {
+		asgTags[aws.StringValue(tag.Key)] = aws.StringValue(tag.Value)
+	}
+	return asgTags, nil
+}
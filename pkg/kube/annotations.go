@@ -0,0 +1,92 @@
+package kube
+
+import (
+	"sort"
+	"strconv"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+const (
+	// Pods annotated with dockyard.io/do-not-evict=true are never evicted by
+	// dockyard. Drain of their node is blocked until an operator removes the
+	// annotation or deletes the pod.
+	DoNotEvictAnnotationKey = "dockyard.io/do-not-evict"
+
+	// Overrides the grace period (in seconds) used while evicting the pod
+	EvictionGracePeriodAnnotationKey = "dockyard.io/eviction-grace-period"
+
+	// Pods with a higher drain priority are evicted before pods with a lower
+	// one. Pods without the annotation have priority 0.
+	DrainPriorityAnnotationKey = "dockyard.io/drain-priority"
+
+	// Team or person owning a Deployment or StatefulSet, set as annotation
+	// or label of the workload. It is shown next to workloads which need
+	// attention before a rollout.
+	OwnerAnnotationKey = "dockyard.io/owner"
+)
+
+var drainAnnotationKeys = []string{
+	DoNotEvictAnnotationKey,
+	EvictionGracePeriodAnnotationKey,
+	DrainPriorityAnnotationKey,
+}
+
+// Checks if the pod has opted out of eviction
+func isDoNotEvict(pod *corev1.Pod) bool {
+	val, ok := pod.ObjectMeta.Annotations[DoNotEvictAnnotationKey]
+	if !ok {
+		return false
+	}
+	doNotEvict, err := strconv.ParseBool(val)
+	return err == nil && doNotEvict
+}
+
+// Returns the grace period requested by the pod annotation, nil if the pod
+// doesn't override it or the value is not a valid number of seconds
+func evictionGracePeriod(pod *corev1.Pod) *int64 {
+	val, ok := pod.ObjectMeta.Annotations[EvictionGracePeriodAnnotationKey]
+	if !ok {
+		return nil
+	}
+	seconds, err := strconv.ParseInt(val, 10, 64)
+	if err != nil || seconds < 0 {
+		return nil
+	}
+	return &seconds
+}
+
+// Returns the drain priority of the pod, defaults to 0
+func drainPriority(pod *corev1.Pod) int {
+	val, ok := pod.ObjectMeta.Annotations[DrainPriorityAnnotationKey]
+	if !ok {
+		return 0
+	}
+	priority, err := strconv.Atoi(val)
+	if err != nil {
+		return 0
+	}
+	return priority
+}
+
+// Groups pods by drain priority. Groups are ordered from the highest
+// priority to the lowest one.
+func groupByDrainPriority(pods []corev1.Pod) [][]corev1.Pod {
+	groups := map[int][]corev1.Pod{}
+	priorities := make([]int, 0)
+	for _, pod := range pods {
+		priority := drainPriority(&pod)
+		if _, ok := groups[priority]; !ok {
+			priorities = append(priorities, priority)
+		}
+		groups[priority] = append(groups[priority], pod)
+	}
+
+	sort.Sort(sort.Reverse(sort.IntSlice(priorities)))
+
+	result := make([][]corev1.Pod, 0, len(priorities))
+	for _, priority := range priorities {
+		result = append(result, groups[priority])
+	}
+	return result
+}
@@ -0,0 +1,138 @@
+package kube
+
+import (
+	"reflect"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func annotatedPod(name string, annotations map[string]string) corev1.Pod {
+	return corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        name,
+			Namespace:   "default",
+			Annotations: annotations,
+		},
+	}
+}
+
+func TestEvictionGracePeriod(t *testing.T) {
+	tests := []struct {
+		name  string
+		value *string
+		want  *int64
+	}{
+		{name: "not annotated", want: nil},
+		{name: "seconds", value: strPtr("45"), want: int64Ptr(45)},
+		{name: "zero", value: strPtr("0"), want: int64Ptr(0)},
+		{name: "negative", value: strPtr("-1"), want: nil},
+		{name: "not a number", value: strPtr("30s"), want: nil},
+		{name: "empty", value: strPtr(""), want: nil},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			annotations := map[string]string{}
+			if test.value != nil {
+				annotations[EvictionGracePeriodAnnotationKey] = *test.value
+			}
+			pod := annotatedPod("app", annotations)
+			got := evictionGracePeriod(&pod)
+			if !reflect.DeepEqual(got, test.want) {
+				t.Errorf("evictionGracePeriod() = %v, want %v", ptrString(got), ptrString(test.want))
+			}
+		})
+	}
+}
+
+func TestIsDoNotEvict(t *testing.T) {
+	tests := []struct {
+		value *string
+		want  bool
+	}{
+		{value: nil, want: false},
+		{value: strPtr("true"), want: true},
+		{value: strPtr("1"), want: true},
+		{value: strPtr("false"), want: false},
+		{value: strPtr("yes"), want: false},
+	}
+	for _, test := range tests {
+		annotations := map[string]string{}
+		if test.value != nil {
+			annotations[DoNotEvictAnnotationKey] = *test.value
+		}
+		pod := annotatedPod("app", annotations)
+		if got := isDoNotEvict(&pod); got != test.want {
+			t.Errorf("isDoNotEvict() of %v = %t, want %t", annotations, got, test.want)
+		}
+	}
+}
+
+func TestGroupByDrainPriority(t *testing.T) {
+	priority := func(name, value string) corev1.Pod {
+		return annotatedPod(name, map[string]string{DrainPriorityAnnotationKey: value})
+	}
+	tests := []struct {
+		name string
+		pods []corev1.Pod
+		want [][]string
+	}{
+		{
+			name: "no pods",
+			pods: []corev1.Pod{},
+			want: [][]string{},
+		},
+		{
+			name: "not annotated",
+			pods: []corev1.Pod{annotatedPod("a", nil), annotatedPod("b", nil)},
+			want: [][]string{{"a", "b"}},
+		},
+		{
+			name: "highest priority first",
+			pods: []corev1.Pod{
+				priority("low", "-5"),
+				annotatedPod("default", nil),
+				priority("high", "10"),
+				priority("mid", "3"),
+				priority("high-2", "10"),
+			},
+			want: [][]string{{"high", "high-2"}, {"mid"}, {"default"}, {"low"}},
+		},
+		{
+			name: "invalid priority defaults to 0",
+			pods: []corev1.Pod{priority("invalid", "first"), priority("zero", "0"), priority("one", "1")},
+			want: [][]string{{"one"}, {"invalid", "zero"}},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got := make([][]string, 0)
+			for _, group := range groupByDrainPriority(test.pods) {
+				names := make([]string, 0, len(group))
+				for _, pod := range group {
+					names = append(names, pod.Name)
+				}
+				got = append(got, names)
+			}
+			if !reflect.DeepEqual(got, test.want) {
+				t.Errorf("groupByDrainPriority() = %v, want %v", got, test.want)
+			}
+		})
+	}
+}
+
+func strPtr(s string) *string {
+	return &s
+}
+
+func int64Ptr(i int64) *int64 {
+	return &i
+}
+
+func ptrString(i *int64) interface{} {
+	if i == nil {
+		return nil
+	}
+	return *i
+}
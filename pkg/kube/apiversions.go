@@ -0,0 +1,213 @@
+package kube
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+const (
+	lastAppliedAnnotationKey = "kubectl.kubernetes.io/last-applied-configuration"
+
+	// Objects listed per request while scanning for api versions
+	apiVersionScanPageSize = 500
+
+	deprecatedApiMetric = "apiserver_requested_deprecated_apis"
+)
+
+var metricLabelRe = regexp.MustCompile(`(\w+)="([^"]*)"`)
+
+// Api version an object was written with
+type ApiVersionUsage struct {
+	Kind       string
+	Namespace  string
+	Name       string
+	ApiVersion string
+	// Where the api version was found, last-applied-configuration or the
+	// managedFields of a field manager
+	Source string
+}
+
+func (c *kubeClient) ListApiVersionUsages(
+	groupResources []schema.GroupResource,
+	apiVersions []string,
+) ([]ApiVersionUsage, error) {
+	if c.dynamicClient == nil {
+		return nil, errors.New("Unable to list objects without a dynamic client")
+	}
+
+	wanted := map[string]bool{}
+	for _, apiVersion := range apiVersions {
+		wanted[apiVersion] = true
+	}
+
+	resources, err := c.servedResources(groupResources)
+	if err != nil {
+		return nil, err
+	}
+
+	usages := make([]ApiVersionUsage, 0)
+	for _, resource := range resources {
+		objects, err := c.listObjects(resource)
+		if err != nil {
+			return nil, err
+		}
+		for _, object := range objects {
+			usages = append(usages, apiVersionUsagesOf(object, wanted)...)
+		}
+	}
+	sort.SliceStable(usages, func(i, j int) bool {
+		if usages[i].Namespace != usages[j].Namespace {
+			return usages[i].Namespace < usages[j].Namespace
+		}
+		return usages[i].Name < usages[j].Name
+	})
+	return usages, nil
+}
+
+// Returns the resources along with the version the server prefers for
+// them, resources the server doesn't serve are left out
+func (c *kubeClient) servedResources(
+	groupResources []schema.GroupResource,
+) ([]schema.GroupVersionResource, error) {
+	groups, err := c.clientSet.Discovery().ServerGroups()
+	if err != nil {
+		return nil, err
+	}
+
+	served := make([]schema.GroupVersionResource, 0)
+	for _, group := range groups.Groups {
+		resourceList, err := c.clientSet.Discovery().ServerResourcesForGroupVersion(
+			group.PreferredVersion.GroupVersion,
+		)
+		if err != nil {
+			if apierrors.IsNotFound(err) {
+				continue
+			}
+			return nil, err
+		}
+		for _, groupResource := range groupResources {
+			if groupResource.Group != group.Name {
+				continue
+			}
+			for _, resource := range resourceList.APIResources {
+				if resource.Name == groupResource.Resource {
+					served = append(
+						served,
+						groupResource.WithVersion(group.PreferredVersion.Version),
+					)
+				}
+			}
+		}
+	}
+	return served, nil
+}
+
+// Lists all objects of the resource in all namespaces page by page
+func (c *kubeClient) listObjects(
+	resource schema.GroupVersionResource,
+) ([]unstructured.Unstructured, error) {
+	objects := make([]unstructured.Unstructured, 0)
+	opts := metav1.ListOptions{Limit: apiVersionScanPageSize}
+	for {
+		list, err := c.dynamicClient.Resource(resource).List(context.TODO(), opts)
+		if err != nil {
+			return nil, fmt.Errorf(
+				"Unable to list %s due to %w",
+				resource.String(),
+				err,
+			)
+		}
+		objects = append(objects, list.Items...)
+		if len(list.GetContinue()) == 0 {
+			return objects, nil
+		}
+		opts.Continue = list.GetContinue()
+	}
+}
+
+// Returns the wanted api versions found in the last applied configuration
+// and the managed fields of the object
+func apiVersionUsagesOf(
+	object unstructured.Unstructured,
+	wanted map[string]bool,
+) []ApiVersionUsage {
+	usages := make([]ApiVersionUsage, 0)
+	usage := func(apiVersion, source string) {
+		if !wanted[apiVersion] {
+			return
+		}
+		usages = append(usages, ApiVersionUsage{
+			Kind:       object.GetKind(),
+			Namespace:  object.GetNamespace(),
+			Name:       object.GetName(),
+			ApiVersion: apiVersion,
+			Source:     source,
+		})
+	}
+
+	if lastApplied, ok := object.GetAnnotations()[lastAppliedAnnotationKey]; ok {
+		applied := struct {
+			ApiVersion string `json:"apiVersion"`
+		}{}
+		if err := json.Unmarshal([]byte(lastApplied), &applied); err == nil {
+			usage(applied.ApiVersion, "last-applied-configuration")
+		}
+	}
+	for _, field := range object.GetManagedFields() {
+		usage(field.APIVersion, fmt.Sprintf("managedFields ( %s )", field.Manager))
+	}
+	return usages
+}
+
+func (c *kubeClient) ListDeprecatedApiRequests() ([][]string, error) {
+	restClient := c.clientSet.Discovery().RESTClient()
+	if restClient == nil {
+		return nil, errors.New("Unable to reach the metrics of the api server")
+	}
+	metrics, err := restClient.Get().AbsPath("/metrics").DoRaw(context.TODO())
+	if err != nil {
+		return nil, fmt.Errorf(
+			"Unable to reach the metrics of the api server due to %w",
+			err,
+		)
+	}
+	return parseDeprecatedApiRequests(string(metrics)), nil
+}
+
+// Parses the apiserver_requested_deprecated_apis samples of the metrics in
+// the prometheus text format
+func parseDeprecatedApiRequests(metrics string) [][]string {
+	seen := map[string]bool{}
+	requests := make([][]string, 0)
+	for _, line := range strings.Split(metrics, "\n") {
+		if !strings.HasPrefix(line, deprecatedApiMetric+"{") {
+			continue
+		}
+		labels := map[string]string{}
+		for _, match := range metricLabelRe.FindAllStringSubmatch(line, -1) {
+			labels[match[1]] = match[2]
+		}
+		apiVersion := schema.GroupVersion{
+			Group:   labels["group"],
+			Version: labels["version"],
+		}.String()
+		row := []string{apiVersion, labels["resource"], labels["removed_release"]}
+		key := strings.Join(row, " ")
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+		requests = append(requests, row)
+	}
+	return requests
+}
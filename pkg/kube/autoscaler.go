@@ -0,0 +1,219 @@
+package kube
+
+import (
+	"context"
+	"sort"
+	"strings"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/util/retry"
+)
+
+const (
+	// Nodes annotated with true are never scaled down by cluster-autoscaler
+	ScaleDownDisabledAnnotationKey = "cluster-autoscaler.kubernetes.io/scale-down-disabled"
+
+	clusterAutoscalerName = "cluster-autoscaler"
+)
+
+// Deployment of cluster-autoscaler along with the node groups it manages
+type ClusterAutoscaler struct {
+	Namespace string
+	Name      string
+	Replicas  int32
+	// Asgs of the --nodes=<min>:<max>:<asg> flags
+	NodeGroups []string
+	// Tags of the --node-group-auto-discovery=asg:tag=<key>[=<value>],...
+	// flags. An asg is discovered if it carries all tags of any of the
+	// sets, tags without value match any value.
+	AutoDiscoveryTags []map[string]string
+}
+
+// Returns the flag through which cluster-autoscaler manages the asg, empty
+// if it doesn't manage the asg
+func (ca ClusterAutoscaler) ManagedBy(asgName string, asgTags map[string]string) string {
+	for _, nodeGroup := range ca.NodeGroups {
+		if nodeGroup == asgName {
+			return "--nodes"
+		}
+	}
+	for _, tags := range ca.AutoDiscoveryTags {
+		matched := len(tags) != 0
+		for key, value := range tags {
+			asgValue, ok := asgTags[key]
+			if !ok || (len(value) != 0 && asgValue != value) {
+				matched = false
+				break
+			}
+		}
+		if matched {
+			return "--node-group-auto-discovery"
+		}
+	}
+	return ""
+}
+
+func (c *kubeClient) ListClusterAutoscalers() ([]ClusterAutoscaler, error) {
+	deployments, err := c.clientSet.AppsV1().
+		Deployments(metav1.NamespaceAll).
+		List(context.TODO(), metav1.ListOptions{})
+	if err != nil {
+		return nil, err
+	}
+
+	autoscalers := make([]ClusterAutoscaler, 0)
+	for _, deployment := range deployments.Items {
+		if ca, ok := clusterAutoscalerOf(&deployment); ok {
+			autoscalers = append(autoscalers, ca)
+		}
+	}
+	sort.Slice(autoscalers, func(i, j int) bool {
+		if autoscalers[i].Namespace != autoscalers[j].Namespace {
+			return autoscalers[i].Namespace < autoscalers[j].Namespace
+		}
+		return autoscalers[i].Name < autoscalers[j].Name
+	})
+	return autoscalers, nil
+}
+
+// Parses the node groups of the deployment if it runs cluster-autoscaler
+func clusterAutoscalerOf(deployment *appsv1.Deployment) (ClusterAutoscaler, bool) {
+	for _, container := range deployment.Spec.Template.Spec.Containers {
+		args := append(append([]string{}, container.Command...), container.Args...)
+		if !strings.Contains(container.Image, clusterAutoscalerName) &&
+			!containsArg(args, clusterAutoscalerName) {
+			continue
+		}
+
+		ca := ClusterAutoscaler{
+			Namespace: deployment.Namespace,
+			Name:      deployment.Name,
+			Replicas:  replicasOrDefault(deployment.Spec.Replicas),
+		}
+		for _, value := range flagValues(args, "--nodes") {
+			// <min>:<max>:<asg>
+			if parts := strings.SplitN(value, ":", 3); len(parts) == 3 {
+				ca.NodeGroups = append(ca.NodeGroups, parts[2])
+			}
+		}
+		for _, value := range flagValues(args, "--node-group-auto-discovery") {
+			if !strings.HasPrefix(value, "asg:tag=") {
+				continue
+			}
+			tags := map[string]string{}
+			for _, tag := range strings.Split(strings.TrimPrefix(value, "asg:tag="), ",") {
+				keyValue := strings.SplitN(tag, "=", 2)
+				if len(keyValue) == 2 {
+					tags[keyValue[0]] = keyValue[1]
+				} else if len(keyValue[0]) != 0 {
+					tags[keyValue[0]] = ""
+				}
+			}
+			ca.AutoDiscoveryTags = append(ca.AutoDiscoveryTags, tags)
+		}
+		return ca, true
+	}
+	return ClusterAutoscaler{}, false
+}
+
+func containsArg(args []string, name string) bool {
+	for _, arg := range args {
+		if strings.HasSuffix(arg, name) {
+			return true
+		}
+	}
+	return false
+}
+
+// Returns values of the flag given as --flag=value or --flag value
+func flagValues(args []string, flag string) []string {
+	values := make([]string, 0)
+	for i, arg := range args {
+		if strings.HasPrefix(arg, flag+"=") {
+			values = append(values, strings.TrimPrefix(arg, flag+"="))
+		} else if arg == flag && i+1 < len(args) {
+			values = append(values, args[i+1])
+		}
+	}
+	return values
+}
+
+func (c *kubeClient) ScaleDeployment(
+	namespace, name string,
+	replicas int32,
+) error {
+	deployments := c.clientSet.AppsV1().Deployments(namespace)
+	return retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		scale, err := deployments.GetScale(context.TODO(), name, metav1.GetOptions{})
+		if err != nil {
+			return err
+		}
+		scale.Spec.Replicas = replicas
+		_, err = deployments.UpdateScale(context.TODO(), name, scale, metav1.UpdateOptions{})
+		return err
+	})
+}
+
+func (c *kubeClient) AnnotateNode(
+	nodeName string,
+	annotationKey, annotationVal string,
+	ignoreNotFoundErrors bool,
+) error {
+	return c.updateNodeAnnotations(
+		nodeName,
+		ignoreNotFoundErrors,
+		func(annotations map[string]string) {
+			annotations[annotationKey] = annotationVal
+		},
+	)
+}
+
+func (c *kubeClient) RemoveNodeAnnotation(
+	nodeName, annotationKey string,
+	ignoreNotFoundErrors bool,
+) error {
+	return c.updateNodeAnnotations(
+		nodeName,
+		ignoreNotFoundErrors,
+		func(annotations map[string]string) {
+			delete(annotations, annotationKey)
+		},
+	)
+}
+
+func (c *kubeClient) updateNodeAnnotations(
+	nodeName string,
+	ignoreNotFoundErrors bool,
+	update func(annotations map[string]string),
+) error {
+	var written *corev1.Node
+	err := retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		node, err := c.clientSet.CoreV1().
+			Nodes().
+			Get(context.TODO(), nodeName, metav1.GetOptions{})
+		if apierrors.IsNotFound(err) && ignoreNotFoundErrors {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if node.ObjectMeta.Annotations == nil {
+			node.ObjectMeta.Annotations = map[string]string{}
+		}
+		update(node.ObjectMeta.Annotations)
+		updated, err := c.clientSet.CoreV1().
+			Nodes().
+			Update(context.TODO(), node, metav1.UpdateOptions{})
+		if err == nil {
+			written = updated
+		}
+		return filterError(err, ignoreNotFoundErrors)
+	})
+	if err == nil {
+		c.awaitNode(written)
+	}
+	return err
+}
@@ -0,0 +1,315 @@
+package kube
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	corev1 "k8s.io/api/core/v1"
+	apiequality "k8s.io/apimachinery/pkg/api/equality"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes"
+	corelisters "k8s.io/client-go/listers/core/v1"
+	policylisters "k8s.io/client-go/listers/policy/v1beta1"
+	"k8s.io/client-go/tools/cache"
+)
+
+const (
+	// Index of the pod informer to look up pods by the node they run on
+	podNodeNameIndex = "spec.nodeName"
+
+	// Max time a write of this client waits for the informer cache to
+	// observe it
+	cacheWriteTimeout = 10 * time.Second
+)
+
+// Max time to wait for the informers to list all resources
+var cacheSyncTimeout = 2 * time.Minute
+
+// Shared informers for Nodes, Pods and PDBs. Reads of the kube client are
+// served from the informer caches and waiters are woken up by watch events
+// instead of polling the api server.
+type kubeCache struct {
+	factory    informers.SharedInformerFactory
+	nodeLister corelisters.NodeLister
+	podLister  corelisters.PodLister
+	podIndexer cache.Indexer
+	pdbLister  policylisters.PodDisruptionBudgetLister
+	// Notified on every node change, keyed by node name
+	nodeWatchers *watchers
+	// Notified on every pod change, keyed by the node of the pod
+	podWatchers *watchers
+}
+
+func newKubeCache(clientSet kubernetes.Interface) *kubeCache {
+	factory := informers.NewSharedInformerFactory(clientSet, 0)
+
+	nodeInformer := factory.Core().V1().Nodes()
+	podInformer := factory.Core().V1().Pods()
+	pdbInformer := factory.Policy().V1beta1().PodDisruptionBudgets()
+
+	c := &kubeCache{
+		factory:      factory,
+		nodeLister:   nodeInformer.Lister(),
+		podLister:    podInformer.Lister(),
+		podIndexer:   podInformer.Informer().GetIndexer(),
+		pdbLister:    pdbInformer.Lister(),
+		nodeWatchers: newWatchers(),
+		podWatchers:  newWatchers(),
+	}
+
+	podInformer.Informer().AddIndexers(cache.Indexers{
+		podNodeNameIndex: func(obj interface{}) ([]string, error) {
+			pod, ok := obj.(*corev1.Pod)
+			if !ok || len(pod.Spec.NodeName) == 0 {
+				return []string{}, nil
+			}
+			return []string{pod.Spec.NodeName}, nil
+		},
+	})
+
+	nodeInformer.Informer().AddEventHandler(
+		notifyingHandler(c.nodeWatchers, func(obj interface{}) string {
+			if node, ok := obj.(*corev1.Node); ok {
+				return node.Name
+			}
+			return ""
+		}),
+	)
+	podInformer.Informer().AddEventHandler(
+		notifyingHandler(c.podWatchers, func(obj interface{}) string {
+			if pod, ok := obj.(*corev1.Pod); ok {
+				return pod.Spec.NodeName
+			}
+			return ""
+		}),
+	)
+
+	// Registers the informer with the factory before it is started
+	pdbInformer.Informer()
+
+	return c
+}
+
+// Starts the informers and blocks till their caches are synced
+func (c *kubeCache) start(stopCh <-chan struct{}) error {
+	c.factory.Start(stopCh)
+
+	syncCtx, cancel := context.WithTimeout(context.Background(), cacheSyncTimeout)
+	defer cancel()
+
+	for informerType, synced := range c.factory.WaitForCacheSync(syncCtx.Done()) {
+		if !synced {
+			return fmt.Errorf("Unable to sync cache of %s", informerType.String())
+		}
+	}
+	return nil
+}
+
+// Returns pods scheduled on the node
+func (c *kubeCache) podsOfNode(nodeName string) ([]corev1.Pod, error) {
+	objs, err := c.podIndexer.ByIndex(podNodeNameIndex, nodeName)
+	if err != nil {
+		return nil, err
+	}
+	pods := make([]corev1.Pod, 0, len(objs))
+	for _, obj := range objs {
+		if pod, ok := obj.(*corev1.Pod); ok {
+			pods = append(pods, *pod)
+		}
+	}
+	return pods, nil
+}
+
+// Returns the synced informer cache, informers are started on first use.
+// A start which failed is retried by the next call.
+func (c *kubeClient) informerCache() (*kubeCache, error) {
+	c.cacheLock.Lock()
+	defer c.cacheLock.Unlock()
+	if c.cache != nil {
+		return c.cache, nil
+	}
+
+	kubeCache := newKubeCache(c.clientSet)
+	stopCh := make(chan struct{})
+	if err := kubeCache.start(stopCh); err != nil {
+		close(stopCh)
+		return nil, err
+	}
+	c.cache = kubeCache
+	c.cacheStop = stopCh
+	return kubeCache, nil
+}
+
+// Returns the informer cache if it was started, nil otherwise
+func (c *kubeClient) startedCache() *kubeCache {
+	c.cacheLock.Lock()
+	defer c.cacheLock.Unlock()
+	return c.cache
+}
+
+func (c *kubeClient) Close() {
+	c.cacheLock.Lock()
+	defer c.cacheLock.Unlock()
+	if c.cacheStop != nil {
+		close(c.cacheStop)
+	}
+	c.cache = nil
+	c.cacheStop = nil
+}
+
+// Blocks till the informer cache observed the node written by this client,
+// so that reads following a write see it. The write succeeded anyway, reads
+// just lag behind if the watch event doesn't arrive within
+// cacheWriteTimeout.
+func (c *kubeClient) awaitNode(written *corev1.Node) {
+	kubeCache := c.startedCache()
+	if kubeCache == nil || written == nil {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), cacheWriteTimeout)
+	defer cancel()
+
+	err := waitFor(ctx, kubeCache.nodeWatchers, written.Name, time.Second, func() (bool, error) {
+		cached, err := kubeCache.nodeLister.Get(written.Name)
+		if apierrors.IsNotFound(err) {
+			return false, nil
+		}
+		if err != nil {
+			return false, err
+		}
+		return observedNode(cached, written), nil
+	})
+	if err != nil {
+		log.Debugf("Cache didn't observe write of node %s, %s", written.Name, err.Error())
+	}
+}
+
+// Blocks till the informer cache observed the deletion of the node
+func (c *kubeClient) awaitNodeDeleted(nodeName string) {
+	kubeCache := c.startedCache()
+	if kubeCache == nil {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), cacheWriteTimeout)
+	defer cancel()
+
+	err := waitFor(ctx, kubeCache.nodeWatchers, nodeName, time.Second, func() (bool, error) {
+		_, err := kubeCache.nodeLister.Get(nodeName)
+		if apierrors.IsNotFound(err) {
+			return true, nil
+		}
+		return false, err
+	})
+	if err != nil {
+		log.Debugf("Cache didn't observe deletion of node %s, %s", nodeName, err.Error())
+	}
+}
+
+// Checks if the cached node reflects the written one. Api servers set
+// resource versions on every write, the fake client set doesn't, so its
+// nodes are compared by the fields dockyard writes.
+func observedNode(cached, written *corev1.Node) bool {
+	if len(written.ResourceVersion) != 0 {
+		return cached.ResourceVersion == written.ResourceVersion ||
+			isNewer(cached.ResourceVersion, written.ResourceVersion)
+	}
+	return apiequality.Semantic.DeepEqual(cached.Labels, written.Labels) &&
+		apiequality.Semantic.DeepEqual(cached.Annotations, written.Annotations) &&
+		apiequality.Semantic.DeepEqual(cached.Spec, written.Spec)
+}
+
+// Checks if resource version a is newer than b. Resource versions are
+// opaque, they are only compared if both are numbers.
+func isNewer(a, b string) bool {
+	versionA, errA := strconv.ParseUint(a, 10, 64)
+	versionB, errB := strconv.ParseUint(b, 10, 64)
+	return errA == nil && errB == nil && versionA > versionB
+}
+
+// Blocks till condition is satisfied, fails or ctx is done. The condition
+// is evaluated on every change of the watched key ( all keys if empty ) and
+// at least once every recheck.
+func waitFor(
+	ctx context.Context,
+	w *watchers,
+	key string,
+	recheck time.Duration,
+	condition func() (bool, error),
+) error {
+	events := w.subscribe(key)
+	defer w.unsubscribe(events)
+
+	for {
+		done, err := condition()
+		if err != nil || done {
+			return err
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-events:
+		case <-time.After(recheck):
+		}
+	}
+}
+
+// Subscribers of watch events. Events are coalesced, a subscriber is only
+// told that something has changed and has to re-read the cache.
+type watchers struct {
+	lock sync.Mutex
+	subs map[chan struct{}]string
+}
+
+func newWatchers() *watchers {
+	return &watchers{subs: map[chan struct{}]string{}}
+}
+
+func (w *watchers) subscribe(key string) chan struct{} {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	ch := make(chan struct{}, 1)
+	w.subs[ch] = key
+	return ch
+}
+
+func (w *watchers) unsubscribe(ch chan struct{}) {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	delete(w.subs, ch)
+}
+
+func (w *watchers) notify(key string) {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	for ch, subKey := range w.subs {
+		if len(subKey) != 0 && subKey != key {
+			continue
+		}
+		select {
+		case ch <- struct{}{}:
+		default:
+		}
+	}
+}
+
+func notifyingHandler(
+	w *watchers,
+	keyOf func(obj interface{}) string,
+) cache.ResourceEventHandler {
+	notify := func(obj interface{}) {
+		if tombstone, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+			obj = tombstone.Obj
+		}
+		w.notify(keyOf(obj))
+	}
+	return cache.ResourceEventHandlerFuncs{
+		AddFunc:    notify,
+		UpdateFunc: func(_, newObj interface{}) { notify(newObj) },
+		DeleteFunc: notify,
+	}
+}
@@ -0,0 +1,370 @@
+package kube
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+// Time a waiter has to return within once it is woken up
+const wakeUpTimeout = 5 * time.Second
+
+func testNode(name string, ready bool) *corev1.Node {
+	status := corev1.ConditionFalse
+	if ready {
+		status = corev1.ConditionTrue
+	}
+	return &corev1.Node{
+		ObjectMeta: metav1.ObjectMeta{Name: name},
+		Status: corev1.NodeStatus{
+			Conditions: []corev1.NodeCondition{
+				{Type: corev1.NodeReady, Status: status},
+			},
+		},
+	}
+}
+
+// Runs wait in the background, its result is sent to the returned channel
+func waitResult(wait func() error) <-chan error {
+	done := make(chan error, 1)
+	go func() {
+		done <- wait()
+	}()
+	return done
+}
+
+func TestWaitFor(t *testing.T) {
+	tests := []struct {
+		name string
+		// Key the waiter watches
+		key string
+		// Key notified once the condition is satisfied, none if empty
+		notify  string
+		recheck time.Duration
+		cancel  bool
+		condErr error
+		wantErr bool
+	}{
+		{name: "woken by event", key: "node-1", notify: "node-1", recheck: time.Hour},
+		{name: "woken by any key", key: "", notify: "node-2", recheck: time.Hour},
+		{name: "event of other key", key: "node-1", notify: "node-2", recheck: 50 * time.Millisecond},
+		{name: "recheck", key: "node-1", recheck: 50 * time.Millisecond},
+		{name: "cancelled", key: "node-1", recheck: time.Hour, cancel: true, wantErr: true},
+		{name: "condition fails", key: "node-1", recheck: time.Hour, condErr: errors.New("list failed"), wantErr: true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			w := newWatchers()
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			var lock sync.Mutex
+			satisfied := false
+			checked := make(chan struct{}, 1)
+			done := waitResult(func() error {
+				return waitFor(ctx, w, test.key, test.recheck, func() (bool, error) {
+					lock.Lock()
+					defer lock.Unlock()
+					select {
+					case checked <- struct{}{}:
+					default:
+					}
+					return satisfied, test.condErr
+				})
+			})
+
+			// First check happens before waiting on events
+			<-checked
+			lock.Lock()
+			satisfied = !test.cancel
+			lock.Unlock()
+			if len(test.notify) != 0 {
+				w.notify(test.notify)
+			}
+			if test.cancel {
+				cancel()
+			}
+
+			select {
+			case err := <-done:
+				if (err != nil) != test.wantErr {
+					t.Errorf("waitFor returned %v, want error %t", err, test.wantErr)
+				}
+				if test.cancel && !errors.Is(err, context.Canceled) {
+					t.Errorf("waitFor returned %v, want %v", err, context.Canceled)
+				}
+			case <-time.After(wakeUpTimeout):
+				t.Fatalf("waitFor wasn't woken up")
+			}
+		})
+	}
+}
+
+func TestWatchersCoalesceEvents(t *testing.T) {
+	w := newWatchers()
+	events := w.subscribe("node-1")
+	for i := 0; i < 3; i++ {
+		w.notify("node-1")
+	}
+	<-events
+	select {
+	case <-events:
+		t.Errorf("Notifications weren't coalesced")
+	default:
+	}
+
+	w.unsubscribe(events)
+	w.notify("node-1")
+	select {
+	case <-events:
+		t.Errorf("Unsubscribed channel was notified")
+	default:
+	}
+}
+
+// Returns a client whose node and pod informers watch the returned fake
+// watchers instead of the object tracker
+func newWatchedClient(
+	t *testing.T,
+	objects ...runtime.Object,
+) (*kubeClient, *watch.FakeWatcher, *watch.FakeWatcher) {
+	t.Helper()
+	clientSet := fake.NewSimpleClientset(objects...)
+	nodeWatcher := watch.NewFake()
+	podWatcher := watch.NewFake()
+	clientSet.PrependWatchReactor("nodes", k8stesting.DefaultWatchReactor(nodeWatcher, nil))
+	clientSet.PrependWatchReactor("pods", k8stesting.DefaultWatchReactor(podWatcher, nil))
+
+	client := NewKubeClientWithClientSet(clientSet, "", false, "test-cluster")
+	t.Cleanup(client.Close)
+	if _, err := client.informerCache(); err != nil {
+		t.Fatalf("Unable to start informers, %s", err.Error())
+	}
+	return client, nodeWatcher, podWatcher
+}
+
+func TestWaitForNodeReady(t *testing.T) {
+	client, nodeWatcher, _ := newWatchedClient(t, testNode("node-1", false))
+
+	// Readiness is only re-checked on watch events
+	done := waitResult(func() error {
+		return client.WaitForNodeReady(context.Background(), "node-1", time.Hour, false)
+	})
+	nodeWatcher.Modify(testNode("node-2", true))
+	nodeWatcher.Modify(testNode("node-1", true))
+
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Errorf("WaitForNodeReady failed, %s", err.Error())
+		}
+	case <-time.After(wakeUpTimeout):
+		t.Fatalf("WaitForNodeReady wasn't woken up by the node becoming Ready")
+	}
+}
+
+func TestWaitForNodeReadyCancelled(t *testing.T) {
+	client, _, _ := newWatchedClient(t, testNode("node-1", false))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := waitResult(func() error {
+		return client.WaitForNodeReady(ctx, "node-1", time.Hour, false)
+	})
+	cancel()
+
+	select {
+	case err := <-done:
+		if !errors.Is(err, context.Canceled) {
+			t.Errorf("WaitForNodeReady returned %v, want %v", err, context.Canceled)
+		}
+	case <-time.After(wakeUpTimeout):
+		t.Fatalf("WaitForNodeReady didn't return once ctx was cancelled")
+	}
+}
+
+func TestWaitForPodToBeDeleted(t *testing.T) {
+	pod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: "app", Namespace: "default", UID: "uid-1"},
+		Spec:       corev1.PodSpec{NodeName: "node-1"},
+	}
+	replaced := pod.DeepCopy()
+	replaced.UID = "uid-2"
+
+	tests := []struct {
+		name  string
+		event func(w *watch.FakeWatcher)
+	}{
+		{name: "deleted", event: func(w *watch.FakeWatcher) { w.Delete(pod) }},
+		{name: "replaced", event: func(w *watch.FakeWatcher) { w.Modify(replaced) }},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			client, _, podWatcher := newWatchedClient(t, pod)
+
+			done := waitResult(func() error {
+				return client.WaitForPodToBeDeleted(*pod, time.Hour)
+			})
+			test.event(podWatcher)
+
+			select {
+			case err := <-done:
+				if err != nil {
+					t.Errorf("WaitForPodToBeDeleted failed, %s", err.Error())
+				}
+			case <-time.After(wakeUpTimeout):
+				t.Fatalf("WaitForPodToBeDeleted wasn't woken up by the watch event")
+			}
+		})
+	}
+}
+
+func TestWaitForPodEventOnNodeRecheck(t *testing.T) {
+	client, _, _ := newWatchedClient(t)
+
+	start := time.Now()
+	err := client.WaitForPodEventOnNode(context.Background(), "node-1", 50*time.Millisecond)
+	if err != nil {
+		t.Errorf("WaitForPodEventOnNode failed, %s", err.Error())
+	}
+	if elapsed := time.Since(start); elapsed < 50*time.Millisecond || elapsed > wakeUpTimeout {
+		t.Errorf("WaitForPodEventOnNode returned after %s, want the recheck interval", elapsed)
+	}
+}
+
+func TestWritesAreObservedByReads(t *testing.T) {
+	node := testNode("node-1", true)
+	node.Labels = map[string]string{"kubernetes.io/hostname": "node-1"}
+	client := NewKubeClientWithClientSet(
+		fake.NewSimpleClientset(node),
+		"",
+		false,
+		"test-cluster",
+	)
+	defer client.Close()
+
+	// Informers are started by the first read
+	if _, err := client.GetNodeByLabel("", false); err != nil {
+		t.Fatalf("GetNodeByLabel failed, %s", err.Error())
+	}
+
+	if err := client.AddLabelToNode("node-1", "dockyard.io/node-state", "old", false); err != nil {
+		t.Fatalf("AddLabelToNode failed, %s", err.Error())
+	}
+	nodes, err := client.GetNodeByLabel("dockyard.io/node-state=old", false)
+	if err != nil || len(nodes) != 1 {
+		t.Errorf("Labelled node isn't read back, got %v %v", nodes, err)
+	}
+
+	if err := client.CordonNode("node-1", false); err != nil {
+		t.Fatalf("CordonNode failed, %s", err.Error())
+	}
+	if node, err := client.getNode("node-1"); err != nil || !node.Spec.Unschedulable {
+		t.Errorf("Cordoned node isn't read back as unschedulable")
+	}
+
+	if err := client.DeleteNode("node-1", false); err != nil {
+		t.Fatalf("DeleteNode failed, %s", err.Error())
+	}
+	if _, err := client.getNode("node-1"); !apierrors.IsNotFound(err) {
+		t.Errorf("Deleted node is still read, %v", err)
+	}
+}
+
+func TestObservedNode(t *testing.T) {
+	labelled := func(resourceVersion, state string) *corev1.Node {
+		return &corev1.Node{ObjectMeta: metav1.ObjectMeta{
+			Name:            "node-1",
+			ResourceVersion: resourceVersion,
+			Labels:          map[string]string{"dockyard.io/node-state": state},
+		}}
+	}
+	tests := []struct {
+		name    string
+		cached  *corev1.Node
+		written *corev1.Node
+		want    bool
+	}{
+		{name: "same version", cached: labelled("12", "old"), written: labelled("12", "old"), want: true},
+		{name: "newer version", cached: labelled("15", "new"), written: labelled("12", "old"), want: true},
+		{name: "older version", cached: labelled("11", "old"), written: labelled("12", "old"), want: false},
+		{name: "opaque version", cached: labelled("b", "old"), written: labelled("a", "old"), want: false},
+		{name: "no version, same fields", cached: labelled("", "old"), written: labelled("", "old"), want: true},
+		{name: "no version, other fields", cached: labelled("", "new"), written: labelled("", "old"), want: false},
+	}
+	for _, test := range tests {
+		if got := observedNode(test.cached, test.written); got != test.want {
+			t.Errorf("observedNode() of %s = %t, want %t", test.name, got, test.want)
+		}
+	}
+}
+
+func TestInformerCacheRetriesFailedStart(t *testing.T) {
+	previous := cacheSyncTimeout
+	cacheSyncTimeout = 200 * time.Millisecond
+	t.Cleanup(func() { cacheSyncTimeout = previous })
+
+	var lock sync.Mutex
+	failing := true
+	clientSet := fake.NewSimpleClientset(testNode("node-1", true))
+	clientSet.PrependReactor(
+		"list",
+		"nodes",
+		func(action k8stesting.Action) (bool, runtime.Object, error) {
+			lock.Lock()
+			defer lock.Unlock()
+			if failing {
+				return true, nil, apierrors.NewServiceUnavailable("api server is starting")
+			}
+			return false, nil, nil
+		},
+	)
+	client := NewKubeClientWithClientSet(clientSet, "", false, "test-cluster")
+	defer client.Close()
+
+	if _, err := client.GetNodeByLabel("", false); err == nil {
+		t.Fatalf("GetNodeByLabel succeeded while nodes can't be listed")
+	}
+
+	lock.Lock()
+	failing = false
+	lock.Unlock()
+	cacheSyncTimeout = previous
+
+	nodes, err := client.GetNodeByLabel("", false)
+	if err != nil || len(nodes) != 1 {
+		t.Errorf("Cache wasn't started again, got %v %v", nodes, err)
+	}
+}
+
+func TestCloseStopsInformers(t *testing.T) {
+	client, nodeWatcher, podWatcher := newWatchedClient(t, testNode("node-1", true))
+
+	client.Close()
+	if client.startedCache() != nil {
+		t.Errorf("Closed client still has a started cache")
+	}
+	deadline := time.Now().Add(wakeUpTimeout)
+	for !nodeWatcher.IsStopped() || !podWatcher.IsStopped() {
+		if time.Now().After(deadline) {
+			t.Fatalf("Informers kept watching after Close")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// Closing twice is a no-op, reads start the informers again
+	client.Close()
+	nodes, err := client.GetNodeByLabel("", false)
+	if err != nil || len(nodes) != 1 {
+		t.Errorf("Reads after Close failed, got %v %v", nodes, err)
+	}
+}
@@ -0,0 +1,263 @@
+package kube
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+)
+
+// Image pulled from outside the allowed registries by a workload
+type PublicImage struct {
+	// Image as referenced by the containers
+	Image     string `json:"image"`
+	Kind      string `json:"kind"`
+	Namespace string `json:"namespace"`
+	Name      string `json:"name"`
+	// Containers using the image, init and ephemeral containers are
+	// prefixed with init: and ephemeral:
+	Containers []string `json:"containers"`
+}
+
+// Matches images against registry patterns. Patterns enclosed in slashes
+// are regular expressions, all other patterns are globs where * doesn't
+// cross a / and ** does. Globs match the repository of the image and all
+// repositories below it, e.g. mirror.example.com/dockerhub matches
+// mirror.example.com/dockerhub/library/nginx:1.23.
+type RegistryMatcher struct {
+	patterns []*regexp.Regexp
+}
+
+// Compiles the registry patterns, empty patterns are ignored
+func NewRegistryMatcher(patterns []string) (*RegistryMatcher, error) {
+	matcher := &RegistryMatcher{}
+	for _, pattern := range patterns {
+		pattern = strings.TrimSpace(pattern)
+		if len(pattern) == 0 {
+			continue
+		}
+		expr := globToRegexp(pattern)
+		if len(pattern) > 1 && strings.HasPrefix(pattern, "/") &&
+			strings.HasSuffix(pattern, "/") {
+			expr = pattern[1 : len(pattern)-1]
+		}
+		re, err := regexp.Compile(expr)
+		if err != nil {
+			return nil, fmt.Errorf(
+				"Unable to parse registry pattern %s due to %w",
+				pattern,
+				err,
+			)
+		}
+		matcher.patterns = append(matcher.patterns, re)
+	}
+	return matcher, nil
+}
+
+// Checks if the image is pulled from an allowed registry
+func (m *RegistryMatcher) Allows(image string) bool {
+	repository := normalizeRepository(image)
+	for _, pattern := range m.patterns {
+		if pattern.MatchString(repository) {
+			return true
+		}
+	}
+	return false
+}
+
+func globToRegexp(glob string) string {
+	var expr strings.Builder
+	expr.WriteString("^")
+	for i := 0; i < len(glob); i++ {
+		switch {
+		case strings.HasPrefix(glob[i:], "**"):
+			expr.WriteString(".*")
+			i++
+		case glob[i] == '*':
+			expr.WriteString("[^/]*")
+		case glob[i] == '?':
+			expr.WriteString("[^/]")
+		default:
+			expr.WriteString(regexp.QuoteMeta(string(glob[i])))
+		}
+	}
+	expr.WriteString("(/.*)?$")
+	return expr.String()
+}
+
+// Returns the repository of the image including its registry, e.g.
+// docker.io/library/nginx for nginx:1.23
+func normalizeRepository(image string) string {
+	repository := image
+	if i := strings.Index(repository, "@"); i != -1 {
+		repository = repository[:i]
+	}
+	if i := strings.LastIndex(repository, ":"); i > strings.LastIndex(repository, "/") {
+		repository = repository[:i]
+	}
+
+	parts := strings.SplitN(repository, "/", 2)
+	if len(parts) == 2 &&
+		(strings.ContainsAny(parts[0], ".:") || parts[0] == "localhost") {
+		return repository
+	}
+	if len(parts) == 1 {
+		return "docker.io/library/" + repository
+	}
+	return "docker.io/" + repository
+}
+
+func (c *kubeClient) ListPublicImages(
+	allowedRegistries []string,
+) ([]PublicImage, error) {
+	matcher, err := NewRegistryMatcher(append([]string{c.registry}, allowedRegistries...))
+	if err != nil {
+		return nil, err
+	}
+
+	images := map[string]*PublicImage{}
+	add := func(kind string, meta metav1.ObjectMeta, spec corev1.PodSpec, onlyEphemeral bool) {
+		for _, container := range containerImagesOf(spec, onlyEphemeral) {
+			if matcher.Allows(container[1]) {
+				continue
+			}
+			key := strings.Join([]string{kind, meta.Namespace, meta.Name, container[1]}, "/")
+			image, ok := images[key]
+			if !ok {
+				image = &PublicImage{
+					Image:     container[1],
+					Kind:      kind,
+					Namespace: meta.Namespace,
+					Name:      meta.Name,
+				}
+				images[key] = image
+			}
+			image.Containers = append(image.Containers, container[0])
+		}
+	}
+
+	ctx := context.Background()
+	apps := c.clientSet.AppsV1()
+	deployments, err := apps.Deployments(metav1.NamespaceAll).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, err
+	}
+	for _, deployment := range deployments.Items {
+		add("Deployment", deployment.ObjectMeta, deployment.Spec.Template.Spec, false)
+	}
+	statefulSets, err := apps.StatefulSets(metav1.NamespaceAll).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, err
+	}
+	for _, sts := range statefulSets.Items {
+		add("StatefulSet", sts.ObjectMeta, sts.Spec.Template.Spec, false)
+	}
+	daemonSets, err := apps.DaemonSets(metav1.NamespaceAll).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, err
+	}
+	for _, ds := range daemonSets.Items {
+		add("DaemonSet", ds.ObjectMeta, ds.Spec.Template.Spec, false)
+	}
+
+	jobs, err := c.clientSet.BatchV1().Jobs(metav1.NamespaceAll).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, err
+	}
+	for _, job := range jobs.Items {
+		// Images of jobs created by cronjobs are listed with the cronjob
+		if controller := metav1.GetControllerOf(&job); controller != nil &&
+			controller.Kind == "CronJob" {
+			continue
+		}
+		add("Job", job.ObjectMeta, job.Spec.Template.Spec, false)
+	}
+	if err := c.addCronJobImages(ctx, add); err != nil {
+		return nil, err
+	}
+
+	kubeCache, err := c.informerCache()
+	if err != nil {
+		return nil, err
+	}
+	pods, err := kubeCache.podLister.List(labels.Everything())
+	if err != nil {
+		return nil, err
+	}
+	for _, pod := range pods {
+		// Containers of controlled pods are covered by their workload,
+		// ephemeral containers are only found on the pods
+		add("Pod", pod.ObjectMeta, pod.Spec, metav1.GetControllerOf(pod) != nil)
+	}
+
+	result := make([]PublicImage, 0, len(images))
+	for _, image := range images {
+		sort.Strings(image.Containers)
+		result = append(result, *image)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		a, b := result[i], result[j]
+		if a.Image != b.Image {
+			return a.Image < b.Image
+		}
+		if a.Namespace != b.Namespace {
+			return a.Namespace < b.Namespace
+		}
+		if a.Kind != b.Kind {
+			return a.Kind < b.Kind
+		}
+		return a.Name < b.Name
+	})
+	return result, nil
+}
+
+// Adds images of the cronjobs, clusters older than 1.21 only serve
+// batch/v1beta1 cronjobs
+func (c *kubeClient) addCronJobImages(
+	ctx context.Context,
+	add func(kind string, meta metav1.ObjectMeta, spec corev1.PodSpec, onlyEphemeral bool),
+) error {
+	cronJobs, err := c.clientSet.BatchV1().CronJobs(metav1.NamespaceAll).List(ctx, metav1.ListOptions{})
+	if err == nil {
+		for _, cronJob := range cronJobs.Items {
+			add("CronJob", cronJob.ObjectMeta, cronJob.Spec.JobTemplate.Spec.Template.Spec, false)
+		}
+		return nil
+	}
+	if !apierrors.IsNotFound(err) {
+		return err
+	}
+	legacyCronJobs, err := c.clientSet.BatchV1beta1().CronJobs(metav1.NamespaceAll).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return err
+	}
+	for _, cronJob := range legacyCronJobs.Items {
+		add("CronJob", cronJob.ObjectMeta, cronJob.Spec.JobTemplate.Spec.Template.Spec, false)
+	}
+	return nil
+}
+
+// Returns container names and images of the pod spec. The return array is
+// of type
+// [][]string{{ "container name", "image" }}
+func containerImagesOf(spec corev1.PodSpec, onlyEphemeral bool) [][]string {
+	images := make([][]string, 0)
+	if !onlyEphemeral {
+		for _, container := range spec.Containers {
+			images = append(images, []string{container.Name, container.Image})
+		}
+		for _, container := range spec.InitContainers {
+			images = append(images, []string{"init:" + container.Name, container.Image})
+		}
+	}
+	for _, container := range spec.EphemeralContainers {
+		images = append(images, []string{"ephemeral:" + container.Name, container.Image})
+	}
+	return images
+}
@@ -3,8 +3,7 @@ package kube
 import (
 	"context"
 	"fmt"
-	"log"
-	"path/filepath"
+	"sort"
 	"strconv"
 	"strings"
 	"sync"
@@ -16,23 +15,35 @@ import (
 	policy "k8s.io/api/policy/v1beta1"
 	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
-	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/dynamic"
 	"k8s.io/client-go/kubernetes"
-	"k8s.io/client-go/tools/clientcmd"
-	"k8s.io/client-go/util/homedir"
 )
 
 // TODO Make this configurable
+const (
+	// How often a drain blocked by dockyard.io/do-not-evict pods is
+	// re-checked
+	doNotEvictPollInterval = 30 * time.Second
+
+	// Max time to wait for an evicted pod to be deleted
+	podDeletionTimeout = 5 * time.Minute
+)
 
 type KubeClient interface {
 
 	// Returns current k8s context's ClientSet
-	GetClientSet() *kubernetes.Clientset
+	GetClientSet() kubernetes.Interface
+
+	// Stops the informers serving the reads of the client. A client which
+	// is read again after Close starts them again.
+	Close()
 
 	// Returns k8s version
 	GetServerVersion() (string, error)
 
-	// Returns k8s current context name
+	// Returns name of the k8s context dockyard is connected to
 	GetContext() string
 
 	// Parses the eks cluster name from the current
@@ -59,20 +70,59 @@ type KubeClient interface {
 	// Marks the node as schedulable
 	UnCordonNode(nodeName string, ignoreNotFoundErrors bool) error
 
+	// Adds a taint to the node. An existing taint with the same key is
+	// replaced, so it can be used to change the effect of a taint
+	TaintNode(
+		nodeName string,
+		taintKey, taintVal, taintEffect string,
+		ignoreNotFoundErrors bool,
+	) error
+
+	// Removes all taints with the provided key from the node
+	RemoveTaint(nodeName, taintKey string, ignoreNotFoundErrors bool) error
+
 	// Returns nodes with provided label
 	GetNodeByLabel(
 		label string,
 		ignoreNotFoundErrors bool,
 	) ([]corev1.Node, error)
 
+	// Returns names of all nodes keyed by the ec2 instance id of their
+	// spec.providerID. Nodes which aren't backed by an ec2 instance are
+	// skipped
+	GetNodeNamesByInstanceId() (map[string]string, error)
+
 	// Checks if provided k8s node is healthy
 	IsNodeHealthy(nodeName string, ignoreNotFoundErrors bool) (bool, error)
 
+	// Blocks till the node is Ready. Readiness is re-checked on every
+	// change of the node and at least once every recheck
+	WaitForNodeReady(
+		ctx context.Context,
+		nodeName string,
+		recheck time.Duration,
+		ignoreNotFoundErrors bool,
+	) error
+
+	// Blocks till any node is added, updated or deleted or the timeout
+	// elapses. Returns an error only if ctx is done
+	WaitForNodeEvent(ctx context.Context, timeout time.Duration) error
+
+	// Blocks till any pod on the node is added, updated or deleted or the
+	// timeout elapses. Returns an error only if ctx is done
+	WaitForPodEventOnNode(
+		ctx context.Context,
+		nodeName string,
+		timeout time.Duration,
+	) error
+
 	// Returns node count by this label
 	GetNodeCountByLabel(label string, ignoreNotFoundErrors bool) (int, error)
 
 	// Evicts all pods in separate go routine in the provided
-	// node
+	// node. Pods are evicted in the order of their dockyard.io/drain-priority
+	// annotation and the drain is blocked as long as any pod on the node is
+	// annotated with dockyard.io/do-not-evict=true
 	DrainNode(
 		ctx context.Context,
 		nodeName string,
@@ -82,9 +132,12 @@ type KubeClient interface {
 
 	//DrainNode(nodeName string, ignoreDS, force, deleteLocalData bool) error
 
-	// Checks if some pods are in pending state in all of
-	// cluster.
-	ArePendingPods() ([]string, error)
+	// Returns pods of all namespaces which are in pending state. The
+	// return array is of type
+	// [][]string{
+	//	"pod name", "pod namespace"
+	//}
+	ListPendingPods() ([][]string, error)
 
 	// Checks if the node has the provided label and value
 	NodeHasLabel(
@@ -104,19 +157,131 @@ type KubeClient interface {
 		ignoreNotFoundErrors bool,
 	) (string, error)
 
-	// Returns an array of deployment names which are using public images
-	// The return array is of type
-	// [][]string{
-	//	"deployment name", "deployment namespace"
-	//}
-	ListPublicImages() ([][]string, error)
+	// Returns images of all workload kinds, bare pods and their init and
+	// ephemeral containers which are pulled from neither the private
+	// registry nor any of the allowed registry patterns. Results are
+	// de-duplicated per workload and image.
+	ListPublicImages(allowedRegistries []string) ([]PublicImage, error)
+
+	// Returns names of all nodes of the cluster which aren't Ready
+	ListNotReadyNodes() ([]string, error)
+
+	// Returns kubelet versions of all nodes of the cluster. The return
+	// array is of type
+	// [][]string{{ "node name", "kubelet version" }}
+	ListKubeletVersions() ([][]string, error)
+
+	// Returns Deployments and StatefulSets whose running pods all sit on
+	// the provided nodes and which have a single replica or no PDB
+	ListUnprotectedWorkloads(nodeNames []string) ([]UnprotectedWorkload, error)
+
+	// Returns admission webhooks with failurePolicy Fail which call a
+	// service, along with the ready endpoints of the service in total and
+	// on the provided nodes
+	ListWebhookBackends(nodeNames []string) ([]ServiceBackend, error)
+
+	// Returns APIServices served by a service of the cluster, along with
+	// their availability and the ready endpoints of the service in total
+	// and on the provided nodes
+	ListApiServiceBackends(nodeNames []string) ([]ServiceBackend, error)
+
+	// Returns the snapshot of the asg stored before its rollout, nil if
+	// the asg has no snapshot
+	GetAsgSnapshot(asgName string) ([]byte, error)
+
+	// Stores the snapshot of the asg in a ConfigMap of kube-system,
+	// replacing a previous snapshot
+	SaveAsgSnapshot(asgName string, snapshot []byte) error
+
+	// Deletes the snapshot of the asg, deleting a missing snapshot succeeds
+	DeleteAsgSnapshot(asgName string) error
+
+	// Returns names of all asgs with a snapshot
+	ListAsgSnapshots() ([]string, error)
+
+	// Returns the lease of the dockyard process rolling the asg, nil if the
+	// asg has no lease
+	GetRolloutLease(asgName string) (*RolloutLease, error)
+
+	// Returns the rollout leases of all asgs, including expired ones
+	ListRolloutLeases() ([]RolloutLease, error)
+
+	// Acquires the rollout lease of the asg for the holder of lease, or
+	// renews it if the holder already holds it. Returns a
+	// *RolloutLeaseHeldError if another process holds the lease, an
+	// expired lease is only taken over if takeover is set.
+	AcquireRolloutLease(lease RolloutLease, takeover bool) error
+
+	// Renews the lease of the asg. Returns a *RolloutLeaseHeldError if
+	// another process took the lease over.
+	RenewRolloutLease(asgName, holder string) error
+
+	// Deletes the lease of the asg if it is still held by holder
+	ReleaseRolloutLease(asgName, holder string) error
+
+	// Returns deployments running cluster-autoscaler along with the node
+	// groups they manage
+	ListClusterAutoscalers() ([]ClusterAutoscaler, error)
+
+	// Sets the replicas of the deployment through its scale subresource
+	ScaleDeployment(namespace, name string, replicas int32) error
+
+	// Adds annotation to k8s node resource
+	AnnotateNode(
+		nodeName string,
+		annotationKey, annotationVal string,
+		ignoreNotFoundErrors bool,
+	) error
+
+	// Removes annotation from k8s node resource
+	RemoveNodeAnnotation(
+		nodeName, annotationKey string,
+		ignoreNotFoundErrors bool,
+	) error
 
-	// Returns health status of all nodes in the cluster
-	// The return array is of type
+	// Returns the api versions objects of the provided resources were last
+	// applied or written with, limited to the provided api versions, e.g.
+	// extensions/v1beta1
+	ListApiVersionUsages(
+		groupResources []schema.GroupResource,
+		apiVersions []string,
+	) ([]ApiVersionUsage, error)
+
+	// Returns deprecated apis requested since the api server started as
+	// reported by its apiserver_requested_deprecated_apis metric. The
+	// return array is of type
+	// [][]string{{ "api version", "resource", "removed release" }}
+	ListDeprecatedApiRequests() ([][]string, error)
+
+	// Returns pods on the provided nodes which carry any of the dockyard
+	// drain annotations. The return array is of type
 	// [][]string{
-	//	"Nodes Healthy", "❌"
+	//	"pod name", "pod namespace", "node name", "annotations"
 	//}
-	AreNodeHealthy() ([]string, error)
+	ListDrainAnnotatedPods(nodeNames []string) ([][]string, error)
+
+	// Returns pods owned by Jobs which are still running or pending on
+	// the node
+	ListRunningJobPods(
+		nodeName string,
+		ignoreNotFoundErrors bool,
+	) ([]JobPod, error)
+
+	// Simulates the drain of the node using server side dry-run evictions.
+	// Nothing is evicted or deleted.
+	SimulateDrain(
+		nodeName string,
+		ignoreDS, ignoreNotFoundErrors bool,
+	) ([]DrainImpact, error)
+}
+
+// Pod owned by a Job ( or by a CronJob through a Job ) which is still
+// running on a node
+type JobPod struct {
+	PodName   string
+	Namespace string
+	JobName   string
+	StartTime time.Time
 }
 
 type podSpec struct {
@@ -125,40 +290,95 @@ type podSpec struct {
 }
 
 type kubeClient struct {
-	clientSet      *kubernetes.Clientset
+	clientSet      kubernetes.Interface
+	dynamicClient  dynamic.Interface
 	registry       string
 	ignoreNotFound bool
-	lock           sync.Mutex
 	clusterName    string
+	contextName    string
+	cacheLock      sync.Mutex
+	cache          *kubeCache
+	// Closed to stop the informers of the cache
+	cacheStop chan struct{}
 }
 
-func NewKubeClient(registry string, ignoreNotFound bool, clusterName string) (*kubeClient, error) {
-	cs, err := getClientSet()
+func NewKubeClient(
+	registry string,
+	ignoreNotFound bool,
+	clusterName string,
+	opts KubeConfigOptions,
+) (*kubeClient, error) {
+	cfg, contextName, err := loadKubeConfig(opts, clusterName)
+	if err != nil {
+		return nil, err
+	}
+	cs, err := kubernetes.NewForConfig(cfg)
 	if err != nil {
 		return nil, fmt.Errorf(
 			"failed to create kubernetes client set: %w",
 			err,
 		)
 	}
-	return &kubeClient{clientSet: cs, lock: sync.Mutex{}, registry: registry, ignoreNotFound: ignoreNotFound, clusterName: clusterName}, nil
-}
-
-func getClientSet() (*kubernetes.Clientset, error) {
-	h := filepath.Join(homedir.HomeDir(), ".kube", "config")
-	cfg, err := clientcmd.BuildConfigFromFlags("", h)
+	dynamicClient, err := dynamic.NewForConfig(cfg)
 	if err != nil {
-		return nil, fmt.Errorf("failed to build config from flags: %w", err)
+		return nil, fmt.Errorf(
+			"failed to create kubernetes dynamic client: %w",
+			err,
+		)
 	}
+	client := NewKubeClientWithClients(
+		cs,
+		dynamicClient,
+		registry,
+		ignoreNotFound,
+		clusterName,
+	)
+	client.contextName = contextName
+	return client, nil
+}
 
-	return kubernetes.NewForConfig(cfg)
+// Creates the kube client on top of the provided client set, e.g. the fake
+// client set of k8s.io/client-go/kubernetes/fake
+func NewKubeClientWithClientSet(
+	clientSet kubernetes.Interface,
+	registry string,
+	ignoreNotFound bool,
+	clusterName string,
+) *kubeClient {
+	return NewKubeClientWithClients(
+		clientSet,
+		nil,
+		registry,
+		ignoreNotFound,
+		clusterName,
+	)
+}
+
+// Creates the kube client on top of the provided client set and dynamic
+// client. Without a dynamic client objects of arbitrary resources can't be
+// listed, e.g. to scan for removed api versions.
+func NewKubeClientWithClients(
+	clientSet kubernetes.Interface,
+	dynamicClient dynamic.Interface,
+	registry string,
+	ignoreNotFound bool,
+	clusterName string,
+) *kubeClient {
+	return &kubeClient{
+		clientSet:      clientSet,
+		dynamicClient:  dynamicClient,
+		registry:       registry,
+		ignoreNotFound: ignoreNotFound,
+		clusterName:    clusterName,
+	}
 }
 
-func (c *kubeClient) GetClientSet() *kubernetes.Clientset {
+func (c *kubeClient) GetClientSet() kubernetes.Interface {
 	return c.clientSet
 }
 
 func (c *kubeClient) GetServerVersion() (string, error) {
-	version, err := c.clientSet.ServerVersion()
+	version, err := c.clientSet.Discovery().ServerVersion()
 
 	if err != nil {
 		return "", err
@@ -168,27 +388,17 @@ func (c *kubeClient) GetServerVersion() (string, error) {
 }
 
 func (c *kubeClient) GetContext() string {
-	//return ""
-	config, err := clientcmd.NewNonInteractiveDeferredLoadingClientConfig(
-		&clientcmd.ClientConfigLoadingRules{
-			ExplicitPath: filepath.Join(homedir.HomeDir(), ".kube", "config"),
-		},
-		&clientcmd.ConfigOverrides{
-			CurrentContext: "",
-		},
-	).RawConfig()
-	if err != nil {
-		log.Fatalf(err.Error())
-	}
-	return config.CurrentContext
+	return c.contextName
 }
 
 func (c *kubeClient) GetPDB() ([][]string, error) {
-	pdbs, err := c.clientSet.PolicyV1beta1().
-		PodDisruptionBudgets(metav1.NamespaceAll).
-		List(context.TODO(), metav1.ListOptions{})
+	kubeCache, err := c.informerCache()
+	if err != nil {
+		return nil, err
+	}
+	pdbs, err := kubeCache.pdbLister.List(labels.Everything())
 	pdbList := make([][]string, 0)
-	for _, pdb := range pdbs.Items {
+	for _, pdb := range pdbs {
 		if pdb.Status.DisruptionsAllowed == 0 {
 			pdbList = append(
 				pdbList,
@@ -217,6 +427,7 @@ func (c *kubeClient) AddLabelToNode(
 	ignoreNotFoundErrors bool,
 ) error {
 
+	var written *corev1.Node
 	err := retry.RetryOnConflict(retry.DefaultRetry, func() error {
 
 		node, err := c.clientSet.CoreV1().
@@ -230,12 +441,18 @@ func (c *kubeClient) AddLabelToNode(
 		if node.ObjectMeta.Labels[labelKey] != labelVal {
 			node.ObjectMeta.Labels[labelKey] = labelVal
 		}
-		_, err = c.clientSet.CoreV1().
+		updated, err := c.clientSet.CoreV1().
 			Nodes().
 			Update(context.TODO(), node, metav1.UpdateOptions{})
+		if err == nil {
+			written = updated
+		}
 
 		return filterError(err, ignoreNotFoundErrors)
 	})
+	if err == nil {
+		c.awaitNode(written)
+	}
 
 	return err
 }
@@ -245,6 +462,7 @@ func (c *kubeClient) CordonNode(
 	ignoreNotFoundErrors bool,
 ) error {
 
+	var written *corev1.Node
 	err := retry.RetryOnConflict(retry.DefaultRetry, func() error {
 		node, err := c.clientSet.CoreV1().
 			Nodes().
@@ -254,12 +472,113 @@ func (c *kubeClient) CordonNode(
 			return err
 		}
 		node.Spec.Unschedulable = true
-		_, err = c.clientSet.CoreV1().
+		updated, err := c.clientSet.CoreV1().
 			Nodes().
 			Update(context.TODO(), node, metav1.UpdateOptions{})
+		if err == nil {
+			written = updated
+		}
 
 		return filterError(err, ignoreNotFoundErrors)
 	})
+	if err == nil {
+		c.awaitNode(written)
+	}
+
+	return err
+}
+
+func (c *kubeClient) TaintNode(
+	nodeName string,
+	taintKey, taintVal, taintEffect string,
+	ignoreNotFoundErrors bool,
+) error {
+
+	var written *corev1.Node
+	err := retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		node, err := c.clientSet.CoreV1().
+			Nodes().
+			Get(context.TODO(), nodeName, metav1.GetOptions{})
+
+		if filterError(err, ignoreNotFoundErrors) != nil {
+			return err
+		}
+		if apierrors.IsNotFound(err) {
+			return nil
+		}
+
+		taints := make([]corev1.Taint, 0, len(node.Spec.Taints)+1)
+		for _, taint := range node.Spec.Taints {
+			if taint.Key == taintKey {
+				if taint.Value == taintVal &&
+					string(taint.Effect) == taintEffect {
+					return nil
+				}
+				continue
+			}
+			taints = append(taints, taint)
+		}
+		node.Spec.Taints = append(taints, corev1.Taint{
+			Key:    taintKey,
+			Value:  taintVal,
+			Effect: corev1.TaintEffect(taintEffect),
+		})
+		updated, err := c.clientSet.CoreV1().
+			Nodes().
+			Update(context.TODO(), node, metav1.UpdateOptions{})
+		if err == nil {
+			written = updated
+		}
+
+		return filterError(err, ignoreNotFoundErrors)
+	})
+	if err == nil {
+		c.awaitNode(written)
+	}
+
+	return err
+}
+
+func (c *kubeClient) RemoveTaint(
+	nodeName, taintKey string,
+	ignoreNotFoundErrors bool,
+) error {
+
+	var written *corev1.Node
+	err := retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		node, err := c.clientSet.CoreV1().
+			Nodes().
+			Get(context.TODO(), nodeName, metav1.GetOptions{})
+
+		if filterError(err, ignoreNotFoundErrors) != nil {
+			return err
+		}
+		if apierrors.IsNotFound(err) {
+			return nil
+		}
+
+		taints := make([]corev1.Taint, 0, len(node.Spec.Taints))
+		for _, taint := range node.Spec.Taints {
+			if taint.Key != taintKey {
+				taints = append(taints, taint)
+			}
+		}
+		if len(taints) == len(node.Spec.Taints) {
+			return nil
+		}
+		node.Spec.Taints = taints
+		updated, err := c.clientSet.CoreV1().
+			Nodes().
+			Update(context.TODO(), node, metav1.UpdateOptions{})
+		if err == nil {
+			written = updated
+		}
+
+		return filterError(err, ignoreNotFoundErrors)
+	})
+	if err == nil {
+		c.awaitNode(written)
+	}
 
 	return err
 }
@@ -268,41 +587,135 @@ func (c *kubeClient) GetNodeByLabel(
 	label string,
 	ignoreNotFoundErrors bool,
 ) ([]corev1.Node, error) {
-	c.lock.Lock()
-	defer c.lock.Unlock()
-	list, err := c.clientSet.CoreV1().
-		Nodes().
-		List(context.TODO(), metav1.ListOptions{
-			LabelSelector: label,
-		})
+	selector, err := labels.Parse(label)
+	if err != nil {
+		return nil, err
+	}
+	kubeCache, err := c.informerCache()
+	if err != nil {
+		return nil, err
+	}
+	nodes, err := kubeCache.nodeLister.List(selector)
 	if filterError(err, ignoreNotFoundErrors) != nil {
 		return nil, err
 	}
-	return list.Items, nil
+	result := make([]corev1.Node, 0, len(nodes))
+	for _, node := range nodes {
+		result = append(result, *node)
+	}
+	return result, nil
+}
+
+func (c *kubeClient) GetNodeNamesByInstanceId() (map[string]string, error) {
+	kubeCache, err := c.informerCache()
+	if err != nil {
+		return nil, err
+	}
+	nodes, err := kubeCache.nodeLister.List(labels.Everything())
+	if err != nil {
+		return nil, err
+	}
+	nodeNames := make(map[string]string, len(nodes))
+	for _, node := range nodes {
+		if instanceId, ok := InstanceIdFromProviderID(node.Spec.ProviderID); ok {
+			nodeNames[instanceId] = node.Name
+		}
+	}
+	return nodeNames, nil
+}
+
+// Parses the ec2 instance id from the provider id of a node, which is of
+// the form aws:///<availability zone>/<instance id>
+func InstanceIdFromProviderID(providerID string) (string, bool) {
+	if !strings.HasPrefix(providerID, "aws://") {
+		return "", false
+	}
+	parts := strings.Split(providerID, "/")
+	instanceId := parts[len(parts)-1]
+	if !strings.HasPrefix(instanceId, "i-") {
+		return "", false
+	}
+	return instanceId, true
 }
 
 func (c *kubeClient) IsNodeHealthy(
 	nodeName string,
 	ignoreNotFoundErrors bool,
 ) (bool, error) {
-	node, err := c.clientSet.CoreV1().
-		Nodes().
-		Get(context.TODO(), nodeName, metav1.GetOptions{})
+	node, err := c.getNode(nodeName)
 
 	if filterError(err, ignoreNotFoundErrors) != nil {
 		return false, err
 	}
-	nodeConditions := node.Status.Conditions
+	if node == nil {
+		return false, nil
+	}
+	return isNodeReady(node), nil
+}
 
-	for _, nodeCondition := range nodeConditions {
-		if nodeCondition.Type == "Ready" && nodeCondition.Status == "False" {
-			return false, nil
-		} else if nodeCondition.Type == "Ready" && nodeCondition.Status == "True" {
-			return true, nil
-		}
+func (c *kubeClient) WaitForNodeReady(
+	ctx context.Context,
+	nodeName string,
+	recheck time.Duration,
+	ignoreNotFoundErrors bool,
+) error {
+	kubeCache, err := c.informerCache()
+	if err != nil {
+		return err
+	}
+	return waitFor(ctx, kubeCache.nodeWatchers, nodeName, recheck, func() (bool, error) {
+		return c.IsNodeHealthy(nodeName, ignoreNotFoundErrors)
+	})
+}
+
+func (c *kubeClient) WaitForNodeEvent(
+	ctx context.Context,
+	timeout time.Duration,
+) error {
+	kubeCache, err := c.informerCache()
+	if err != nil {
+		return err
+	}
+	waited := false
+	return waitFor(ctx, kubeCache.nodeWatchers, "", timeout, func() (bool, error) {
+		defer func() { waited = true }()
+		return waited, nil
+	})
+}
+
+func (c *kubeClient) WaitForPodEventOnNode(
+	ctx context.Context,
+	nodeName string,
+	timeout time.Duration,
+) error {
+	kubeCache, err := c.informerCache()
+	if err != nil {
+		return err
+	}
+	waited := false
+	return waitFor(ctx, kubeCache.podWatchers, nodeName, timeout, func() (bool, error) {
+		defer func() { waited = true }()
+		return waited, nil
+	})
+}
+
+// Returns the node from the informer cache. Returned node must not be
+// modified.
+func (c *kubeClient) getNode(nodeName string) (*corev1.Node, error) {
+	kubeCache, err := c.informerCache()
+	if err != nil {
+		return nil, err
 	}
+	return kubeCache.nodeLister.Get(nodeName)
+}
 
-	return false, nil
+func isNodeReady(node *corev1.Node) bool {
+	for _, nodeCondition := range node.Status.Conditions {
+		if nodeCondition.Type == corev1.NodeReady {
+			return nodeCondition.Status == corev1.ConditionTrue
+		}
+	}
+	return false
 }
 
 func (c *kubeClient) GetNodeCountByLabel(
@@ -324,56 +737,126 @@ func (c *kubeClient) DrainNode(
 	ignoreDS, force, deleteLocalData, ignoreNotFoundErrors bool,
 	eventLogs chan string,
 ) []error {
-	pods, err := c.clientSet.CoreV1().
-		Pods("").
-		List(context.Background(), metav1.ListOptions{
-			FieldSelector: "spec.nodeName=" + nodeName,
-		})
+	// Pods which have opted out of eviction block the drain
+	err := c.waitForDoNotEvictPods(ctx, nodeName, ignoreNotFoundErrors, eventLogs)
+	if err != nil {
+		return []error{err}
+	}
 
-	if filterError(err, ignoreNotFoundErrors) != nil {
+	podList, err := c.podsToDrain(nodeName, ignoreDS, ignoreNotFoundErrors)
+
+	if err != nil {
 		return []error{err}
 	}
 
-	podList := make([]podSpec, 0)
-	for _, pod := range pods.Items {
-		controller := pod.GetOwnerReferences()
-		controllerKind := controller[0].Kind
+	errors := make([]error, 0)
+	// Evict pods group by group, starting with the highest drain priority
+	for _, group := range groupByDrainPriority(podList) {
+		errCh := make(chan error, len(group))
+		for _, po := range group {
+			pod, err := c.clientSet.CoreV1().
+				Pods(po.Namespace).
+				Get(context.Background(), po.Name, metav1.GetOptions{})
+
+			if filterError(err, ignoreNotFoundErrors) != nil {
+				return []error{err}
+			}
+			if pod == nil || apierrors.IsNotFound(err) {
+				errCh <- nil
+				continue
+			}
+			go c.EvictPod(
+				podSpec{podName: po.Name, podNs: po.Namespace},
+				errCh,
+				pod,
+				force,
+				ignoreNotFoundErrors,
+				eventLogs,
+			)
+		}
 
-		if ignoreDS && controllerKind == "DaemonSet" {
-			continue
+		// Block till we evict all pods of this group
+		for count := 0; count < len(group); count++ {
+			err := <-errCh
+
+			if filterError(err, ignoreNotFoundErrors) != nil {
+				errors = append(errors, err)
+			}
+		}
+
+		// Lower priority pods must wait for this group to be evicted
+		if len(errors) > 0 {
+			return errors
 		}
-		podList = append(podList, podSpec{
-			podName: pod.Name,
-			podNs:   pod.Namespace,
-		})
 	}
+	return errors
+}
 
-	errCh := make(chan error, 1)
-	for _, po := range podList {
-		pod, err := c.clientSet.CoreV1().
-			Pods(po.podNs).
-			Get(context.Background(), po.podName, metav1.GetOptions{})
+// Returns pods of the node which would be evicted while draining it
+func (c *kubeClient) podsToDrain(
+	nodeName string,
+	ignoreDS, ignoreNotFoundErrors bool,
+) ([]corev1.Pod, error) {
+	pods, err := c.podsOfNode(nodeName)
 
-		if filterError(err, ignoreNotFoundErrors) != nil {
-			return []error{err}
+	if filterError(err, ignoreNotFoundErrors) != nil {
+		return nil, err
+	}
+
+	podList := make([]corev1.Pod, 0)
+	for _, pod := range pods {
+		controller := pod.GetOwnerReferences()
+
+		if ignoreDS && len(controller) > 0 &&
+			controller[0].Kind == "DaemonSet" {
+			continue
 		}
-		go c.EvictPod(po, errCh, pod, force, ignoreNotFoundErrors, eventLogs)
+		podList = append(podList, pod)
+	}
+	return podList, nil
+}
 
+// Blocks till none of the pods on the node is annotated with
+// dockyard.io/do-not-evict=true. An operator has to remove the annotation
+// or delete the pod for the drain to continue.
+func (c *kubeClient) waitForDoNotEvictPods(
+	ctx context.Context,
+	nodeName string,
+	ignoreNotFoundErrors bool,
+	eventLogs chan string,
+) error {
+	kubeCache, err := c.informerCache()
+	if err != nil {
+		return err
 	}
 
-	count := 0
-	totalPods := len(podList)
-	errors := make([]error, 0)
-	for count < totalPods {
-		// Block till we evict all pods one by one
-		err := <-errCh
-		count++
+	reported := map[string]bool{}
+	return waitFor(ctx, kubeCache.podWatchers, nodeName, doNotEvictPollInterval, func() (bool, error) {
+		pods, err := c.podsOfNode(nodeName)
 
 		if filterError(err, ignoreNotFoundErrors) != nil {
-			errors = append(errors, err)
+			return false, err
 		}
-	}
-	return errors
+
+		blocked := false
+		for _, pod := range pods {
+			if !isDoNotEvict(&pod) {
+				continue
+			}
+			blocked = true
+			key := pod.Namespace + "/" + pod.Name
+			if !reported[key] {
+				reported[key] = true
+				eventLogs <- fmt.Sprintf(
+					"Drain of node %s blocked by pod %s ( %s=true ), remove the annotation or delete the pod to continue",
+					nodeName,
+					key,
+					DoNotEvictAnnotationKey,
+				)
+			}
+		}
+		return !blocked, nil
+	})
 }
 
 // TODO remove redundant vars
@@ -385,13 +868,14 @@ func (c *kubeClient) EvictPod(
 	eventLogs chan string,
 ) {
 
+	gracePeriod := evictionGracePeriod(po)
 	evictPolicy := &policy.Eviction{
 		ObjectMeta: metav1.ObjectMeta{
 			Name:      pod.podName,
 			Namespace: pod.podNs,
 		},
 		DeleteOptions: &metav1.DeleteOptions{
-			GracePeriodSeconds: nil,
+			GracePeriodSeconds: gracePeriod,
 		},
 	}
 	eventLogs <- fmt.Sprintf("Evicting :: pod %s, ns %s ", pod.podName, pod.podNs)
@@ -404,14 +888,13 @@ func (c *kubeClient) EvictPod(
 		eventLogs <- fmt.Sprintf("Unable to gracefully evict pod %s due to %s", pod.podName, err.Error())
 		if force {
 			eventLogs <- fmt.Sprintf("Force Delete po %s", pod.podName)
-			errCh <- c.DeletePod(pod.podName, pod.podNs)
+			errCh <- c.deletePod(pod.podName, pod.podNs, gracePeriod)
 		} else {
 			errCh <- err
 		}
 	} else {
-		errCh <- nil
 		//eventLogs <- fmt.Sprintf("Waiting for pod deletion :: pod %s, ns %s ", pod.podNs, pod.podNs)
-		err = c.WaitForPodToBeDeleted(*po, 30, 5)
+		err = c.WaitForPodToBeDeleted(*po, podDeletionTimeout)
 
 		if filterError(err, ignoreNotFoundErrors) != nil {
 			errCh <- err
@@ -421,46 +904,50 @@ func (c *kubeClient) EvictPod(
 	}
 }
 
+// Blocks till the pod is deleted or replaced by a pod with the same name
 func (c *kubeClient) WaitForPodToBeDeleted(
 	existingPod corev1.Pod,
-	interval, timeout int,
+	timeout time.Duration,
 ) error {
-	intervalDuration := time.Duration(interval) * time.Second
-	timeoutDuration := time.Duration(timeout) * time.Minute
-	podName := existingPod.Name
-	podUid := existingPod.ObjectMeta.UID
-	err := wait.PollImmediate(
-		intervalDuration,
-		timeoutDuration,
-		func() (bool, error) {
-			p, err := c.clientSet.CoreV1().
-				Pods(metav1.NamespaceAll).
-				Get(context.Background(), podName, metav1.GetOptions{})
-			if apierrors.IsNotFound(err) ||
-				(p != nil && p.ObjectMeta.UID != podUid) {
-				return true, nil
-			} else if err != nil {
-				return false, err
-			}
-			return false, err
-		},
-	)
-	return err
+	kubeCache, err := c.informerCache()
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	return waitFor(ctx, kubeCache.podWatchers, existingPod.Spec.NodeName, timeout, func() (bool, error) {
+		p, err := kubeCache.podLister.
+			Pods(existingPod.Namespace).
+			Get(existingPod.Name)
+		if apierrors.IsNotFound(err) ||
+			(p != nil && p.ObjectMeta.UID != existingPod.ObjectMeta.UID) {
+			return true, nil
+		}
+		return false, err
+	})
 }
 
-func (c *kubeClient) ArePendingPods() ([]string, error) {
-	list, err := c.clientSet.CoreV1().
-		Pods(metav1.NamespaceAll).
-		List(context.TODO(), metav1.ListOptions{FieldSelector: "status.phase=Pending"})
+func (c *kubeClient) ListPendingPods() ([][]string, error) {
+	kubeCache, err := c.informerCache()
 	if err != nil {
-		return []string{"No Pending Pods", "❌"}, err
+		return nil, err
 	}
-	if len(list.Items) != 0 {
-		return []string{"No Pending Pods", "❌"}, err
-	} else {
-		return []string{"No Pending Pods", "✅"}, err
+	pods, err := kubeCache.podLister.List(labels.Everything())
+	if err != nil {
+		return nil, err
 	}
-
+	pendingPods := make([][]string, 0)
+	for _, pod := range pods {
+		if pod.Status.Phase == corev1.PodPending {
+			pendingPods = append(
+				pendingPods,
+				[]string{pod.Name, pod.Namespace},
+			)
+		}
+	}
+	return pendingPods, nil
 }
 
 func (c *kubeClient) NodeHasLabel(
@@ -468,17 +955,16 @@ func (c *kubeClient) NodeHasLabel(
 	ignoreNotFoundErrors bool,
 ) (bool, error) {
 
-	c.lock.Lock()
-	defer c.lock.Unlock()
-	node, err := c.clientSet.CoreV1().
-		Nodes().
-		Get(context.TODO(), nodeName, metav1.GetOptions{})
+	node, err := c.getNode(nodeName)
 
 	// For situations if ec2 instance is deleted
 
 	if filterError(err, ignoreNotFoundErrors) != nil {
 		return false, err
 	}
+	if node == nil {
+		return false, err
+	}
 	if value, ok := node.ObjectMeta.Labels[labelKey]; ok && value == labelVal {
 		return true, err
 	} else {
@@ -490,6 +976,7 @@ func (c *kubeClient) RemoveLabel(
 	nodeName, labelKey string,
 	ignoreNotFoundErrors bool,
 ) error {
+	var written *corev1.Node
 	err := retry.RetryOnConflict(retry.DefaultRetry, func() error {
 		node, err := c.clientSet.CoreV1().
 			Nodes().
@@ -499,12 +986,18 @@ func (c *kubeClient) RemoveLabel(
 			return err
 		}
 		delete(node.ObjectMeta.Labels, labelKey)
-		_, err = c.clientSet.CoreV1().
+		updated, err := c.clientSet.CoreV1().
 			Nodes().
 			Update(context.Background(), node, metav1.UpdateOptions{})
+		if err == nil {
+			written = updated
+		}
 
 		return filterError(err, ignoreNotFoundErrors)
 	})
+	if err == nil {
+		c.awaitNode(written)
+	}
 
 	return err
 }
@@ -516,6 +1009,9 @@ func (c *kubeClient) DeleteNode(
 	err := c.clientSet.CoreV1().
 		Nodes().
 		Delete(context.Background(), nodeName, metav1.DeleteOptions{})
+	if err == nil || apierrors.IsNotFound(err) {
+		c.awaitNodeDeleted(nodeName)
+	}
 	return filterError(err, ignoreNotFoundErrors)
 }
 
@@ -523,13 +1019,14 @@ func (c *kubeClient) GetLabelValOfNode(
 	nodeName, labelKey string,
 	ignoreNotFoundErrors bool,
 ) (string, error) {
-	node, err := c.clientSet.CoreV1().
-		Nodes().
-		Get(context.TODO(), nodeName, metav1.GetOptions{})
+	node, err := c.getNode(nodeName)
 
 	if filterError(err, ignoreNotFoundErrors) != nil {
 		return "", err
 	}
+	if node == nil {
+		return "", err
+	}
 	return node.ObjectMeta.Labels[labelKey], nil
 }
 
@@ -537,6 +1034,7 @@ func (c *kubeClient) UnCordonNode(
 	nodeName string,
 	ignoreNotFoundErrors bool,
 ) error {
+	var written *corev1.Node
 	err := retry.RetryOnConflict(retry.DefaultRetry, func() error {
 		node, err := c.clientSet.CoreV1().
 			Nodes().
@@ -546,70 +1044,163 @@ func (c *kubeClient) UnCordonNode(
 			return err
 		}
 		node.Spec.Unschedulable = false
-		_, err = c.clientSet.CoreV1().
+		updated, err := c.clientSet.CoreV1().
 			Nodes().
 			Update(context.TODO(), node, metav1.UpdateOptions{})
+		if err == nil {
+			written = updated
+		}
 
 		return filterError(err, ignoreNotFoundErrors)
 	})
+	if err == nil {
+		c.awaitNode(written)
+	}
 	return err
 }
 
-func (c *kubeClient) ListPublicImages() ([][]string, error) {
-
-	containerImages := make([][]string, 0)
-	deployments, err := c.clientSet.AppsV1().
-		Deployments(metav1.NamespaceAll).
-		List(context.Background(), metav1.ListOptions{})
+func (c *kubeClient) ListNotReadyNodes() ([]string, error) {
 
+	kubeCache, err := c.informerCache()
 	if err != nil {
-		return [][]string{}, err
+		return nil, err
+	}
+	nodes, err := kubeCache.nodeLister.List(labels.Everything())
+	if err != nil {
+		return nil, err
 	}
 
-	count := 0
-	for _, deployment := range deployments.Items {
-		for _, container := range deployment.Spec.Template.Spec.Containers {
-			if isPublic(container.Image, c.registry) {
-				count += 1
-				containerImages = append(
-					containerImages,
-					[]string{deployment.Name, deployment.Namespace},
-				)
-			}
+	notReady := make([]string, 0)
+	for _, node := range nodes {
+		if !isNodeReady(node) {
+			notReady = append(notReady, node.Name)
 		}
 	}
+	return notReady, nil
+}
 
-	containerImages = append(
-		containerImages,
-		[]string{"Total", strconv.Itoa(count)},
-	)
+func (c *kubeClient) ListKubeletVersions() ([][]string, error) {
+
+	kubeCache, err := c.informerCache()
+	if err != nil {
+		return nil, err
+	}
+	nodes, err := kubeCache.nodeLister.List(labels.Everything())
+	if err != nil {
+		return nil, err
+	}
 
-	return containerImages, nil
+	versions := make([][]string, 0, len(nodes))
+	for _, node := range nodes {
+		versions = append(
+			versions,
+			[]string{node.Name, node.Status.NodeInfo.KubeletVersion},
+		)
+	}
+	sort.Slice(versions, func(i, j int) bool {
+		return versions[i][0] < versions[j][0]
+	})
+	return versions, nil
 }
 
-func (c *kubeClient) AreNodeHealthy() ([]string, error) {
+func (c *kubeClient) DeletePod(podName string, ns string) error {
+
+	return c.deletePod(podName, ns, nil)
+}
 
-	nodes, _ := c.clientSet.CoreV1().
-		Nodes().
-		List(context.Background(), metav1.ListOptions{
-			LabelSelector: "",
-			FieldSelector: "",
+func (c *kubeClient) deletePod(
+	podName, ns string,
+	gracePeriod *int64,
+) error {
+
+	return c.clientSet.CoreV1().
+		Pods(ns).
+		Delete(context.TODO(), podName, metav1.DeleteOptions{
+			GracePeriodSeconds: gracePeriod,
 		})
+}
 
-	for _, node := range nodes.Items {
-		healthy, _ := c.IsNodeHealthy(node.Name, c.ignoreNotFound)
-		if !healthy {
-			return []string{"Nodes Healthy", "❌"}, nil
+func (c *kubeClient) ListDrainAnnotatedPods(
+	nodeNames []string,
+) ([][]string, error) {
+	annotatedPods := make([][]string, 0)
+	for _, nodeName := range nodeNames {
+		pods, err := c.podsOfNode(nodeName)
+
+		if err != nil {
+			return [][]string{}, err
+		}
+
+		for _, pod := range pods {
+			annotations := make([]string, 0)
+			for _, key := range drainAnnotationKeys {
+				if val, ok := pod.ObjectMeta.Annotations[key]; ok {
+					annotations = append(
+						annotations,
+						fmt.Sprintf("%s=%s", key, val),
+					)
+				}
+			}
+			if len(annotations) == 0 {
+				continue
+			}
+			annotatedPods = append(
+				annotatedPods,
+				[]string{
+					pod.Name,
+					pod.Namespace,
+					nodeName,
+					strings.Join(annotations, ","),
+				},
+			)
 		}
 	}
-	return []string{"Nodes Healthy", "✅"}, nil
+	return annotatedPods, nil
 }
 
-func (c *kubeClient) DeletePod(podName string, ns string) error {
+func (c *kubeClient) ListRunningJobPods(
+	nodeName string,
+	ignoreNotFoundErrors bool,
+) ([]JobPod, error) {
+	pods, err := c.podsOfNode(nodeName)
 
-	return c.clientSet.CoreV1().
-		Pods(ns).
-		Delete(context.TODO(), podName, metav1.DeleteOptions{})
+	if filterError(err, ignoreNotFoundErrors) != nil {
+		return nil, err
+	}
+
+	jobPods := make([]JobPod, 0)
+	for _, pod := range pods {
+		if pod.Status.Phase != corev1.PodRunning &&
+			pod.Status.Phase != corev1.PodPending {
+			continue
+		}
+		for _, owner := range pod.GetOwnerReferences() {
+			if owner.Kind != "Job" {
+				continue
+			}
+			startTime := pod.CreationTimestamp.Time
+			if pod.Status.StartTime != nil {
+				startTime = pod.Status.StartTime.Time
+			}
+			jobPods = append(jobPods, JobPod{
+				PodName:   pod.Name,
+				Namespace: pod.Namespace,
+				JobName:   owner.Name,
+				StartTime: startTime,
+			})
+			break
+		}
+	}
+	return jobPods, nil
+}
+
+// Returns pods scheduled on the node from the informer cache
+func (c *kubeClient) podsOfNode(nodeName string) ([]corev1.Pod, error) {
+	kubeCache, err := c.informerCache()
+	if err != nil {
+		return nil, err
+	}
+	return kubeCache.podsOfNode(nodeName)
 }
 
 func filterError(err error, ignoreNotFoundErrors bool) error {
@@ -623,7 +1214,3 @@ func filterError(err error, ignoreNotFoundErrors bool) error {
 	}
 	return err
 }
-
-func isPublic(repo, privateRepo string) bool {
-	return !strings.Contains(repo, privateRepo)
-}
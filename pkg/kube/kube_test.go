@@ -0,0 +1,177 @@
+package kube
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+const testTaintKey = "dockyard.io/rolling"
+
+func taintedNode(taints ...corev1.Taint) *corev1.Node {
+	return &corev1.Node{
+		ObjectMeta: metav1.ObjectMeta{Name: "node-1"},
+		Spec:       corev1.NodeSpec{Taints: taints},
+	}
+}
+
+func countNodeUpdates(clientSet *fake.Clientset) int {
+	updates := 0
+	for _, action := range clientSet.Actions() {
+		if action.Matches("update", "nodes") {
+			updates++
+		}
+	}
+	return updates
+}
+
+func TestTaintNode(t *testing.T) {
+	other := corev1.Taint{Key: "dedicated", Value: "infra", Effect: corev1.TaintEffectNoSchedule}
+	rolling := func(effect corev1.TaintEffect) corev1.Taint {
+		return corev1.Taint{Key: testTaintKey, Value: "true", Effect: effect}
+	}
+	tests := []struct {
+		name        string
+		node        *corev1.Node
+		effect      corev1.TaintEffect
+		want        []corev1.Taint
+		wantUpdates int
+	}{
+		{
+			name:        "untainted",
+			node:        taintedNode(other),
+			effect:      corev1.TaintEffectNoSchedule,
+			want:        []corev1.Taint{other, rolling(corev1.TaintEffectNoSchedule)},
+			wantUpdates: 1,
+		},
+		{
+			name:        "already tainted",
+			node:        taintedNode(rolling(corev1.TaintEffectNoSchedule), other),
+			effect:      corev1.TaintEffectNoSchedule,
+			want:        []corev1.Taint{rolling(corev1.TaintEffectNoSchedule), other},
+			wantUpdates: 0,
+		},
+		{
+			name:        "effect changed",
+			node:        taintedNode(rolling(corev1.TaintEffectPreferNoSchedule), other),
+			effect:      corev1.TaintEffectNoSchedule,
+			want:        []corev1.Taint{other, rolling(corev1.TaintEffectNoSchedule)},
+			wantUpdates: 1,
+		},
+		{
+			name:        "missing node",
+			effect:      corev1.TaintEffectNoSchedule,
+			wantUpdates: 0,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			clientSet := fake.NewSimpleClientset()
+			if test.node != nil {
+				clientSet = fake.NewSimpleClientset(test.node)
+			}
+			client := NewKubeClientWithClientSet(clientSet, "", true, "test-cluster")
+			defer client.Close()
+
+			// Tainting twice has to leave the same taints behind
+			for i := 0; i < 2; i++ {
+				err := client.TaintNode("node-1", testTaintKey, "true", string(test.effect), true)
+				if err != nil {
+					t.Fatalf("TaintNode failed, %s", err.Error())
+				}
+			}
+			if updates := countNodeUpdates(clientSet); updates != test.wantUpdates {
+				t.Errorf("TaintNode updated the node %d times, want %d", updates, test.wantUpdates)
+			}
+			if test.node == nil {
+				return
+			}
+			node, err := clientSet.CoreV1().Nodes().Get(context.TODO(), "node-1", metav1.GetOptions{})
+			if err != nil {
+				t.Fatalf("Unable to get node, %s", err.Error())
+			}
+			if !reflect.DeepEqual(node.Spec.Taints, test.want) {
+				t.Errorf("Node has taints %v, want %v", node.Spec.Taints, test.want)
+			}
+		})
+	}
+}
+
+func TestRemoveTaint(t *testing.T) {
+	other := corev1.Taint{Key: "dedicated", Value: "infra", Effect: corev1.TaintEffectNoSchedule}
+	rolling := corev1.Taint{Key: testTaintKey, Value: "true", Effect: corev1.TaintEffectNoSchedule}
+	tests := []struct {
+		name        string
+		node        *corev1.Node
+		want        []corev1.Taint
+		wantUpdates int
+	}{
+		{
+			name:        "tainted",
+			node:        taintedNode(rolling, other),
+			want:        []corev1.Taint{other},
+			wantUpdates: 1,
+		},
+		{
+			name:        "tainted with every effect",
+			node:        taintedNode(rolling, other, corev1.Taint{Key: testTaintKey, Effect: corev1.TaintEffectNoExecute}),
+			want:        []corev1.Taint{other},
+			wantUpdates: 1,
+		},
+		{
+			name:        "untainted",
+			node:        taintedNode(other),
+			want:        []corev1.Taint{other},
+			wantUpdates: 0,
+		},
+		{
+			name:        "missing node",
+			wantUpdates: 0,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			clientSet := fake.NewSimpleClientset()
+			if test.node != nil {
+				clientSet = fake.NewSimpleClientset(test.node)
+			}
+			client := NewKubeClientWithClientSet(clientSet, "", true, "test-cluster")
+			defer client.Close()
+
+			for i := 0; i < 2; i++ {
+				err := client.RemoveTaint("node-1", testTaintKey, true)
+				if err != nil {
+					t.Fatalf("RemoveTaint failed, %s", err.Error())
+				}
+			}
+			if updates := countNodeUpdates(clientSet); updates != test.wantUpdates {
+				t.Errorf("RemoveTaint updated the node %d times, want %d", updates, test.wantUpdates)
+			}
+			if test.node == nil {
+				return
+			}
+			node, err := clientSet.CoreV1().Nodes().Get(context.TODO(), "node-1", metav1.GetOptions{})
+			if err != nil {
+				t.Fatalf("Unable to get node, %s", err.Error())
+			}
+			if !reflect.DeepEqual(node.Spec.Taints, test.want) {
+				t.Errorf("Node has taints %v, want %v", node.Spec.Taints, test.want)
+			}
+		})
+	}
+}
+
+func TestTaintNodeNotFound(t *testing.T) {
+	client := NewKubeClientWithClientSet(fake.NewSimpleClientset(), "", false, "test-cluster")
+	defer client.Close()
+	if err := client.TaintNode("node-1", testTaintKey, "true", "NoSchedule", false); err == nil {
+		t.Errorf("TaintNode of a missing node succeeded without ignoring not found errors")
+	}
+	if err := client.RemoveTaint("node-1", testTaintKey, false); err == nil {
+		t.Errorf("RemoveTaint of a missing node succeeded without ignoring not found errors")
+	}
+}
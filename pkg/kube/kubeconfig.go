@@ -0,0 +1,234 @@
+package kube
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/clientcmd"
+	clientcmdapi "k8s.io/client-go/tools/clientcmd/api"
+)
+
+// Context name reported when dockyard runs as a pod using the in-cluster
+// config
+const InClusterContext = "in-cluster"
+
+// Selects the kubeconfig and context used by dockyard. Empty values
+// fall back to the standard client-go loading rules i.e. KUBECONFIG
+// (which may list multiple files), ${HOME}/.kube/config and the in-cluster
+// config when running as a pod.
+type KubeConfigOptions struct {
+	// Path to kubeconfig file, takes precedence over KUBECONFIG
+	Kubeconfig string
+	// Context to use instead of the current context
+	Context string
+	// Verifies that the PEM encoded CA of the in-cluster config belongs to
+	// the eks cluster. The in-cluster config can't be mapped to an eks
+	// cluster by name, without a verifier it is refused whenever an eks
+	// cluster name is set.
+	VerifyInCluster func(clusterName string, caData []byte) error
+}
+
+func (o KubeConfigOptions) clientConfig() clientcmd.ClientConfig {
+	loadingRules := clientcmd.NewDefaultClientConfigLoadingRules()
+	loadingRules.ExplicitPath = o.Kubeconfig
+
+	return clientcmd.NewNonInteractiveDeferredLoadingClientConfig(
+		loadingRules,
+		&clientcmd.ConfigOverrides{
+			CurrentContext: o.Context,
+		},
+	)
+}
+
+// Resolves the rest config and the name of the selected context. The
+// context is validated against the eks cluster name so that dockyard can't
+// roll asgs of one cluster while being connected to another one.
+func loadKubeConfig(
+	opts KubeConfigOptions,
+	clusterName string,
+) (*rest.Config, string, error) {
+	clientConfig := opts.clientConfig()
+
+	cfg, err := clientConfig.ClientConfig()
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to load kubeconfig: %w", err)
+	}
+
+	rawConfig, err := clientConfig.RawConfig()
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to load kubeconfig: %w", err)
+	}
+
+	contextName := opts.Context
+	if len(contextName) == 0 {
+		contextName = rawConfig.CurrentContext
+	}
+
+	// No kubeconfig context available, client-go fell back to the
+	// in-cluster config
+	if _, ok := rawConfig.Contexts[contextName]; !ok {
+		if len(opts.Context) != 0 {
+			return nil, "", fmt.Errorf(
+				"context %s not found in kubeconfig",
+				opts.Context,
+			)
+		}
+		err = verifyInCluster(cfg, clusterName, opts.VerifyInCluster)
+		if err != nil {
+			return nil, "", err
+		}
+		return cfg, InClusterContext, nil
+	}
+
+	if len(clusterName) != 0 &&
+		!contextMatchesCluster(rawConfig, contextName, clusterName) {
+		return nil, "", fmt.Errorf(
+			"kubeconfig context %s doesn't point to EKS cluster %s, select the right context using --context",
+			contextName,
+			clusterName,
+		)
+	}
+
+	return cfg, contextName, nil
+}
+
+// Checks if the in-cluster config belongs to the eks cluster by comparing
+// the CA of the service account with the CA of the eks cluster
+func verifyInCluster(
+	cfg *rest.Config,
+	clusterName string,
+	verify func(clusterName string, caData []byte) error,
+) error {
+	if len(clusterName) == 0 {
+		return nil
+	}
+	if verify == nil {
+		return fmt.Errorf(
+			"in-cluster config can't be verified to belong to EKS cluster %s",
+			clusterName,
+		)
+	}
+	withCA := rest.CopyConfig(cfg)
+	if err := rest.LoadTLSFiles(withCA); err != nil {
+		return fmt.Errorf("failed to load CA of in-cluster config: %w", err)
+	}
+	if len(withCA.CAData) == 0 {
+		return fmt.Errorf(
+			"in-cluster config has no CA to verify it belongs to EKS cluster %s",
+			clusterName,
+		)
+	}
+	if err := verify(clusterName, withCA.CAData); err != nil {
+		return fmt.Errorf(
+			"in-cluster config doesn't belong to EKS cluster %s: %w",
+			clusterName,
+			err,
+		)
+	}
+	return nil
+}
+
+// Checks if the kubeconfig context belongs to the eks cluster
+func contextMatchesCluster(
+	rawConfig clientcmdapi.Config,
+	contextName, clusterName string,
+) bool {
+	if contextName == clusterName ||
+		rawConfig.Contexts[contextName].Cluster == clusterName {
+		return true
+	}
+	return describeContext(rawConfig, contextName).EksClusterName == clusterName
+}
+
+// Kubeconfig context along with the eks cluster details which could be
+// derived from it
+type ContextInfo struct {
+	Name string
+	// Name of the eks cluster, empty if it couldn't be derived
+	EksClusterName string
+	// Region of the eks cluster, empty if it couldn't be derived
+	Region string
+	// AWS profile used by the token generator of the context
+	Profile string
+	// Role assumed by the token generator of the context
+	RoleArn string
+}
+
+// Returns all contexts of the kubeconfig selected by opts. The context
+// override of opts is ignored.
+func ListContexts(opts KubeConfigOptions) ([]ContextInfo, error) {
+	rawConfig, err := KubeConfigOptions{Kubeconfig: opts.Kubeconfig}.
+		clientConfig().
+		RawConfig()
+	if err != nil {
+		return nil, fmt.Errorf("failed to load kubeconfig: %w", err)
+	}
+
+	names := make([]string, 0, len(rawConfig.Contexts))
+	for name := range rawConfig.Contexts {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	contexts := make([]ContextInfo, 0, len(names))
+	for _, name := range names {
+		contexts = append(contexts, describeContext(rawConfig, name))
+	}
+	return contexts, nil
+}
+
+// Derives eks cluster details from the context. Contexts written by
+// `aws eks update-kubeconfig` reference the cluster arn
+// arn:aws:eks:<region>:<account>:cluster/<name>, eksctl names the cluster
+// <name>.<region>.eksctl.io and both pass cluster name and region to the
+// token generator.
+func describeContext(rawConfig clientcmdapi.Config, name string) ContextInfo {
+	info := ContextInfo{Name: name}
+	kubeContext := rawConfig.Contexts[name]
+
+	for _, clusterRef := range []string{kubeContext.Cluster, name} {
+		if arn := strings.Split(clusterRef, ":"); len(arn) == 6 &&
+			arn[0] == "arn" && arn[2] == "eks" &&
+			strings.HasPrefix(arn[5], "cluster/") {
+			info.Region = arn[3]
+			info.EksClusterName = strings.TrimPrefix(arn[5], "cluster/")
+			break
+		}
+		if parts := strings.Split(clusterRef, "."); len(parts) == 4 &&
+			strings.HasSuffix(clusterRef, ".eksctl.io") {
+			info.EksClusterName = parts[0]
+			info.Region = parts[1]
+			break
+		}
+	}
+
+	authInfo, ok := rawConfig.AuthInfos[kubeContext.AuthInfo]
+	if !ok || authInfo.Exec == nil {
+		return info
+	}
+	args := authInfo.Exec.Args
+	for i := 0; i < len(args)-1; i++ {
+		switch args[i] {
+		case "--cluster-name", "-i":
+			if len(info.EksClusterName) == 0 {
+				info.EksClusterName = args[i+1]
+			}
+		case "--region":
+			if len(info.Region) == 0 {
+				info.Region = args[i+1]
+			}
+		case "--profile":
+			info.Profile = args[i+1]
+		case "--role-arn", "-r":
+			info.RoleArn = args[i+1]
+		}
+	}
+	for _, env := range authInfo.Exec.Env {
+		if env.Name == "AWS_PROFILE" && len(info.Profile) == 0 {
+			info.Profile = env.Value
+		}
+	}
+	return info
+}
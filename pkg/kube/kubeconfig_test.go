@@ -0,0 +1,206 @@
+package kube
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"k8s.io/client-go/rest"
+	clientcmdapi "k8s.io/client-go/tools/clientcmd/api"
+)
+
+func testKubeConfig() clientcmdapi.Config {
+	return clientcmdapi.Config{
+		Contexts: map[string]*clientcmdapi.Context{
+			// aws eks update-kubeconfig
+			"arn:aws:eks:eu-west-1:123456789012:cluster/prod": {
+				Cluster:  "arn:aws:eks:eu-west-1:123456789012:cluster/prod",
+				AuthInfo: "arn:aws:eks:eu-west-1:123456789012:cluster/prod",
+			},
+			// eksctl
+			"admin@staging.us-east-1.eksctl.io": {
+				Cluster:  "staging.us-east-1.eksctl.io",
+				AuthInfo: "admin@staging.us-east-1.eksctl.io",
+			},
+			// Renamed context, cluster is only known to the token generator
+			"dev": {
+				Cluster:  "dev-cluster",
+				AuthInfo: "dev-user",
+			},
+			// Neither an eks cluster nor a token generator
+			"kind-local": {
+				Cluster:  "kind-local",
+				AuthInfo: "kind-local",
+			},
+		},
+		AuthInfos: map[string]*clientcmdapi.AuthInfo{
+			"arn:aws:eks:eu-west-1:123456789012:cluster/prod": {
+				Exec: &clientcmdapi.ExecConfig{
+					Command: "aws",
+					Args: []string{
+						"--region", "eu-west-1",
+						"eks", "get-token",
+						"--cluster-name", "prod",
+						"--role-arn", "arn:aws:iam::123456789012:role/admin",
+					},
+					Env: []clientcmdapi.ExecEnvVar{{Name: "AWS_PROFILE", Value: "prod"}},
+				},
+			},
+			"admin@staging.us-east-1.eksctl.io": {
+				Exec: &clientcmdapi.ExecConfig{
+					Command: "aws-iam-authenticator",
+					Args:    []string{"token", "-i", "staging"},
+				},
+			},
+			"dev-user": {
+				Exec: &clientcmdapi.ExecConfig{
+					Command: "aws",
+					Args: []string{
+						"eks", "get-token",
+						"--cluster-name", "dev",
+						"--region", "ap-south-1",
+						"--profile", "dev",
+					},
+				},
+			},
+			"kind-local": {Token: "secret"},
+		},
+	}
+}
+
+func TestDescribeContext(t *testing.T) {
+	tests := []struct {
+		context string
+		want    ContextInfo
+	}{
+		{
+			context: "arn:aws:eks:eu-west-1:123456789012:cluster/prod",
+			want: ContextInfo{
+				Name:           "arn:aws:eks:eu-west-1:123456789012:cluster/prod",
+				EksClusterName: "prod",
+				Region:         "eu-west-1",
+				Profile:        "prod",
+				RoleArn:        "arn:aws:iam::123456789012:role/admin",
+			},
+		},
+		{
+			context: "admin@staging.us-east-1.eksctl.io",
+			want: ContextInfo{
+				Name:           "admin@staging.us-east-1.eksctl.io",
+				EksClusterName: "staging",
+				Region:         "us-east-1",
+			},
+		},
+		{
+			context: "dev",
+			want: ContextInfo{
+				Name:           "dev",
+				EksClusterName: "dev",
+				Region:         "ap-south-1",
+				Profile:        "dev",
+			},
+		},
+		{
+			context: "kind-local",
+			want:    ContextInfo{Name: "kind-local"},
+		},
+	}
+	rawConfig := testKubeConfig()
+	for _, test := range tests {
+		t.Run(test.context, func(t *testing.T) {
+			got := describeContext(rawConfig, test.context)
+			if !reflect.DeepEqual(got, test.want) {
+				t.Errorf("describeContext() = %+v, want %+v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestContextMatchesCluster(t *testing.T) {
+	tests := []struct {
+		context string
+		cluster string
+		want    bool
+	}{
+		{context: "arn:aws:eks:eu-west-1:123456789012:cluster/prod", cluster: "prod", want: true},
+		{context: "arn:aws:eks:eu-west-1:123456789012:cluster/prod", cluster: "staging", want: false},
+		{context: "admin@staging.us-east-1.eksctl.io", cluster: "staging", want: true},
+		{context: "dev", cluster: "dev", want: true},
+		{context: "dev", cluster: "dev-cluster", want: true},
+		{context: "dev", cluster: "prod", want: false},
+		{context: "kind-local", cluster: "kind-local", want: true},
+		{context: "kind-local", cluster: "prod", want: false},
+	}
+	rawConfig := testKubeConfig()
+	for _, test := range tests {
+		got := contextMatchesCluster(rawConfig, test.context, test.cluster)
+		if got != test.want {
+			t.Errorf(
+				"contextMatchesCluster(%s, %s) = %t, want %t",
+				test.context,
+				test.cluster,
+				got,
+				test.want,
+			)
+		}
+	}
+}
+
+func TestVerifyInCluster(t *testing.T) {
+	caFile := filepath.Join(t.TempDir(), "ca.crt")
+	if err := os.WriteFile(caFile, []byte("cluster-ca"), 0600); err != nil {
+		t.Fatalf("Unable to write CA, %s", err.Error())
+	}
+	inCluster := &rest.Config{
+		Host:            "https://10.100.0.1:443",
+		TLSClientConfig: rest.TLSClientConfig{CAFile: caFile},
+	}
+	matching := func(clusterName string, caData []byte) error {
+		if clusterName != "prod" || string(caData) != "cluster-ca" {
+			return errors.New("CA doesn't match")
+		}
+		return nil
+	}
+	tests := []struct {
+		name    string
+		cfg     *rest.Config
+		cluster string
+		verify  func(string, []byte) error
+		wantErr bool
+	}{
+		{name: "no eks cluster", cfg: inCluster, cluster: "", verify: nil, wantErr: false},
+		{name: "no verifier", cfg: inCluster, cluster: "prod", verify: nil, wantErr: true},
+		{name: "matching CA", cfg: inCluster, cluster: "prod", verify: matching, wantErr: false},
+		{name: "other cluster", cfg: inCluster, cluster: "staging", verify: matching, wantErr: true},
+		{
+			name:    "no CA",
+			cfg:     &rest.Config{Host: "https://10.100.0.1:443"},
+			cluster: "prod",
+			verify:  matching,
+			wantErr: true,
+		},
+		{
+			name: "missing CA file",
+			cfg: &rest.Config{
+				Host:            "https://10.100.0.1:443",
+				TLSClientConfig: rest.TLSClientConfig{CAFile: caFile + ".missing"},
+			},
+			cluster: "prod",
+			verify:  matching,
+			wantErr: true,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := verifyInCluster(test.cfg, test.cluster, test.verify)
+			if (err != nil) != test.wantErr {
+				t.Errorf("verifyInCluster() = %v, want error %t", err, test.wantErr)
+			}
+		})
+	}
+	if len(inCluster.CAData) != 0 {
+		t.Errorf("verifyInCluster modified the in-cluster config")
+	}
+}
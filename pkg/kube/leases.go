@@ -0,0 +1,253 @@
+package kube
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	coordinationv1 "k8s.io/api/coordination/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/util/retry"
+)
+
+const (
+	rolloutLeasePrefix = "dockyard-rollout-"
+
+	// User and host of the dockyard process holding a rollout lease
+	HolderUserAnnotationKey = "dockyard.io/holder-user"
+	HolderHostAnnotationKey = "dockyard.io/holder-host"
+)
+
+// Lease held by the dockyard process rolling an asg, stored as k8s Lease in
+// kube-system. Only one process holds the lease of an asg at a time.
+type RolloutLease struct {
+	AsgName string
+	// Identity of the holding process, unique per process
+	Holder     string
+	User       string
+	Host       string
+	AcquiredAt time.Time
+	RenewedAt  time.Time
+	Duration   time.Duration
+}
+
+// Checks if the holder renewed the lease within its duration
+func (l RolloutLease) Live(now time.Time) bool {
+	return now.Before(l.RenewedAt.Add(l.Duration))
+}
+
+// Returns when the lease expires unless it is renewed
+func (l RolloutLease) ExpiresAt() time.Time {
+	return l.RenewedAt.Add(l.Duration)
+}
+
+// Returns the user and host holding the lease
+func (l RolloutLease) HolderName() string {
+	if len(l.User) == 0 && len(l.Host) == 0 {
+		return l.Holder
+	}
+	return l.User + "@" + l.Host
+}
+
+// Error of acquiring a rollout lease held by another process. An expired
+// lease may be taken over explicitly.
+type RolloutLeaseHeldError struct {
+	Lease   RolloutLease
+	Expired bool
+}
+
+func (e *RolloutLeaseHeldError) Error() string {
+	if e.Expired {
+		return fmt.Sprintf(
+			"Rollout lease of asg %s held by %s since %s expired at %s, take it over to continue",
+			e.Lease.AsgName,
+			e.Lease.HolderName(),
+			e.Lease.AcquiredAt.Local().Format("2006-01-02 15:04:05"),
+			e.Lease.ExpiresAt().Local().Format("2006-01-02 15:04:05"),
+		)
+	}
+	return fmt.Sprintf(
+		"Asg %s is rolled by %s since %s",
+		e.Lease.AsgName,
+		e.Lease.HolderName(),
+		e.Lease.AcquiredAt.Local().Format("2006-01-02 15:04:05"),
+	)
+}
+
+func rolloutLeaseName(asgName string) string {
+	return asgResourceName(rolloutLeasePrefix, asgName)
+}
+
+func (c *kubeClient) GetRolloutLease(asgName string) (*RolloutLease, error) {
+	lease, err := c.clientSet.CoordinationV1().
+		Leases(SnapshotNamespace).
+		Get(context.TODO(), rolloutLeaseName(asgName), metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return rolloutLeaseOf(asgName, lease), nil
+}
+
+func (c *kubeClient) ListRolloutLeases() ([]RolloutLease, error) {
+	leases, err := c.clientSet.CoordinationV1().
+		Leases(SnapshotNamespace).
+		List(context.TODO(), metav1.ListOptions{LabelSelector: managedByLabelKey + "=dockyard"})
+	if err != nil {
+		return nil, err
+	}
+	rolloutLeases := make([]RolloutLease, 0, len(leases.Items))
+	for i := range leases.Items {
+		lease := &leases.Items[i]
+		if !strings.HasPrefix(lease.Name, rolloutLeasePrefix) {
+			continue
+		}
+		if asgName, ok := lease.Annotations[AsgAnnotationKey]; ok {
+			rolloutLeases = append(rolloutLeases, *rolloutLeaseOf(asgName, lease))
+		}
+	}
+	sort.Slice(rolloutLeases, func(i, j int) bool {
+		return rolloutLeases[i].AsgName < rolloutLeases[j].AsgName
+	})
+	return rolloutLeases, nil
+}
+
+func rolloutLeaseOf(asgName string, lease *coordinationv1.Lease) *RolloutLease {
+	rolloutLease := &RolloutLease{
+		AsgName: asgName,
+		User:    lease.Annotations[HolderUserAnnotationKey],
+		Host:    lease.Annotations[HolderHostAnnotationKey],
+	}
+	if lease.Spec.HolderIdentity != nil {
+		rolloutLease.Holder = *lease.Spec.HolderIdentity
+	}
+	if lease.Spec.AcquireTime != nil {
+		rolloutLease.AcquiredAt = lease.Spec.AcquireTime.Time
+	}
+	if lease.Spec.RenewTime != nil {
+		rolloutLease.RenewedAt = lease.Spec.RenewTime.Time
+	}
+	if lease.Spec.LeaseDurationSeconds != nil {
+		rolloutLease.Duration = time.Duration(*lease.Spec.LeaseDurationSeconds) * time.Second
+	}
+	return rolloutLease
+}
+
+func (c *kubeClient) AcquireRolloutLease(lease RolloutLease, takeover bool) error {
+	leases := c.clientSet.CoordinationV1().Leases(SnapshotNamespace)
+	name := rolloutLeaseName(lease.AsgName)
+	seconds := int32(lease.Duration / time.Second)
+	// Updates carry the resource version read, a concurrent acquire makes
+	// them conflict and the lease is evaluated again
+	return retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		now := metav1.NewMicroTime(time.Now())
+		current, err := leases.Get(context.TODO(), name, metav1.GetOptions{})
+		if apierrors.IsNotFound(err) {
+			_, err = leases.Create(
+				context.TODO(),
+				&coordinationv1.Lease{
+					ObjectMeta: metav1.ObjectMeta{
+						Name:      name,
+						Namespace: SnapshotNamespace,
+						Labels:    map[string]string{managedByLabelKey: "dockyard"},
+						Annotations: map[string]string{
+							AsgAnnotationKey:        lease.AsgName,
+							HolderUserAnnotationKey: lease.User,
+							HolderHostAnnotationKey: lease.Host,
+						},
+					},
+					Spec: coordinationv1.LeaseSpec{
+						HolderIdentity:       &lease.Holder,
+						LeaseDurationSeconds: &seconds,
+						AcquireTime:          &now,
+						RenewTime:            &now,
+					},
+				},
+				metav1.CreateOptions{},
+			)
+			if apierrors.IsAlreadyExists(err) {
+				return apierrors.NewConflict(
+					coordinationv1.Resource("leases"),
+					name,
+					err,
+				)
+			}
+			return err
+		}
+		if err != nil {
+			return err
+		}
+
+		held := rolloutLeaseOf(lease.AsgName, current)
+		if held.Holder != lease.Holder {
+			if held.Live(now.Time) {
+				return &RolloutLeaseHeldError{Lease: *held}
+			}
+			if !takeover {
+				return &RolloutLeaseHeldError{Lease: *held, Expired: true}
+			}
+			current.Spec.HolderIdentity = &lease.Holder
+			current.Spec.AcquireTime = &now
+		}
+		if current.Annotations == nil {
+			current.Annotations = map[string]string{}
+		}
+		current.Annotations[HolderUserAnnotationKey] = lease.User
+		current.Annotations[HolderHostAnnotationKey] = lease.Host
+		current.Spec.LeaseDurationSeconds = &seconds
+		current.Spec.RenewTime = &now
+		_, err = leases.Update(context.TODO(), current, metav1.UpdateOptions{})
+		return err
+	})
+}
+
+func (c *kubeClient) RenewRolloutLease(asgName, holder string) error {
+	leases := c.clientSet.CoordinationV1().Leases(SnapshotNamespace)
+	name := rolloutLeaseName(asgName)
+	return retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		current, err := leases.Get(context.TODO(), name, metav1.GetOptions{})
+		if err != nil {
+			return err
+		}
+		held := rolloutLeaseOf(asgName, current)
+		if held.Holder != holder {
+			return &RolloutLeaseHeldError{Lease: *held}
+		}
+		now := metav1.NewMicroTime(time.Now())
+		current.Spec.RenewTime = &now
+		_, err = leases.Update(context.TODO(), current, metav1.UpdateOptions{})
+		return err
+	})
+}
+
+func (c *kubeClient) ReleaseRolloutLease(asgName, holder string) error {
+	leases := c.clientSet.CoordinationV1().Leases(SnapshotNamespace)
+	name := rolloutLeaseName(asgName)
+	lease, err := leases.Get(context.TODO(), name, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	// Lease was taken over by another process meanwhile
+	if lease.Spec.HolderIdentity == nil || *lease.Spec.HolderIdentity != holder {
+		return nil
+	}
+	err = leases.Delete(
+		context.TODO(),
+		name,
+		metav1.DeleteOptions{
+			Preconditions: &metav1.Preconditions{ResourceVersion: &lease.ResourceVersion},
+		},
+	)
+	if apierrors.IsNotFound(err) || apierrors.IsConflict(err) {
+		return nil
+	}
+	return err
+}
@@ -0,0 +1,112 @@
+package kube
+
+import (
+	"context"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	policy "k8s.io/api/policy/v1beta1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// Outcome of a dry-run eviction of a single pod
+type DrainImpact struct {
+	NodeName  string
+	PodName   string
+	Namespace string
+	// Eviction would be rejected due to a PodDisruptionBudget
+	BlockedByPdb bool
+	// Pod isn't managed by any controller and won't be recreated
+	Unmanaged bool
+	// Pod uses emptyDir volumes whose data is lost on eviction
+	LocalStorage bool
+	// Pod is annotated with dockyard.io/do-not-evict=true
+	DoNotEvict bool
+	// Any other error returned by the eviction api
+	Err error
+}
+
+// Checks if the eviction of the pod would be disrupting in any way
+func (d DrainImpact) HasImpact() bool {
+	return d.BlockedByPdb || d.Unmanaged || d.LocalStorage || d.DoNotEvict ||
+		d.Err != nil
+}
+
+// Human readable summary of the impact
+func (d DrainImpact) String() string {
+	impacts := make([]string, 0)
+	if d.BlockedByPdb {
+		impacts = append(impacts, "Blocked by PDB")
+	}
+	if d.Unmanaged {
+		impacts = append(impacts, "Unmanaged")
+	}
+	if d.LocalStorage {
+		impacts = append(impacts, "Local storage")
+	}
+	if d.DoNotEvict {
+		impacts = append(impacts, "Do not evict")
+	}
+	if d.Err != nil {
+		impacts = append(impacts, d.Err.Error())
+	}
+	if len(impacts) == 0 {
+		return "Evictable"
+	}
+	return strings.Join(impacts, ",")
+}
+
+func (c *kubeClient) SimulateDrain(
+	nodeName string,
+	ignoreDS, ignoreNotFoundErrors bool,
+) ([]DrainImpact, error) {
+	pods, err := c.podsToDrain(nodeName, ignoreDS, ignoreNotFoundErrors)
+
+	if err != nil {
+		return nil, err
+	}
+
+	impacts := make([]DrainImpact, 0, len(pods))
+	for _, pod := range pods {
+		impact := DrainImpact{
+			NodeName:     nodeName,
+			PodName:      pod.Name,
+			Namespace:    pod.Namespace,
+			Unmanaged:    metav1.GetControllerOf(&pod) == nil,
+			LocalStorage: hasLocalStorage(&pod),
+			DoNotEvict:   isDoNotEvict(&pod),
+		}
+
+		evictPolicy := &policy.Eviction{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      pod.Name,
+				Namespace: pod.Namespace,
+			},
+			DeleteOptions: &metav1.DeleteOptions{
+				GracePeriodSeconds: evictionGracePeriod(&pod),
+				DryRun:             []string{metav1.DryRunAll},
+			},
+		}
+		err := c.clientSet.PolicyV1beta1().
+			Evictions(pod.Namespace).
+			Evict(context.TODO(), evictPolicy)
+
+		if apierrors.IsTooManyRequests(err) {
+			impact.BlockedByPdb = true
+		} else if filterError(err, ignoreNotFoundErrors) != nil {
+			impact.Err = err
+		}
+		impacts = append(impacts, impact)
+	}
+	return impacts, nil
+}
+
+func hasLocalStorage(pod *corev1.Pod) bool {
+	for _, volume := range pod.Spec.Volumes {
+		if volume.EmptyDir != nil {
+			return true
+		}
+	}
+	return false
+}
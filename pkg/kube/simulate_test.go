@@ -0,0 +1,146 @@
+package kube
+
+import (
+	"errors"
+	"sort"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	policy "k8s.io/api/policy/v1beta1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+func TestSimulateDrain(t *testing.T) {
+	controller := []metav1.OwnerReference{{
+		APIVersion: "apps/v1",
+		Kind:       "ReplicaSet",
+		Name:       "app-5d8f7c",
+		Controller: boolPtr(true),
+	}}
+	daemonSet := []metav1.OwnerReference{{
+		APIVersion: "apps/v1",
+		Kind:       "DaemonSet",
+		Name:       "agent",
+		Controller: boolPtr(true),
+	}}
+	pod := func(name string, owners []metav1.OwnerReference, mutate func(*corev1.Pod)) *corev1.Pod {
+		p := &corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:            name,
+				Namespace:       "default",
+				OwnerReferences: owners,
+			},
+			Spec: corev1.PodSpec{NodeName: "node-1"},
+		}
+		if mutate != nil {
+			mutate(p)
+		}
+		return p
+	}
+
+	clientSet := fake.NewSimpleClientset(
+		pod("evictable", controller, nil),
+		pod("guarded", controller, nil),
+		pod("bare", nil, nil),
+		pod("cache", controller, func(p *corev1.Pod) {
+			p.Spec.Volumes = []corev1.Volume{{
+				Name:         "scratch",
+				VolumeSource: corev1.VolumeSource{EmptyDir: &corev1.EmptyDirVolumeSource{}},
+			}}
+		}),
+		pod("pinned", controller, func(p *corev1.Pod) {
+			p.Annotations = map[string]string{DoNotEvictAnnotationKey: "true"}
+		}),
+		pod("failing", controller, nil),
+		pod("gone", controller, nil),
+		pod("agent", daemonSet, nil),
+		pod("elsewhere", controller, func(p *corev1.Pod) {
+			p.Spec.NodeName = "node-2"
+		}),
+	)
+
+	dryRuns := 0
+	clientSet.PrependReactor(
+		"create",
+		"pods",
+		func(action k8stesting.Action) (bool, runtime.Object, error) {
+			if action.GetSubresource() != "eviction" {
+				return false, nil, nil
+			}
+			eviction := action.(k8stesting.CreateAction).GetObject().(*policy.Eviction)
+			if eviction.DeleteOptions == nil ||
+				len(eviction.DeleteOptions.DryRun) != 1 ||
+				eviction.DeleteOptions.DryRun[0] != metav1.DryRunAll {
+				t.Errorf("Eviction of pod %s isn't a dry-run", eviction.Name)
+			}
+			dryRuns++
+			switch eviction.Name {
+			case "guarded":
+				return true, nil, apierrors.NewTooManyRequests(
+					"Cannot evict pod as it would violate the pod's disruption budget.",
+					0,
+				)
+			case "failing":
+				return true, nil, apierrors.NewInternalError(errors.New("etcd timeout"))
+			case "gone":
+				return true, nil, apierrors.NewNotFound(corev1.Resource("pods"), eviction.Name)
+			}
+			return true, nil, nil
+		},
+	)
+
+	client := NewKubeClientWithClientSet(clientSet, "", true, "test-cluster")
+	defer client.Close()
+
+	impacts, err := client.SimulateDrain("node-1", true, true)
+	if err != nil {
+		t.Fatalf("SimulateDrain failed, %s", err.Error())
+	}
+	sort.Slice(impacts, func(i, j int) bool {
+		return impacts[i].PodName < impacts[j].PodName
+	})
+
+	want := map[string]DrainImpact{
+		"bare":      {Unmanaged: true},
+		"cache":     {LocalStorage: true},
+		"evictable": {},
+		"failing":   {Err: apierrors.NewInternalError(errors.New("etcd timeout"))},
+		"gone":      {},
+		"guarded":   {BlockedByPdb: true},
+		"pinned":    {DoNotEvict: true},
+	}
+	if len(impacts) != len(want) {
+		t.Fatalf("SimulateDrain returned %d impacts, want %d: %v", len(impacts), len(want), impacts)
+	}
+	for _, impact := range impacts {
+		expected, ok := want[impact.PodName]
+		if !ok {
+			t.Errorf("Pod %s shouldn't be evicted", impact.PodName)
+			continue
+		}
+		if impact.NodeName != "node-1" || impact.Namespace != "default" {
+			t.Errorf("Pod %s has node %s and namespace %s", impact.PodName, impact.NodeName, impact.Namespace)
+		}
+		if impact.BlockedByPdb != expected.BlockedByPdb ||
+			impact.Unmanaged != expected.Unmanaged ||
+			impact.LocalStorage != expected.LocalStorage ||
+			impact.DoNotEvict != expected.DoNotEvict ||
+			(impact.Err != nil) != (expected.Err != nil) {
+			t.Errorf("Pod %s has impact %s, want %s", impact.PodName, impact, expected)
+		}
+		if impact.HasImpact() != (impact.String() != "Evictable") {
+			t.Errorf("Pod %s has impact %t but is described as %s", impact.PodName, impact.HasImpact(), impact)
+		}
+	}
+	if dryRuns != len(want) {
+		t.Errorf("%d dry-run evictions were made, want %d", dryRuns, len(want))
+	}
+}
+
+func boolPtr(b bool) *bool {
+	return &b
+}
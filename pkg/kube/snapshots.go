@@ -0,0 +1,141 @@
+package kube
+
+import (
+	"context"
+	"fmt"
+	"hash/fnv"
+	"sort"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/util/retry"
+)
+
+const (
+	// Namespace of the ConfigMaps holding asg snapshots and of rollout Leases
+	SnapshotNamespace = "kube-system"
+
+	// Name of the asg a snapshot ConfigMap or rollout Lease belongs to.
+	// Their names are derived from asg names, which allow characters k8s
+	// names don't.
+	AsgAnnotationKey = "dockyard.io/asg"
+
+	managedByLabelKey = "app.kubernetes.io/managed-by"
+	snapshotDataKey   = "snapshot.json"
+	snapshotPrefix    = "dockyard-snapshot-"
+	maxResourceName   = 253
+)
+
+// Returns the name of the ConfigMap holding the snapshot of the asg
+func snapshotConfigMapName(asgName string) string {
+	return asgResourceName(snapshotPrefix, asgName)
+}
+
+// Returns a k8s name for a resource of the asg, a hash of the asg name keeps
+// names of similar asgs apart
+func asgResourceName(prefix, asgName string) string {
+	var b strings.Builder
+	for _, r := range strings.ToLower(asgName) {
+		if (r >= 'a' && r <= 'z') || (r >= '0' && r <= '9') || r == '-' || r == '.' {
+			b.WriteRune(r)
+		} else {
+			b.WriteRune('-')
+		}
+	}
+	h := fnv.New32a()
+	h.Write([]byte(asgName))
+	suffix := fmt.Sprintf("-%08x", h.Sum32())
+
+	name := strings.Trim(b.String(), "-.")
+	if max := maxResourceName - len(prefix) - len(suffix); len(name) > max {
+		name = strings.Trim(name[:max], "-.")
+	}
+	return prefix + name + suffix
+}
+
+func (c *kubeClient) GetAsgSnapshot(asgName string) ([]byte, error) {
+	configMap, err := c.clientSet.CoreV1().
+		ConfigMaps(SnapshotNamespace).
+		Get(context.TODO(), snapshotConfigMapName(asgName), metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	data, ok := configMap.Data[snapshotDataKey]
+	if !ok {
+		return nil, fmt.Errorf(
+			"Unable to read snapshot of asg %s, ConfigMap %s has no %s",
+			asgName,
+			configMap.Name,
+			snapshotDataKey,
+		)
+	}
+	return []byte(data), nil
+}
+
+func (c *kubeClient) SaveAsgSnapshot(asgName string, snapshot []byte) error {
+	configMaps := c.clientSet.CoreV1().ConfigMaps(SnapshotNamespace)
+	name := snapshotConfigMapName(asgName)
+	return retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		configMap, err := configMaps.Get(context.TODO(), name, metav1.GetOptions{})
+		if apierrors.IsNotFound(err) {
+			_, err = configMaps.Create(
+				context.TODO(),
+				&corev1.ConfigMap{
+					ObjectMeta: metav1.ObjectMeta{
+						Name:        name,
+						Namespace:   SnapshotNamespace,
+						Labels:      map[string]string{managedByLabelKey: "dockyard"},
+						Annotations: map[string]string{AsgAnnotationKey: asgName},
+					},
+					Data: map[string]string{snapshotDataKey: string(snapshot)},
+				},
+				metav1.CreateOptions{},
+			)
+			return err
+		}
+		if err != nil {
+			return err
+		}
+		if configMap.Data == nil {
+			configMap.Data = map[string]string{}
+		}
+		configMap.Data[snapshotDataKey] = string(snapshot)
+		_, err = configMaps.Update(context.TODO(), configMap, metav1.UpdateOptions{})
+		return err
+	})
+}
+
+func (c *kubeClient) DeleteAsgSnapshot(asgName string) error {
+	err := c.clientSet.CoreV1().
+		ConfigMaps(SnapshotNamespace).
+		Delete(context.TODO(), snapshotConfigMapName(asgName), metav1.DeleteOptions{})
+	if apierrors.IsNotFound(err) {
+		return nil
+	}
+	return err
+}
+
+func (c *kubeClient) ListAsgSnapshots() ([]string, error) {
+	configMaps, err := c.clientSet.CoreV1().
+		ConfigMaps(SnapshotNamespace).
+		List(context.TODO(), metav1.ListOptions{LabelSelector: managedByLabelKey + "=dockyard"})
+	if err != nil {
+		return nil, err
+	}
+	asgNames := make([]string, 0, len(configMaps.Items))
+	for _, configMap := range configMaps.Items {
+		if !strings.HasPrefix(configMap.Name, snapshotPrefix) {
+			continue
+		}
+		if asgName, ok := configMap.Annotations[AsgAnnotationKey]; ok {
+			asgNames = append(asgNames, asgName)
+		}
+	}
+	sort.Strings(asgNames)
+	return asgNames, nil
+}
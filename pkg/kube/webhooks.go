@@ -0,0 +1,210 @@
+package kube
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+
+	admissionv1 "k8s.io/api/admissionregistration/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+var apiServiceResource = schema.GroupVersionResource{
+	Group:    "apiregistration.k8s.io",
+	Version:  "v1",
+	Resource: "apiservices",
+}
+
+// Admission webhook or aggregated APIService backed by a service of the
+// cluster. Requests fail while the service has no ready endpoints.
+type ServiceBackend struct {
+	// ValidatingWebhook, MutatingWebhook or APIService
+	Kind string
+	// Name of the webhook configuration and webhook, or of the APIService
+	Name             string
+	ServiceNamespace string
+	ServiceName      string
+	// Only set for APIServices, from their Available condition
+	Available bool
+	// Ready endpoint addresses of the service
+	ReadyEndpoints int
+	// Ready endpoint addresses on the provided nodes
+	ReadyEndpointsOnNodes int
+}
+
+func (b ServiceBackend) Service() string {
+	return b.ServiceNamespace + "/" + b.ServiceName
+}
+
+// Checks if all ready endpoints of the backend sit on the provided nodes
+func (b ServiceBackend) OnlyOnNodes() bool {
+	return b.ReadyEndpoints != 0 && b.ReadyEndpoints == b.ReadyEndpointsOnNodes
+}
+
+func (c *kubeClient) ListWebhookBackends(
+	nodeNames []string,
+) ([]ServiceBackend, error) {
+	ctx := context.TODO()
+	admission := c.clientSet.AdmissionregistrationV1()
+	backends := make([]ServiceBackend, 0)
+
+	validating, err := admission.ValidatingWebhookConfigurations().List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, err
+	}
+	for _, config := range validating.Items {
+		for _, webhook := range config.Webhooks {
+			if backend, ok := webhookBackend(
+				"ValidatingWebhook",
+				config.Name,
+				webhook.Name,
+				webhook.FailurePolicy,
+				webhook.ClientConfig,
+			); ok {
+				backends = append(backends, backend)
+			}
+		}
+	}
+	mutating, err := admission.MutatingWebhookConfigurations().List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, err
+	}
+	for _, config := range mutating.Items {
+		for _, webhook := range config.Webhooks {
+			if backend, ok := webhookBackend(
+				"MutatingWebhook",
+				config.Name,
+				webhook.Name,
+				webhook.FailurePolicy,
+				webhook.ClientConfig,
+			); ok {
+				backends = append(backends, backend)
+			}
+		}
+	}
+
+	return c.withEndpoints(backends, nodeNames)
+}
+
+// Returns the backend of webhooks which reject requests if they can't be
+// called, webhooks ignoring failures or called by url are left out
+func webhookBackend(
+	kind, configName, webhookName string,
+	failurePolicy *admissionv1.FailurePolicyType,
+	clientConfig admissionv1.WebhookClientConfig,
+) (ServiceBackend, bool) {
+	// Webhooks of admissionregistration.k8s.io/v1 fail by default
+	if failurePolicy != nil && *failurePolicy == admissionv1.Ignore {
+		return ServiceBackend{}, false
+	}
+	if clientConfig.Service == nil {
+		return ServiceBackend{}, false
+	}
+	return ServiceBackend{
+		Kind:             kind,
+		Name:             fmt.Sprintf("%s/%s", configName, webhookName),
+		ServiceNamespace: clientConfig.Service.Namespace,
+		ServiceName:      clientConfig.Service.Name,
+	}, true
+}
+
+func (c *kubeClient) ListApiServiceBackends(
+	nodeNames []string,
+) ([]ServiceBackend, error) {
+	if c.dynamicClient == nil {
+		return nil, errors.New("Unable to list APIServices without a dynamic client")
+	}
+	apiServices, err := c.dynamicClient.Resource(apiServiceResource).List(
+		context.TODO(),
+		metav1.ListOptions{},
+	)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to list APIServices due to %w", err)
+	}
+
+	backends := make([]ServiceBackend, 0)
+	for _, apiService := range apiServices.Items {
+		// APIServices without a service are served by the api server
+		namespace, _, _ := unstructured.NestedString(apiService.Object, "spec", "service", "namespace")
+		name, _, _ := unstructured.NestedString(apiService.Object, "spec", "service", "name")
+		if len(name) == 0 {
+			continue
+		}
+		backends = append(backends, ServiceBackend{
+			Kind:             "APIService",
+			Name:             apiService.GetName(),
+			ServiceNamespace: namespace,
+			ServiceName:      name,
+			Available:        isApiServiceAvailable(apiService),
+		})
+	}
+	return c.withEndpoints(backends, nodeNames)
+}
+
+func isApiServiceAvailable(apiService unstructured.Unstructured) bool {
+	conditions, _, _ := unstructured.NestedSlice(apiService.Object, "status", "conditions")
+	for _, condition := range conditions {
+		fields, ok := condition.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		if fields["type"] == "Available" {
+			return fields["status"] == string(metav1.ConditionTrue)
+		}
+	}
+	return false
+}
+
+// Counts the ready endpoints of the services of the backends, in total and
+// on the provided nodes
+func (c *kubeClient) withEndpoints(
+	backends []ServiceBackend,
+	nodeNames []string,
+) ([]ServiceBackend, error) {
+	onNodes := map[string]bool{}
+	for _, nodeName := range nodeNames {
+		onNodes[nodeName] = true
+	}
+
+	endpointsOf := map[string]*corev1.Endpoints{}
+	for i, backend := range backends {
+		endpoints, ok := endpointsOf[backend.Service()]
+		if !ok {
+			var err error
+			endpoints, err = c.clientSet.CoreV1().
+				Endpoints(backend.ServiceNamespace).
+				Get(context.TODO(), backend.ServiceName, metav1.GetOptions{})
+			if apierrors.IsNotFound(err) {
+				endpoints, err = nil, nil
+			}
+			if err != nil {
+				return nil, err
+			}
+			endpointsOf[backend.Service()] = endpoints
+		}
+		if endpoints == nil {
+			continue
+		}
+		for _, subset := range endpoints.Subsets {
+			for _, address := range subset.Addresses {
+				backends[i].ReadyEndpoints++
+				if address.NodeName != nil && onNodes[*address.NodeName] {
+					backends[i].ReadyEndpointsOnNodes++
+				}
+			}
+		}
+	}
+
+	sort.SliceStable(backends, func(i, j int) bool {
+		if backends[i].Kind != backends[j].Kind {
+			return backends[i].Kind < backends[j].Kind
+		}
+		return backends[i].Name < backends[j].Name
+	})
+	return backends, nil
+}
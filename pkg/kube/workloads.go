@@ -0,0 +1,251 @@
+package kube
+
+import (
+	"context"
+	"sort"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+)
+
+// Deployment or StatefulSet which loses all its running pods while the
+// nodes it runs on are drained
+type UnprotectedWorkload struct {
+	Kind      string
+	Namespace string
+	Name      string
+	// Value of the dockyard.io/owner annotation or label, empty if unset
+	Owner    string
+	Replicas int32
+	HasPdb   bool
+	// Nodes the pods of the workload run on
+	Nodes []string
+}
+
+// Human readable reasons the workload is unprotected
+func (w UnprotectedWorkload) Reasons() string {
+	reasons := make([]string, 0)
+	if w.Replicas <= 1 {
+		reasons = append(reasons, "Single replica")
+	}
+	if !w.HasPdb {
+		reasons = append(reasons, "No PDB")
+	}
+	return strings.Join(reasons, ",")
+}
+
+// Deployment or StatefulSet controlling a pod
+type workloadRef struct {
+	kind     string
+	meta     metav1.ObjectMeta
+	replicas int32
+	selector *metav1.LabelSelector
+}
+
+func (c *kubeClient) ListUnprotectedWorkloads(
+	nodeNames []string,
+) ([]UnprotectedWorkload, error) {
+	kubeCache, err := c.informerCache()
+	if err != nil {
+		return nil, err
+	}
+	pdbs, err := kubeCache.pdbLister.List(labels.Everything())
+	if err != nil {
+		return nil, err
+	}
+
+	onNodes := map[string]bool{}
+	for _, nodeName := range nodeNames {
+		onNodes[nodeName] = true
+	}
+
+	// Workloads keyed by the controller of their pods, nil if the
+	// controller isn't a Deployment or StatefulSet
+	workloads := map[string]*workloadRef{}
+	seen := map[string]bool{}
+	unprotected := make([]UnprotectedWorkload, 0)
+	for _, nodeName := range nodeNames {
+		pods, err := kubeCache.podsOfNode(nodeName)
+		if err != nil {
+			return nil, err
+		}
+		for _, pod := range pods {
+			if isTerminated(&pod) {
+				continue
+			}
+			controller := metav1.GetControllerOf(&pod)
+			if controller == nil {
+				continue
+			}
+			controllerKey := strings.Join(
+				[]string{controller.Kind, pod.Namespace, controller.Name},
+				"/",
+			)
+			workload, ok := workloads[controllerKey]
+			if !ok {
+				workload, err = c.workloadOf(pod.Namespace, controller)
+				if err != nil {
+					return nil, err
+				}
+				workloads[controllerKey] = workload
+			}
+			if workload == nil {
+				continue
+			}
+			workloadKey := strings.Join(
+				[]string{workload.kind, workload.meta.Namespace, workload.meta.Name},
+				"/",
+			)
+			if seen[workloadKey] {
+				continue
+			}
+			seen[workloadKey] = true
+
+			selector, err := metav1.LabelSelectorAsSelector(workload.selector)
+			if err != nil {
+				continue
+			}
+			workloadPods, err := kubeCache.podLister.Pods(pod.Namespace).List(selector)
+			if err != nil {
+				return nil, err
+			}
+			nodes := make([]string, 0)
+			elsewhere := false
+			for _, workloadPod := range workloadPods {
+				if isTerminated(workloadPod) || len(workloadPod.Spec.NodeName) == 0 {
+					continue
+				}
+				if !onNodes[workloadPod.Spec.NodeName] {
+					elsewhere = true
+					break
+				}
+				nodes = append(nodes, workloadPod.Spec.NodeName)
+			}
+			if elsewhere {
+				continue
+			}
+
+			hasPdb := false
+			for _, pdb := range pdbs {
+				if pdb.Namespace != pod.Namespace || pdb.Spec.Selector == nil {
+					continue
+				}
+				pdbSelector, err := metav1.LabelSelectorAsSelector(pdb.Spec.Selector)
+				if err == nil && pdbSelector.Matches(labels.Set(pod.Labels)) {
+					hasPdb = true
+					break
+				}
+			}
+			if workload.replicas > 1 && hasPdb {
+				continue
+			}
+
+			sort.Strings(nodes)
+			unprotected = append(unprotected, UnprotectedWorkload{
+				Kind:      workload.kind,
+				Namespace: workload.meta.Namespace,
+				Name:      workload.meta.Name,
+				Owner:     ownerOf(workload.meta),
+				Replicas:  workload.replicas,
+				HasPdb:    hasPdb,
+				Nodes:     nodes,
+			})
+		}
+	}
+
+	sort.Slice(unprotected, func(i, j int) bool {
+		if unprotected[i].Namespace != unprotected[j].Namespace {
+			return unprotected[i].Namespace < unprotected[j].Namespace
+		}
+		return unprotected[i].Name < unprotected[j].Name
+	})
+	return unprotected, nil
+}
+
+// Returns the Deployment or StatefulSet behind the controller of a pod,
+// nil for any other controller or if the controller is gone
+func (c *kubeClient) workloadOf(
+	namespace string,
+	controller *metav1.OwnerReference,
+) (*workloadRef, error) {
+	apps := c.clientSet.AppsV1()
+	switch controller.Kind {
+	case "StatefulSet":
+		sts, err := apps.StatefulSets(namespace).Get(
+			context.TODO(),
+			controller.Name,
+			metav1.GetOptions{},
+		)
+		if apierrors.IsNotFound(err) {
+			return nil, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		return &workloadRef{
+			kind:     "StatefulSet",
+			meta:     sts.ObjectMeta,
+			replicas: replicasOrDefault(sts.Spec.Replicas),
+			selector: sts.Spec.Selector,
+		}, nil
+	case "ReplicaSet":
+		rs, err := apps.ReplicaSets(namespace).Get(
+			context.TODO(),
+			controller.Name,
+			metav1.GetOptions{},
+		)
+		if apierrors.IsNotFound(err) {
+			return nil, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		rsController := metav1.GetControllerOf(rs)
+		if rsController == nil || rsController.Kind != "Deployment" {
+			return nil, nil
+		}
+		deployment, err := apps.Deployments(namespace).Get(
+			context.TODO(),
+			rsController.Name,
+			metav1.GetOptions{},
+		)
+		if apierrors.IsNotFound(err) {
+			return nil, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		return &workloadRef{
+			kind:     "Deployment",
+			meta:     deployment.ObjectMeta,
+			replicas: replicasOrDefault(deployment.Spec.Replicas),
+			selector: deployment.Spec.Selector,
+		}, nil
+	}
+	return nil, nil
+}
+
+// Replicas of workloads default to 1
+func replicasOrDefault(replicas *int32) int32 {
+	if replicas == nil {
+		return 1
+	}
+	return *replicas
+}
+
+// Returns the dockyard.io/owner annotation of the workload, falls back to
+// the label of the same key
+func ownerOf(meta metav1.ObjectMeta) string {
+	if owner, ok := meta.Annotations[OwnerAnnotationKey]; ok {
+		return owner
+	}
+	return meta.Labels[OwnerAnnotationKey]
+}
+
+func isTerminated(pod *corev1.Pod) bool {
+	return pod.Status.Phase == corev1.PodSucceeded ||
+		pod.Status.Phase == corev1.PodFailed
+}
@@ -0,0 +1,95 @@
+package preflight
+
+import (
+	"context"
+	"dockyard/pkg/aws"
+	"fmt"
+	"strconv"
+)
+
+func init() {
+	Register(NewCheck(
+		"cluster-autoscaler",
+		CategoryCapacity,
+		SeverityWarning,
+		"Set ASG_ROLLOUT.CLUSTER_AUTOSCALER.MODE to annotate or scale-to-zero so that cluster-autoscaler doesn't scale in new nodes or scale out old ones during the rollout",
+		checkClusterAutoscaler,
+	))
+}
+
+// Fails if a running cluster-autoscaler manages asgs to be rolled while
+// dockyard doesn't coordinate with it
+func checkClusterAutoscaler(
+	ctx context.Context,
+	env *Env,
+	config CheckConfig,
+) (Result, error) {
+	autoscalers, err := env.Kube.ListClusterAutoscalers()
+	if err != nil {
+		return Result{}, err
+	}
+	if len(autoscalers) == 0 {
+		return Pass("No cluster-autoscaler found", nil), nil
+	}
+	asgNames, err := env.AsgNames()
+	if err != nil {
+		return Result{}, err
+	}
+
+	mode := env.ClusterAutoscalerMode
+	if len(mode) == 0 {
+		mode = aws.ClusterAutoscalerModeNone
+	}
+	details := [][]string{{
+		"Cluster Autoscaler",
+		"Replicas",
+		"ASG",
+		"Managed By",
+		"Coordination",
+	}}
+	uncoordinated := 0
+	for _, asgName := range asgNames {
+		if err := ctx.Err(); err != nil {
+			return Result{}, err
+		}
+		tags, err := env.Asg.GetTagsOfAsg(asgName)
+		if err != nil {
+			return Result{}, err
+		}
+		for _, ca := range autoscalers {
+			managedBy := ca.ManagedBy(asgName, tags)
+			if len(managedBy) == 0 {
+				continue
+			}
+			status := "✅"
+			if ca.Replicas != 0 && mode == aws.ClusterAutoscalerModeNone {
+				status = "❌"
+				uncoordinated++
+			}
+			details = append(details, []string{
+				fmt.Sprintf("%s/%s", ca.Namespace, ca.Name),
+				strconv.Itoa(int(ca.Replicas)),
+				asgName,
+				managedBy,
+				fmt.Sprintf("%s %s", mode, status),
+			})
+		}
+	}
+
+	if uncoordinated != 0 {
+		return Fail(
+			fmt.Sprintf("%d asgs are managed by cluster-autoscaler which isn't coordinated with the rollout", uncoordinated),
+			details,
+		), nil
+	}
+	if len(details) == 1 {
+		return Pass(
+			fmt.Sprintf("%d cluster-autoscalers don't manage any of %d asgs", len(autoscalers), len(asgNames)),
+			details,
+		), nil
+	}
+	return Pass(
+		fmt.Sprintf("Cluster-autoscaler is coordinated with the rollout, mode %s", mode),
+		details,
+	), nil
+}
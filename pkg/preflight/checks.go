@@ -0,0 +1,327 @@
+package preflight
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+const (
+	// Subnets with less available ips can't fit the surge of new nodes
+	// and their pods
+	defaultMinAvailableIps = 10
+)
+
+// Check assembled from its metadata and a run function
+type check struct {
+	name        string
+	category    string
+	severity    Severity
+	remediation string
+	run         func(ctx context.Context, env *Env, config CheckConfig) (Result, error)
+}
+
+// Returns a check which runs the provided function, e.g. to register
+// custom checks
+func NewCheck(
+	name, category string,
+	severity Severity,
+	remediation string,
+	run func(ctx context.Context, env *Env, config CheckConfig) (Result, error),
+) PreflightCheck {
+	return &check{
+		name:        name,
+		category:    category,
+		severity:    severity,
+		remediation: remediation,
+		run:         run,
+	}
+}
+
+func (c *check) Name() string        { return c.name }
+func (c *check) Category() string    { return c.category }
+func (c *check) Severity() Severity  { return c.severity }
+func (c *check) Remediation() string { return c.remediation }
+
+func (c *check) Run(
+	ctx context.Context,
+	env *Env,
+	config CheckConfig,
+) (Result, error) {
+	return c.run(ctx, env, config)
+}
+
+func init() {
+	Register(NewCheck(
+		"available-ips",
+		CategoryCapacity,
+		SeverityWarning,
+		"Add subnets to the node groups or free up ips of the subnets, THRESHOLD is the min available ips per subnet",
+		checkAvailableIps,
+	))
+	Register(NewCheck(
+		"ec2-limits",
+		CategoryCapacity,
+		SeverityInfo,
+		"Make sure the ec2 service quotas are readable with servicequotas:ListServiceQuotas",
+		checkEc2Limits,
+	))
+	Register(NewCheck(
+		"node-health",
+		CategoryHealth,
+		SeverityCritical,
+		"Fix or replace nodes which aren't Ready before rolling the cluster",
+		checkNodeHealth,
+	))
+	Register(NewCheck(
+		"pending-pods",
+		CategoryHealth,
+		SeverityWarning,
+		"Schedule pending pods before the rollout, THRESHOLD is the max pending pods allowed",
+		checkPendingPods,
+	))
+	Register(NewCheck(
+		"pdbs",
+		CategoryWorkloads,
+		SeverityWarning,
+		"Scale up workloads or relax their PodDisruptionBudgets, drains wait till a disruption is allowed",
+		checkPdbs,
+	))
+	Register(NewCheck(
+		"public-images",
+		CategoryWorkloads,
+		SeverityWarning,
+		"Mirror images to the private registry or add mirrors and pull-through caches to ASG_ROLLOUT.ALLOWED_REGISTRIES, new nodes pulling public images may be rate limited. THRESHOLD is the max workload images allowed to be public. Export the list with dockyard public-images",
+		checkPublicImages,
+	))
+	Register(NewCheck(
+		"drain-annotations",
+		CategoryWorkloads,
+		SeverityInfo,
+		"Pods annotated with dockyard.io/do-not-evict=true block the drain of their node till the annotation is removed",
+		checkDrainAnnotations,
+	))
+	Register(NewCheck(
+		"drain-impact",
+		CategoryWorkloads,
+		SeverityWarning,
+		"Run dockyard drain --dry-run <node> for details, pods blocked by PDBs or without controller need attention",
+		checkDrainImpact,
+	))
+}
+
+// Fails if any subnet of the cluster has less than THRESHOLD available ips
+func checkAvailableIps(
+	ctx context.Context,
+	env *Env,
+	config CheckConfig,
+) (Result, error) {
+	subnets, err := env.Eks.AvailableIp()
+	if err != nil {
+		return Result{}, err
+	}
+	if len(subnets) == 0 {
+		return Fail("No subnets found in the vpc of the cluster", nil), nil
+	}
+
+	minIps := config.ThresholdOr(defaultMinAvailableIps)
+	details := [][]string{{"Subnet", "Availability Zone", "Ips"}}
+	short := 0
+	for _, subnet := range subnets {
+		details = append(details, subnet)
+		ips, err := strconv.Atoi(subnet[2])
+		if err == nil && ips < minIps {
+			short++
+		}
+	}
+	if short != 0 {
+		return Fail(
+			fmt.Sprintf("%d of %d subnets have less than %d available ips", short, len(subnets), minIps),
+			details,
+		), nil
+	}
+	return Pass(
+		fmt.Sprintf("All %d subnets have at least %d available ips", len(subnets), minIps),
+		details,
+	), nil
+}
+
+// Fails if the ec2 instance quotas can't be found
+func checkEc2Limits(
+	ctx context.Context,
+	env *Env,
+	config CheckConfig,
+) (Result, error) {
+	limits, err := env.Eks.Ec2Limits()
+	if err != nil {
+		return Result{}, err
+	}
+	if len(limits) == 0 {
+		return Fail("No ec2 instance quotas found", nil), nil
+	}
+	details := append([][]string{{"Type", "Limit"}}, limits...)
+	return Pass(fmt.Sprintf("Found %d ec2 instance quotas", len(limits)), details), nil
+}
+
+// Fails if any node of the cluster isn't Ready
+func checkNodeHealth(
+	ctx context.Context,
+	env *Env,
+	config CheckConfig,
+) (Result, error) {
+	nodes, err := env.Kube.ListNotReadyNodes()
+	if err != nil {
+		return Result{}, err
+	}
+	if len(nodes) == 0 {
+		return Pass("All nodes are Ready", nil), nil
+	}
+	details := [][]string{{"Node"}}
+	for _, node := range nodes {
+		details = append(details, []string{node})
+	}
+	return Fail(fmt.Sprintf("%d nodes aren't Ready", len(nodes)), details), nil
+}
+
+// Fails if more than THRESHOLD pods are pending
+func checkPendingPods(
+	ctx context.Context,
+	env *Env,
+	config CheckConfig,
+) (Result, error) {
+	pods, err := env.Kube.ListPendingPods()
+	if err != nil {
+		return Result{}, err
+	}
+	details := append([][]string{{"Pod", "Namespace"}}, pods...)
+	maxPending := config.ThresholdOr(0)
+	if len(pods) > maxPending {
+		return Fail(fmt.Sprintf("%d pods are pending", len(pods)), details), nil
+	}
+	return Pass(fmt.Sprintf("%d pods are pending", len(pods)), details), nil
+}
+
+// Fails if any PDB allows no disruption
+func checkPdbs(
+	ctx context.Context,
+	env *Env,
+	config CheckConfig,
+) (Result, error) {
+	pdbs, err := env.Kube.GetPDB()
+	if err != nil {
+		return Result{}, err
+	}
+	details := append([][]string{{"PDB Name", "Namespace", "ExpectedPods"}}, pdbs...)
+	if len(pdbs) != 0 {
+		return Fail(
+			fmt.Sprintf("%d PDBs allow no disruption", len(pdbs)),
+			details,
+		), nil
+	}
+	return Pass("All PDBs allow disruptions", details), nil
+}
+
+// Fails if workloads pull more than THRESHOLD images from outside the
+// private registry and the allowed registries
+func checkPublicImages(
+	ctx context.Context,
+	env *Env,
+	config CheckConfig,
+) (Result, error) {
+	images, err := env.Kube.ListPublicImages(env.AllowedRegistries)
+	if err != nil {
+		return Result{}, err
+	}
+	details := [][]string{{"Image", "Kind", "Namespace", "Workload", "Containers"}}
+	distinct := map[string]bool{}
+	for _, image := range images {
+		distinct[image.Image] = true
+		details = append(details, []string{
+			image.Image,
+			image.Kind,
+			image.Namespace,
+			image.Name,
+			strings.Join(image.Containers, ","),
+		})
+	}
+	message := fmt.Sprintf(
+		"%d workloads use %d public images",
+		len(images),
+		len(distinct),
+	)
+	maxPublic := config.ThresholdOr(0)
+	if len(images) > maxPublic {
+		return Fail(message, details), nil
+	}
+	return Pass(message, details), nil
+}
+
+// Fails if pods on nodes to be rolled carry dockyard drain annotations
+func checkDrainAnnotations(
+	ctx context.Context,
+	env *Env,
+	config CheckConfig,
+) (Result, error) {
+	nodes, err := env.NodesToRollout()
+	if err != nil {
+		return Result{}, err
+	}
+	pods, err := env.Kube.ListDrainAnnotatedPods(nodes)
+	if err != nil {
+		return Result{}, err
+	}
+	details := append([][]string{{"Pod", "Namespace", "Node", "Annotations"}}, pods...)
+	if len(pods) != 0 {
+		return Fail(
+			fmt.Sprintf("%d pods on nodes to be rolled carry drain annotations", len(pods)),
+			details,
+		), nil
+	}
+	return Pass("No pods on nodes to be rolled carry drain annotations", details), nil
+}
+
+// Fails if the dry-run drain of any node to be rolled has impact on its
+// pods
+func checkDrainImpact(
+	ctx context.Context,
+	env *Env,
+	config CheckConfig,
+) (Result, error) {
+	nodes, err := env.NodesToRollout()
+	if err != nil {
+		return Result{}, err
+	}
+
+	details := [][]string{{"Node", "Pod", "Namespace", "Impact"}}
+	for _, nodeName := range nodes {
+		if err := ctx.Err(); err != nil {
+			return Result{}, err
+		}
+		impacts, err := env.Kube.SimulateDrain(nodeName, true, env.IgnoreNotFound)
+		if err != nil {
+			return Result{}, err
+		}
+		for _, impact := range impacts {
+			if !impact.HasImpact() {
+				continue
+			}
+			details = append(details, []string{
+				impact.NodeName,
+				impact.PodName,
+				impact.Namespace,
+				impact.String(),
+			})
+		}
+	}
+	if impacted := len(details) - 1; impacted != 0 {
+		return Fail(
+			fmt.Sprintf("Drain of %d nodes impacts %d pods", len(nodes), impacted),
+			details,
+		), nil
+	}
+	return Pass(
+		fmt.Sprintf("All pods of %d nodes can be evicted without impact", len(nodes)),
+		details,
+	), nil
+}
@@ -0,0 +1,83 @@
+package preflight
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Never block the rollout, failed checks only warn
+const blockNever = "never"
+
+type Config struct {
+	// Failed checks of at least this severity block the rollout, never
+	// only warns
+	BlockOn string `mapstructure:"BLOCK_ON" validate:"omitempty,oneof=info warning critical never"`
+	// Settings of the checks, keyed by check name
+	Checks map[string]CheckConfig `mapstructure:"CHECKS" validate:"dive"`
+}
+
+type CheckConfig struct {
+	// Checks are enabled by default
+	Enabled *bool `mapstructure:"ENABLED"`
+	// Overrides the default severity of the check
+	Severity string `mapstructure:"SEVERITY" validate:"omitempty,oneof=info warning critical"`
+	// Tunes when the check fails, e.g. the min available ips of a subnet.
+	// Meaning and default are specific to the check.
+	Threshold *int `mapstructure:"THRESHOLD" validate:"omitempty,min=0"`
+	// K8s version the cluster is upgraded to, e.g. 1.25. Defaults to the
+	// minor version after the control plane.
+	TargetVersion string `mapstructure:"TARGET_VERSION"`
+}
+
+// Returns an error if the config refers to checks which aren't registered
+func (c *Config) Validate() error {
+	if c == nil {
+		return nil
+	}
+	unknown := make([]string, 0)
+	for name := range c.Checks {
+		if _, ok := lookup(name); !ok {
+			unknown = append(unknown, name)
+		}
+	}
+	if len(unknown) != 0 {
+		return fmt.Errorf(
+			"Unable to configure unknown preflight checks %s",
+			strings.Join(unknown, ", "),
+		)
+	}
+	return nil
+}
+
+// Returns the severity failed checks block the rollout from, nil if the
+// rollout is never blocked. Defaults to critical.
+func (c Config) blockOn() *Severity {
+	if c.BlockOn == blockNever {
+		return nil
+	}
+	severity, err := ParseSeverity(c.BlockOn)
+	if err != nil {
+		severity = SeverityCritical
+	}
+	return &severity
+}
+
+// Returns the threshold of the config, def if the check isn't tuned
+func (c CheckConfig) ThresholdOr(def int) int {
+	if c.Threshold == nil {
+		return def
+	}
+	return *c.Threshold
+}
+
+func (c CheckConfig) enabled() bool {
+	return c.Enabled == nil || *c.Enabled
+}
+
+func (c CheckConfig) severityOr(def Severity) Severity {
+	severity, err := ParseSeverity(c.Severity)
+	if err != nil {
+		return def
+	}
+	return severity
+}
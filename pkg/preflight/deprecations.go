@@ -0,0 +1,228 @@
+package preflight
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+func init() {
+	Register(NewCheck(
+		"deprecated-apis",
+		CategoryCompatibility,
+		SeverityWarning,
+		"Migrate manifests and controllers to the replacement api versions before upgrading the control plane, objects applied with removed api versions can't be applied again. TARGET_VERSION is the k8s version the cluster is upgraded to",
+		checkDeprecatedApis,
+	))
+}
+
+// Api version of a kind which isn't served anymore from a k8s version on
+type apiRemoval struct {
+	apiVersion  string
+	kind        string
+	resource    string
+	removedIn   minorVersion
+	replacement string
+}
+
+func (r apiRemoval) groupResource() schema.GroupResource {
+	groupVersion, _ := schema.ParseGroupVersion(r.apiVersion)
+	return schema.GroupResource{Group: groupVersion.Group, Resource: r.resource}
+}
+
+// Removals of the k8s deprecated api migration guide
+// https://kubernetes.io/docs/reference/using-api/deprecation-guide/
+var apiRemovals = []apiRemoval{
+	{"extensions/v1beta1", "Deployment", "deployments", minorVersion{1, 16}, "apps/v1"},
+	{"extensions/v1beta1", "DaemonSet", "daemonsets", minorVersion{1, 16}, "apps/v1"},
+	{"extensions/v1beta1", "ReplicaSet", "replicasets", minorVersion{1, 16}, "apps/v1"},
+	{"extensions/v1beta1", "NetworkPolicy", "networkpolicies", minorVersion{1, 16}, "networking.k8s.io/v1"},
+	{"extensions/v1beta1", "PodSecurityPolicy", "podsecuritypolicies", minorVersion{1, 16}, "policy/v1beta1"},
+	{"apps/v1beta1", "Deployment", "deployments", minorVersion{1, 16}, "apps/v1"},
+	{"apps/v1beta1", "StatefulSet", "statefulsets", minorVersion{1, 16}, "apps/v1"},
+	{"apps/v1beta2", "Deployment", "deployments", minorVersion{1, 16}, "apps/v1"},
+	{"apps/v1beta2", "StatefulSet", "statefulsets", minorVersion{1, 16}, "apps/v1"},
+	{"apps/v1beta2", "DaemonSet", "daemonsets", minorVersion{1, 16}, "apps/v1"},
+	{"apps/v1beta2", "ReplicaSet", "replicasets", minorVersion{1, 16}, "apps/v1"},
+	{"admissionregistration.k8s.io/v1beta1", "MutatingWebhookConfiguration", "mutatingwebhookconfigurations", minorVersion{1, 22}, "admissionregistration.k8s.io/v1"},
+	{"admissionregistration.k8s.io/v1beta1", "ValidatingWebhookConfiguration", "validatingwebhookconfigurations", minorVersion{1, 22}, "admissionregistration.k8s.io/v1"},
+	{"apiextensions.k8s.io/v1beta1", "CustomResourceDefinition", "customresourcedefinitions", minorVersion{1, 22}, "apiextensions.k8s.io/v1"},
+	{"apiregistration.k8s.io/v1beta1", "APIService", "apiservices", minorVersion{1, 22}, "apiregistration.k8s.io/v1"},
+	{"certificates.k8s.io/v1beta1", "CertificateSigningRequest", "certificatesigningrequests", minorVersion{1, 22}, "certificates.k8s.io/v1"},
+	{"coordination.k8s.io/v1beta1", "Lease", "leases", minorVersion{1, 22}, "coordination.k8s.io/v1"},
+	{"extensions/v1beta1", "Ingress", "ingresses", minorVersion{1, 22}, "networking.k8s.io/v1"},
+	{"networking.k8s.io/v1beta1", "Ingress", "ingresses", minorVersion{1, 22}, "networking.k8s.io/v1"},
+	{"networking.k8s.io/v1beta1", "IngressClass", "ingressclasses", minorVersion{1, 22}, "networking.k8s.io/v1"},
+	{"rbac.authorization.k8s.io/v1beta1", "ClusterRole", "clusterroles", minorVersion{1, 22}, "rbac.authorization.k8s.io/v1"},
+	{"rbac.authorization.k8s.io/v1beta1", "ClusterRoleBinding", "clusterrolebindings", minorVersion{1, 22}, "rbac.authorization.k8s.io/v1"},
+	{"rbac.authorization.k8s.io/v1beta1", "Role", "roles", minorVersion{1, 22}, "rbac.authorization.k8s.io/v1"},
+	{"rbac.authorization.k8s.io/v1beta1", "RoleBinding", "rolebindings", minorVersion{1, 22}, "rbac.authorization.k8s.io/v1"},
+	{"scheduling.k8s.io/v1beta1", "PriorityClass", "priorityclasses", minorVersion{1, 22}, "scheduling.k8s.io/v1"},
+	{"storage.k8s.io/v1beta1", "CSIDriver", "csidrivers", minorVersion{1, 22}, "storage.k8s.io/v1"},
+	{"storage.k8s.io/v1beta1", "CSINode", "csinodes", minorVersion{1, 22}, "storage.k8s.io/v1"},
+	{"storage.k8s.io/v1beta1", "StorageClass", "storageclasses", minorVersion{1, 22}, "storage.k8s.io/v1"},
+	{"storage.k8s.io/v1beta1", "VolumeAttachment", "volumeattachments", minorVersion{1, 22}, "storage.k8s.io/v1"},
+	{"batch/v1beta1", "CronJob", "cronjobs", minorVersion{1, 25}, "batch/v1"},
+	{"discovery.k8s.io/v1beta1", "EndpointSlice", "endpointslices", minorVersion{1, 25}, "discovery.k8s.io/v1"},
+	{"events.k8s.io/v1beta1", "Event", "events", minorVersion{1, 25}, "events.k8s.io/v1"},
+	{"autoscaling/v2beta1", "HorizontalPodAutoscaler", "horizontalpodautoscalers", minorVersion{1, 25}, "autoscaling/v2"},
+	{"policy/v1beta1", "PodDisruptionBudget", "poddisruptionbudgets", minorVersion{1, 25}, "policy/v1"},
+	{"policy/v1beta1", "PodSecurityPolicy", "podsecuritypolicies", minorVersion{1, 25}, "Pod Security Admission"},
+	{"node.k8s.io/v1beta1", "RuntimeClass", "runtimeclasses", minorVersion{1, 25}, "node.k8s.io/v1"},
+	{"flowcontrol.apiserver.k8s.io/v1beta1", "FlowSchema", "flowschemas", minorVersion{1, 26}, "flowcontrol.apiserver.k8s.io/v1beta3"},
+	{"flowcontrol.apiserver.k8s.io/v1beta1", "PriorityLevelConfiguration", "prioritylevelconfigurations", minorVersion{1, 26}, "flowcontrol.apiserver.k8s.io/v1beta3"},
+	{"autoscaling/v2beta2", "HorizontalPodAutoscaler", "horizontalpodautoscalers", minorVersion{1, 26}, "autoscaling/v2"},
+	{"storage.k8s.io/v1beta1", "CSIStorageCapacity", "csistoragecapacities", minorVersion{1, 27}, "storage.k8s.io/v1"},
+	{"flowcontrol.apiserver.k8s.io/v1beta2", "FlowSchema", "flowschemas", minorVersion{1, 29}, "flowcontrol.apiserver.k8s.io/v1"},
+	{"flowcontrol.apiserver.k8s.io/v1beta2", "PriorityLevelConfiguration", "prioritylevelconfigurations", minorVersion{1, 29}, "flowcontrol.apiserver.k8s.io/v1"},
+	{"flowcontrol.apiserver.k8s.io/v1beta3", "FlowSchema", "flowschemas", minorVersion{1, 32}, "flowcontrol.apiserver.k8s.io/v1"},
+	{"flowcontrol.apiserver.k8s.io/v1beta3", "PriorityLevelConfiguration", "prioritylevelconfigurations", minorVersion{1, 32}, "flowcontrol.apiserver.k8s.io/v1"},
+}
+
+// Returns the removals which took effect up to the target version
+func removalsUntil(target minorVersion) []apiRemoval {
+	removals := make([]apiRemoval, 0)
+	for _, removal := range apiRemovals {
+		if removal.removedIn.behind(target) >= 0 {
+			removals = append(removals, removal)
+		}
+	}
+	return removals
+}
+
+// Returns the removal of the api version of the kind or resource
+func findRemoval(
+	removals []apiRemoval,
+	apiVersion, kind, resource string,
+) (apiRemoval, bool) {
+	for _, removal := range removals {
+		if removal.apiVersion == apiVersion &&
+			(removal.kind == kind || removal.resource == resource) {
+			return removal, true
+		}
+	}
+	return apiRemoval{}, false
+}
+
+// Returns the version the cluster is upgraded to, the minor version after
+// the control plane if the config doesn't set it
+func targetVersion(env *Env, config CheckConfig) (minorVersion, error) {
+	if len(config.TargetVersion) != 0 {
+		return parseMinorVersion(config.TargetVersion)
+	}
+	serverVersion, err := env.Kube.GetServerVersion()
+	if err != nil {
+		return minorVersion{}, err
+	}
+	controlPlane, err := parseMinorVersion(serverVersion)
+	if err != nil {
+		return minorVersion{}, err
+	}
+	return controlPlane.add(1), nil
+}
+
+// Fails if objects were last applied or written with api versions removed
+// up to the target version, or if such api versions were requested from
+// the api server since it started
+func checkDeprecatedApis(
+	ctx context.Context,
+	env *Env,
+	config CheckConfig,
+) (Result, error) {
+	target, err := targetVersion(env, config)
+	if err != nil {
+		return Result{}, err
+	}
+	removals := removalsUntil(target)
+
+	groupResources := make([]schema.GroupResource, 0)
+	apiVersions := make([]string, 0)
+	for _, removal := range removals {
+		groupResources = append(groupResources, removal.groupResource())
+		apiVersions = append(apiVersions, removal.apiVersion)
+	}
+	usages, err := env.Kube.ListApiVersionUsages(groupResources, apiVersions)
+	if err != nil {
+		return Result{}, err
+	}
+
+	details := [][]string{{
+		"Namespace",
+		"Kind",
+		"Name",
+		"Api Version",
+		"Removed In",
+		"Replacement",
+		"Source",
+	}}
+	namespaces := map[string]bool{}
+	objects := map[string]bool{}
+	for _, usage := range usages {
+		removal, ok := findRemoval(removals, usage.ApiVersion, usage.Kind, "")
+		if !ok {
+			continue
+		}
+		namespace := usage.Namespace
+		if len(namespace) == 0 {
+			namespace = "<cluster>"
+		}
+		namespaces[namespace] = true
+		objects[strings.Join([]string{namespace, usage.Kind, usage.Name}, "/")] = true
+		details = append(details, []string{
+			namespace,
+			usage.Kind,
+			usage.Name,
+			usage.ApiVersion,
+			removal.removedIn.String(),
+			removal.replacement,
+			usage.Source,
+		})
+	}
+
+	// Requests of removed apis by clients which don't leave a trace on the
+	// objects, e.g. controllers only reading them
+	requested := 0
+	metricsNote := ""
+	requests, err := env.Kube.ListDeprecatedApiRequests()
+	if err != nil {
+		metricsNote = ", deprecated api metrics of the api server are unreachable"
+	}
+	sort.Slice(requests, func(i, j int) bool { return requests[i][0] < requests[j][0] })
+	for _, request := range requests {
+		removal, ok := findRemoval(removals, request[0], "", request[1])
+		if !ok {
+			continue
+		}
+		requested++
+		details = append(details, []string{
+			"",
+			removal.kind,
+			"",
+			request[0],
+			removal.removedIn.String(),
+			removal.replacement,
+			"apiserver_requested_deprecated_apis",
+		})
+	}
+
+	if len(objects) != 0 || requested != 0 {
+		return Fail(
+			fmt.Sprintf(
+				"%d objects in %d namespaces and %d requested apis use api versions removed by %s%s",
+				len(objects),
+				len(namespaces),
+				requested,
+				target,
+				metricsNote,
+			),
+			details,
+		), nil
+	}
+	return Pass(
+		fmt.Sprintf("No api versions removed by %s are in use%s", target, metricsNote),
+		details,
+	), nil
+}
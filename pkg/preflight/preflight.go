@@ -0,0 +1,341 @@
+package preflight
+
+import (
+	"context"
+	"dockyard/pkg/aws"
+	"dockyard/pkg/kube"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Severity of a failing check
+type Severity int
+
+const (
+	// Failing check is only informative
+	SeverityInfo Severity = iota
+	// Failing check warns before the rollout
+	SeverityWarning
+	// Failing check blocks the rollout
+	SeverityCritical
+)
+
+func (s Severity) String() string {
+	switch s {
+	case SeverityInfo:
+		return "info"
+	case SeverityWarning:
+		return "warning"
+	case SeverityCritical:
+		return "critical"
+	default:
+		return fmt.Sprintf("severity(%d)", int(s))
+	}
+}
+
+// Parses severities of the form info, warning or critical
+func ParseSeverity(severity string) (Severity, error) {
+	switch strings.ToLower(severity) {
+	case "info":
+		return SeverityInfo, nil
+	case "warning":
+		return SeverityWarning, nil
+	case "critical":
+		return SeverityCritical, nil
+	default:
+		return SeverityInfo, fmt.Errorf("Unable to parse severity %s", severity)
+	}
+}
+
+// Categories checks are grouped by
+const (
+	CategoryCapacity      = "Capacity"
+	CategoryHealth        = "Health"
+	CategoryWorkloads     = "Workloads"
+	CategoryCompatibility = "Compatibility"
+)
+
+type Status string
+
+const (
+	StatusPass Status = "pass"
+	StatusFail Status = "fail"
+	// Check couldn't be run, e.g. an aws call failed
+	StatusError Status = "error"
+	// Check is disabled in the config
+	StatusSkipped Status = "skipped"
+)
+
+// Outcome of a single run of a check
+type Result struct {
+	Status Status
+	// One line summary of the outcome
+	Message string
+	// Findings backing the outcome, e.g. subnets running out of ips. The
+	// first row is the header.
+	Details [][]string
+}
+
+// Returns a passing result
+func Pass(message string, details [][]string) Result {
+	return Result{Status: StatusPass, Message: message, Details: details}
+}
+
+// Returns a failing result
+func Fail(message string, details [][]string) Result {
+	return Result{Status: StatusFail, Message: message, Details: details}
+}
+
+type PreflightCheck interface {
+
+	// Unique name of the check, it is the key of the check in
+	// PREFLIGHT.CHECKS of config.yaml
+	Name() string
+
+	// Category the check is grouped by
+	Category() string
+
+	// Default severity of the check if it fails
+	Severity() Severity
+
+	// Hint on how to fix the cluster if the check fails
+	Remediation() string
+
+	// Runs the check against the cluster of env. config holds the
+	// settings of the check, e.g. its threshold
+	Run(ctx context.Context, env *Env, config CheckConfig) (Result, error)
+}
+
+// Clients of the cluster the checks are run against
+type Env struct {
+	Kube           kube.KubeClient
+	Asg            aws.AsgRolloutClient
+	Eks            aws.AwsEksClient
+	ClusterName    string
+	IgnoreNotFound bool
+	// Asg about to be rolled, checks of asgs cover all asgs of the
+	// cluster if empty
+	AsgName string
+	// Nodes rolled per batch, defaults to 1
+	BatchSize int64
+	// Registry patterns images may be pulled from besides the private
+	// registry
+	AllowedRegistries []string
+	// How the rollout coordinates with cluster-autoscaler, none if empty
+	ClusterAutoscalerMode string
+
+	nodesOnce sync.Once
+	nodes     []string
+	nodesErr  error
+}
+
+// Returns names of the nodes of old instances in the asg about to be rolled,
+// or in all asgs of the cluster if no asg is set. Nodes are looked up once
+// and shared by all checks of a run.
+func (env *Env) NodesToRollout() ([]string, error) {
+	env.nodesOnce.Do(func() {
+		if len(env.AsgName) != 0 {
+			env.nodes, env.nodesErr = env.Asg.GetNodesToRolloutOfAsg(env.AsgName)
+			return
+		}
+		env.nodes, env.nodesErr = env.Asg.GetNodesToRollout(env.ClusterName)
+	})
+	return env.nodes, env.nodesErr
+}
+
+// Returns the asg about to be rolled or all asgs of the cluster
+func (env *Env) AsgNames() ([]string, error) {
+	if len(env.AsgName) != 0 {
+		return []string{env.AsgName}, nil
+	}
+	return env.Asg.ListAsgsOfEks(env.ClusterName)
+}
+
+// Returns nodes rolled per batch
+func (env *Env) Batch() int64 {
+	if env.BatchSize < 1 {
+		return 1
+	}
+	return env.BatchSize
+}
+
+var (
+	registry     = []PreflightCheck{}
+	registryLock sync.RWMutex
+)
+
+// Registers the check, it is run by all runners created afterwards.
+// Panics if a check with the same name is already registered.
+func Register(check PreflightCheck) {
+	registryLock.Lock()
+	defer registryLock.Unlock()
+	for _, registered := range registry {
+		if registered.Name() == check.Name() {
+			panic(fmt.Sprintf("preflight check %s is already registered", check.Name()))
+		}
+	}
+	registry = append(registry, check)
+}
+
+// Returns all registered checks in the order they were registered
+func Checks() []PreflightCheck {
+	registryLock.RLock()
+	defer registryLock.RUnlock()
+	return append([]PreflightCheck{}, registry...)
+}
+
+func lookup(name string) (PreflightCheck, bool) {
+	for _, check := range Checks() {
+		if check.Name() == name {
+			return check, true
+		}
+	}
+	return nil, false
+}
+
+// Outcome of a check along with the check's metadata
+type CheckResult struct {
+	Name        string
+	Category    string
+	Severity    Severity
+	Remediation string
+	Result
+	StartedAt  time.Time
+	FinishedAt time.Time
+}
+
+// Overall outcome of all checks
+type Verdict string
+
+const (
+	VerdictPass Verdict = "pass"
+	// Some checks failed, the rollout can still be started
+	VerdictWarn Verdict = "warn"
+	// Checks of at least PREFLIGHT.BLOCK_ON severity failed, the rollout
+	// must not be started
+	VerdictBlock Verdict = "block"
+)
+
+type Report struct {
+	// Name of the cluster the checks were run against
+	ClusterName string
+	// Asg about to be rolled, empty if all asgs of the cluster were checked
+	AsgName    string
+	Results    []CheckResult
+	Verdict    Verdict
+	StartedAt  time.Time
+	FinishedAt time.Time
+}
+
+// Returns failed checks, including checks which couldn't be run, of at
+// least the provided severity
+func (r Report) Failed(severity Severity) []CheckResult {
+	failed := make([]CheckResult, 0)
+	for _, result := range r.Results {
+		if result.Severity < severity {
+			continue
+		}
+		if result.Status == StatusFail || result.Status == StatusError {
+			failed = append(failed, result)
+		}
+	}
+	return failed
+}
+
+// Returns names and messages of the failed checks of at least the
+// provided severity
+func (r Report) Summary(severity Severity) string {
+	lines := make([]string, 0)
+	for _, result := range r.Failed(severity) {
+		lines = append(
+			lines,
+			fmt.Sprintf("%s ( %s ): %s", result.Name, result.Severity, result.Message),
+		)
+	}
+	return strings.Join(lines, "\n")
+}
+
+// Runs the registered checks with the settings of the config
+type Runner struct {
+	checks []PreflightCheck
+	config Config
+}
+
+// Creates a runner for all checks registered so far. config is expected
+// to be validated, nil runs all checks with their defaults.
+func NewRunner(config *Config) *Runner {
+	runner := &Runner{checks: Checks()}
+	if config != nil {
+		runner.config = *config
+	}
+	return runner
+}
+
+// Runs all enabled checks concurrently and returns their results in the
+// order the checks were registered
+func (r *Runner) Run(ctx context.Context, env *Env) Report {
+	startedAt := time.Now()
+	results := make([]CheckResult, len(r.checks))
+
+	var w sync.WaitGroup
+	for i, check := range r.checks {
+		checkConfig := r.config.Checks[check.Name()]
+		results[i] = CheckResult{
+			Name:        check.Name(),
+			Category:    check.Category(),
+			Severity:    checkConfig.severityOr(check.Severity()),
+			Remediation: check.Remediation(),
+		}
+		if !checkConfig.enabled() {
+			results[i].Result = Result{
+				Status:  StatusSkipped,
+				Message: "Disabled in config",
+			}
+			results[i].StartedAt = startedAt
+			results[i].FinishedAt = startedAt
+			continue
+		}
+
+		w.Add(1)
+		go func(i int, check PreflightCheck, checkConfig CheckConfig) {
+			defer w.Done()
+			results[i].StartedAt = time.Now()
+			result, err := check.Run(ctx, env, checkConfig)
+			if err != nil {
+				result = Result{Status: StatusError, Message: err.Error()}
+			}
+			results[i].Result = result
+			results[i].FinishedAt = time.Now()
+		}(i, check, checkConfig)
+	}
+	w.Wait()
+
+	return Report{
+		ClusterName: env.ClusterName,
+		AsgName:     env.AsgName,
+		Results:     results,
+		Verdict:     verdictOf(results, r.config.blockOn()),
+		StartedAt:   startedAt,
+		FinishedAt:  time.Now(),
+	}
+}
+
+// Failed checks of at least blockOn severity block the rollout, all other
+// failed checks except informative ones warn. A nil blockOn never blocks.
+func verdictOf(results []CheckResult, blockOn *Severity) Verdict {
+	verdict := VerdictPass
+	for _, result := range results {
+		if result.Status != StatusFail && result.Status != StatusError {
+			continue
+		}
+		if blockOn != nil && result.Severity >= *blockOn {
+			return VerdictBlock
+		}
+		if result.Severity > SeverityInfo {
+			verdict = VerdictWarn
+		}
+	}
+	return verdict
+}
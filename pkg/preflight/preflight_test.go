@@ -0,0 +1,929 @@
+package preflight
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"dockyard/pkg/aws"
+	"dockyard/pkg/aws/fake"
+	"dockyard/pkg/kube"
+
+	admissionv1 "k8s.io/api/admissionregistration/v1"
+	appsv1 "k8s.io/api/apps/v1"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	policy "k8s.io/api/policy/v1beta1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/apimachinery/pkg/version"
+	fakediscovery "k8s.io/client-go/discovery/fake"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
+	k8sfake "k8s.io/client-go/kubernetes/fake"
+)
+
+func staticCheck(name string, severity Severity, status Status) PreflightCheck {
+	return NewCheck(
+		name,
+		CategoryHealth,
+		severity,
+		"",
+		func(ctx context.Context, env *Env, config CheckConfig) (Result, error) {
+			if status == StatusError {
+				return Result{}, errors.New("unreachable")
+			}
+			return Result{Status: status}, nil
+		},
+	)
+}
+
+func TestRunnerVerdict(t *testing.T) {
+	disabled := false
+	tests := []struct {
+		name   string
+		checks []PreflightCheck
+		config Config
+		want   Verdict
+	}{
+		{
+			name: "all checks pass",
+			checks: []PreflightCheck{
+				staticCheck("a", SeverityCritical, StatusPass),
+				staticCheck("b", SeverityWarning, StatusPass),
+			},
+			want: VerdictPass,
+		},
+		{
+			name: "failed info check",
+			checks: []PreflightCheck{
+				staticCheck("a", SeverityInfo, StatusFail),
+			},
+			want: VerdictPass,
+		},
+		{
+			name: "failed warning check",
+			checks: []PreflightCheck{
+				staticCheck("a", SeverityWarning, StatusFail),
+				staticCheck("b", SeverityCritical, StatusPass),
+			},
+			want: VerdictWarn,
+		},
+		{
+			name: "critical check couldn't run",
+			checks: []PreflightCheck{
+				staticCheck("a", SeverityCritical, StatusError),
+			},
+			want: VerdictBlock,
+		},
+		{
+			name: "block on warnings",
+			checks: []PreflightCheck{
+				staticCheck("a", SeverityWarning, StatusFail),
+			},
+			config: Config{BlockOn: "warning"},
+			want:   VerdictBlock,
+		},
+		{
+			name: "never block",
+			checks: []PreflightCheck{
+				staticCheck("a", SeverityCritical, StatusFail),
+			},
+			config: Config{BlockOn: "never"},
+			want:   VerdictWarn,
+		},
+		{
+			name: "severity lowered in config",
+			checks: []PreflightCheck{
+				staticCheck("a", SeverityCritical, StatusFail),
+			},
+			config: Config{Checks: map[string]CheckConfig{
+				"a": {Severity: "warning"},
+			}},
+			want: VerdictWarn,
+		},
+		{
+			name: "check disabled in config",
+			checks: []PreflightCheck{
+				staticCheck("a", SeverityCritical, StatusFail),
+			},
+			config: Config{Checks: map[string]CheckConfig{
+				"a": {Enabled: &disabled},
+			}},
+			want: VerdictPass,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			runner := &Runner{checks: test.checks, config: test.config}
+			report := runner.Run(context.Background(), &Env{})
+			if report.Verdict != test.want {
+				t.Errorf("Verdict is %s, want %s", report.Verdict, test.want)
+			}
+			if len(report.Results) != len(test.checks) {
+				t.Errorf(
+					"Report has %d results, want %d",
+					len(report.Results),
+					len(test.checks),
+				)
+			}
+		})
+	}
+}
+
+func TestReportExitCode(t *testing.T) {
+	tests := []struct {
+		name   string
+		checks []PreflightCheck
+		want   int
+	}{
+		{
+			name:   "all checks pass",
+			checks: []PreflightCheck{staticCheck("a", SeverityCritical, StatusPass)},
+			want:   ExitPass,
+		},
+		{
+			name:   "failed info check",
+			checks: []PreflightCheck{staticCheck("a", SeverityInfo, StatusFail)},
+			want:   ExitInfo,
+		},
+		{
+			name: "worst severity wins",
+			checks: []PreflightCheck{
+				staticCheck("a", SeverityWarning, StatusFail),
+				staticCheck("b", SeverityCritical, StatusError),
+				staticCheck("c", SeverityInfo, StatusFail),
+			},
+			want: ExitCritical,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			runner := &Runner{checks: test.checks}
+			report := runner.Run(context.Background(), &Env{})
+			if code := report.ExitCode(); code != test.want {
+				t.Errorf("Exit code is %d, want %d", code, test.want)
+			}
+		})
+	}
+}
+
+func TestWriteReport(t *testing.T) {
+	started := time.Date(2022, 9, 14, 10, 0, 0, 0, time.UTC)
+	report := Report{
+		ClusterName: "test-cluster",
+		Verdict:     VerdictWarn,
+		StartedAt:   started,
+		FinishedAt:  started.Add(2 * time.Second),
+		Results: []CheckResult{
+			{
+				Name:        "available-ips",
+				Category:    CategoryCapacity,
+				Severity:    SeverityWarning,
+				Remediation: "Add subnets",
+				Result: Fail("1 subnet runs out of ips", [][]string{
+					{"Subnet", "Available"},
+					{"subnet-a|b", "3"},
+				}),
+				StartedAt:  started,
+				FinishedAt: started.Add(1500 * time.Millisecond),
+			},
+			{
+				Name:       "node-health",
+				Category:   CategoryHealth,
+				Severity:   SeverityCritical,
+				Result:     Pass("All nodes are Ready", nil),
+				StartedAt:  started,
+				FinishedAt: started.Add(time.Second),
+			},
+			{
+				Name:     "pdbs",
+				Category: CategoryWorkloads,
+				Severity: SeverityWarning,
+				Result:   Result{Status: StatusSkipped, Message: "Disabled in config"},
+			},
+		},
+	}
+
+	var output bytes.Buffer
+	if err := WriteReport(&output, report, FormatJSON); err != nil {
+		t.Fatalf("WriteReport json failed, %s", err.Error())
+	}
+	var decoded jsonReport
+	if err := json.Unmarshal(output.Bytes(), &decoded); err != nil {
+		t.Fatalf("Json report doesn't decode, %s", err.Error())
+	}
+	if decoded.ExitCode != ExitWarning || len(decoded.Checks) != 3 {
+		t.Errorf("Json report has exit code %d and %d checks, want %d and 3", decoded.ExitCode, len(decoded.Checks), ExitWarning)
+	}
+	if check := decoded.Checks[0]; check.Severity != "warning" ||
+		!reflect.DeepEqual(check.Columns, []string{"Subnet", "Available"}) ||
+		!reflect.DeepEqual(check.Rows, [][]string{{"subnet-a|b", "3"}}) {
+		t.Errorf("Json check is %+v", check)
+	}
+
+	output.Reset()
+	if err := WriteReport(&output, report, FormatJUnit); err != nil {
+		t.Fatalf("WriteReport junit failed, %s", err.Error())
+	}
+	var suites junitTestSuites
+	if err := xml.Unmarshal(output.Bytes(), &suites); err != nil {
+		t.Fatalf("Junit report doesn't decode, %s", err.Error())
+	}
+	if suites.Tests != 3 || suites.Failures != 1 || suites.Skipped != 1 || len(suites.Suites) != 3 {
+		t.Errorf("Junit report is %+v, want 3 tests in 3 suites, 1 failure and 1 skipped", suites)
+	}
+	if failure := suites.Suites[0].Cases[0].Failure; failure == nil ||
+		failure.Type != "warning" || suites.Suites[0].Cases[0].Time != "1.500" {
+		t.Errorf("Junit test case is %+v", suites.Suites[0].Cases[0])
+	}
+
+	output.Reset()
+	if err := WriteReport(&output, report, FormatMarkdown); err != nil {
+		t.Fatalf("WriteReport markdown failed, %s", err.Error())
+	}
+	for _, want := range []string{
+		"| available-ips | Capacity | warning | fail | 1 subnet runs out of ips |",
+		"| subnet-a\\|b | 3 |",
+		"> Add subnets",
+	} {
+		if !strings.Contains(output.String(), want) {
+			t.Errorf("Markdown report misses %q\n%s", want, output.String())
+		}
+	}
+
+	if err := WriteReport(&output, report, "yaml"); err == nil {
+		t.Error("WriteReport of an unknown format succeeded")
+	}
+}
+
+func TestConfigValidate(t *testing.T) {
+	valid := &Config{Checks: map[string]CheckConfig{"available-ips": {}}}
+	if err := valid.Validate(); err != nil {
+		t.Errorf("Validate of a registered check failed, %s", err.Error())
+	}
+	invalid := &Config{Checks: map[string]CheckConfig{"available-ip": {}}}
+	if err := invalid.Validate(); err == nil {
+		t.Error("Validate of an unknown check succeeded")
+	}
+}
+
+func TestAvailableIpsThreshold(t *testing.T) {
+	cloud := fake.NewCloud()
+	cloud.AddCluster("test-cluster", "vpc-0123")
+	cloud.AddSubnet("vpc-0123", "subnet-a", "us-east-1a", 120)
+	cloud.AddSubnet("vpc-0123", "subnet-b", "us-east-1b", 8)
+	env := &Env{
+		Eks: aws.NewAwsEKSWithClients(
+			"test-cluster",
+			cloud.EKS(),
+			cloud.EC2(),
+			cloud.STS(),
+			cloud.ServiceQuotas(),
+		),
+	}
+
+	result, err := checkAvailableIps(context.Background(), env, CheckConfig{})
+	if err != nil {
+		t.Fatalf("checkAvailableIps failed, %s", err.Error())
+	}
+	if result.Status != StatusFail {
+		t.Errorf("Subnet with 8 ips passed the default threshold")
+	}
+	if len(result.Details) != 3 {
+		t.Errorf("Details have %d rows, want header and 2 subnets", len(result.Details))
+	}
+
+	threshold := 8
+	result, err = checkAvailableIps(
+		context.Background(),
+		env,
+		CheckConfig{Threshold: &threshold},
+	)
+	if err != nil {
+		t.Fatalf("checkAvailableIps failed, %s", err.Error())
+	}
+	if result.Status != StatusPass {
+		t.Errorf("Subnet with 8 ips failed the threshold of 8, %s", result.Message)
+	}
+}
+
+func TestSurgeCapacity(t *testing.T) {
+	cloud := fake.NewCloud()
+	cloud.AddCluster("test-cluster", "vpc-0123")
+	cloud.AddSubnet("vpc-0123", "subnet-a", "us-east-1a", 40)
+	cloud.AddInstanceType("m5.xlarge", 4, 4, 15)
+	cloud.AddImage("ami-old", "eks-node-old")
+	cloud.AddLaunchTemplateVersion("lt-0123", "ami-old")
+	cloud.SetLaunchTemplateInstanceType("lt-0123", "m5.xlarge")
+	cloud.AddAsg(fake.AsgSpec{
+		Name:             "test-asg",
+		LaunchTemplateId: "lt-0123",
+		MinSize:          1,
+		MaxSize:          3,
+		DesiredCapacity:  2,
+		SubnetIds:        []string{"subnet-a", "subnet-b"},
+	})
+	cloud.SetQuota(aws.EC2OnDemandServiceQuotaCode, 16)
+	env := &Env{
+		Asg: aws.NewAsgRolloutWithClients(cloud.AutoScaling(), cloud.EC2(), nil, nil),
+		Eks: aws.NewAwsEKSWithClients(
+			"test-cluster",
+			cloud.EKS(),
+			cloud.EC2(),
+			cloud.STS(),
+			cloud.ServiceQuotas(),
+		),
+		AsgName: "test-asg",
+	}
+
+	// 8 vCPUs are running, a m5.xlarge takes up 60 ips
+	result, err := checkSurgeCapacity(context.Background(), env, CheckConfig{})
+	if err != nil {
+		t.Fatalf("checkSurgeCapacity failed, %s", err.Error())
+	}
+	if result.Status != StatusFail {
+		t.Errorf("Surge passed with 40 free ips in the subnets of the asg")
+	}
+
+	cloud.AddSubnet("vpc-0123", "subnet-b", "us-east-1b", 120)
+	result, err = checkSurgeCapacity(context.Background(), env, CheckConfig{})
+	if err != nil {
+		t.Fatalf("checkSurgeCapacity failed, %s", err.Error())
+	}
+	if result.Status != StatusPass {
+		t.Errorf("Surge of 1 node failed, %s", result.Message)
+	}
+
+	env.BatchSize = 3
+	result, err = checkSurgeCapacity(context.Background(), env, CheckConfig{})
+	if err != nil {
+		t.Fatalf("checkSurgeCapacity failed, %s", err.Error())
+	}
+	if result.Status != StatusFail {
+		t.Errorf("Surge of 12 vCPUs passed with 8 free vCPUs")
+	}
+
+	// GPU instances don't count against the standard quota, their quota
+	// is unknown
+	cloud.AddInstanceType("g4dn.xlarge", 4, 3, 10)
+	version := cloud.AddLaunchTemplateVersion("lt-0123", "ami-old")
+	cloud.SetLaunchTemplateVersionInstanceType("lt-0123", version, "g4dn.xlarge")
+	result, err = checkSurgeCapacity(context.Background(), env, CheckConfig{})
+	if err != nil {
+		t.Fatalf("checkSurgeCapacity failed, %s", err.Error())
+	}
+	if result.Status != StatusPass {
+		t.Errorf("Surge of 12 g4dn vCPUs was checked against the standard quota, %s", result.Message)
+	}
+	if free := result.Details[1][5]; free != "unknown" {
+		t.Errorf("Free vCPUs of a g4dn.xlarge are %s, want unknown", free)
+	}
+}
+
+func TestVersionSkew(t *testing.T) {
+	clientSet := k8sfake.NewSimpleClientset(
+		&corev1.Node{
+			ObjectMeta: metav1.ObjectMeta{Name: "node-a"},
+			Status: corev1.NodeStatus{NodeInfo: corev1.NodeSystemInfo{
+				KubeletVersion: "v1.22.12-eks-ba74326",
+			}},
+		},
+	)
+	clientSet.Discovery().(*fakediscovery.FakeDiscovery).FakedServerVersion = &version.Info{
+		GitVersion: "v1.23.10-eks-15b7512",
+	}
+
+	cloud := fake.NewCloud()
+	cloud.AddImage("ami-new", "amazon-eks-node-1.24-v20221027")
+	cloud.AddLaunchTemplateVersion("lt-0123", "ami-new")
+	cloud.AddAsg(fake.AsgSpec{
+		Name:             "test-asg",
+		LaunchTemplateId: "lt-0123",
+		MaxSize:          1,
+	})
+	env := &Env{
+		Kube:    kube.NewKubeClientWithClientSet(clientSet, "", false, "test-cluster"),
+		Asg:     aws.NewAsgRolloutWithClients(cloud.AutoScaling(), cloud.EC2(), nil, nil),
+		AsgName: "test-asg",
+	}
+
+	result, err := checkVersionSkew(context.Background(), env, CheckConfig{})
+	if err != nil {
+		t.Fatalf("checkVersionSkew failed, %s", err.Error())
+	}
+	if result.Status != StatusFail {
+		t.Errorf("Target ami of 1.24 passed control plane 1.23")
+	}
+	if len(result.Details) != 3 {
+		t.Errorf("Details have %d rows, want header, node and target ami", len(result.Details))
+	}
+}
+
+func TestNextVersionStep(t *testing.T) {
+	tests := []struct {
+		controlPlane minorVersion
+		oldest       minorVersion
+		ahead        bool
+		tooOld       bool
+		want         string
+	}{
+		{
+			controlPlane: minorVersion{1, 23},
+			oldest:       minorVersion{1, 23},
+			ahead:        true,
+			want:         "upgrade the control plane to 1.24 before rolling newer amis",
+		},
+		{
+			controlPlane: minorVersion{1, 24},
+			oldest:       minorVersion{1, 21},
+			tooOld:       true,
+			want:         "roll the nodes to amis of 1.22 to 1.24",
+		},
+		{
+			controlPlane: minorVersion{1, 24},
+			oldest:       minorVersion{1, 22},
+			want:         "roll the nodes to amis of 1.23 or newer before upgrading the control plane to 1.25",
+		},
+		{
+			controlPlane: minorVersion{1, 27},
+			oldest:       minorVersion{1, 25},
+			want:         "control plane can be upgraded to 1.28 next",
+		},
+	}
+
+	for _, test := range tests {
+		got := nextVersionStep(test.controlPlane, test.oldest, test.ahead, test.tooOld)
+		if got != test.want {
+			t.Errorf("Next step of %s is %q, want %q", test.controlPlane, got, test.want)
+		}
+	}
+}
+
+func TestDeprecatedApis(t *testing.T) {
+	clientSet := k8sfake.NewSimpleClientset()
+	clientSet.Resources = []*metav1.APIResourceList{{
+		GroupVersion: "networking.k8s.io/v1",
+		APIResources: []metav1.APIResource{{Name: "ingresses", Kind: "Ingress"}},
+	}}
+	ingress := func(name, appliedApiVersion string) *unstructured.Unstructured {
+		object := &unstructured.Unstructured{}
+		object.SetAPIVersion("networking.k8s.io/v1")
+		object.SetKind("Ingress")
+		object.SetNamespace("web")
+		object.SetName(name)
+		object.SetAnnotations(map[string]string{
+			"kubectl.kubernetes.io/last-applied-configuration": `{"apiVersion":"` + appliedApiVersion + `","kind":"Ingress"}`,
+		})
+		return object
+	}
+	dynamicClient := dynamicfake.NewSimpleDynamicClientWithCustomListKinds(
+		runtime.NewScheme(),
+		map[schema.GroupVersionResource]string{
+			{Group: "networking.k8s.io", Version: "v1", Resource: "ingresses"}: "IngressList",
+		},
+		ingress("legacy", "extensions/v1beta1"),
+		ingress("current", "networking.k8s.io/v1"),
+	)
+	env := &Env{
+		Kube: kube.NewKubeClientWithClients(clientSet, dynamicClient, "", false, "test-cluster"),
+	}
+
+	result, err := checkDeprecatedApis(
+		context.Background(),
+		env,
+		CheckConfig{TargetVersion: "1.22"},
+	)
+	if err != nil {
+		t.Fatalf("checkDeprecatedApis failed, %s", err.Error())
+	}
+	if result.Status != StatusFail {
+		t.Errorf("Ingress applied with extensions/v1beta1 passed target 1.22")
+	}
+	if len(result.Details) != 2 || result.Details[1][2] != "legacy" {
+		t.Errorf("Details are %v, want header and the legacy ingress", result.Details)
+	}
+
+	result, err = checkDeprecatedApis(
+		context.Background(),
+		env,
+		CheckConfig{TargetVersion: "1.21"},
+	)
+	if err != nil {
+		t.Fatalf("checkDeprecatedApis failed, %s", err.Error())
+	}
+	if result.Status != StatusPass {
+		t.Errorf("Ingress applied with extensions/v1beta1 failed target 1.21, %s", result.Message)
+	}
+}
+
+// Returns a deployment along with its replica set and a pod per node
+func testDeployment(name string, replicas int32, nodeNames ...string) []runtime.Object {
+	isController := true
+	selector := &metav1.LabelSelector{MatchLabels: map[string]string{"app": name}}
+	objects := []runtime.Object{
+		&appsv1.Deployment{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:        name,
+				Namespace:   "default",
+				Annotations: map[string]string{kube.OwnerAnnotationKey: "team-" + name},
+			},
+			Spec: appsv1.DeploymentSpec{Replicas: &replicas, Selector: selector},
+		},
+		&appsv1.ReplicaSet{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      name + "-5d8f7c",
+				Namespace: "default",
+				OwnerReferences: []metav1.OwnerReference{
+					{Kind: "Deployment", Name: name, Controller: &isController},
+				},
+			},
+		},
+	}
+	for _, nodeName := range nodeNames {
+		objects = append(objects, &corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      name + "-5d8f7c-" + nodeName,
+				Namespace: "default",
+				Labels:    map[string]string{"app": name},
+				OwnerReferences: []metav1.OwnerReference{
+					{Kind: "ReplicaSet", Name: name + "-5d8f7c", Controller: &isController},
+				},
+			},
+			Spec:   corev1.PodSpec{NodeName: nodeName},
+			Status: corev1.PodStatus{Phase: corev1.PodRunning},
+		})
+	}
+	return objects
+}
+
+func TestUnprotectedWorkloads(t *testing.T) {
+	cloud := fake.NewCloud()
+	cloud.AddImage("ami-old", "amazon-eks-node-1.22-v20220914")
+	cloud.AddImage("ami-new", "amazon-eks-node-1.23-v20221027")
+	cloud.AddLaunchTemplateVersion("lt-0123", "ami-old")
+	cloud.AddAsg(fake.AsgSpec{
+		Name:             "test-asg",
+		LaunchTemplateId: "lt-0123",
+		MaxSize:          3,
+		DesiredCapacity:  2,
+	})
+	cloud.AddLaunchTemplateVersion("lt-0123", "ami-new")
+
+	objects := []runtime.Object{}
+	oldNodes := []string{}
+	for _, instance := range cloud.Instances("test-asg") {
+		nodeName := instance.InstanceId + ".ec2.internal"
+		oldNodes = append(oldNodes, nodeName)
+		objects = append(objects, &corev1.Node{
+			ObjectMeta: metav1.ObjectMeta{Name: nodeName},
+			Spec:       corev1.NodeSpec{ProviderID: "aws:///us-east-1a/" + instance.InstanceId},
+		})
+	}
+	minAvailable := intstr.FromInt(1)
+	objects = append(objects, &policy.PodDisruptionBudget{
+		ObjectMeta: metav1.ObjectMeta{Name: "spread", Namespace: "default"},
+		Spec: policy.PodDisruptionBudgetSpec{
+			MinAvailable: &minAvailable,
+			Selector:     &metav1.LabelSelector{MatchLabels: map[string]string{"app": "spread"}},
+		},
+	})
+	objects = append(objects, testDeployment("single", 1, oldNodes[0])...)
+	objects = append(objects, testDeployment("spread", 2, oldNodes...)...)
+	objects = append(objects, testDeployment("elsewhere", 2, oldNodes[0], "other-node")...)
+
+	kubeClient := kube.NewKubeClientWithClientSet(
+		k8sfake.NewSimpleClientset(objects...),
+		"",
+		false,
+		"test-cluster",
+	)
+	env := &Env{
+		Kube:    kubeClient,
+		Asg:     aws.NewAsgRolloutWithClients(cloud.AutoScaling(), cloud.EC2(), kubeClient, nil),
+		AsgName: "test-asg",
+	}
+
+	result, err := checkUnprotectedWorkloads(context.Background(), env, CheckConfig{})
+	if err != nil {
+		t.Fatalf("checkUnprotectedWorkloads failed, %s", err.Error())
+	}
+	if result.Status != StatusFail {
+		t.Errorf("Single replica deployment on old nodes passed")
+	}
+	if len(result.Details) != 2 {
+		t.Fatalf("Details are %v, want header and the single replica deployment", result.Details)
+	}
+	if row := result.Details[1]; row[3] != "single" || row[4] != "team-single" {
+		t.Errorf("Details row is %v, want deployment single owned by team-single", row)
+	}
+}
+
+func TestNodesToRollout(t *testing.T) {
+	cloud := fake.NewCloud()
+	cloud.AddImage("ami-old", "amazon-eks-node-1.22-v20220914")
+	cloud.AddImage("ami-new", "amazon-eks-node-1.23-v20221027")
+	for _, asgName := range []string{"test-asg", "other-asg"} {
+		cloud.AddLaunchTemplateVersion("lt-"+asgName, "ami-old")
+		cloud.AddAsg(fake.AsgSpec{
+			Name:             asgName,
+			LaunchTemplateId: "lt-" + asgName,
+			MaxSize:          1,
+			DesiredCapacity:  1,
+			Tags:             map[string]string{"kubernetes.io/cluster/test-cluster": "owned"},
+		})
+		cloud.AddLaunchTemplateVersion("lt-"+asgName, "ami-new")
+	}
+
+	objects := []runtime.Object{}
+	nodeOf := map[string]string{}
+	for _, asgName := range []string{"test-asg", "other-asg"} {
+		instance := cloud.Instances(asgName)[0]
+		nodeOf[asgName] = instance.InstanceId + ".ec2.internal"
+		objects = append(objects, &corev1.Node{
+			ObjectMeta: metav1.ObjectMeta{Name: nodeOf[asgName]},
+			Spec:       corev1.NodeSpec{ProviderID: "aws:///us-east-1a/" + instance.InstanceId},
+		})
+	}
+	kubeClient := kube.NewKubeClientWithClientSet(
+		k8sfake.NewSimpleClientset(objects...),
+		"",
+		false,
+		"test-cluster",
+	)
+	defer kubeClient.Close()
+	asgClient := aws.NewAsgRolloutWithClients(cloud.AutoScaling(), cloud.EC2(), kubeClient, nil)
+
+	// The rollout gate only looks at the asg about to be rolled
+	env := &Env{Kube: kubeClient, Asg: asgClient, ClusterName: "test-cluster", AsgName: "test-asg"}
+	nodes, err := env.NodesToRollout()
+	if err != nil {
+		t.Fatalf("NodesToRollout failed, %s", err.Error())
+	}
+	if want := []string{nodeOf["test-asg"]}; !reflect.DeepEqual(nodes, want) {
+		t.Errorf("Nodes to rollout of asg test-asg are %v, want %v", nodes, want)
+	}
+
+	env = &Env{Kube: kubeClient, Asg: asgClient, ClusterName: "test-cluster"}
+	nodes, err = env.NodesToRollout()
+	if err != nil {
+		t.Fatalf("NodesToRollout failed, %s", err.Error())
+	}
+	if len(nodes) != 2 {
+		t.Errorf("Nodes to rollout of the cluster are %v, want the nodes of both asgs", nodes)
+	}
+}
+
+func TestPublicImages(t *testing.T) {
+	isController := true
+	podSpec := func(images ...string) corev1.PodSpec {
+		spec := corev1.PodSpec{}
+		for i, image := range images {
+			spec.Containers = append(spec.Containers, corev1.Container{
+				Name:  "c" + strconv.Itoa(i),
+				Image: image,
+			})
+		}
+		return spec
+	}
+	web := podSpec("nginx:1.23", "nginx:1.23", "registry.example.com/web:v2")
+	web.InitContainers = []corev1.Container{
+		{Name: "setup", Image: "123456789012.dkr.ecr.us-east-1.amazonaws.com/setup:v1"},
+	}
+	debugged := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "web-5d8f7c-x2l9k",
+			Namespace: "default",
+			OwnerReferences: []metav1.OwnerReference{
+				{Kind: "ReplicaSet", Name: "web-5d8f7c", Controller: &isController},
+			},
+		},
+		Spec: podSpec("nginx:1.23"),
+	}
+	debugged.Spec.EphemeralContainers = []corev1.EphemeralContainer{{
+		EphemeralContainerCommon: corev1.EphemeralContainerCommon{
+			Name:  "debugger",
+			Image: "busybox",
+		},
+	}}
+	clientSet := k8sfake.NewSimpleClientset(
+		&appsv1.Deployment{
+			ObjectMeta: metav1.ObjectMeta{Name: "web", Namespace: "default"},
+			Spec:       appsv1.DeploymentSpec{Template: corev1.PodTemplateSpec{Spec: web}},
+		},
+		&batchv1.CronJob{
+			ObjectMeta: metav1.ObjectMeta{Name: "report", Namespace: "jobs"},
+			Spec: batchv1.CronJobSpec{JobTemplate: batchv1.JobTemplateSpec{
+				Spec: batchv1.JobSpec{Template: corev1.PodTemplateSpec{
+					Spec: podSpec("mirror.example.com/dockerhub/library/python:3.10"),
+				}},
+			}},
+		},
+		debugged,
+	)
+	env := &Env{
+		Kube: kube.NewKubeClientWithClientSet(
+			clientSet,
+			"registry.example.com",
+			false,
+			"test-cluster",
+		),
+		AllowedRegistries: []string{
+			"*.dkr.ecr.*.amazonaws.com",
+			"/^mirror\\.example\\.com/dockerhub//",
+		},
+	}
+
+	result, err := checkPublicImages(context.Background(), env, CheckConfig{})
+	if err != nil {
+		t.Fatalf("checkPublicImages failed, %s", err.Error())
+	}
+	want := [][]string{
+		{"Image", "Kind", "Namespace", "Workload", "Containers"},
+		{"busybox", "Pod", "default", "web-5d8f7c-x2l9k", "ephemeral:debugger"},
+		{"nginx:1.23", "Deployment", "default", "web", "c0,c1"},
+	}
+	if !reflect.DeepEqual(result.Details, want) {
+		t.Errorf("Details are %v, want %v", result.Details, want)
+	}
+	if result.Status != StatusFail {
+		t.Errorf("Public images passed the default threshold")
+	}
+}
+
+func TestWebhookBackends(t *testing.T) {
+	ignore := admissionv1.Ignore
+	oldNode := "ip-10-0-1-12.ec2.internal"
+	clientSet := k8sfake.NewSimpleClientset(
+		&admissionv1.ValidatingWebhookConfiguration{
+			ObjectMeta: metav1.ObjectMeta{Name: "policy"},
+			Webhooks: []admissionv1.ValidatingWebhook{{
+				Name: "validate.policy.example.com",
+				ClientConfig: admissionv1.WebhookClientConfig{
+					Service: &admissionv1.ServiceReference{Namespace: "policy", Name: "webhook"},
+				},
+			}},
+		},
+		&admissionv1.MutatingWebhookConfiguration{
+			ObjectMeta: metav1.ObjectMeta{Name: "sidecar"},
+			Webhooks: []admissionv1.MutatingWebhook{{
+				Name:          "inject.sidecar.example.com",
+				FailurePolicy: &ignore,
+				ClientConfig: admissionv1.WebhookClientConfig{
+					Service: &admissionv1.ServiceReference{Namespace: "sidecar", Name: "injector"},
+				},
+			}},
+		},
+		&corev1.Endpoints{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "policy", Name: "webhook"},
+			Subsets: []corev1.EndpointSubset{{
+				Addresses: []corev1.EndpointAddress{{IP: "10.0.1.40", NodeName: &oldNode}},
+			}},
+		},
+	)
+	apiService := &unstructured.Unstructured{Object: map[string]interface{}{
+		"apiVersion": "apiregistration.k8s.io/v1",
+		"kind":       "APIService",
+		"metadata":   map[string]interface{}{"name": "v1beta1.metrics.k8s.io"},
+		"spec": map[string]interface{}{
+			"service": map[string]interface{}{"namespace": "kube-system", "name": "metrics-server"},
+		},
+		"status": map[string]interface{}{
+			"conditions": []interface{}{
+				map[string]interface{}{"type": "Available", "status": "False"},
+			},
+		},
+	}}
+	dynamicClient := dynamicfake.NewSimpleDynamicClientWithCustomListKinds(
+		runtime.NewScheme(),
+		map[schema.GroupVersionResource]string{
+			{Group: "apiregistration.k8s.io", Version: "v1", Resource: "apiservices"}: "APIServiceList",
+		},
+		apiService,
+	)
+	env := &Env{
+		Kube: kube.NewKubeClientWithClients(clientSet, dynamicClient, "", false, "test-cluster"),
+	}
+	env.nodesOnce.Do(func() { env.nodes = []string{oldNode} })
+
+	result, err := checkWebhookBackends(context.Background(), env, CheckConfig{})
+	if err != nil {
+		t.Fatalf("checkWebhookBackends failed, %s", err.Error())
+	}
+	want := [][]string{
+		{"Kind", "Name", "Service", "Ready Endpoints", "On Nodes to Roll", "Status"},
+		{"ValidatingWebhook", "policy/validate.policy.example.com", "policy/webhook", "1", "1", "✅"},
+		{"APIService", "v1beta1.metrics.k8s.io", "kube-system/metrics-server", "0", "0", "❌ no ready endpoints"},
+	}
+	if !reflect.DeepEqual(result.Details, want) {
+		t.Errorf("Details are %v, want %v", result.Details, want)
+	}
+	if result.Status != StatusFail {
+		t.Errorf("Unavailable APIService passed")
+	}
+
+	result, err = checkWebhookBackendsOnOldNodes(context.Background(), env, CheckConfig{})
+	if err != nil {
+		t.Fatalf("checkWebhookBackendsOnOldNodes failed, %s", err.Error())
+	}
+	if result.Status != StatusFail || result.Details[1][5] != "❌ only on nodes to roll" {
+		t.Errorf("Webhook with its only endpoint on an old node passed, %v", result.Details)
+	}
+}
+
+func TestClusterAutoscaler(t *testing.T) {
+	cloud := fake.NewCloud()
+	cloud.AddLaunchTemplateVersion("lt-0123", "ami-old")
+	cloud.AddAsg(fake.AsgSpec{
+		Name:             "discovered",
+		LaunchTemplateId: "lt-0123",
+		MaxSize:          3,
+		DesiredCapacity:  1,
+		Tags: map[string]string{
+			"k8s.io/cluster-autoscaler/enabled":      "true",
+			"k8s.io/cluster-autoscaler/test-cluster": "owned",
+		},
+	})
+	cloud.AddAsg(fake.AsgSpec{
+		Name:             "listed",
+		LaunchTemplateId: "lt-0123",
+		MaxSize:          3,
+		DesiredCapacity:  1,
+	})
+	cloud.AddAsg(fake.AsgSpec{
+		Name:             "unmanaged",
+		LaunchTemplateId: "lt-0123",
+		MaxSize:          3,
+		DesiredCapacity:  1,
+		Tags:             map[string]string{"k8s.io/cluster-autoscaler/enabled": "true"},
+	})
+
+	replicas := int32(1)
+	kubeClient := kube.NewKubeClientWithClientSet(
+		k8sfake.NewSimpleClientset(&appsv1.Deployment{
+			ObjectMeta: metav1.ObjectMeta{Name: "cluster-autoscaler", Namespace: "kube-system"},
+			Spec: appsv1.DeploymentSpec{
+				Replicas: &replicas,
+				Template: corev1.PodTemplateSpec{
+					Spec: corev1.PodSpec{
+						Containers: []corev1.Container{{
+							Name:  "cluster-autoscaler",
+							Image: "registry.k8s.io/autoscaling/cluster-autoscaler:v1.22.3",
+							Command: []string{
+								"./cluster-autoscaler",
+								"--nodes", "1:3:listed",
+								"--node-group-auto-discovery=asg:tag=k8s.io/cluster-autoscaler/enabled,k8s.io/cluster-autoscaler/test-cluster",
+							},
+						}},
+					},
+				},
+			},
+		}),
+		"",
+		false,
+		"test-cluster",
+	)
+
+	for _, test := range []struct {
+		asgName   string
+		mode      string
+		status    Status
+		managedBy string
+	}{
+		{"discovered", "", StatusFail, "--node-group-auto-discovery"},
+		{"listed", aws.ClusterAutoscalerModeNone, StatusFail, "--nodes"},
+		{"listed", aws.ClusterAutoscalerModeScaleToZero, StatusPass, "--nodes"},
+		{"unmanaged", "", StatusPass, ""},
+	} {
+		env := &Env{
+			Kube:                  kubeClient,
+			Asg:                   aws.NewAsgRolloutWithClients(cloud.AutoScaling(), cloud.EC2(), kubeClient, nil),
+			AsgName:               test.asgName,
+			ClusterAutoscalerMode: test.mode,
+		}
+		result, err := checkClusterAutoscaler(context.Background(), env, CheckConfig{})
+		if err != nil {
+			t.Fatalf("checkClusterAutoscaler failed, %s", err.Error())
+		}
+		if result.Status != test.status {
+			t.Errorf("Asg %s with mode %q has status %v, want %v", test.asgName, test.mode, result.Status, test.status)
+		}
+		managedBy := ""
+		if len(result.Details) > 1 {
+			managedBy = result.Details[1][3]
+		}
+		if managedBy != test.managedBy {
+			t.Errorf("Asg %s is managed by %q, want %q", test.asgName, managedBy, test.managedBy)
+		}
+	}
+}
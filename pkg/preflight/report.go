@@ -0,0 +1,338 @@
+package preflight
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+)
+
+// Formats reports can be written in
+const (
+	FormatJSON     = "json"
+	FormatJUnit    = "junit"
+	FormatMarkdown = "markdown"
+)
+
+// Exit codes of a report. Failed checks, including checks which couldn't
+// be run, exit with their worst severity, 1 is left to errors of dockyard.
+const (
+	ExitPass     = 0
+	ExitInfo     = 2
+	ExitWarning  = 3
+	ExitCritical = 4
+)
+
+// Returns the exit code following the worst severity of the failed checks
+func (r Report) ExitCode() int {
+	failed := r.Failed(SeverityInfo)
+	if len(failed) == 0 {
+		return ExitPass
+	}
+	worst := SeverityInfo
+	for _, result := range failed {
+		if result.Severity > worst {
+			worst = result.Severity
+		}
+	}
+	switch worst {
+	case SeverityCritical:
+		return ExitCritical
+	case SeverityWarning:
+		return ExitWarning
+	default:
+		return ExitInfo
+	}
+}
+
+// Writes the report in the format, one of json, junit or markdown
+func WriteReport(w io.Writer, report Report, format string) error {
+	switch format {
+	case FormatJSON:
+		return writeJSON(w, report)
+	case FormatJUnit:
+		return writeJUnit(w, report)
+	case FormatMarkdown:
+		return writeMarkdown(w, report)
+	default:
+		return ValidateFormat(format)
+	}
+}
+
+// Returns an error if reports can't be written in the format
+func ValidateFormat(format string) error {
+	switch format {
+	case FormatJSON, FormatJUnit, FormatMarkdown:
+		return nil
+	default:
+		return fmt.Errorf(
+			"Unable to write report in format %s, use %s, %s or %s",
+			format,
+			FormatJSON,
+			FormatJUnit,
+			FormatMarkdown,
+		)
+	}
+}
+
+type jsonReport struct {
+	Cluster    string      `json:"cluster"`
+	Asg        string      `json:"asg,omitempty"`
+	Verdict    Verdict     `json:"verdict"`
+	ExitCode   int         `json:"exit_code"`
+	StartedAt  time.Time   `json:"started_at"`
+	FinishedAt time.Time   `json:"finished_at"`
+	Checks     []jsonCheck `json:"checks"`
+}
+
+type jsonCheck struct {
+	Name        string    `json:"name"`
+	Category    string    `json:"category"`
+	Severity    string    `json:"severity"`
+	Status      Status    `json:"status"`
+	Message     string    `json:"message"`
+	Remediation string    `json:"remediation,omitempty"`
+	StartedAt   time.Time `json:"started_at"`
+	FinishedAt  time.Time `json:"finished_at"`
+	// Header of the details, each row holds a value per column
+	Columns []string   `json:"columns"`
+	Rows    [][]string `json:"rows"`
+}
+
+func writeJSON(w io.Writer, report Report) error {
+	output := jsonReport{
+		Cluster:    report.ClusterName,
+		Asg:        report.AsgName,
+		Verdict:    report.Verdict,
+		ExitCode:   report.ExitCode(),
+		StartedAt:  report.StartedAt,
+		FinishedAt: report.FinishedAt,
+		Checks:     make([]jsonCheck, 0, len(report.Results)),
+	}
+	for _, result := range report.Results {
+		columns, rows := splitDetails(result.Details)
+		output.Checks = append(output.Checks, jsonCheck{
+			Name:        result.Name,
+			Category:    result.Category,
+			Severity:    result.Severity.String(),
+			Status:      result.Status,
+			Message:     result.Message,
+			Remediation: result.Remediation,
+			StartedAt:   result.StartedAt,
+			FinishedAt:  result.FinishedAt,
+			Columns:     columns,
+			Rows:        rows,
+		})
+	}
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(output)
+}
+
+type junitTestSuites struct {
+	XMLName  xml.Name         `xml:"testsuites"`
+	Name     string           `xml:"name,attr"`
+	Tests    int              `xml:"tests,attr"`
+	Failures int              `xml:"failures,attr"`
+	Errors   int              `xml:"errors,attr"`
+	Skipped  int              `xml:"skipped,attr"`
+	Time     string           `xml:"time,attr"`
+	Suites   []junitTestSuite `xml:"testsuite"`
+}
+
+type junitTestSuite struct {
+	Name      string          `xml:"name,attr"`
+	Tests     int             `xml:"tests,attr"`
+	Failures  int             `xml:"failures,attr"`
+	Errors    int             `xml:"errors,attr"`
+	Skipped   int             `xml:"skipped,attr"`
+	Time      string          `xml:"time,attr"`
+	Timestamp string          `xml:"timestamp,attr"`
+	Cases     []junitTestCase `xml:"testcase"`
+}
+
+type junitTestCase struct {
+	Name      string        `xml:"name,attr"`
+	ClassName string        `xml:"classname,attr"`
+	Time      string        `xml:"time,attr"`
+	Failure   *junitProblem `xml:"failure,omitempty"`
+	Error     *junitProblem `xml:"error,omitempty"`
+	Skipped   *junitSkipped `xml:"skipped,omitempty"`
+	SystemOut string        `xml:"system-out,omitempty"`
+}
+
+type junitProblem struct {
+	Message string `xml:"message,attr"`
+	Type    string `xml:"type,attr"`
+	Text    string `xml:",chardata"`
+}
+
+type junitSkipped struct {
+	Message string `xml:"message,attr"`
+}
+
+// Writes a test suite per category and a test case per check. Failed
+// checks carry their severity as failure type.
+func writeJUnit(w io.Writer, report Report) error {
+	suites := junitTestSuites{
+		Name: "dockyard preflight " + report.ClusterName,
+		Time: seconds(report.StartedAt, report.FinishedAt),
+	}
+	suiteIndex := map[string]int{}
+	for _, result := range report.Results {
+		i, ok := suiteIndex[result.Category]
+		if !ok {
+			i = len(suites.Suites)
+			suiteIndex[result.Category] = i
+			suites.Suites = append(suites.Suites, junitTestSuite{
+				Name:      result.Category,
+				Timestamp: report.StartedAt.Format("2006-01-02T15:04:05"),
+			})
+		}
+		suite := &suites.Suites[i]
+
+		testCase := junitTestCase{
+			Name:      result.Name,
+			ClassName: "preflight." + result.Category,
+			Time:      seconds(result.StartedAt, result.FinishedAt),
+			SystemOut: result.Message,
+		}
+		switch result.Status {
+		case StatusFail:
+			testCase.Failure = &junitProblem{
+				Message: result.Message,
+				Type:    result.Severity.String(),
+				Text:    problemText(result),
+			}
+			suite.Failures++
+		case StatusError:
+			testCase.Error = &junitProblem{
+				Message: result.Message,
+				Type:    result.Severity.String(),
+				Text:    problemText(result),
+			}
+			suite.Errors++
+		case StatusSkipped:
+			testCase.Skipped = &junitSkipped{Message: result.Message}
+			suite.Skipped++
+		}
+		suite.Tests++
+		suite.Cases = append(suite.Cases, testCase)
+	}
+
+	for i := range suites.Suites {
+		suite := &suites.Suites[i]
+		var elapsed time.Duration
+		for _, result := range report.Results {
+			if result.Category == suite.Name {
+				elapsed += result.FinishedAt.Sub(result.StartedAt)
+			}
+		}
+		suite.Time = fmt.Sprintf("%.3f", elapsed.Seconds())
+		suites.Tests += suite.Tests
+		suites.Failures += suite.Failures
+		suites.Errors += suite.Errors
+		suites.Skipped += suite.Skipped
+	}
+
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+	encoder := xml.NewEncoder(w)
+	encoder.Indent("", "  ")
+	if err := encoder.Encode(suites); err != nil {
+		return err
+	}
+	_, err := io.WriteString(w, "\n")
+	return err
+}
+
+// Returns the details and remediation of a failed check as plain text
+func problemText(result CheckResult) string {
+	lines := make([]string, 0, len(result.Details)+2)
+	for _, row := range result.Details {
+		lines = append(lines, strings.Join(row, " | "))
+	}
+	if len(result.Remediation) != 0 {
+		if len(lines) != 0 {
+			lines = append(lines, "")
+		}
+		lines = append(lines, "Remediation: "+result.Remediation)
+	}
+	return strings.Join(lines, "\n")
+}
+
+func writeMarkdown(w io.Writer, report Report) error {
+	var b strings.Builder
+	fmt.Fprintf(&b, "# Preflight report of %s\n\n", report.ClusterName)
+	if len(report.AsgName) != 0 {
+		fmt.Fprintf(&b, "- ASG: %s\n", report.AsgName)
+	}
+	fmt.Fprintf(&b, "- Verdict: **%s**\n", report.Verdict)
+	fmt.Fprintf(&b, "- Started: %s\n", report.StartedAt.Format(time.RFC3339))
+	fmt.Fprintf(&b, "- Finished: %s\n\n", report.FinishedAt.Format(time.RFC3339))
+
+	rows := [][]string{{"Check", "Category", "Severity", "Status", "Message"}}
+	for _, result := range report.Results {
+		rows = append(rows, []string{
+			result.Name,
+			result.Category,
+			result.Severity.String(),
+			string(result.Status),
+			result.Message,
+		})
+	}
+	writeMarkdownTable(&b, rows)
+
+	for _, result := range report.Results {
+		if len(result.Details) < 2 && result.Status != StatusFail {
+			continue
+		}
+		fmt.Fprintf(&b, "\n## %s ( %s )\n\n%s\n", result.Name, result.Status, result.Message)
+		if result.Status == StatusFail && len(result.Remediation) != 0 {
+			fmt.Fprintf(&b, "\n> %s\n", result.Remediation)
+		}
+		if len(result.Details) > 1 {
+			b.WriteString("\n")
+			writeMarkdownTable(&b, result.Details)
+		}
+	}
+
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// Writes table, whose first row is the header, as markdown table
+func writeMarkdownTable(b *strings.Builder, table [][]string) {
+	for r, row := range table {
+		cells := make([]string, len(row))
+		for c, cell := range row {
+			cells[c] = strings.ReplaceAll(strings.ReplaceAll(cell, "|", "\\|"), "\n", " ")
+		}
+		fmt.Fprintf(b, "| %s |\n", strings.Join(cells, " | "))
+		if r == 0 {
+			separators := make([]string, len(row))
+			for c := range separators {
+				separators[c] = "---"
+			}
+			fmt.Fprintf(b, "| %s |\n", strings.Join(separators, " | "))
+		}
+	}
+}
+
+// Splits details into their header and rows, rows are never nil
+func splitDetails(details [][]string) ([]string, [][]string) {
+	if len(details) == 0 {
+		return []string{}, [][]string{}
+	}
+	return details[0], append([][]string{}, details[1:]...)
+}
+
+func seconds(start, end time.Time) string {
+	if end.Before(start) {
+		return "0.000"
+	}
+	return fmt.Sprintf("%.3f", end.Sub(start).Seconds())
+}
@@ -0,0 +1,147 @@
+package preflight
+
+import (
+	"context"
+	"dockyard/pkg/aws"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+func init() {
+	Register(NewCheck(
+		"surge-capacity",
+		CategoryCapacity,
+		SeverityCritical,
+		"Request a higher ec2 vCPU quota, add subnets with free ips to the asg or roll in smaller batches. New nodes which can't be launched stall the rollout",
+		checkSurgeCapacity,
+	))
+}
+
+// Fails if the instances a rollout launches on top of the desired capacity
+// of an asg exceed the free vCPU quota or don't fit the free ips of the
+// subnets of the asg. Only the standard instance quotas are known, the
+// quota of other instance families isn't checked.
+func checkSurgeCapacity(
+	ctx context.Context,
+	env *Env,
+	config CheckConfig,
+) (Result, error) {
+	asgNames, err := env.AsgNames()
+	if err != nil {
+		return Result{}, err
+	}
+
+	// Quotas are shared by all asgs of the region
+	quotas := map[bool]aws.VCpuQuota{}
+	quotaOf := func(spot bool) (aws.VCpuQuota, error) {
+		if quota, ok := quotas[spot]; ok {
+			return quota, nil
+		}
+		quota, err := env.Eks.InstanceQuota(spot)
+		if err != nil {
+			return aws.VCpuQuota{}, err
+		}
+		quotas[spot] = quota
+		return quota, nil
+	}
+
+	details := [][]string{{
+		"ASG",
+		"Instance Type",
+		"Market",
+		"Surge",
+		"vCPUs",
+		"Free vCPUs",
+		"Ips per Node",
+		"Max Pods",
+		"Nodes Subnets Fit",
+		"Satisfied",
+	}}
+	unsatisfied := make([]string, 0)
+	for _, asgName := range asgNames {
+		if err := ctx.Err(); err != nil {
+			return Result{}, err
+		}
+		surge, err := env.Asg.GetSurgeRequirements(asgName, env.Batch())
+		if err != nil {
+			return Result{}, err
+		}
+		quota := aws.VCpuQuota{}
+		if surge.Standard {
+			quota, err = quotaOf(surge.Spot)
+			if err != nil {
+				return Result{}, err
+			}
+		}
+		availableIps, err := env.Eks.AvailableIpOfSubnets(surge.SubnetIds)
+		if err != nil {
+			return Result{}, err
+		}
+
+		reasons := make([]string, 0)
+		freeVCpus := "unknown"
+		if quota.Limit != 0 {
+			freeVCpus = strconv.FormatInt(quota.Free(), 10)
+			if quota.Free() < surge.VCpus {
+				reasons = append(reasons, "vCPU quota")
+			}
+		}
+		fitting := nodesFitting(availableIps, surge.IpsPerInstance)
+		if fitting < surge.Instances {
+			reasons = append(reasons, "subnet ips")
+		}
+
+		market := "on-demand"
+		if surge.Spot {
+			market = "spot"
+		}
+		satisfied := "✅"
+		if len(reasons) != 0 {
+			satisfied = "❌ " + strings.Join(reasons, ", ")
+			unsatisfied = append(
+				unsatisfied,
+				fmt.Sprintf("%s ( %s )", asgName, strings.Join(reasons, ", ")),
+			)
+		} else if surge.ExceedsMax {
+			satisfied = "✅ max size is raised"
+		}
+		details = append(details, []string{
+			asgName,
+			surge.InstanceType,
+			market,
+			strconv.FormatInt(surge.Instances, 10),
+			strconv.FormatInt(surge.VCpus, 10),
+			freeVCpus,
+			strconv.FormatInt(surge.IpsPerInstance, 10),
+			strconv.FormatInt(surge.MaxPods, 10),
+			strconv.FormatInt(fitting, 10),
+			satisfied,
+		})
+	}
+
+	if len(unsatisfied) != 0 {
+		return Fail(
+			fmt.Sprintf("Surge can't be satisfied for %s", strings.Join(unsatisfied, ", ")),
+			details,
+		), nil
+	}
+	return Pass(
+		fmt.Sprintf("Surge of %d nodes per batch fits %d asgs", env.Batch(), len(asgNames)),
+		details,
+	), nil
+}
+
+// Returns how many nodes taking up ipsPerNode ips fit the free ips of the
+// subnets. A node has to fit a single subnet.
+func nodesFitting(availableIps map[string]int64, ipsPerNode int64) int64 {
+	fitting := int64(0)
+	for _, ips := range availableIps {
+		if ipsPerNode < 1 {
+			fitting += ips
+		} else {
+			fitting += ips / ipsPerNode
+		}
+	}
+	return fitting
+}
@@ -0,0 +1,208 @@
+package preflight
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+func init() {
+	Register(NewCheck(
+		"version-skew",
+		CategoryCompatibility,
+		SeverityCritical,
+		"Upgrade the control plane one minor version at a time. Kubelets and target amis must not be newer than the control plane and at most 2 ( 3 from 1.28 on ) minor versions older",
+		checkVersionSkew,
+	))
+}
+
+var minorVersionRe = regexp.MustCompile(`^v?(\d+)\.(\d+)`)
+
+// Major and minor part of a k8s version, e.g. 1.24 of v1.24.3-eks-2d98532
+type minorVersion struct {
+	major int
+	minor int
+}
+
+func (v minorVersion) String() string {
+	return fmt.Sprintf("%d.%d", v.major, v.minor)
+}
+
+// Returns the version moved by delta minor versions
+func (v minorVersion) add(delta int) minorVersion {
+	return minorVersion{major: v.major, minor: v.minor + delta}
+}
+
+// Returns by how many minor versions v is older than other, negative if
+// v is newer
+func (v minorVersion) behind(other minorVersion) int {
+	if v.major != other.major {
+		return (other.major - v.major) * 100
+	}
+	return other.minor - v.minor
+}
+
+func parseMinorVersion(version string) (minorVersion, error) {
+	matches := minorVersionRe.FindStringSubmatch(strings.TrimSpace(version))
+	if len(matches) != 3 {
+		return minorVersion{}, fmt.Errorf("Unable to parse k8s version %s", version)
+	}
+	major, _ := strconv.Atoi(matches[1])
+	minor, _ := strconv.Atoi(matches[2])
+	return minorVersion{major: major, minor: minor}, nil
+}
+
+// Returns how many minor versions kubelets may be older than the control
+// plane according to the k8s version skew policy
+func maxKubeletSkew(controlPlane minorVersion) int {
+	if controlPlane.behind(minorVersion{major: 1, minor: 28}) > 0 {
+		return 2
+	}
+	return 3
+}
+
+// Fails if kubelets or target amis of the asgs are newer than the control
+// plane or older than the version skew policy allows
+func checkVersionSkew(
+	ctx context.Context,
+	env *Env,
+	config CheckConfig,
+) (Result, error) {
+	serverVersion, err := env.Kube.GetServerVersion()
+	if err != nil {
+		return Result{}, err
+	}
+	controlPlane, err := parseMinorVersion(serverVersion)
+	if err != nil {
+		return Result{}, err
+	}
+	maxSkew := maxKubeletSkew(controlPlane)
+
+	details := [][]string{{"Kind", "Name", "Version", "Control Plane", "Skew", "Status"}}
+	ahead, tooOld := 0, 0
+	// Oldest version running or about to be launched, it limits the next
+	// control plane upgrade
+	oldest := controlPlane
+	addRow := func(kind, name string, version minorVersion) {
+		skew := version.behind(controlPlane)
+		status := "✅"
+		switch {
+		case skew < 0:
+			ahead++
+			status = "❌ newer than control plane"
+		case skew > maxSkew:
+			tooOld++
+			status = fmt.Sprintf("❌ more than %d minor versions behind", maxSkew)
+		}
+		if version.behind(oldest) > 0 {
+			oldest = version
+		}
+		details = append(details, []string{
+			kind,
+			name,
+			version.String(),
+			controlPlane.String(),
+			strconv.Itoa(skew),
+			status,
+		})
+	}
+
+	addUnknown := func(kind, name, version string) {
+		details = append(details, []string{
+			kind, name, version, controlPlane.String(), "", "unknown version",
+		})
+	}
+
+	kubelets, err := env.Kube.ListKubeletVersions()
+	if err != nil {
+		return Result{}, err
+	}
+	for _, kubelet := range kubelets {
+		version, err := parseMinorVersion(kubelet[1])
+		if err != nil {
+			addUnknown("Node", kubelet[0], kubelet[1])
+			continue
+		}
+		addRow("Node", kubelet[0], version)
+	}
+
+	asgNames, err := env.AsgNames()
+	if err != nil {
+		return Result{}, err
+	}
+	for _, asgName := range asgNames {
+		if err := ctx.Err(); err != nil {
+			return Result{}, err
+		}
+		ami, err := env.Asg.GetTargetAmiOfAsg(asgName)
+		if err != nil {
+			return Result{}, err
+		}
+		name := fmt.Sprintf("%s ( %s )", asgName, *ami.ImageId)
+		if ami.Name == nil {
+			addUnknown("Target AMI", name, "")
+			continue
+		}
+		eksVersion, err := env.Asg.GetEksVersionFromAmiName(*ami.Name)
+		if err != nil {
+			addUnknown("Target AMI", name, "")
+			continue
+		}
+		version, err := parseMinorVersion(eksVersion)
+		if err != nil {
+			return Result{}, err
+		}
+		addRow("Target AMI", name, version)
+	}
+
+	next := nextVersionStep(controlPlane, oldest, ahead != 0, tooOld != 0)
+	if ahead != 0 || tooOld != 0 {
+		return Fail(
+			fmt.Sprintf(
+				"%d versions are newer than control plane %s and %d are too old, %s",
+				ahead,
+				controlPlane,
+				tooOld,
+				next,
+			),
+			details,
+		), nil
+	}
+	return Pass(
+		fmt.Sprintf("Versions are within the skew of control plane %s, %s", controlPlane, next),
+		details,
+	), nil
+}
+
+// Explains the next upgrade allowed by the version skew policy
+func nextVersionStep(
+	controlPlane, oldest minorVersion,
+	ahead, tooOld bool,
+) string {
+	maxSkew := maxKubeletSkew(controlPlane)
+	switch {
+	case ahead:
+		return fmt.Sprintf(
+			"upgrade the control plane to %s before rolling newer amis",
+			controlPlane.add(1),
+		)
+	case tooOld:
+		return fmt.Sprintf(
+			"roll the nodes to amis of %s to %s",
+			controlPlane.add(-maxSkew),
+			controlPlane,
+		)
+	}
+	nextControlPlane := controlPlane.add(1)
+	minKubelet := nextControlPlane.add(-maxKubeletSkew(nextControlPlane))
+	if oldest.behind(minKubelet) > 0 {
+		return fmt.Sprintf(
+			"roll the nodes to amis of %s or newer before upgrading the control plane to %s",
+			minKubelet,
+			nextControlPlane,
+		)
+	}
+	return fmt.Sprintf("control plane can be upgraded to %s next", nextControlPlane)
+}
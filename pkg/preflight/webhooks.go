@@ -0,0 +1,141 @@
+package preflight
+
+import (
+	"context"
+	"dockyard/pkg/kube"
+	"fmt"
+	"strconv"
+)
+
+func init() {
+	Register(NewCheck(
+		"webhook-backends",
+		CategoryHealth,
+		SeverityCritical,
+		"Fix the services of the listed webhooks and APIServices, or set failurePolicy: Ignore. Every pod rescheduled by a drain is rejected while they can't be reached",
+		checkWebhookBackends,
+	))
+	Register(NewCheck(
+		"webhook-backends-on-old-nodes",
+		CategoryHealth,
+		SeverityWarning,
+		"Run more replicas of the listed webhook and APIService backends or move them to new nodes first. Pods rescheduled while their last endpoint is drained are rejected",
+		checkWebhookBackendsOnOldNodes,
+	))
+}
+
+// Returns admission webhooks failing closed and APIServices backed by
+// services of the cluster, with endpoints counted on the nodes to be rolled
+func serviceBackends(env *Env) ([]kube.ServiceBackend, error) {
+	nodes, err := env.NodesToRollout()
+	if err != nil {
+		return nil, err
+	}
+	webhooks, err := env.Kube.ListWebhookBackends(nodes)
+	if err != nil {
+		return nil, err
+	}
+	apiServices, err := env.Kube.ListApiServiceBackends(nodes)
+	if err != nil {
+		return nil, err
+	}
+	return append(webhooks, apiServices...), nil
+}
+
+// Returns the backends as details rows, statusOf returns the status shown
+// for a backend and whether the backend fails the check
+func serviceBackendDetails(
+	backends []kube.ServiceBackend,
+	statusOf func(kube.ServiceBackend) (string, bool),
+) (details [][]string, failed int) {
+	details = [][]string{{
+		"Kind",
+		"Name",
+		"Service",
+		"Ready Endpoints",
+		"On Nodes to Roll",
+		"Status",
+	}}
+	for _, backend := range backends {
+		status, fails := statusOf(backend)
+		if fails {
+			failed++
+		}
+		details = append(details, []string{
+			backend.Kind,
+			backend.Name,
+			backend.Service(),
+			strconv.Itoa(backend.ReadyEndpoints),
+			strconv.Itoa(backend.ReadyEndpointsOnNodes),
+			status,
+		})
+	}
+	return details, failed
+}
+
+// Fails if admission webhooks with failurePolicy Fail or APIServices have
+// no ready endpoints or are unavailable
+func checkWebhookBackends(
+	ctx context.Context,
+	env *Env,
+	config CheckConfig,
+) (Result, error) {
+	backends, err := serviceBackends(env)
+	if err != nil {
+		return Result{}, err
+	}
+	details, failed := serviceBackendDetails(
+		backends,
+		func(backend kube.ServiceBackend) (string, bool) {
+			switch {
+			case backend.ReadyEndpoints == 0:
+				return "❌ no ready endpoints", true
+			case backend.Kind == "APIService" && !backend.Available:
+				return "❌ unavailable", true
+			}
+			return "✅", false
+		},
+	)
+	if failed != 0 {
+		return Fail(
+			fmt.Sprintf("%d webhooks and APIServices can't be reached", failed),
+			details,
+		), nil
+	}
+	return Pass(
+		fmt.Sprintf("All %d webhooks and APIServices have ready endpoints", len(backends)),
+		details,
+	), nil
+}
+
+// Fails if all ready endpoints of admission webhooks with failurePolicy
+// Fail or APIServices run on nodes to be rolled
+func checkWebhookBackendsOnOldNodes(
+	ctx context.Context,
+	env *Env,
+	config CheckConfig,
+) (Result, error) {
+	backends, err := serviceBackends(env)
+	if err != nil {
+		return Result{}, err
+	}
+	details, failed := serviceBackendDetails(
+		backends,
+		func(backend kube.ServiceBackend) (string, bool) {
+			if backend.OnlyOnNodes() {
+				return "❌ only on nodes to roll", true
+			}
+			return "✅", false
+		},
+	)
+	if failed != 0 {
+		return Fail(
+			fmt.Sprintf("%d webhooks and APIServices only run on nodes to be rolled", failed),
+			details,
+		), nil
+	}
+	return Pass(
+		fmt.Sprintf("%d webhooks and APIServices keep endpoints during the rollout", len(backends)),
+		details,
+	), nil
+}
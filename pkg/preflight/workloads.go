@@ -0,0 +1,85 @@
+package preflight
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+func init() {
+	Register(NewCheck(
+		"unprotected-workloads",
+		CategoryWorkloads,
+		SeverityWarning,
+		"Scale workloads to more than one replica and add a PDB, or warn their owners ( dockyard.io/owner annotation ) of the downtime. Drains only wait for replacements of workloads protected by a PDB",
+		checkUnprotectedWorkloads,
+	))
+}
+
+// Fails if Deployments or StatefulSets with a single replica or without a
+// PDB have all their running pods on nodes to be rolled
+func checkUnprotectedWorkloads(
+	ctx context.Context,
+	env *Env,
+	config CheckConfig,
+) (Result, error) {
+	asgNames, err := env.AsgNames()
+	if err != nil {
+		return Result{}, err
+	}
+
+	details := [][]string{{
+		"ASG",
+		"Kind",
+		"Namespace",
+		"Workload",
+		"Owner",
+		"Replicas",
+		"Reasons",
+		"Nodes",
+	}}
+	for _, asgName := range asgNames {
+		if err := ctx.Err(); err != nil {
+			return Result{}, err
+		}
+		nodes, err := env.Asg.GetNodesToRolloutOfAsg(asgName)
+		if err != nil {
+			return Result{}, err
+		}
+		if len(nodes) == 0 {
+			continue
+		}
+		workloads, err := env.Kube.ListUnprotectedWorkloads(nodes)
+		if err != nil {
+			return Result{}, err
+		}
+		for _, workload := range workloads {
+			owner := workload.Owner
+			if len(owner) == 0 {
+				owner = "-"
+			}
+			details = append(details, []string{
+				asgName,
+				workload.Kind,
+				workload.Namespace,
+				workload.Name,
+				owner,
+				strconv.Itoa(int(workload.Replicas)),
+				workload.Reasons(),
+				strings.Join(workload.Nodes, ","),
+			})
+		}
+	}
+
+	if unprotected := len(details) - 1; unprotected != 0 {
+		return Fail(
+			fmt.Sprintf("%d workloads lose all their pods while their nodes are drained", unprotected),
+			details,
+		), nil
+	}
+	return Pass(
+		fmt.Sprintf("All workloads on nodes of %d asgs keep pods running during drains", len(asgNames)),
+		details,
+	), nil
+}
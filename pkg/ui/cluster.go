@@ -0,0 +1,128 @@
+package ui
+
+import (
+	"context"
+	"dockyard/pkg/aws"
+	"dockyard/pkg/kube"
+	"fmt"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Clients bound to a single cluster. Clients of a cluster are created once
+// and reused, so rollouts keep running with their clients while another
+// cluster is browsed.
+type clusterClients struct {
+	cluster          aws.ClusterConfig
+	kube             kube.KubeClient
+	asgClient        aws.AsgRolloutClient
+	awsEksClient     aws.AwsEksClient
+	awsConfig        *aws.AwsConfig
+	asgRolloutConfig *aws.AsgRolloutConfig
+	// events of the rollouts and preflight runs of the cluster
+	events *clusterEvents
+}
+
+// Creates kube and aws clients for the cluster
+func newClusterClients(
+	ctx context.Context,
+	cluster aws.ClusterConfig,
+	kubeOpts kube.KubeConfigOptions,
+	baseAwsConfig *aws.AwsConfig,
+	baseRolloutConfig *aws.AsgRolloutConfig,
+	eventView *eventFlex,
+) (*clusterClients, error) {
+	awsConfig := cluster.AwsConfig(baseAwsConfig)
+	rolloutConfig := *baseRolloutConfig
+	rolloutConfig.EksClusterName = cluster.EksClusterName
+
+	kubeClient, err := kube.NewKubeClient(
+		rolloutConfig.PrivateRegistry,
+		rolloutConfig.IgnoreNotFound,
+		cluster.EksClusterName,
+		kube.KubeConfigOptions{
+			Kubeconfig: kubeOpts.Kubeconfig,
+			Context:    cluster.Context,
+		},
+	)
+	if err != nil {
+		return nil, fmt.Errorf(
+			"Unable to connect to cluster %s, %s",
+			cluster.Name,
+			err.Error(),
+		)
+	}
+
+	return &clusterClients{
+		cluster:          cluster,
+		kube:             kubeClient,
+		asgClient:        aws.NewAsgRollout(ctx, &awsConfig, kubeClient, &rolloutConfig),
+		awsEksClient:     aws.NewAwsEKS(cluster.EksClusterName, &awsConfig),
+		awsConfig:        &awsConfig,
+		asgRolloutConfig: &rolloutConfig,
+		events:           newClusterEvents(cluster.Name, eventView),
+	}, nil
+}
+
+// Switches all views to the selected cluster. Clients of the cluster are
+// created on first use.
+func (tui *tuiConfig) switchCluster(ctx context.Context, cluster aws.ClusterConfig) {
+	go func() {
+		tui.showMessage(fmt.Sprintf("Switching to cluster %s", cluster.Name))
+
+		tui.clustersLock.Lock()
+		clients, ok := tui.clusters[cluster.Name]
+		tui.clustersLock.Unlock()
+
+		if !ok {
+			var err error
+			clients, err = newClusterClients(
+				ctx,
+				cluster,
+				tui.kubeOpts,
+				tui.baseAwsConfig,
+				tui.baseRolloutConfig,
+				tui.eventFlex,
+			)
+			if err != nil {
+				log.Errorf("Unable to switch cluster due to %s", err.Error())
+				tui.queueUpdateDraw(func() {
+					tui.showError(err)
+				})
+				return
+			}
+			tui.clustersLock.Lock()
+			// Cluster was switched to twice meanwhile, the clients created
+			// first are kept
+			if existing, ok := tui.clusters[cluster.Name]; ok {
+				clients.kube.Close()
+				close(clients.events.events)
+				clients = existing
+			} else {
+				tui.clusters[cluster.Name] = clients
+			}
+			tui.clustersLock.Unlock()
+		}
+
+		// Credentials are resolved here, so that a mfa token is prompted
+		// for before any view of the cluster calls aws
+		meta := headerMeta(clients)
+		tui.queueUpdateDraw(func() {
+			tui.clusterClients = clients
+			tui.eventFlex.Show(clients.events)
+			renderHeaderTable(tui.header.clusterTable, meta)
+			tui.sidebar.layout.SetActiveCluster(cluster.Name)
+			tui.body.layout.SwitchToPage("0")
+			tui.renderASGList(ctx)
+		})
+	}()
+}
+
+// Stops the informers of the kube clients of all clusters visited so far
+func (tui *tuiConfig) Close() {
+	tui.clustersLock.Lock()
+	defer tui.clustersLock.Unlock()
+	for _, clients := range tui.clusters {
+		clients.kube.Close()
+	}
+}
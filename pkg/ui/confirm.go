@@ -0,0 +1,47 @@
+package ui
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/gdamore/tcell/v2"
+	"github.com/rivo/tview"
+)
+
+// Asks to confirm the message in a modal covering the whole TUI and blocks
+// till it is answered. Must not be called from the event loop.
+func (tui *tuiConfig) confirm(title, message, confirmLabel string) (bool, error) {
+	shown := make(chan struct{})
+	abandoned := make(chan struct{})
+	answers := make(chan bool, 1)
+
+	tui.queueUpdateDraw(func() {
+		select {
+		case shown <- struct{}{}:
+		case <-abandoned:
+			return
+		}
+		modal := tview.NewModal().
+			SetText(message).
+			AddButtons([]string{confirmLabel, "Cancel"}).
+			SetDoneFunc(func(_ int, label string) {
+				if tui.root != nil {
+					tui.App.SetRoot(tui.root, true)
+				}
+				answers <- label == confirmLabel
+			})
+		modal.SetTitle(title).
+			SetTitleColor(tcell.ColorYellow).
+			SetBorderColor(tcell.ColorYellow)
+		tui.App.SetRoot(modal, true).SetFocus(modal)
+	})
+
+	// Same limit as the mfa prompt, the event loop may be blocked
+	select {
+	case <-shown:
+	case <-time.After(mfaPromptTimeout):
+		close(abandoned)
+		return false, fmt.Errorf("Unable to ask for confirmation, retry once the TUI is responsive")
+	}
+	return <-answers, nil
+}
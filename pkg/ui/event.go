@@ -2,6 +2,7 @@ package ui
 
 import (
 	"bytes"
+	"sync"
 
 	"github.com/gdamore/tcell/v2"
 	"github.com/rivo/tview"
@@ -11,8 +12,10 @@ type eventFlex struct {
 	layout          *tview.Flex
 	eventTextLayout *tview.TextView
 	eventTextFrame  *tview.Frame
-	events          chan string
 	focused         bool
+	// events of the cluster the view shows
+	lock  sync.Mutex
+	shown *clusterEvents
 }
 
 func NewEventFlex() *eventFlex {
@@ -31,22 +34,61 @@ func NewEventFlex() *eventFlex {
 		eventTextLayout: eventTextLayout,
 		eventTextFrame:  eventTextFrame,
 		focused:         false,
-		events:          make(chan string),
 	}
-
-	go func(e *eventFlex) {
-		e.UpdateText()
-	}(eF)
 	return eF
 }
 
-// Parse context
-func (e *eventFlex) UpdateText() {
-	var buffer bytes.Buffer
+// Shows the events of the cluster, events of other clusters are kept in
+// their buffers meanwhile
+func (e *eventFlex) Show(events *clusterEvents) {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	e.shown = events
+	e.eventTextFrame.Clear().
+		AddText("Rollout Events of "+events.clusterName, true, tview.AlignLeft, tcell.ColorYellow)
+	e.eventTextLayout.SetText(events.String())
+}
+
+// Events of the rollouts and preflight runs of a single cluster. Rollouts
+// keep writing to the events of their cluster while another cluster is
+// shown.
+type clusterEvents struct {
+	clusterName string
+	events      chan string
+	view        *eventFlex
+	lock        sync.Mutex
+	buffer      bytes.Buffer
+}
+
+func newClusterEvents(clusterName string, view *eventFlex) *clusterEvents {
+	events := &clusterEvents{
+		clusterName: clusterName,
+		events:      make(chan string),
+		view:        view,
+	}
+	go events.UpdateText()
+	return events
+}
+
+// Buffers the events and updates the view while it shows the cluster
+func (c *clusterEvents) UpdateText() {
+	for event := range c.events {
+		c.lock.Lock()
+		c.buffer.WriteString(event)
+		c.buffer.WriteString("\n")
+		text := c.buffer.String()
+		c.lock.Unlock()
 
-	for event := range e.events {
-		buffer.WriteString(event)
-		buffer.WriteString("\n")
-		e.eventTextLayout.SetText(buffer.String())
+		c.view.lock.Lock()
+		if c.view.shown == c {
+			c.view.eventTextLayout.SetText(text)
+		}
+		c.view.lock.Unlock()
 	}
 }
+
+func (c *clusterEvents) String() string {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.buffer.String()
+}
@@ -1,46 +1,80 @@
 package ui
 
 import (
-	"log"
-	"os"
+	"dockyard/pkg/aws"
 
 	"github.com/gdamore/tcell/v2"
 	"github.com/rivo/tview"
+	log "github.com/sirupsen/logrus"
 )
 
 type header struct {
-	layout  *tview.Flex
-	focused bool
+	layout       *tview.Flex
+	clusterTable *tview.Table
+	focused      bool
 }
 
 func NewHeader() *header {
 
 	flex := tview.NewFlex().SetDirection(tview.FlexColumn)
 	return &header{
-		layout:  flex,
-		focused: false,
+		layout:       flex,
+		clusterTable: tview.NewTable(),
+		focused:      false,
 	}
 }
 
 func (tui *tuiConfig) prepareHeader() *tview.Table {
-	kubectx := tui.kube.GetContext()
-	k8sServerVersion, err := tui.kube.GetServerVersion()
+	clients := tui.clusterClients
+	renderHeaderTable(tui.header.clusterTable, [][]string{
+		{"Cluster: ", clients.cluster.Name},
+		{"Context: ", clients.kube.GetContext()},
+	})
+
+	// Resolving the aws identity may prompt for a mfa token, which needs
+	// the running event loop
+	go func() {
+		meta := headerMeta(clients)
+		tui.queueUpdateDraw(func() {
+			if tui.clusterClients == clients {
+				renderHeaderTable(tui.header.clusterTable, meta)
+			}
+		})
+	}()
+	return tui.header.clusterTable
+}
+
+// Returns details of the active cluster shown in the header
+func headerMeta(clients *clusterClients) [][]string {
+	k8sServerVersion, err := clients.kube.GetServerVersion()
 
 	if err != nil {
-		log.Fatal(err)
+		log.Errorf("Unable to fetch server version due to %s", err.Error())
+		k8sServerVersion = "unknown"
 	}
 
-	row, col := 3, 2
+	identity, err := clients.awsEksClient.GetCallerIdentity()
 
-	headerMeta := [3][2]string{
-		{"Context: ", kubectx},
+	if err != nil {
+		log.Errorf("Unable to fetch aws identity due to %s", err.Error())
+		identity = aws.CallerIdentity{Account: "unknown", Arn: "unknown"}
+	}
+
+	return [][]string{
+		{"Cluster: ", clients.cluster.Name},
+		{"Context: ", clients.kube.GetContext()},
 		{"Server Version:", k8sServerVersion},
-		{"AWS Region:", os.Getenv("AWS_REGION")},
+		{"AWS Account:", identity.Account},
+		{"AWS Identity:", identity.Arn},
+		{"AWS Region:", clients.awsConfig.GetRegion()},
 	}
-	table := tview.NewTable()
+}
+
+func renderHeaderTable(table *tview.Table, headerMeta [][]string) {
+	table.Clear()
 
-	for r := 0; r < row; r++ {
-		for c := 0; c < col; c++ {
+	for r := 0; r < len(headerMeta); r++ {
+		for c := 0; c < len(headerMeta[r]); c++ {
 			color := tcell.ColorWhite
 			if c == 0 {
 				color = tcell.ColorYellow
@@ -54,5 +88,4 @@ func (tui *tuiConfig) prepareHeader() *tview.Table {
 			)
 		}
 	}
-	return table
 }
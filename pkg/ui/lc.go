@@ -57,9 +57,60 @@ func addProgress(bar *progressbar.ProgressBar, progress aws.RolloutProgress) {
 	}
 }
 
+// Rollout started from the TUI. Progress of the rollout is consumed even
+// while the rollout page isn't visible, e.g. when another cluster is
+// browsed.
+type rolloutState struct {
+	asgClient aws.AsgRolloutClient
+	lock      *sync.Mutex
+	bar       *progressbar.ProgressBar
+	updated   chan struct{}
+}
+
+func newRolloutState(
+	asgClient aws.AsgRolloutClient,
+	rolloutProgressChan aws.RolloutProgressChan,
+) *rolloutState {
+	state := &rolloutState{
+		asgClient: asgClient,
+		lock:      &sync.Mutex{},
+		updated:   make(chan struct{}, 1),
+	}
+
+	go func() {
+		for progress := range rolloutProgressChan {
+			state.lock.Lock()
+			if state.bar == nil {
+				state.bar = createProgressBar(int(progress.TotalSize))
+			}
+			addProgress(state.bar, progress)
+			state.lock.Unlock()
+
+			// Notify the rollout page if it is visible
+			select {
+			case state.updated <- struct{}{}:
+			default:
+			}
+		}
+	}()
+	return state
+}
+
+func rolloutKey(clusterName, asgName string) string {
+	return clusterName + "/" + asgName
+}
+
+// Returns the rollout of the asg in the active cluster, nil if there is no
+// rollout running
+func (tui *tuiConfig) runningRollout(asgName string) *rolloutState {
+	tui.rolloutsLock.Lock()
+	defer tui.rolloutsLock.Unlock()
+	return tui.rollouts[rolloutKey(tui.cluster.Name, asgName)]
+}
+
 func (tui *tuiConfig) renderLcFlexWithReloading(
 	asgName string,
-	rolloutProgressChan aws.RolloutProgressChan,
+	state *rolloutState,
 ) {
 	doneChan := make(chan bool)
 
@@ -69,13 +120,9 @@ func (tui *tuiConfig) renderLcFlexWithReloading(
 			tui.body.layout.SetChangedFunc(func() {})
 		})
 
-	rolloutProgress := aws.RolloutProgress{}
-
 	renderMutex := &sync.Mutex{}
 
-	var bar *progressbar.ProgressBar
-
-	tui.renderLcFlex(asgName, renderMutex, bar)
+	tui.renderLcFlex(asgName, renderMutex, state)
 
 	go func() {
 	loop:
@@ -83,22 +130,12 @@ func (tui *tuiConfig) renderLcFlexWithReloading(
 			select {
 			case <-doneChan:
 				break loop
-			case progress := <-rolloutProgressChan:
+			case <-state.updated:
 				tui.showMessage("Reloading with progress...")
-
-				if bar == nil {
-					bar = createProgressBar(int(progress.TotalSize))
-				}
-
-				addProgress(bar, progress)
-
-				rolloutProgress.StepsSize = progress.StepsSize
-				rolloutProgress.StepsDone = progress.StepsDone
-				rolloutProgress.TotalSize = progress.TotalSize
-				tui.renderLcFlex(asgName, renderMutex, bar)
+				tui.renderLcFlex(asgName, renderMutex, state)
 			case <-time.After(5 * time.Second):
 				tui.showMessage("Reloading...")
-				tui.renderLcFlex(asgName, renderMutex, bar)
+				tui.renderLcFlex(asgName, renderMutex, state)
 			}
 		}
 	}()
@@ -107,8 +144,9 @@ func (tui *tuiConfig) renderLcFlexWithReloading(
 func (tui *tuiConfig) renderLcFlex(
 	asgName string,
 	mutex *sync.Mutex,
-	progressBar *progressbar.ProgressBar,
+	state *rolloutState,
 ) {
+	asgClient := state.asgClient
 	tui.queueUpdateDraw(func() {
 		mutex.Lock()
 		defer mutex.Unlock()
@@ -124,7 +162,7 @@ func (tui *tuiConfig) renderLcFlex(
 			{"Node name", "EKS Version", "Status"},
 		}
 
-		instances, _ := tui.asgClient.GetInstanceDetailsOfAsg(asgName)
+		instances, _ := asgClient.GetInstanceDetailsOfAsg(asgName)
 
 		amiIds := []*string{}
 		instanceIds := make([]string, 0)
@@ -133,15 +171,25 @@ func (tui *tuiConfig) renderLcFlex(
 			instanceIds = append(instanceIds, *instance.InstanceId)
 		}
 
-		amis, _ := tui.asgClient.GetAmiDetails(amiIds)
+		amis, _ := asgClient.GetAmiDetails(amiIds)
 
-		isNew, _ := tui.asgClient.AreInstancesNew(asgName, instanceIds)
+		isNew, _ := asgClient.AreInstancesNew(asgName, instanceIds)
+		mapping, mappingErr := asgClient.GetNodeMapping(asgName)
+		if mappingErr != nil {
+			logrus.Error(mappingErr)
+		}
 		for i, instance := range instances {
 			nodeState := "old"
 			if isNew[i] {
 				nodeState = "new"
 			}
 
+			// Instances not registered with the cluster show up by their id
+			nodeName := *instance.InstanceId
+			if mapping != nil && len(mapping.NodeName(nodeName)) != 0 {
+				nodeName = mapping.NodeName(nodeName)
+			}
+
 			var amiName *string
 
 			for _, ami := range amis {
@@ -151,20 +199,20 @@ func (tui *tuiConfig) renderLcFlex(
 				}
 			}
 
-			eksVersion, eksVersionErr := tui.asgClient.GetEksVersionFromAmiName(
+			eksVersion, eksVersionErr := asgClient.GetEksVersionFromAmiName(
 				*amiName,
 			)
 
 			var newRow []string
 			if eksVersionErr == nil {
 				newRow = []string{
-					*instance.PrivateDnsName,
+					nodeName,
 					eksVersion,
 					nodeState,
 				}
 			} else {
 				logrus.Error(eksVersionErr)
-				newRow = []string{*instance.PrivateDnsName, "", nodeState}
+				newRow = []string{nodeName, "", nodeState}
 			}
 
 			result = append(result, newRow)
@@ -204,12 +252,36 @@ func (tui *tuiConfig) renderLcFlex(
 		lcFrame := tview.NewFrame(asgTable).
 			AddText(asgName, true, tview.AlignLeft, tcell.ColorYellow)
 
+		nodesFlex := tview.NewFlex().SetDirection(tview.FlexRow).
+			AddItem(lcFrame, 0, 2, false)
+
+		// Jobs which nodes under rollout are waiting on
+		if jobs := asgClient.JobsWaitedOn(); len(jobs) > 0 {
+			jobsTable := tview.NewTable()
+			jobsTable.SetBorders(true)
+			jobsTable.SetFixed(1, 1)
+			renderTable(
+				append(
+					[][]string{{"Node name", "Job", "Namespace", "Running for"}},
+					jobs...,
+				),
+				jobsTable,
+			)
+			jobsFrame := tview.NewFrame(jobsTable).
+				AddText("Waiting on Jobs", true, tview.AlignLeft, tcell.ColorYellow)
+			nodesFlex.AddItem(jobsFrame, 0, 1, false)
+		}
+
 		asgTableFlex := tview.NewFlex().
-			AddItem(lcFrame, 0, 1, false).
+			AddItem(nodesFlex, 0, 1, false).
 			AddItem(tui.eventFlex.layout, 0, 1, false)
+		state.lock.Lock()
+		progressBar := state.bar
 		if progressBar == nil {
 			progressBar = createProgressBar(100)
 		}
+		progressText := progressBar.String()
+		state.lock.Unlock()
 
 		if progressBar.IsFinished() {
 			time.AfterFunc(3*time.Second, func() {
@@ -219,7 +291,7 @@ func (tui *tuiConfig) renderLcFlex(
 
 		loaderFlex := tview.NewFlex().
 			AddItem(nil, 0, 1, true).
-			AddItem(tview.NewTextView().SetText(progressBar.String()), 0, 2, true).
+			AddItem(tview.NewTextView().SetText(progressText), 0, 2, true).
 			AddItem(nil, 0, 1, true)
 
 		loaderFlex.SetBorder(true)
@@ -0,0 +1,84 @@
+package ui
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/gdamore/tcell/v2"
+	"github.com/rivo/tview"
+)
+
+// Max time to wait for the event loop to show the mfa prompt. The prompt
+// can't be shown while the caller blocks the event loop itself, e.g. an
+// aws call of an input handler, which fails instead of hanging.
+const mfaPromptTimeout = 5 * time.Second
+
+// Asks for the token of the mfa device in a modal covering the whole TUI and
+// blocks till it is submitted. Called by the aws credential provider
+// whenever a role is assumed with mfa.
+func (tui *tuiConfig) promptMfaToken(mfaDevice string) (string, error) {
+	tui.mfaLock.Lock()
+	defer tui.mfaLock.Unlock()
+
+	type result struct {
+		token string
+		err   error
+	}
+	shown := make(chan struct{})
+	abandoned := make(chan struct{})
+	results := make(chan result, 1)
+
+	tui.queueUpdateDraw(func() {
+		select {
+		case shown <- struct{}{}:
+		case <-abandoned:
+			return
+		}
+		tui.mfaPrompting = true
+
+		var form *tview.Form
+		done := func(res result) {
+			tui.mfaPrompting = false
+			if tui.root != nil {
+				tui.App.SetRoot(tui.root, true)
+			}
+			results <- res
+		}
+		form = tview.NewForm().
+			AddPasswordField("Token", "", 6, '*', nil).
+			AddButton("OK", func() {
+				token := form.GetFormItemByLabel("Token").(*tview.InputField).GetText()
+				done(result{token: token})
+			}).
+			AddButton("Cancel", func() {
+				done(result{err: errors.New("MFA token prompt was cancelled")})
+			})
+		form.SetButtonsAlign(tview.AlignCenter).
+			SetBorder(true).
+			SetTitle(fmt.Sprintf("MFA token of %s", mfaDevice)).
+			SetTitleColor(tcell.ColorYellow)
+
+		modal := tview.NewFlex().
+			AddItem(nil, 0, 1, false).
+			AddItem(tview.NewFlex().SetDirection(tview.FlexRow).
+				AddItem(nil, 0, 1, false).
+				AddItem(form, 7, 1, true).
+				AddItem(nil, 0, 1, false), 60, 1, true).
+			AddItem(nil, 0, 1, false)
+		tui.App.SetRoot(modal, true).SetFocus(form)
+	})
+
+	select {
+	case <-shown:
+	case <-time.After(mfaPromptTimeout):
+		close(abandoned)
+		return "", fmt.Errorf(
+			"Unable to prompt for the MFA token of %s, retry once the TUI is responsive",
+			mfaDevice,
+		)
+	}
+
+	res := <-results
+	return res.token, res.err
+}
@@ -3,6 +3,9 @@ package ui
 import (
 	"context"
 	"dockyard/pkg/aws"
+	"dockyard/pkg/kube"
+	"errors"
+	"fmt"
 	"time"
 	"unicode"
 
@@ -10,6 +13,10 @@ import (
 	"github.com/rivo/tview"
 )
 
+// Nodes rolled per batch
+// TODO Make this configurable
+const rolloutBatchSize = 1
+
 type newRollout struct {
 	layout  *tview.Flex
 	focused bool
@@ -24,15 +31,21 @@ func NewRollout() *newRollout {
 }
 
 func SetRolloutForm(ctx context.Context, tui *tuiConfig, asgName string) {
-	minNodes, _ := tui.asgClient.GetTagValueOfAsg(asgName, "dockyard.io/min")
+	// Rollout is already running in the background, show its progress
+	if state := tui.runningRollout(asgName); state != nil {
+		tui.renderLcFlexWithReloading(asgName, state)
+		return
+	}
 
-	maxNodes, _ := tui.asgClient.GetTagValueOfAsg(asgName, "dockyard.io/max")
+	// Rollout keeps using the clients of the cluster it was started in even
+	// if another cluster is selected meanwhile
+	clients := tui.clusterClients
 
-	hasRolloutStarted := false
+	// Rollouts started before snapshots only left min and max tags behind
+	snapshot, _ := clients.asgClient.GetAsgSnapshot(asgName)
+	_, legacyErr := clients.asgClient.GetTagValueOfAsg(asgName, "dockyard.io/min")
 
-	if minNodes != 0 || maxNodes != 0 {
-		hasRolloutStarted = true
-	}
+	hasRolloutStarted := snapshot != nil || legacyErr == nil
 
 	flexBox := tui.rolloutForm.layout
 	flexBox.Clear()
@@ -65,6 +78,18 @@ func SetRolloutForm(ctx context.Context, tui *tuiConfig, asgName string) {
 		AddItem(batchSizeWarningText, 0, 3, true).
 		AddItem(tview.NewBox().SetBackgroundColor(tcell.ColorBlue), 3, 1, false)
 
+	// Holder of the rollout lease, e.g. another engineer rolling the asg
+	lease, _ := clients.asgClient.GetRolloutLease(asgName)
+	leaseText := tview.NewTextView().
+		SetText(leaseStatus(lease)).
+		SetTextColor(tcell.ColorYellow).
+		SetWrap(true)
+	leaseText.SetBackgroundColor(tcell.ColorBlue)
+	leaseFlex := tview.NewFlex().
+		AddItem(tview.NewBox().SetBackgroundColor(tcell.ColorBlue), 5, 1, false).
+		AddItem(leaseText, 0, 3, true).
+		AddItem(tview.NewBox().SetBackgroundColor(tcell.ColorBlue), 3, 1, false)
+
 	rolloutContinueText := tview.NewTextView().
 		SetText("Rollout has already started!!").
 		SetTextColor(tcell.ColorDarkRed).
@@ -86,38 +111,70 @@ func SetRolloutForm(ctx context.Context, tui *tuiConfig, asgName string) {
 	saveButton := tview.NewButton(buttonText)
 	saveButton.SetBackgroundColor(tcell.ColorGreen)
 	saveButton.SetSelectedFunc(func() {
+		key := rolloutKey(clients.cluster.Name, asgName)
 		progressChan := make(aws.RolloutProgressChan)
-		tui.renderLcFlexWithReloading(asgName, progressChan)
+
+		tui.rolloutsLock.Lock()
+		state, ok := tui.rollouts[key]
+		if !ok {
+			state = newRolloutState(clients.asgClient, progressChan)
+			tui.rollouts[key] = state
+		}
+		tui.rolloutsLock.Unlock()
+
+		tui.renderLcFlexWithReloading(asgName, state)
+
+		// Button was pressed again, rollout is already running
+		if ok {
+			return
+		}
 
 		go func() {
+			defer func() {
+				tui.rolloutsLock.Lock()
+				delete(tui.rollouts, key)
+				tui.rolloutsLock.Unlock()
+				close(progressChan)
+			}()
+
+			release, err := tui.holdRollout(ctx, clients, asgName)
+			if err != nil {
+				tui.showError(err)
+				return
+			}
+			defer release()
+
+			// A rollout which was already started is continued as is
+			if !hasRolloutStarted {
+				if err := tui.runPreflightGate(ctx, clients, asgName); err != nil {
+					tui.showError(err)
+					return
+				}
+			}
+
 			rolloutSuccess := false
-			tui.sidebar.layout.DisableSelection()
-			err := tui.asgClient.StartRollout(
+			err = clients.asgClient.StartRollout(
 				ctx,
 				asgName,
-				// TODO Make this configurable
-				1,
+				rolloutBatchSize,
 				progressChan,
-				tui.eventFlex.events,
+				clients.events.events,
 			)
 			if err != nil {
 				tui.showError(err)
-				tui.sidebar.layout.EnableSelection()
 			} else {
 				rolloutSuccess = true
 			}
-			time.Sleep(time.Duration(tui.asgRolloutConfig.PeriodWait.BeforePost) * time.Second)
-			err = tui.asgClient.PostRolloutStart(
+			time.Sleep(time.Duration(clients.asgRolloutConfig.PeriodWait.BeforePost) * time.Second)
+			err = clients.asgClient.PostRolloutStart(
 				asgName,
 				progressChan,
-				tui.eventFlex.events,
+				clients.events.events,
 				rolloutSuccess,
 			)
 			if err != nil {
 				tui.showError(err)
-				tui.sidebar.layout.EnableSelection()
 			}
-			tui.sidebar.layout.EnableSelection()
 		}()
 
 	})
@@ -135,6 +192,9 @@ func SetRolloutForm(ctx context.Context, tui *tuiConfig, asgName string) {
 	if hasRolloutStarted {
 		formFlex.AddItem(rolloutContinueFlex, 0, 1, true)
 	}
+	if lease != nil {
+		formFlex.AddItem(leaseFlex, 0, 1, true)
+	}
 
 	formFlex.
 		AddItem(tview.NewBox().SetBackgroundColor(tcell.ColorBlue), 5, 1, true).
@@ -153,3 +213,59 @@ func SetRolloutForm(ctx context.Context, tui *tuiConfig, asgName string) {
 			AddItem(nil, 0, 1, false), 0, 1, true).
 		AddItem(nil, 0, 1, false)
 }
+
+// Acquires the rollout lease of the asg for the rollout and post rollout
+// steps. An expired lease of another process is only taken over once the
+// takeover is confirmed.
+func (tui *tuiConfig) holdRollout(
+	ctx context.Context,
+	clients *clusterClients,
+	asgName string,
+) (func(), error) {
+	release, err := clients.asgClient.HoldRollout(ctx, asgName, false)
+	var heldErr *kube.RolloutLeaseHeldError
+	if !errors.As(err, &heldErr) || !heldErr.Expired {
+		return release, err
+	}
+
+	confirmed, err := tui.confirm(
+		"Expired rollout lease",
+		fmt.Sprintf(
+			"ASG %s was rolled by %s since %s, the lease expired at %s without being released.\n\nMake sure that dockyard process is gone before taking over.",
+			asgName,
+			heldErr.Lease.HolderName(),
+			heldErr.Lease.AcquiredAt.Local().Format("2006-01-02 15:04:05"),
+			heldErr.Lease.ExpiresAt().Local().Format("2006-01-02 15:04:05"),
+		),
+		"Take over",
+	)
+	if err != nil {
+		return nil, err
+	}
+	if !confirmed {
+		return nil, fmt.Errorf("Rollout of ASG %s wasn't started, its lease wasn't taken over", asgName)
+	}
+	clients.events.events <- fmt.Sprintf(
+		"Taking over rollout lease of ASG %s from %s",
+		asgName,
+		heldErr.Lease.HolderName(),
+	)
+	return clients.asgClient.HoldRollout(ctx, asgName, true)
+}
+
+// Describes the holder of the rollout lease
+func leaseStatus(lease *kube.RolloutLease) string {
+	if lease == nil {
+		return ""
+	}
+	since := lease.AcquiredAt.Local().Format("2006-01-02 15:04:05")
+	if lease.Live(time.Now()) {
+		return fmt.Sprintf("Rolled by %s since %s", lease.HolderName(), since)
+	}
+	return fmt.Sprintf(
+		"Lease of %s since %s expired at %s, starting takes it over",
+		lease.HolderName(),
+		since,
+		lease.ExpiresAt().Local().Format("15:04:05"),
+	)
+}
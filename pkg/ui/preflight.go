@@ -1,150 +1,237 @@
 package ui
 
 import (
+	"context"
+	"dockyard/pkg/preflight"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
 	"github.com/gdamore/tcell/v2"
 	"github.com/rivo/tview"
 )
 
-type preflight struct {
-	layout             *tview.Flex
-	availableIpFrame   *tview.Frame
-	availableIpTable   *tview.Table
-	resourceQuotaFrame *tview.Frame
-	resourceQuotaTable *tview.Table
-	publicImageTable   *tview.Table
-	publicImageFrame   *tview.Frame
-	pdbFrame           *tview.Frame
-	pdbTable           *tview.Table
-	focused            bool
+type preflightFlex struct {
+	layout          *tview.Flex
+	checksFrame     *tview.Frame
+	checksTable     *tview.Table
+	detailsTable    *tview.Table
+	remediationView *tview.TextView
+	focused         bool
 }
 
-func NewPreflight() *preflight {
-
-	publicImageTable := tview.NewTable()
-	resourceQuotaTableView := tview.NewTable()
-	availableIpTableView := tview.NewTable()
-	pdbTableView := tview.NewTable()
-	publicImageTable.SetBorder(true).SetTitle("PublicImages").SetBorder(true)
-	availableIpTableView.SetBorder(true).SetTitle("Subnets").SetBorder(true)
-	resourceQuotaTableView.SetBorder(true).
-		SetTitle("Health Checks").
-		SetBorder(true)
-	pdbTableView.SetBorder(true).SetTitle("Misconfigured PDB").SetBorder(true)
-	availableIpsFrame := tview.NewFrame(availableIpTableView).
-		AddText("Available Ips", true, tview.AlignLeft, tcell.ColorYellow)
-	resourceQuotaFrame := tview.NewFrame(resourceQuotaTableView).
-		AddText("Health Checks", true, tview.AlignLeft, tcell.ColorYellow)
-	pdbTableFrame := tview.NewFrame(pdbTableView).
-		AddText("PDB - with no disruption allowed", true, tview.AlignLeft, tcell.ColorYellow)
-	publicImageFrame := tview.NewFrame(publicImageTable).
-		AddText("Public Images", true, tview.AlignLeft, tcell.ColorYellow)
-
-	layout := tview.NewFlex().SetDirection(tview.FlexColumn).
-		AddItem(availableIpsFrame, 0, 3, false).
-		AddItem(pdbTableFrame, 0, 3, false).
-		AddItem(publicImageFrame, 0, 3, false).
-		AddItem(resourceQuotaFrame, 0, 2, false)
-
-	return &preflight{
-		layout:             layout,
-		availableIpFrame:   tview.NewFrame(availableIpTableView),
-		availableIpTable:   availableIpTableView,
-		resourceQuotaFrame: tview.NewFrame(resourceQuotaTableView),
-		resourceQuotaTable: resourceQuotaTableView,
-		pdbFrame:           pdbTableFrame,
-		pdbTable:           pdbTableView,
-		publicImageTable:   publicImageTable,
-		publicImageFrame:   publicImageFrame,
-		focused:            false,
-	}
-}
+func NewPreflight() *preflightFlex {
 
-func (tui *tuiConfig) renderPreflightFlex() {
+	checksTableView := tview.NewTable().
+		SetSelectable(true, false).
+		SetSelectedStyle(tcell.StyleDefault.Foreground(tcell.ColorWhite).Background(tcell.ColorNavy).Attributes(tcell.AttrBold))
+	checksTableView.SetBorder(true).SetTitle("Checks")
+	detailsTableView := tview.NewTable()
+	detailsTableView.SetBorder(true).SetTitle("Details")
+	remediationView := tview.NewTextView().SetWrap(true).SetWordWrap(true)
+	remediationView.SetBorder(true).SetTitle("Remediation")
 
-	tui.queueUpdateDraw(func() {
+	checksFrame := tview.NewFrame(checksTableView).
+		AddText("Preflight checks", true, tview.AlignLeft, tcell.ColorYellow)
 
-		defer tui.body.layout.SwitchToPage("2")
+	detailsFlex := tview.NewFlex().SetDirection(tview.FlexRow).
+		AddItem(detailsTableView, 0, 4, false).
+		AddItem(remediationView, 4, 1, false)
 
-		// Initialize preflight table headers
-		ips := make([][]string, 0)
-		ips = append(ips, []string{"Subnet", "Availability Zone", "Ips"})
-		rqs := make([][]string, 0)
-		rqs = append(rqs, []string{"Type", "Status"})
-		pdbs := make([][]string, 0)
-		pdbs = append(pdbs, []string{"PDB Name", "Namespace", "ExpectedPods"})
-		publicImages := make([][]string, 0)
-		publicImages = append(publicImages, []string{"Deployment", "Namespace"})
+	layout := tview.NewFlex().SetDirection(tview.FlexColumn).
+		AddItem(checksFrame, 0, 3, true).
+		AddItem(detailsFlex, 0, 2, false)
 
-		healthStatus := make(chan []string)
+	return &preflightFlex{
+		layout:          layout,
+		checksFrame:     checksFrame,
+		checksTable:     checksTableView,
+		detailsTable:    detailsTableView,
+		remediationView: remediationView,
+		focused:         false,
+	}
+}
 
-		// fetch if all worker nodes are healthy
-		go func(healthStatus chan []string) {
-			healthy, _ := tui.kube.AreNodeHealthy()
-			healthStatus <- healthy
-		}(healthStatus)
+// Returns the env to run preflight checks against the cluster the views
+// are bound to
+func (clients *clusterClients) preflightEnv() *preflight.Env {
+	return &preflight.Env{
+		Kube:                  clients.kube,
+		Asg:                   clients.asgClient,
+		Eks:                   clients.awsEksClient,
+		ClusterName:           clients.kube.GetClusterName(),
+		IgnoreNotFound:        clients.asgRolloutConfig.IgnoreNotFound,
+		AllowedRegistries:     clients.asgRolloutConfig.AllowedRegistries,
+		ClusterAutoscalerMode: clients.asgRolloutConfig.ClusterAutoscalerMode(),
+	}
+}
 
-		// fetch if any pods are in pending state
-		go func(healthStatus chan []string) {
-			pending, _ := tui.kube.ArePendingPods()
-			healthStatus <- pending
-		}(healthStatus)
+func (tui *tuiConfig) renderPreflightFlex(ctx context.Context) {
+	clients := tui.clusterClients
 
-		// fetch count of available ips in each subnet
-		res, err := tui.awsEksClient.AvailableIp()
-		if err != nil {
-			tui.showError(err)
-		}
-		ips = append(ips, res...)
+	// Checks call aws and k8s, so they must not block the event loop
+	go func() {
+		report := tui.preflightRunner.Run(ctx, clients.preflightEnv())
 
-		// Get EC2 spot limits
-		res, err = tui.awsEksClient.Ec2Limits()
+		tui.queueUpdateDraw(func() {
+			defer tui.body.layout.SwitchToPage("2")
 
-		if err != nil {
-			tui.showError(err)
-		}
-		rqs = append(rqs, res...)
+			rows := [][]string{
+				{"Check", "Category", "Severity", "Status", "Message"},
+			}
+			for _, result := range report.Results {
+				rows = append(rows, []string{
+					result.Name,
+					result.Category,
+					result.Severity.String(),
+					statusSymbol(result.Status),
+					result.Message,
+				})
+			}
 
-		// Get misconfigured PDBs
-		res, err = tui.kube.GetPDB()
+			checksFrame := tui.preflightFlex.checksFrame
+			checksFrame.Clear().
+				AddText("Preflight checks", true, tview.AlignLeft, tcell.ColorYellow).
+				AddText(
+					fmt.Sprintf("Verdict: %s", report.Verdict),
+					true,
+					tview.AlignRight,
+					verdictColor(report.Verdict),
+				).
+				AddText("e: export report", false, tview.AlignRight, tcell.ColorGray)
 
-		if err != nil {
-			tui.showError(err)
-		}
-		pdbs = append(pdbs, res...)
+			checksTable := tui.preflightFlex.checksTable
+			checksTable.Clear()
+			checksTable.SetFixed(1, 1)
+			renderTable(rows, checksTable)
 
-		// Fetch all images which are not pulled of private registry
-		res, err = tui.kube.ListPublicImages()
+			showDetails := func(row int) {
+				if row < 1 || row > len(report.Results) {
+					return
+				}
+				result := report.Results[row-1]
+				detailsTable := tui.preflightFlex.detailsTable
+				detailsTable.Clear()
+				detailsTable.SetFixed(1, 0)
+				detailsTable.SetTitle(result.Name)
+				renderTable(result.Details, detailsTable)
+				tui.preflightFlex.remediationView.SetText(result.Remediation)
+			}
+			// Header is rendered not selectable, only check rows are
+			for r := 1; r < len(rows); r++ {
+				for c := range rows[r] {
+					checksTable.GetCell(r, c).SetSelectable(true)
+				}
+			}
+			checksTable.SetSelectionChangedFunc(func(row, column int) {
+				showDetails(row)
+			})
+			checksTable.SetInputCapture(func(event *tcell.EventKey) *tcell.EventKey {
+				if event.Rune() == 'e' {
+					tui.exportPreflightReport(report)
+					return nil
+				}
+				return event
+			})
+			checksTable.Select(1, 0)
+			showDetails(1)
+		})
+	}()
+}
 
+// Writes the report as markdown, e.g. for change tickets, and as json to
+// the working directory
+func (tui *tuiConfig) exportPreflightReport(report preflight.Report) {
+	name := fmt.Sprintf(
+		"preflight-%s-%s",
+		report.ClusterName,
+		report.StartedAt.Format("20060102-150405"),
+	)
+	files := make([]string, 0, 2)
+	for format, extension := range map[string]string{
+		preflight.FormatMarkdown: ".md",
+		preflight.FormatJSON:     ".json",
+	} {
+		file := name + extension
+		err := writeReportFile(file, report, format)
 		if err != nil {
-			tui.showError(err)
+			tui.showError(fmt.Errorf("Unable to export preflight report, %s", err.Error()))
+			return
 		}
+		files = append(files, file)
+	}
+	sort.Strings(files)
+	tui.showMessage(fmt.Sprintf("Exported preflight report to %s", strings.Join(files, ", ")))
+}
 
-		publicImages = append(publicImages, res...)
-
-		rqs = append(rqs, <-healthStatus)
-		rqs = append(rqs, <-healthStatus)
-
-		availableIpsTable := tui.preflightFlex.availableIpTable
-		availableIpsTable.SetBorder(true)
-		availableIpsTable.SetFixed(1, 1)
+func writeReportFile(file string, report preflight.Report, format string) error {
+	f, err := os.Create(file)
+	if err != nil {
+		return err
+	}
+	err = preflight.WriteReport(f, report, format)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
 
-		resourceQuotaTable := tui.preflightFlex.resourceQuotaTable
-		resourceQuotaTable.SetFixed(1, 1)
-		resourceQuotaTable.SetBorder(true)
+// Runs the preflight checks before the rollout of the asg is started.
+// Returns an error if the verdict blocks the rollout, failed checks which
+// only warn are reported to the events.
+func (tui *tuiConfig) runPreflightGate(
+	ctx context.Context,
+	clients *clusterClients,
+	asgName string,
+) error {
+	clients.events.events <- fmt.Sprintf("Running preflight checks before the rollout of ASG %s", asgName)
+	env := clients.preflightEnv()
+	env.AsgName = asgName
+	env.BatchSize = rolloutBatchSize
+	report := tui.preflightRunner.Run(ctx, env)
 
-		pdbTable := tui.preflightFlex.pdbTable
-		pdbTable.SetFixed(1, 1)
-		pdbTable.SetBorder(true)
+	switch report.Verdict {
+	case preflight.VerdictBlock:
+		return fmt.Errorf(
+			"Rollout of ASG %s is blocked by preflight checks\n%s",
+			asgName,
+			report.Summary(preflight.SeverityInfo),
+		)
+	case preflight.VerdictWarn:
+		for _, result := range report.Failed(preflight.SeverityWarning) {
+			clients.events.events <- fmt.Sprintf(
+				"Preflight check %s failed: %s",
+				result.Name,
+				result.Message,
+			)
+		}
+	}
+	return nil
+}
 
-		publicImageTable := tui.preflightFlex.publicImageTable
-		publicImageTable.SetFixed(1, 1)
-		publicImageTable.SetBorder(true)
+func statusSymbol(status preflight.Status) string {
+	switch status {
+	case preflight.StatusPass:
+		return "✅"
+	case preflight.StatusFail:
+		return "❌"
+	case preflight.StatusError:
+		return "⚠️"
+	default:
+		return string(status)
+	}
+}
 
-		renderTable(ips, availableIpsTable)
-		renderTable(rqs, resourceQuotaTable)
-		renderTable(pdbs, pdbTable)
-		renderTable(publicImages, publicImageTable)
-	})
+func verdictColor(verdict preflight.Verdict) tcell.Color {
+	switch verdict {
+	case preflight.VerdictBlock:
+		return tcell.ColorRed
+	case preflight.VerdictWarn:
+		return tcell.ColorOrange
+	default:
+		return tcell.ColorGreen
+	}
 }
 
 func renderTable(table [][]string, tableTui *tview.Table) {
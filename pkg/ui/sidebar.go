@@ -1,6 +1,8 @@
 package ui
 
 import (
+	"dockyard/pkg/aws"
+
 	"github.com/gdamore/tcell/v2"
 	"github.com/rivo/tview"
 )
@@ -12,6 +14,7 @@ type sidebar struct {
 
 type sidebarList struct {
 	asgRolloutTree *tview.TreeView
+	clustersNode   *tview.TreeNode
 }
 
 func NewSidebar() *sidebar {
@@ -34,7 +37,42 @@ func NewSidebarList() *sidebarList {
 			tview.NewTreeNode(option).SetSelectable(true).SetReference(option),
 		)
 	}
-	return &sidebarList{asgRolloutTree: workerUpgrade}
+
+	clustersNode := tview.NewTreeNode("Clusters").
+		SetSelectable(true).
+		SetReference("Clusters").
+		SetColor(tcell.ColorYellow)
+	root.AddChild(clustersNode)
+
+	return &sidebarList{asgRolloutTree: workerUpgrade, clustersNode: clustersNode}
+}
+
+// Lists the clusters which can be switched to
+func (list *sidebarList) SetClusters(clusters []aws.ClusterConfig, active string) {
+	list.clustersNode.ClearChildren()
+	for _, cluster := range clusters {
+		list.clustersNode.AddChild(
+			tview.NewTreeNode(cluster.Name).
+				SetSelectable(true).
+				SetReference(cluster),
+		)
+	}
+	list.SetActiveCluster(active)
+}
+
+// Highlights the cluster all views are bound to
+func (list *sidebarList) SetActiveCluster(active string) {
+	for _, child := range list.clustersNode.GetChildren() {
+		cluster, ok := child.GetReference().(aws.ClusterConfig)
+		if !ok {
+			continue
+		}
+		if cluster.Name == active {
+			child.SetText("* " + cluster.Name).SetColor(tcell.ColorGreen)
+		} else {
+			child.SetText(cluster.Name).SetColor(tcell.ColorWhite)
+		}
+	}
 }
 
 func (list *sidebarList) DisableSelection() {
@@ -4,6 +4,7 @@ import (
 	"context"
 	"dockyard/pkg/aws"
 	"dockyard/pkg/kube"
+	"dockyard/pkg/preflight"
 	"sync"
 	"time"
 
@@ -25,7 +26,7 @@ type tuiLayout struct {
 	sidebar           *sidebar
 	loader            *loader
 	asgTable          *asgTable
-	preflightFlex     *preflight
+	preflightFlex     *preflightFlex
 	lcFlex            *lcFlex
 	infoPage          *infoPage
 	rolloutForm       *newRollout
@@ -36,12 +37,23 @@ type tuiLayout struct {
 
 type tuiConfig struct {
 	tuiLayout
-	App              *tview.Application
-	kube             kube.KubeClient
-	asgClient        aws.AsgRolloutClient
-	awsEksClient     aws.AwsEksClient
-	awsConfig        *aws.AwsConfig
-	asgRolloutConfig *aws.AsgRolloutConfig
+	// clients of the cluster all views are bound to
+	*clusterClients
+	App               *tview.Application
+	root              *tview.Flex
+	kubeOpts          kube.KubeConfigOptions
+	baseAwsConfig     *aws.AwsConfig
+	baseRolloutConfig *aws.AsgRolloutConfig
+	preflightRunner   *preflight.Runner
+	// clients of all clusters visited so far, keyed by cluster name
+	clusters     map[string]*clusterClients
+	clustersLock *sync.Mutex
+	// rollouts started from the TUI, keyed by cluster and asg name
+	rollouts     map[string]*rolloutState
+	rolloutsLock *sync.Mutex
+	// serializes mfa prompts, mfaPrompting is only accessed by the event loop
+	mfaLock      *sync.Mutex
+	mfaPrompting bool
 }
 
 // Initialize dockyard tview components
@@ -50,11 +62,41 @@ func NewTUI(
 	kubeClient kube.KubeClient,
 	awsConfig *aws.AwsConfig,
 	asgRolloutConfig *aws.AsgRolloutConfig,
+	kubeOpts kube.KubeConfigOptions,
+	clusters []aws.ClusterConfig,
+	preflightConfig *preflight.Config,
 ) *tuiConfig {
 
+	activeCluster := aws.ClusterConfig{
+		Context:        kubeClient.GetContext(),
+		EksClusterName: asgRolloutConfig.EksClusterName,
+	}.WithDefaults(awsConfig)
+	activeAwsConfig := activeCluster.AwsConfig(awsConfig)
+	eventView := NewEventFlex()
+	activeClients := &clusterClients{
+		cluster:          activeCluster,
+		kube:             kubeClient,
+		asgClient:        aws.NewAsgRollout(ctx, &activeAwsConfig, kubeClient, asgRolloutConfig),
+		awsEksClient:     aws.NewAwsEKS(kubeClient.GetClusterName(), &activeAwsConfig),
+		awsConfig:        &activeAwsConfig,
+		asgRolloutConfig: asgRolloutConfig,
+		events:           newClusterEvents(activeCluster.Name, eventView),
+	}
+
 	// UI initialize
 	tui := &tuiConfig{
-		awsConfig: awsConfig,
+		clusterClients:    activeClients,
+		kubeOpts:          kubeOpts,
+		baseAwsConfig:     awsConfig,
+		baseRolloutConfig: asgRolloutConfig,
+		preflightRunner:   preflight.NewRunner(preflightConfig),
+		clusters: map[string]*clusterClients{
+			activeCluster.Name: activeClients,
+		},
+		clustersLock: &sync.Mutex{},
+		rollouts:     map[string]*rolloutState{},
+		rolloutsLock: &sync.Mutex{},
+		mfaLock:      &sync.Mutex{},
 		tuiLayout: tuiLayout{
 			header:            NewHeader(),
 			footer:            NewFooter(),
@@ -66,18 +108,25 @@ func NewTUI(
 			lcFlex:            NewLcFlex(),
 			infoPage:          NewInfoPage(),
 			rolloutForm:       NewRollout(),
-			eventFlex:         NewEventFlex(),
+			eventFlex:         eventView,
 			messageModalMutex: &sync.Mutex{},
 		},
-		App:       tview.NewApplication(),
-		kube:      kubeClient,
-		asgClient: aws.NewAsgRollout(ctx, awsConfig, kubeClient, asgRolloutConfig),
-		awsEksClient: aws.NewAwsEKS(
-			kubeClient.GetClusterName(),
-			awsConfig.GetProfile(),
-		),
-		asgRolloutConfig: asgRolloutConfig,
+		App: tview.NewApplication(),
 	}
+	aws.SetMfaTokenProvider(tui.promptMfaToken)
+	tui.eventFlex.Show(activeClients.events)
+
+	clusterList := aws.ListClusters(kubeOpts, clusters, awsConfig)
+	hasActiveCluster := false
+	for _, cluster := range clusterList {
+		if cluster.Name == activeCluster.Name {
+			hasActiveCluster = true
+		}
+	}
+	if !hasActiveCluster {
+		clusterList = append([]aws.ClusterConfig{activeCluster}, clusterList...)
+	}
+	tui.sidebar.layout.SetClusters(clusterList, activeCluster.Name)
 
 	tui.body.layout.AddPage("-1", tui.infoPage.layout, true, false)
 	tui.body.layout.AddPage("0", tui.loader.layout, true, false)
@@ -106,7 +155,11 @@ func NewTUI(
 				tui.renderASGList(ctx)
 			} else if reference == "Preflight checks" {
 				tui.body.layout.SwitchToPage("0")
-				tui.renderPreflightFlex()
+				tui.renderPreflightFlex(ctx)
+			} else if reference == "Clusters" {
+				node.SetExpanded(!node.IsExpanded())
+			} else if cluster, ok := reference.(aws.ClusterConfig); ok {
+				tui.switchCluster(ctx, cluster)
 			}
 		},
 	)
@@ -116,7 +169,7 @@ func NewTUI(
 
 // Create a flex wrapper for all child components
 func (tui *tuiConfig) RenderTUI() *tview.Flex {
-	return tview.NewFlex().SetDirection(tview.FlexRow).
+	tui.root = tview.NewFlex().SetDirection(tview.FlexRow).
 		AddItem(tview.NewFlex().SetDirection(tview.FlexRow).
 			AddItem(tui.header.layout, 0, 2, false), 0, 2, false).
 		AddItem(tview.NewFlex().SetDirection(tview.FlexColumn).
@@ -124,6 +177,7 @@ func (tui *tuiConfig) RenderTUI() *tview.Flex {
 			AddItem(tui.body.layout, 0, 7, false), 0, 8, false).
 		AddItem(tview.NewFlex().SetDirection(tview.FlexRow).
 			AddItem(tui.footer.layout, 0, 1, false), 0, 1, false)
+	return tui.root
 }
 
 func (tui *tuiConfig) setFocus(p tview.Primitive) {
@@ -161,6 +215,10 @@ func (tui *tuiConfig) EnableEventCapture() {
 		}
 	)
 	tui.App.SetInputCapture(func(event *tcell.EventKey) *tcell.EventKey {
+		// Views hidden by the mfa prompt must not get the focus
+		if tui.mfaPrompting {
+			return event
+		}
 		switch event.Key() {
 		case KeyMapping[KeyTop]:
 		case KeyMapping[KeyBottom]: